@@ -24,6 +24,7 @@ type DASHSource struct {
 	inSvr bool
 
 	slicer *dashSlicer.DASHSlicer
+	fmp4Slicer *FMP4Slicer //hevc av1 vp9不走dashSlicer
 	mediaReceiver *FMP4Cache
 	appendedAACHeader bool
 	appendedKeyFrame bool
@@ -46,13 +47,19 @@ func (this *DASHSource)serveHTTP(reqType,param string,w http.ResponseWriter,req
 
 func (this *DASHSource)serveMPD(param string,w http.ResponseWriter,req *http.Request)  {
 
-	if nil==this.slicer{
+	var mpd []byte
+	var err error
+	if nil!=this.fmp4Slicer{
+		mpd,err=this.fmp4Slicer.GetMPD()
+	}else if nil!=this.slicer{
+		mpd,err=this.slicer.GetMPD()
+	}else{
 		w.WriteHeader(404)
 		return
 	}
-	mpd,err:= this.slicer.GetMPD()
 	if err!=nil{
 		logger.LOGE(err.Error())
+		w.WriteHeader(404)
 		return
 	}
 	//mpd,err=wssAPI.ReadFileAll("mpd/taotao.mpd")
@@ -176,7 +183,8 @@ func (this *DASHSource) ProcessMessage(msg *wssAPI.Msg) (err error) {
 	case wssAPI.MSG_PLAY_STOP:
 		this.Stop(nil)
 	case wssAPI.MSG_FLV_TAG:
-		this.addFlvTag(msg.Param1.(*flv.FlvTag))
+		//返回错误源会移除这个sink并Stop
+		err=this.addFlvTag(msg.Param1.(*flv.FlvTag))
 	}
 	return
 }
@@ -234,25 +242,51 @@ func (this *DASHSource)createSlicer() (err error){
 	return
 }
 
-func (this *DASHSource)addFlvTag(tag *flv.FlvTag)  {
+func (this *DASHSource)addFlvTag(tag *flv.FlvTag) (err error) {
+	if nil!=this.fmp4Slicer{
+		this.fmp4Slicer.AddFlvTag(tag)
+		return
+	}
 	switch tag.TagType {
 	case flv.FLV_TAG_Audio:
 		if nil==this.audioHeader{
 			this.audioHeader=tag.Copy()
 			return
 		}else if this.slicer==nil {
+			//视频头没到不知道走哪个slicer，音频先丢掉
+			if nil==this.videoHeader{
+				return
+			}
 			this.createSlicer()
 		}
+		//slicer会留着数据，tag的Data是池里的，拷一份给它
 		if false==this.appendedAACHeader{
 			logger.LOGD("AAC")
-			this.slicer.AddAACFrame(tag.Data[2:],int64(tag.Timestamp))
+			this.slicer.AddAACFrame(copyPayload(tag.Data[2:]),int64(tag.Timestamp))
 			this.appendedAACHeader=true
 		}else{
 			if this.appendedKeyFrame{
-				this.slicer.AddAACFrame(tag.Data[2:],int64(tag.Timestamp))
+				this.slicer.AddAACFrame(copyPayload(tag.Data[2:]),int64(tag.Timestamp))
 			}
 		}
 	case flv.FLV_TAG_Video:
+		codecId:=flv.VideoCodecId(tag)
+		if flv.CodecID_AVC!=codecId{
+			//其他编码fMP4也出不了，直接结束这路dash
+			if flv.CodecID_HEVC!=codecId&&flv.CodecID_AV1!=codecId&&flv.CodecID_VP9!=codecId{
+				err=errors.New(fmt.Sprintf("video codec %d not supported by dash",codecId))
+				logger.LOGE(err.Error())
+				return
+			}
+			//dash slicer(muxer-fmp4)只有h264的接口，hevc av1 vp9用FMP4Slicer，攒着的音频头也给它
+			this.mediaReceiver=NewFMP4Cache(5)
+			this.fmp4Slicer=NewFMP4Slicer(this.mediaReceiver)
+			if nil!=this.audioHeader{
+				this.fmp4Slicer.AddFlvTag(this.audioHeader)
+			}
+			this.fmp4Slicer.AddFlvTag(tag)
+			return
+		}
 		if nil==this.videoHeader{
 			this.videoHeader=tag.Copy()
 			return
@@ -285,6 +319,14 @@ func (this *DASHSource)addFlvTag(tag *flv.FlvTag)  {
 		compositionTime:=int(tag.Data[2])<<16
 		compositionTime|=int(tag.Data[3])<<8
 		compositionTime|=int(tag.Data[4])<<0
-		this.slicer.AddH264Frame(tag.Data[5:],int64(tag.Timestamp),compositionTime)
+		this.slicer.AddH264Frame(copyPayload(tag.Data[5:]),int64(tag.Timestamp),compositionTime)
 	}
+	return
+}
+
+//源Release以后池里的内存会被复用
+func copyPayload(data []byte)(payload []byte)  {
+	payload=make([]byte,len(data))
+	copy(payload,data)
+	return
 }
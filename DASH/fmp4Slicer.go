@@ -0,0 +1,166 @@
+package DASH
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"mediaTypes/flv"
+	"mediaTypes/mp4"
+	"strconv"
+	"sync"
+	"wssAPI"
+)
+
+//dashSlicer(muxer-fmp4)只有h264的接口，hevc av1 vp9用mp4.FMP4Creater出fMP4
+//FMP4Creater一帧一个moof+mdat，从视频关键帧开始攒成一个segment，音频跟着视频的关键帧切
+type FMP4Slicer struct {
+	id              string
+	creater         mp4.FMP4Creater
+	converter       flv.PacketConverter
+	receiver        *FMP4Cache
+	mpd             mpdCreater
+	firstNoZeroTime uint32
+	videoSeg        []byte
+	audioSeg        []byte
+	segStart        uint32
+	segStarted      bool
+	segNumber       int
+	muxTimeline     sync.RWMutex
+	video           *wssAPI.CodecConfig
+	audio           *wssAPI.CodecConfig
+	timeline        *list.List //SegmentTimelineDesc,和cache里的segment一一对应
+	startNumber     int
+}
+
+func NewFMP4Slicer(receiver *FMP4Cache) (slicer *FMP4Slicer) {
+	slicer = &FMP4Slicer{}
+	slicer.id = wssAPI.GenerateGUID()
+	slicer.receiver = receiver
+	slicer.timeline = list.New()
+	slicer.mpd.init()
+	return
+}
+
+func (this *FMP4Slicer) AddFlvTag(tag *flv.FlvTag) {
+	//mp3没有头，从第一帧取；vp9的分辨率在第一个关键帧里
+	if flv.IsAudioSequenceHeader(tag) || flv.IsVideoSequenceHeader(tag) ||
+		(tag.TagType == flv.FLV_TAG_Audio && nil == this.audio) ||
+		(flv.IsVideoKeyFrame(tag) && this.video != nil && 0 == this.video.Width) {
+		this.updateConfig(tag)
+	}
+	//和FMP4Creater一样从第一个非0时间戳开始算，segment的时间和tfdt对上
+	if 0 == this.firstNoZeroTime && tag.Timestamp != 0 {
+		this.firstNoZeroTime = tag.Timestamp
+	}
+	timestamp := tag.Timestamp
+	if timestamp >= this.firstNoZeroTime {
+		timestamp -= this.firstNoZeroTime
+	}
+	if flv.IsVideoKeyFrame(tag) {
+		this.cut(timestamp)
+	}
+	slice := this.creater.AddFlvTag(tag)
+	if nil == slice {
+		return
+	}
+	if slice.Idx < 0 {
+		if flv.FLV_TAG_Video == slice.Type {
+			//vp9的init后面跟着第一个关键帧的moof+mdat，分开
+			initSeg, media := splitInitSegment(slice.Data)
+			this.receiver.VideoHeaderGenerated(initSeg)
+			this.videoSeg = append(this.videoSeg, media...)
+		} else {
+			this.receiver.AudioHeaderGenerated(slice.Data)
+		}
+		return
+	}
+	//第一个关键帧之前的音频不要
+	if false == this.segStarted {
+		return
+	}
+	if flv.FLV_TAG_Video == slice.Type {
+		this.videoSeg = append(this.videoSeg, slice.Data...)
+	} else {
+		this.audioSeg = append(this.audioSeg, slice.Data...)
+	}
+}
+
+func (this *FMP4Slicer) updateConfig(tag *flv.FlvTag) {
+	pkt, err := this.converter.Convert(tag)
+	if err != nil || nil == pkt {
+		return
+	}
+	pkt.Release()
+	this.muxTimeline.Lock()
+	defer this.muxTimeline.Unlock()
+	switch pkt.MediaType {
+	case wssAPI.MEDIA_TYPE_AUDIO:
+		this.audio = pkt.Config
+	case wssAPI.MEDIA_TYPE_VIDEO:
+		this.video = pkt.Config
+	}
+}
+
+//关键帧到了，前面攒的出一个segment
+//音频tfdt是按采样时长累加的，和视频差得不多，用同一条时间线
+func (this *FMP4Slicer) cut(timestamp uint32) {
+	if this.segStarted {
+		//时间戳没往前走，接着攒
+		if timestamp <= this.segStart {
+			return
+		}
+		duration := timestamp - this.segStart
+		this.receiver.VideoSegmentGenerated(this.videoSeg, int64(this.segNumber), int(duration))
+		if len(this.audioSeg) > 0 {
+			this.receiver.AudioSegmentGenerated(this.audioSeg, int64(this.segNumber), int(duration))
+		}
+		this.muxTimeline.Lock()
+		this.timeline.PushBack(SegmentTimelineDesc{T: strconv.Itoa(int(this.segStart)), D: strconv.Itoa(int(duration))})
+		if this.timeline.Len() > this.receiver.cacheSize {
+			this.timeline.Remove(this.timeline.Front())
+			this.startNumber++
+		}
+		this.muxTimeline.Unlock()
+		this.segNumber++
+		this.videoSeg = nil
+		this.audioSeg = nil
+	}
+	this.segStart = timestamp
+	this.segStarted = true
+}
+
+//从第一个moof分开，没有moof整个都是init
+func splitInitSegment(data []byte) (initSeg, media []byte) {
+	cur := 0
+	for cur+8 <= len(data) {
+		if "moof" == string(data[cur+4:cur+8]) {
+			return data[:cur], data[cur:]
+		}
+		size := int(binary.BigEndian.Uint32(data[cur:]))
+		if size < 8 {
+			break
+		}
+		cur += size
+	}
+	return data, nil
+}
+
+func (this *FMP4Slicer) GetMPD() (mpd []byte, err error) {
+	this.muxTimeline.RLock()
+	defer this.muxTimeline.RUnlock()
+	if nil == this.video || 0 == this.timeline.Len() {
+		return nil, errors.New("no segment generated yet")
+	}
+	timeline := make([]SegmentTimelineDesc, 0, this.timeline.Len())
+	for e := this.timeline.Front(); e != nil; e = e.Next() {
+		timeline = append(timeline, e.Value.(SegmentTimelineDesc))
+	}
+	creater := this.mpd
+	creater.video = this.video
+	creater.audio = this.audio
+	mpd = creater.GetXML(this.id, this.startNumber, timeline)
+	if nil == mpd {
+		err = errors.New("create mpd failed")
+	}
+	return
+}
@@ -4,12 +4,9 @@ import (
 	"encoding/xml"
 	"fmt"
 	"logger"
-	"mediaTypes/flv"
-	"time"
-
-	"mediaTypes/aac"
-	"mediaTypes/h264"
+	"mediaTypes/mp4"
 	"strconv"
+	"time"
 	"wssAPI"
 )
 
@@ -92,15 +89,15 @@ type AudioChannelConfigurationXML struct {
 	Value       int    `xml:"value,attr"`
 }
 
+//dashSlicer以外的fMP4(hevc av1 vp9)用，segment的地址和serveVideo serveAudio解析的一致
+//FMP4Creater音视频的timescale都是1000
 type mpdCreater struct {
-	audioHeader  *flv.FlvTag
-	videoHeader  *flv.FlvTag
+	video        *wssAPI.CodecConfig
+	audio        *wssAPI.CodecConfig
 	avaStartTime string
 }
 
-func (this *mpdCreater) init(videoHeader, audioHeader *flv.FlvTag) {
-	this.audioHeader = audioHeader
-	this.videoHeader = videoHeader
+func (this *mpdCreater) init() {
 	t := time.Now()
 	this.avaStartTime = t.Format("2006-01-02T15:04:05.000Z")
 }
@@ -111,7 +108,7 @@ func generatePTime(year, month, day, hour, minute, sec, mill int) string {
 	return str
 }
 
-func (this *mpdCreater) GetXML(id string, startNumber int) (buf []byte) {
+func (this *mpdCreater) GetXML(id string, startNumber int, timeline []SegmentTimelineDesc) (buf []byte) {
 	mpd := &MPD{Id: id,
 		Profiles: ProfileISOLive,
 		Type:     dynamicMPD,
@@ -122,7 +119,7 @@ func (this *mpdCreater) GetXML(id string, startNumber int) (buf []byte) {
 	mpd.MinimumUpdatePeriod=generatePTime(0,0,0,0,0,3,0)
 	mpd.MinBufferTime = generatePTime(0, 0, 0, 0, 0, 1, 0)
 	mpd.Xmlns = MPDXMLNS
-	mpd.Period = this.createPeriod(startNumber)
+	mpd.Period = this.createPeriod(startNumber, timeline)
 
 	buf, err := xml.Marshal(mpd)
 
@@ -137,109 +134,67 @@ func (this *mpdCreater) GetXML(id string, startNumber int) (buf []byte) {
 	return data
 }
 
-func (this *mpdCreater) createPeriod(startNumber int) (period []PeriodXML) {
-
-	period = make([]PeriodXML, 0, 2)
-
-	if this.videoHeader != nil {
-		videPeroid := PeriodXML{Id:wssAPI.GenerateGUID()}
-		this.createVidePeroid(startNumber, &videPeroid)
-		period = append(period, videPeroid)
+//音视频是同一个Period里的两个AdaptationSet
+func (this *mpdCreater) createPeriod(startNumber int, timeline []SegmentTimelineDesc) (period []PeriodXML) {
+	if nil == this.video && nil == this.audio {
+		return nil
 	}
-
-	if this.audioHeader != nil {
-		audioPeriod := PeriodXML{Id:wssAPI.GenerateGUID()}
-		this.createAudioPeroid(startNumber, &audioPeriod)
-		period = append(period, audioPeriod)
+	period = make([]PeriodXML, 1)
+	period[0].Id = wssAPI.GenerateGUID()
+	if this.video != nil {
+		period[0].AdaptationSet = append(period[0].AdaptationSet, this.createVideoAdaptationSet(startNumber, timeline))
 	}
-
-	if len(period) == 0 {
-		return nil
+	if this.audio != nil {
+		period[0].AdaptationSet = append(period[0].AdaptationSet, this.createAudioAdaptationSet(startNumber, timeline))
 	}
-	return period
+	return
 }
 
-func (this *mpdCreater) createVidePeroid(startNumber int, period *PeriodXML) {
-	ada := make([]AdaptationSetXML, 1)
-	ada[0].MimeType = "video/mp4"
-	var width, height, fps int
-	if this.videoHeader.Data[0] == 0x17 && this.videoHeader.Data[1] == 0 {
-		ada[0].Codecs = "avc1."
-		sps, _ := h264.GetSpsPpsFromAVC(this.videoHeader.Data[5:])
-		str := fmt.Sprintf("%x", sps[1])
-		if len(str) == 1 {
-			ada[0].Codecs += "0"
-		}
-		ada[0].Codecs += str
-
-		str = fmt.Sprintf("%x", sps[2])
-		if len(str) == 1 {
-			ada[0].Codecs += "0"
-		}
-		ada[0].Codecs += str
-
-		str = fmt.Sprintf("%x", sps[3])
-		if len(str) == 1 {
-			ada[0].Codecs += "0"
-		}
-		ada[0].Codecs += str
-		width, height, fps = h264.ParseSPS(sps)
+func (this *mpdCreater) createVideoAdaptationSet(startNumber int, timeline []SegmentTimelineDesc) (ada AdaptationSetXML) {
+	ada.MimeType = "video/mp4"
+	ada.Codecs = mp4.CodecString(this.video)
+
+	ada.SegmentTemplate.Media = Video_PREFIX + "_video0_$Number$_mp4.m4s"
+	ada.SegmentTemplate.Initialization = Video_PREFIX + "_video0_init_mp4.m4s"
+	ada.SegmentTemplate.TimeScale = "1000"
+	ada.SegmentTemplate.StartNumber = strconv.Itoa(startNumber)
+	ada.SegmentTemplate.SegmentTimeline = &SegmentTimelineXML{S: timeline}
+
+	width, height := this.video.Width, this.video.Height
+	ada.Representation = make([]RepresentationXML, 1)
+	ada.Representation[0].Id = "video0"
+	//bandwidth必须有，分辨率不知道时给个默认值
+	bandwidth := width * 1000
+	if 0 == bandwidth {
+		bandwidth = 1000000
 	}
-
-	ada[0].SegmentTemplate.Media = "../video/$RepresentationID$/$Number$.m4s"
-	ada[0].SegmentTemplate.Initialization = "../video/$RepresentationID$/init.mp4"
-	ada[0].SegmentTemplate.TimeScale = "1000"
-	ada[0].SegmentTemplate.StartNumber = strconv.Itoa(startNumber)
-	ada[0].SegmentTemplate.SegmentTimeline=this.createSegmentTimeLine()
-
-	ada[0].Representation = make([]RepresentationXML, 1)
-	ada[0].Representation[0].Id = strconv.Itoa(width) + "_" + strconv.Itoa(height)
-	ada[0].Representation[0].Bandwidth = strconv.Itoa(width * 1000)
-	ada[0].Representation[0].Width = strconv.Itoa(width)
-	ada[0].Representation[0].Height = strconv.Itoa(height)
-	ada[0].Representation[0].FrameRate = strconv.Itoa(fps)
-
-	period.AdaptationSet = ada
-}
-
-func (this *mpdCreater) createAudioPeroid(startNumber int, period *PeriodXML) {
-	ada := make([]AdaptationSetXML, 1)
-
-	ada[0].MimeType = "audio/mp4"
-	ada[0].Lang = "en"
-	sampleFreq := 0
-	channel := 0
-	if this.audioHeader.Data[0]>>4 == flv.SoundFormat_AAC {
-		asc := aac.MP4AudioGetConfig(this.audioHeader.Data[2:])
-		ada[0].Codecs = "mp4a.40."
-		ada[0].Codecs += strconv.Itoa(asc.Object_type)
-		sampleFreq = asc.Sample_rate
-		channel = asc.Channels
+	ada.Representation[0].Bandwidth = strconv.Itoa(bandwidth)
+	ada.Representation[0].Width = strconv.Itoa(width)
+	ada.Representation[0].Height = strconv.Itoa(height)
+	if this.video.Fps > 0 {
+		ada.Representation[0].FrameRate = strconv.Itoa(this.video.Fps)
 	}
-
-	ada[0].AudioChannelConfiguration = &AudioChannelConfigurationXML{}
-	ada[0].AudioChannelConfiguration.SchemeIdUri = SchemeIdUri
-	ada[0].AudioChannelConfiguration.Value = channel
-
-	ada[0].SegmentTemplate.Media = "../video/$RepresentationID$/$Number$.m4s"
-	ada[0].SegmentTemplate.Initialization = "../video/$RepresentationID$/init.mp4"
-	ada[0].SegmentTemplate.TimeScale = strconv.Itoa(sampleFreq)
-	ada[0].SegmentTemplate.StartNumber = strconv.Itoa(startNumber)
-	ada[0].SegmentTemplate.SegmentTimeline=this.createSegmentTimeLine()
-
-	ada[0].Representation = make([]RepresentationXML, 1)
-	ada[0].Representation[0].Id = "1_stereo"
-	ada[0].Representation[0].Bandwidth = "12800"
-	ada[0].Representation[0].AudioSamplingRate = strconv.Itoa(sampleFreq)
-
-	period.AdaptationSet = ada
+	return
 }
 
-func (this *mpdCreater)createSegmentTimeLine()(segTm *SegmentTimelineXML) {
-	//bad time line
-	segTm=&SegmentTimelineXML{}
-	segTm.S=make([]SegmentTimelineDesc,1)
-	segTm.S[0].D="1000"
-
+func (this *mpdCreater) createAudioAdaptationSet(startNumber int, timeline []SegmentTimelineDesc) (ada AdaptationSetXML) {
+	ada.MimeType = "audio/mp4"
+	ada.Lang = "en"
+	ada.Codecs = mp4.CodecString(this.audio)
+
+	ada.AudioChannelConfiguration = &AudioChannelConfigurationXML{}
+	ada.AudioChannelConfiguration.SchemeIdUri = SchemeIdUri
+	ada.AudioChannelConfiguration.Value = this.audio.Channels
+
+	ada.SegmentTemplate.Media = Audio_PREFIX + "_audio0_$Number$_mp4.m4s"
+	ada.SegmentTemplate.Initialization = Audio_PREFIX + "_audio0_init_mp4.m4s"
+	ada.SegmentTemplate.TimeScale = "1000"
+	ada.SegmentTemplate.StartNumber = strconv.Itoa(startNumber)
+	ada.SegmentTemplate.SegmentTimeline = &SegmentTimelineXML{S: timeline}
+
+	ada.Representation = make([]RepresentationXML, 1)
+	ada.Representation[0].Id = "audio0"
+	ada.Representation[0].Bandwidth = "128000"
+	ada.Representation[0].AudioSamplingRate = strconv.Itoa(this.audio.SampleRate)
 	return
-}
\ No newline at end of file
+}
@@ -3,7 +3,7 @@ package HLSService
 import (
 	"logger"
 	"mediaTypes/aac"
-	"mediaTypes/flv"
+	"wssAPI"
 )
 
 //may be aac or mp3 or other
@@ -13,26 +13,26 @@ type audioCache struct {
 	aacCache  *aac.AACCreater
 }
 
-func (this *audioCache) Init(tag *flv.FlvTag) {
-	this.audioType = int((tag.Data[0] >> 4) & 0xf)
-	if this.audioType == flv.SoundFormat_AAC {
+func (this *audioCache) Init(cfg *wssAPI.CodecConfig) {
+	this.audioType = cfg.CodecId
+	if this.audioType == wssAPI.CODEC_AAC {
 		this.aacCache = &aac.AACCreater{}
-		this.aacCache.Init(tag.Data[2:])
+		this.aacCache.Init(cfg.Data)
 	} else {
 		logger.LOGW("sound fmt not processed")
 	}
 }
 
-func (this *audioCache) AddTag(tag *flv.FlvTag) {
-	if this.audioType == flv.SoundFormat_AAC {
-		this.aacCache.Add(tag.Data[2:])
+func (this *audioCache) AddPacket(pkt *wssAPI.MediaPacket) {
+	if this.audioType == wssAPI.CODEC_AAC {
+		this.aacCache.Add(pkt.Data)
 	} else {
 		//		logger.LOGW("sound fmt not processed")
 	}
 }
 
 func (this *audioCache) Flush() (data []byte) {
-	if this.audioType == flv.SoundFormat_AAC {
+	if this.audioType == wssAPI.CODEC_AAC {
 		data = this.aacCache.Flush()
 		return data
 	} else {
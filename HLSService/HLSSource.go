@@ -6,7 +6,6 @@ import (
 	"events/eStreamerEvent"
 	"fmt"
 	"logger"
-	"mediaTypes/flv"
 	"mediaTypes/ts"
 	"net/http"
 	"os"
@@ -38,8 +37,8 @@ type HLSSource struct {
 	streamName   string
 	urlPref      string
 	clientId     string
-	audioHeader  *flv.FlvTag
-	videoHeader  *flv.FlvTag
+	audioHeader  *wssAPI.MediaPacket
+	videoHeader  *wssAPI.MediaPacket
 	segIdx       int64
 	tsCur        *ts.TsCreater
 	audioCur     *audioCache
@@ -128,6 +127,11 @@ func (this *HLSSource) Stop(msg *wssAPI.Msg) (err error) {
 	return
 }
 
+//ts直接用MediaPacket封装，不再解析flv tag
+func (this *HLSSource) AcceptMediaPacket() bool {
+	return true
+}
+
 func (this *HLSSource) GetType() string {
 	return ""
 }
@@ -155,9 +159,9 @@ func (this *HLSSource) ProcessMessage(msg *wssAPI.Msg) (err error) {
 	case wssAPI.MSG_PLAY_STOP:
 		//hls 停止就结束移除，不像RTMP等待
 		this.Stop(nil)
-	case wssAPI.MSG_FLV_TAG:
-		tag := msg.Param1.(*flv.FlvTag)
-		this.AddFlvTag(tag)
+	case wssAPI.MSG_MEDIA_PACKET:
+		pkt := msg.Param1.(*wssAPI.MediaPacket)
+		this.AddPacket(pkt)
 	default:
 		logger.LOGT(msg.Type)
 	}
@@ -298,43 +302,52 @@ func (this *HLSSource) serveVideo(w http.ResponseWriter, req *http.Request, para
 	}
 }
 
-func (this *HLSSource) AddFlvTag(tag *flv.FlvTag) {
-	if this.audioHeader == nil && tag.TagType == flv.FLV_TAG_Audio {
-		this.audioHeader = tag.Copy()
+//pkt只在调用期间有效，要留下来的头拷贝一份
+func (this *HLSSource) AddPacket(pkt *wssAPI.MediaPacket) {
+	if pkt.IsConfig {
+		header := &wssAPI.MediaPacket{}
+		*header = *pkt
+		header.Data = make([]byte, len(pkt.Data))
+		copy(header.Data, pkt.Data)
+		header.Ref = nil
+		if pkt.MediaType == wssAPI.MEDIA_TYPE_AUDIO && this.audioHeader == nil {
+			this.audioHeader = header
+		} else if pkt.MediaType == wssAPI.MEDIA_TYPE_VIDEO && this.videoHeader == nil {
+			this.videoHeader = header
+		}
 		return
 	}
-	if this.videoHeader == nil && tag.TagType == flv.FLV_TAG_Video {
-		this.videoHeader = tag.Copy()
+	if pkt.MediaType == wssAPI.MEDIA_TYPE_DATA {
 		return
 	}
 
 	//if idr,new slice
-	if tag.TagType == flv.FLV_TAG_Video && tag.Data[0] == 0x17 && tag.Data[1] == 1 {
-		this.createNewTSSegment(tag)
+	if pkt.MediaType == wssAPI.MEDIA_TYPE_VIDEO && pkt.Keyframe {
+		this.createNewTSSegment(pkt)
 	} else {
-		this.appendTag(tag)
+		this.appendPacket(pkt)
 	}
 }
 
-func (this *HLSSource) createNewTSSegment(keyframe *flv.FlvTag) {
+func (this *HLSSource) createNewTSSegment(keyframe *wssAPI.MediaPacket) {
 
 	if this.tsCur == nil {
 		this.tsCur = &ts.TsCreater{}
 		if this.audioHeader != nil {
-			this.tsCur.AddTag(this.audioHeader)
+			this.tsCur.AddPacket(this.audioHeader)
 
 			this.audioCur = &audioCache{}
-			this.audioCur.Init(this.audioHeader)
+			this.audioCur.Init(this.audioHeader.Config)
 		}
 		if this.videoHeader != nil {
-			this.tsCur.AddTag(this.videoHeader)
+			this.tsCur.AddPacket(this.videoHeader)
 		}
-		this.tsCur.AddTag(keyframe)
+		this.tsCur.AddPacket(keyframe)
 
 	} else {
 		//flush data
 		if this.tsCur.GetDuration() < 10000 {
-			this.appendTag(keyframe)
+			this.appendPacket(keyframe)
 			return
 		}
 		data := this.tsCur.FlushTsList()
@@ -382,30 +395,28 @@ func (this *HLSSource) createNewTSSegment(keyframe *flv.FlvTag) {
 				fpaac, _ := os.Create("aac.aac")
 				defer fpaac.Close()
 				fpaac.Write(aacData)
-				this.audioCur.Init(this.audioHeader)
+				this.audioCur.Init(this.audioHeader.Config)
 			}
 		}
 
 		this.tsCur.Reset()
 		//		this.tsCur = &ts.TsCreater{}
 		//		if this.videoHeader != nil {
-		//			this.tsCur.AddTag(this.videoHeader)
+		//			this.tsCur.AddPacket(this.videoHeader)
 		//		}
-		this.tsCur.AddTag(keyframe)
+		this.tsCur.AddPacket(keyframe)
 
 	}
 }
 
-func (this *HLSSource) appendTag(tag *flv.FlvTag) {
+func (this *HLSSource) appendPacket(pkt *wssAPI.MediaPacket) {
 	if this.tsCur != nil {
-		if this.beginTime == 0 && tag.Timestamp > 0 {
-			this.beginTime = tag.Timestamp
+		if this.beginTime == 0 && pkt.Dts > 0 {
+			this.beginTime = pkt.Dts
 		}
-		tagIn := tag.Copy()
-		//tagIn.Timestamp-=this.beginTime
-		this.tsCur.AddTag(tagIn)
+		this.tsCur.AddPacket(pkt)
 	}
-	if flv.FLV_TAG_Audio == tag.TagType && this.audioCur != nil {
-		this.audioCur.AddTag(tag)
+	if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType && this.audioCur != nil {
+		this.audioCur.AddPacket(pkt)
 	}
 }
@@ -0,0 +1,144 @@
+package HTTPFLVService
+
+import (
+	"HTTPMUX"
+	"encoding/json"
+	"errors"
+	"events/eStreamerEvent"
+	"logger"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"wssAPI"
+)
+
+//http://addr/route/app/streamName.flv
+const (
+	flvSuffix              = ".flv"
+	routeDefault           = "/flv/" //"/"会和其他http服务抢路由
+	writeTimeoutSecDefault = 10
+	cacheCountDefault      = 1000
+	maxQueueMsDefault      = 3000
+)
+
+type HTTPFLVService struct {
+	parent wssAPI.Obj
+}
+
+type HTTPFLVConfig struct {
+	Port            int    `json:"Port"`
+	Route           string `json:"Route"`
+	WriteTimeoutSec int    `json:"WriteTimeoutSec"`
+	CacheCount      int    `json:"CacheCount"`
+	MaxQueueMs      int    `json:"MaxQueueMs"` //积压超过它丢帧，3倍断开
+}
+
+var service *HTTPFLVService
+var serviceConfig HTTPFLVConfig
+
+func (this *HTTPFLVService) Init(msg *wssAPI.Msg) (err error) {
+	if nil == msg || nil == msg.Param1 {
+		logger.LOGE("invalid param init http flv server")
+		return errors.New("invalid param")
+	}
+	fileName := msg.Param1.(string)
+	err = this.loadConfigFile(fileName)
+	if err != nil {
+		logger.LOGE(err.Error())
+		return errors.New("load http flv config failed")
+	}
+	service = this
+	strPort := ":" + strconv.Itoa(serviceConfig.Port)
+	HTTPMUX.AddRoute(strPort, serviceConfig.Route, this.ServeHTTP)
+	logger.LOGI("http flv:http://address" + strPort + serviceConfig.Route + "app/streamName" + flvSuffix)
+	return
+}
+
+func (this *HTTPFLVService) loadConfigFile(fileName string) (err error) {
+	data, err := wssAPI.ReadFileAll(fileName)
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &serviceConfig)
+	if err != nil {
+		return
+	}
+	if len(serviceConfig.Route) == 0 {
+		serviceConfig.Route = routeDefault
+	}
+	if serviceConfig.WriteTimeoutSec <= 0 {
+		serviceConfig.WriteTimeoutSec = writeTimeoutSecDefault
+	}
+	if serviceConfig.CacheCount <= 0 {
+		serviceConfig.CacheCount = cacheCountDefault
+	}
+	if serviceConfig.MaxQueueMs <= 0 {
+		serviceConfig.MaxQueueMs = maxQueueMsDefault
+	}
+	return
+}
+
+func (this *HTTPFLVService) Start(msg *wssAPI.Msg) (err error) {
+	return
+}
+
+func (this *HTTPFLVService) Stop(msg *wssAPI.Msg) (err error) {
+	return
+}
+
+func (this *HTTPFLVService) GetType() string {
+	return wssAPI.OBJ_HTTPFLVServer
+}
+
+func (this *HTTPFLVService) HandleTask(task wssAPI.Task) (err error) {
+	return
+}
+
+func (this *HTTPFLVService) ProcessMessage(msg *wssAPI.Msg) (err error) {
+	return
+}
+
+func (this *HTTPFLVService) SetParent(parent wssAPI.Obj) {
+	this.parent = parent
+}
+
+func (this *HTTPFLVService) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, serviceConfig.Route)
+	path = strings.Trim(path, "/")
+	if false == strings.HasSuffix(path, flvSuffix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	streamName := strings.TrimSuffix(path, flvSuffix)
+
+	sink := newFlvSink(streamName)
+	taskAddSink := &eStreamerEvent.EveAddSink{StreamName: streamName, SinkId: sink.id, Sinker: sink}
+	err := wssAPI.HandleTask(taskAddSink)
+	if err != nil {
+		logger.LOGE("add http flv sink failed:" + err.Error())
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer func() {
+		taskDelSink := &eStreamerEvent.EveDelSink{StreamName: streamName, SinkId: sink.id}
+		wssAPI.HandleTask(taskDelSink)
+		sink.release()
+		logger.LOGT("http flv closed:" + req.RemoteAddr)
+	}()
+	//本地没有源时去上游拉，结果异步通知
+	if false == taskAddSink.Added && false == sink.waitSource(time.Duration(serviceConfig.WriteTimeoutSec)*time.Second) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	logger.LOGT("http flv play " + streamName + " " + req.RemoteAddr)
+	w.Header().Set("Content-Type", "video/x-flv")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusOK)
+	sink.serve(w, req)
+}
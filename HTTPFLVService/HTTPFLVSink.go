@@ -0,0 +1,223 @@
+package HTTPFLVService
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"logger"
+	"mediaTypes/flv"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+	"wssAPI"
+)
+
+//一个http连接一个sink，源的线程往cache里放，请求的goroutine负责写
+type flvSink struct {
+	id         string
+	streamName string
+	mutexCache sync.Mutex
+	cache      *list.List
+	stopped    bool //源停了或者客户端太慢
+	chData     chan bool
+	chSource   chan bool
+	headerSent bool
+	baseTime   uint32
+	baseSeted  bool
+	congestion *flv.CongestionController
+}
+
+func newFlvSink(streamName string) (sink *flvSink) {
+	sink = &flvSink{}
+	sink.id = wssAPI.GenerateGUID()
+	sink.streamName = streamName
+	sink.cache = list.New()
+	sink.chData = make(chan bool, 1)
+	sink.chSource = make(chan bool, 1)
+	sink.congestion = flv.NewCongestionController(serviceConfig.MaxQueueMs)
+	return
+}
+
+func (this *flvSink) Init(msg *wssAPI.Msg) (err error) {
+	return
+}
+
+func (this *flvSink) Start(msg *wssAPI.Msg) (err error) {
+	return
+}
+
+func (this *flvSink) Stop(msg *wssAPI.Msg) (err error) {
+	return
+}
+
+func (this *flvSink) GetType() string {
+	return "httpFlvSink"
+}
+
+func (this *flvSink) HandleTask(task wssAPI.Task) (err error) {
+	return
+}
+
+//加入时从关键帧开始
+func (this *flvSink) AcceptGop() bool {
+	return true
+}
+
+func (this *flvSink) ProcessMessage(msg *wssAPI.Msg) (err error) {
+	switch msg.Type {
+	case wssAPI.MSG_GetSource_NOTIFY:
+		notify(this.chSource, true)
+	case wssAPI.MSG_GetSource_Failed:
+		notify(this.chSource, false)
+	case wssAPI.MSG_FLV_TAG:
+		tag := msg.Param1.(*flv.FlvTag)
+		this.mutexCache.Lock()
+		defer this.mutexCache.Unlock()
+		if this.stopped {
+			return errors.New("http flv sink stopped")
+		}
+		//客户端收得太慢，先丢帧，丢帧也追不上才断掉
+		if this.cache.Len() >= serviceConfig.CacheCount && this.congestion.SkipToKeyFrame() {
+			logger.LOGW(fmt.Sprintf("http flv client %s too slow,%d tags cached", this.id, this.cache.Len()))
+			this.congestion.Purge(this.cache)
+		}
+		keep, purge, errCongestion := this.congestion.Admit(tag)
+		if errCongestion != nil {
+			this.stopped = true
+			notify(this.chData, true)
+			return errors.New("http flv client too slow:" + this.id + "," + errCongestion.Error())
+		}
+		if purge {
+			logger.LOGW("http flv client too slow,skip to next keyframe:" + this.id)
+			this.congestion.Purge(this.cache)
+		}
+		if false == keep {
+			return
+		}
+		this.cache.PushBack(tag.Retain())
+		notify(this.chData, true)
+	case wssAPI.MSG_PLAY_STOP:
+		this.mutexCache.Lock()
+		this.stopped = true
+		this.mutexCache.Unlock()
+		notify(this.chData, true)
+	}
+	return
+}
+
+func notify(ch chan bool, value bool) {
+	select {
+	case ch <- value:
+	default:
+	}
+}
+
+func (this *flvSink) waitSource(timeout time.Duration) bool {
+	select {
+	case ok := <-this.chSource:
+		return ok
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (this *flvSink) takeCache() (tags []*flv.FlvTag, stopped bool) {
+	this.mutexCache.Lock()
+	defer this.mutexCache.Unlock()
+	for e := this.cache.Front(); e != nil; e = this.cache.Front() {
+		tag := e.Value.(*flv.FlvTag)
+		tags = append(tags, tag)
+		this.cache.Remove(e)
+		this.congestion.Sent(tag)
+	}
+	return tags, this.stopped
+}
+
+func (this *flvSink) serve(w http.ResponseWriter, req *http.Request) {
+	//http.ResponseController要go1.20以上
+	rc := http.NewResponseController(w)
+	timeout := time.Duration(serviceConfig.WriteTimeoutSec) * time.Second
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-this.chData:
+		}
+		tags, stopped := this.takeCache()
+		if len(tags) > 0 {
+			rc.SetWriteDeadline(time.Now().Add(timeout))
+			err := this.writeTags(w, tags)
+			if nil == err {
+				err = rc.Flush()
+			}
+			if err != nil {
+				logger.LOGE("write http flv failed:" + err.Error())
+				return
+			}
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+//头等第一批数据到了再写，源加sink时metadata和音视频头是同步发过来的
+func (this *flvSink) writeTags(w http.ResponseWriter, tags []*flv.FlvTag) (err error) {
+	defer func() {
+		for _, tag := range tags {
+			tag.Release()
+		}
+	}()
+	if false == this.headerSent {
+		hasAudio, hasVideo := false, false
+		for _, tag := range tags {
+			hasAudio = hasAudio || tag.TagType == flv.FLV_TAG_Audio
+			hasVideo = hasVideo || tag.TagType == flv.FLV_TAG_Video
+		}
+		_, err = w.Write(flv.FlvHeader(hasAudio, hasVideo))
+		if err != nil {
+			return
+		}
+		this.headerSent = true
+	}
+	//tag的data是所有观看者共用的，不拷贝，只有头是每个连接自己的
+	//头都放在一块内存里，容量够不会重新分配，切片一直有效
+	headers := make([]byte, 0, len(tags)*15)
+	bufs := make(net.Buffers, 0, len(tags)*3)
+	for _, tag := range tags {
+		start := len(headers)
+		headers = flv.AppendTagHeader(headers, tag.TagType, len(tag.Data), this.rebase(tag))
+		bufs = append(bufs, headers[start:], tag.Data)
+		start = len(headers)
+		headers = flv.AppendPreviousTagSize(headers, len(tag.Data))
+		bufs = append(bufs, headers[start:])
+	}
+	_, err = bufs.WriteTo(w)
+	return
+}
+
+//时间戳从第一个音视频帧开始算，头都是0
+func (this *flvSink) rebase(tag *flv.FlvTag) (timestamp uint32) {
+	if flv.IsAudioSequenceHeader(tag) || flv.IsVideoSequenceHeader(tag) || tag.TagType == flv.FLV_TAG_ScriptData {
+		return 0
+	}
+	if false == this.baseSeted {
+		this.baseTime = tag.Timestamp
+		this.baseSeted = true
+	}
+	if tag.Timestamp < this.baseTime {
+		return 0
+	}
+	return tag.Timestamp - this.baseTime
+}
+
+func (this *flvSink) release() {
+	this.mutexCache.Lock()
+	defer this.mutexCache.Unlock()
+	this.stopped = true
+	for e := this.cache.Front(); e != nil; e = e.Next() {
+		e.Value.(*flv.FlvTag).Release()
+	}
+	this.cache.Init()
+}
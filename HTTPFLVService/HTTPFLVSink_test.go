@@ -0,0 +1,45 @@
+package HTTPFLVService
+
+import (
+	"bytes"
+	"mediaTypes/flv"
+	"net/http/httptest"
+	"testing"
+)
+
+//头和PreviousTagSize单独写，和EncodeFlvTag拼出来的一样，共享的tag不能被改
+func TestWriteTagsRebase(t *testing.T) {
+	tags := []*flv.FlvTag{
+		{TagType: flv.FLV_TAG_ScriptData, Timestamp: 5000, Data: []byte{2, 0, 1, 'a'}},
+		{TagType: flv.FLV_TAG_Video, Timestamp: 5000, Data: []byte{0x17, 0, 0, 0, 0, 1}},
+		{TagType: flv.FLV_TAG_Video, Timestamp: 5000, Data: []byte{0x17, 1, 0, 0, 0, 2, 3}},
+		{TagType: flv.FLV_TAG_Audio, Timestamp: 5040, Data: []byte{0xaf, 1, 4}},
+		{TagType: flv.FLV_TAG_Video, Timestamp: 4990, Data: []byte{0x27, 1, 0, 0, 0, 5}},
+		{TagType: flv.FLV_TAG_Video, Timestamp: 0x1000000 + 5000, Data: []byte{0x27, 1, 0, 0, 0, 6}},
+	}
+	timestamps := []uint32{0, 0, 0, 40, 0, 0x1000000}
+	origins := make([]flv.FlvTag, len(tags))
+	expected := flv.FlvHeader(true, true)
+	for i, tag := range tags {
+		origins[i] = *tag
+		origins[i].Data = append([]byte(nil), tag.Data...)
+		expected = append(expected, flv.EncodeFlvTag(&flv.FlvTag{TagType: tag.TagType, Timestamp: timestamps[i], Data: tag.Data})...)
+	}
+
+	sink := &flvSink{}
+	w := httptest.NewRecorder()
+	if err := sink.writeTags(w, tags); err != nil {
+		t.Fatal(err)
+	}
+	if false == bytes.Equal(w.Body.Bytes(), expected) {
+		t.Errorf("output mismatch\n got %x\nwant %x", w.Body.Bytes(), expected)
+	}
+	for i, tag := range tags {
+		if tag.Timestamp != origins[i].Timestamp {
+			t.Errorf("tag %d: shared timestamp rebased", i)
+		}
+		if false == bytes.Equal(tag.Data, origins[i].Data) {
+			t.Errorf("tag %d: shared data modified", i)
+		}
+	}
+}
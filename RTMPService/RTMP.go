@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"logger"
 	"math/rand"
+	"mediaTypes/amf"
 	"mediaTypes/flv"
 	"net"
 	"strconv"
@@ -16,7 +17,8 @@ const (
 	RTMP_protocol_rtmp      = "rtmp"
 	RTMP_default_chunk_size = 128
 	RTMP_default_buff_ms    = 500
-	RTMP_better_chunk_size  = 128
+	RTMP_better_chunk_size  = 4096
+	RTMP_max_chunk_size     = 0xffffff
 
 	RTMP_channel_control      = 0x02
 	RTMP_channel_Invoke       = 0x03
@@ -64,6 +66,9 @@ const (
 	RTMP_HEADER_TYPE_3 = 3
 )
 
+//basic header 3 + message header 11 + 扩展时间戳 4
+const rtmp_max_chunk_header = 18
+
 type RTMP_LINK struct {
 	Protocol    string
 	App         string
@@ -88,6 +93,18 @@ type RTMPPacket struct {
 	MessageStreamId uint32
 	Body            []byte
 	BodyReaded      int32
+	timeDelta       uint32 //接收时fmt 3的新消息沿用上一个时间差
+	extTimestamp    bool   //接收时fmt 3的chunk也带扩展时间戳
+}
+
+//发送时每个chunk stream上一个消息的头，用来压缩
+type rtmpChunkHeader struct {
+	timestamp       uint32
+	delta           uint32
+	hasDelta        bool
+	messageLength   uint32
+	messageTypeId   byte
+	messageStreamId uint32
 }
 
 type RTMP struct {
@@ -105,8 +122,18 @@ type RTMP struct {
 	LimitType                 uint32
 	BytesIn                   int64
 	BytesInLast               int64
+	BytesOut                  int64
+	PeerBW                    uint32 //对端限制我们的发送窗口
+	PeerLimitType             uint32
+	bytesAcked                int64
+	ackReceived               bool
+	mutexAck                  sync.Mutex
+	chAck                     chan bool
+	mutexSend                 sync.Mutex
+	ObjectEncoding            float64 //connect时协商，0为amf0,3为amf3
 	buffMS                    uint32
 	recvCache                 map[int32]*RTMPPacket
+	sendHeaders               map[int32]*rtmpChunkHeader
 	methodCache               map[int32]string
 	mutexMethod               sync.RWMutex
 }
@@ -126,13 +153,7 @@ func (this *RTMPPacket) Copy() (dst *RTMPPacket) {
 }
 
 func (this *RTMPPacket) ToFLVTag() (dst *flv.FlvTag) {
-	dst = &flv.FlvTag{}
-	dst.TagType = this.MessageTypeId
-	dst.StreamID = 0
-	dst.Timestamp = this.TimeStamp
-	//dst.Data = make([]byte, len(this.Body))
-	//copy(dst.Data, this.Body)
-	dst.Data = this.Body
+	dst = flv.NewSharedTag(this.MessageTypeId, this.TimeStamp, this.Body)
 	return
 }
 
@@ -159,9 +180,12 @@ func (this *RTMP) Init(conn net.Conn) {
 	this.TargetBW = 2500000
 	this.AcknowledgementWindowSize = 0
 	this.SelfBW = 2500000
-	this.LimitType = 2
+	this.LimitType = RTMP_limit_dynamic
+	this.PeerLimitType = RTMP_limit_dynamic
+	this.chAck = make(chan bool, 1)
 	this.buffMS = RTMP_default_buff_ms
 	this.recvCache = make(map[int32]*RTMPPacket)
+	this.sendHeaders = make(map[int32]*rtmpChunkHeader)
 	this.methodCache = make(map[int32]string)
 }
 
@@ -226,33 +250,37 @@ func (this *RTMP) ReadChunk() (packet *RTMPPacket, err error) {
 			return nil, err
 		}
 		tmpPkt := this.recvCache[chunkId]
-		tmpPkt.TimeStamp, _ = AMF0DecodeInt24(buf)
-		tmpPkt.MessageLength, _ = AMF0DecodeInt24(buf[3:])
+		tmpPkt.TimeStamp, _ = amf.AMF0DecodeInt24(buf)
+		tmpPkt.MessageLength, _ = amf.AMF0DecodeInt24(buf[3:])
 		tmpPkt.MessageTypeId = buf[6]
-		tmpPkt.MessageStreamId, _ = AMF0DecodeInt32LE(buf[7:])
-		if tmpPkt.TimeStamp == 0xffffff {
+		tmpPkt.MessageStreamId, _ = amf.AMF0DecodeInt32LE(buf[7:])
+		tmpPkt.extTimestamp = tmpPkt.TimeStamp == 0xffffff
+		if tmpPkt.extTimestamp {
 			buf, err = this.rtmpSocketRead(4)
 			if err != nil {
 				return nil, err
 			}
-			tmpPkt.TimeStamp, _ = AMF0DecodeInt32(buf)
+			tmpPkt.TimeStamp, _ = amf.AMF0DecodeInt32(buf)
 		}
+		tmpPkt.timeDelta = tmpPkt.TimeStamp
 	case 1:
 		buf, err := this.rtmpSocketRead(7)
 		if err != nil {
 			return nil, err
 		}
 		tmpPkt := this.recvCache[chunkId]
-		timeDelta, _ := AMF0DecodeInt24(buf)
-		tmpPkt.MessageLength, _ = AMF0DecodeInt24(buf[3:])
+		timeDelta, _ := amf.AMF0DecodeInt24(buf)
+		tmpPkt.MessageLength, _ = amf.AMF0DecodeInt24(buf[3:])
 		tmpPkt.MessageTypeId = buf[6]
-		if timeDelta == 0xffffff {
+		tmpPkt.extTimestamp = timeDelta == 0xffffff
+		if tmpPkt.extTimestamp {
 			buf, err = this.rtmpSocketRead(4)
 			if err != nil {
 				return nil, err
 			}
-			timeDelta, _ = AMF0DecodeInt32(buf)
+			timeDelta, _ = amf.AMF0DecodeInt32(buf)
 		}
+		tmpPkt.timeDelta = timeDelta
 		tmpPkt.TimeStamp = timeAdd(tmpPkt.TimeStamp, timeDelta)
 	case 2:
 		buf, err := this.rtmpSocketRead(3)
@@ -262,18 +290,30 @@ func (this *RTMP) ReadChunk() (packet *RTMPPacket, err error) {
 		}
 
 		tmpPkt := this.recvCache[chunkId]
-		timeDelta, _ := AMF0DecodeInt24(buf)
-		if timeDelta == 0xffffff {
+		timeDelta, _ := amf.AMF0DecodeInt24(buf)
+		tmpPkt.extTimestamp = timeDelta == 0xffffff
+		if tmpPkt.extTimestamp {
 			buf, err = this.rtmpSocketRead(4)
 			if err != nil {
 				return nil, err
 			}
-			timeDelta, _ = AMF0DecodeInt32(buf)
+			timeDelta, _ = amf.AMF0DecodeInt32(buf)
 		}
+		tmpPkt.timeDelta = timeDelta
 		tmpPkt.TimeStamp = timeAdd(tmpPkt.TimeStamp, timeDelta)
 
 	case 3:
-
+		tmpPkt := this.recvCache[chunkId]
+		if tmpPkt.extTimestamp {
+			_, err = this.rtmpSocketRead(4)
+			if err != nil {
+				return nil, err
+			}
+		}
+		//fmt 3开始的新消息，时间差和上一个一样
+		if tmpPkt.BodyReaded == int32(tmpPkt.MessageLength) {
+			tmpPkt.TimeStamp = timeAdd(tmpPkt.TimeStamp, tmpPkt.timeDelta)
+		}
 	}
 	//接收chunk data
 	tmpPkt, ok := this.recvCache[chunkId]
@@ -284,13 +324,10 @@ func (this *RTMP) ReadChunk() (packet *RTMPPacket, err error) {
 		logger.LOGE("why 0....message length")
 		return
 	}
-	if chunkfmt != 3 {
-		tmpPkt.Body = make([]byte, tmpPkt.MessageLength)
+	//每个消息新申请body,完整的包直接交出去，不再拷贝
+	if chunkfmt != 3 || tmpPkt.BodyReaded == int32(tmpPkt.MessageLength) {
+		tmpPkt.Body = wssAPI.AllocBuffer(int(tmpPkt.MessageLength))
 		tmpPkt.BodyReaded = 0
-	} else {
-		if tmpPkt.BodyReaded == int32(tmpPkt.MessageLength) {
-			tmpPkt.BodyReaded = 0
-		}
 	}
 	//接收小于等于一个chunksize的数据
 	recvsize := tmpPkt.MessageLength - uint32(tmpPkt.BodyReaded)
@@ -312,7 +349,9 @@ func (this *RTMP) ReadChunk() (packet *RTMPPacket, err error) {
 	tmpPkt.BodyReaded += int32(recvsize)
 	//判断是否收到一个完整的包
 	if tmpPkt.BodyReaded == int32(tmpPkt.MessageLength) {
-		packet = tmpPkt.Copy()
+		packet = &RTMPPacket{}
+		*packet = *tmpPkt
+		tmpPkt.Body = nil
 	}
 	return
 }
@@ -322,155 +361,197 @@ func (this *RTMP) rtmpSocketRead(size int) (data []byte, err error) {
 	if err != nil {
 		return
 	}
-	if this.AcknowledgementWindowSize > 0 {
-		this.BytesIn += int64(len(data))
-		if this.BytesIn > int64(this.AcknowledgementWindowSize/10)+this.BytesInLast {
-			this.BytesInLast = this.BytesIn
-			this.sendAcknowledgement()
-		}
-	}
+	err = this.countBytesIn(len(data))
 	return
 }
 
 func (this *RTMP) SendPacket(packet *RTMPPacket, queue bool) (err error) {
-	//基本头
-	encoder := &AMF0Encoder{}
-	encoder.Init()
-	var tmp8 byte
-	if packet.ChunkStreamID < 63 {
-		tmp8 = (packet.Fmt << 6) | byte(packet.ChunkStreamID)
-		encoder.AppendByte(tmp8)
-	} else if packet.ChunkStreamID < 319 {
-		tmp8 = (packet.Fmt << 6)
-		encoder.AppendByte(tmp8)
-		encoder.AppendByte(byte(packet.ChunkStreamID - 64))
-	} else if packet.ChunkStreamID < 65599 {
-		tmp8 = (packet.Fmt << 6) | byte(1)
-		encoder.AppendByte(tmp8)
-		encoder.AppendByte(byte(packet.ChunkStreamID-64) & 0xff)
-		encoder.AppendByte(byte(packet.ChunkStreamID-64) >> 8)
-	} else {
-		return errors.New(fmt.Sprintf("chunk stream id:%d invalid", packet.ChunkStreamID))
-	}
-	//消息头
-	if packet.Fmt <= 2 {
-		//只有时间
-		if packet.TimeStamp >= 0xffffff {
-			encoder.EncodeInt24(0xffffff)
-		} else {
-			encoder.EncodeInt24(int32(packet.TimeStamp))
-		}
-	}
-	if packet.Fmt <= 1 {
-		//有长度，类型
-		encoder.EncodeInt24(int32(packet.MessageLength))
-		encoder.AppendByte(byte(packet.MessageTypeId))
-	}
-	if packet.Fmt == 0 {
-		//有流id
-		encoder.EncodeInt32LittleEndian(int32(packet.MessageStreamId))
-	}
-	//时间扩展
-	if packet.TimeStamp >= 0xffffff {
-		encoder.EncodeInt32(int32(packet.TimeStamp))
-	}
-	//消息
-	var bodySended uint32
-	bodySended = 0
-	sendSize := packet.MessageLength
-	if sendSize > this.SendChunkSize {
-		sendSize = this.SendChunkSize
-	}
-	encoder.AppendByteArray(packet.Body[:sendSize])
-	buf, err := encoder.GetData()
-	if err != nil {
-		return
-	}
-
-	_, err = wssAPI.TcpWrite(this.Conn, buf)
+	err = this.SendPackets([]*RTMPPacket{packet})
 	if err != nil {
 		return
 	}
-	bodySended += sendSize
-	//剩下的消息
-	packet.Fmt = 3
-	for bodySended < packet.MessageLength {
-		encoder3 := &AMF0Encoder{}
-		encoder3.Init()
-		if packet.ChunkStreamID < 63 {
-			tmp8 = (packet.Fmt << 6) | byte(packet.ChunkStreamID)
-			encoder3.AppendByte(tmp8)
-		} else if packet.ChunkStreamID < 319 {
-			tmp8 = (packet.Fmt << 6)
-			encoder3.AppendByte(tmp8)
-			encoder3.AppendByte(byte(packet.ChunkStreamID - 64))
-		} else if packet.ChunkStreamID < 65599 {
-			tmp8 = (packet.Fmt << 6) | byte(1)
-			encoder3.AppendByte(tmp8)
-			encoder3.AppendByte(byte(packet.ChunkStreamID-64) & 0xff)
-			encoder3.AppendByte(byte(packet.ChunkStreamID-64) >> 8)
-		}
-		sendSize = packet.MessageLength - bodySended
-		if sendSize > this.SendChunkSize {
-			sendSize = this.SendChunkSize
-		}
-		encoder3.AppendByteArray(packet.Body[bodySended : bodySended+sendSize])
-		buf, err := encoder3.GetData()
+	if RTMP_PACKET_TYPE_INVOKE == packet.MessageTypeId && queue {
+		cmdName, err := amf.AMF0DecodeString(packet.Body[1:])
 		if err != nil {
 			return err
 		}
-		_, err = wssAPI.TcpWrite(this.Conn, buf)
+		transactionId, err := amf.AMF0DecodeNumber(packet.Body[4+len(cmdName):])
 		if err != nil {
 			return err
 		}
-		bodySended += sendSize
+		this.mutexMethod.Lock()
+		this.methodCache[int32(transactionId)] = cmdName
+		this.mutexMethod.Unlock()
 	}
-	if RTMP_PACKET_TYPE_INVOKE == packet.MessageTypeId && queue {
-		cmdName, err := AMF0DecodeString(packet.Body[1:])
+	return
+}
+
+//多个消息编码成chunk，一次writev发出去
+//消息头按chunk stream上一个消息压缩成fmt 1/2/3，调用者设置的Fmt不再使用
+func (this *RTMP) SendPackets(packets []*RTMPPacket) (err error) {
+	return this.SendPacketsUntil(packets, nil)
+}
+
+//对端窗口满了分几次发，chStop关掉时不再等确认
+func (this *RTMP) SendPacketsUntil(packets []*RTMPPacket, chStop chan bool) (err error) {
+	for len(packets) > 0 {
+		count, err := this.waitSendWindow(packets, chStop)
 		if err != nil {
 			return err
 		}
-		transactionId, err := AMF0DecodeNumber(packet.Body[4+len(cmdName):])
+		//多个线程会发，chunk不能交错
+		this.mutexSend.Lock()
+		err = this.writePackets(packets[:count])
+		this.mutexSend.Unlock()
 		if err != nil {
 			return err
 		}
-		this.mutexMethod.Lock()
-		this.methodCache[int32(transactionId)] = cmdName
-		this.mutexMethod.Unlock()
+		packets = packets[count:]
+	}
+	return
+}
+
+func (this *RTMP) writePackets(packets []*RTMPPacket) (err error) {
+	chunks := 0
+	for _, packet := range packets {
+		if int(packet.MessageLength) > len(packet.Body) {
+			return errors.New(fmt.Sprintf("message length %d bigger than body %d",
+				packet.MessageLength, len(packet.Body)))
+		}
+		chunks += int((packet.MessageLength+this.SendChunkSize-1)/this.SendChunkSize) + 1
+	}
+	//头都放在一块内存里，容量够不会重新分配，切片一直有效
+	headers := make([]byte, 0, chunks*rtmp_max_chunk_header)
+	bufs := make(net.Buffers, 0, chunks*2)
+	for _, packet := range packets {
+		var extTimestamp uint32
+		var hasExt bool
+		start := len(headers)
+		headers, extTimestamp, hasExt = this.appendMessageHeader(headers, packet)
+		bodySended := uint32(0)
+		for {
+			sendSize := packet.MessageLength - bodySended
+			if sendSize > this.SendChunkSize {
+				sendSize = this.SendChunkSize
+			}
+			bufs = append(bufs, headers[start:])
+			if sendSize > 0 {
+				bufs = append(bufs, packet.Body[bodySended:bodySended+sendSize])
+			}
+			bodySended += sendSize
+			if bodySended >= packet.MessageLength {
+				break
+			}
+			//后续chunk用fmt 3,有扩展时间戳也要带上
+			start = len(headers)
+			headers = appendBasicHeader(headers, RTMP_HEADER_TYPE_3, packet.ChunkStreamID)
+			if hasExt {
+				headers = appendUint32(headers, extTimestamp)
+			}
+		}
+	}
+	return this.rtmpSocketWriteBuffers(bufs)
+}
+
+func appendBasicHeader(buf []byte, chunkFmt byte, chunkStreamId int32) []byte {
+	if chunkStreamId < 64 {
+		return append(buf, chunkFmt<<6|byte(chunkStreamId))
+	} else if chunkStreamId < 320 {
+		return append(buf, chunkFmt<<6, byte(chunkStreamId-64))
+	}
+	id := chunkStreamId - 64
+	return append(buf, chunkFmt<<6|1, byte(id&0xff), byte(id>>8))
+}
+
+func appendUint24(buf []byte, value uint32) []byte {
+	return append(buf, byte(value>>16), byte(value>>8), byte(value))
+}
+
+func appendUint32(buf []byte, value uint32) []byte {
+	return append(buf, byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+}
+
+//fmt 0:第一个消息，流id变了或者时间戳回退
+//fmt 1:长度或类型变了，fmt 2:只有时间差变了，fmt 3:时间差也和上一个一样
+func (this *RTMP) appendMessageHeader(buf []byte, packet *RTMPPacket) (ret []byte, timeField uint32, hasExt bool) {
+	chunkFmt := byte(RTMP_HEADER_TYPE_0)
+	timeField = packet.TimeStamp
+	last := this.sendHeaders[packet.ChunkStreamID]
+	if last != nil && last.messageStreamId == packet.MessageStreamId &&
+		packet.TimeStamp >= last.timestamp {
+		timeField = packet.TimeStamp - last.timestamp
+		if last.messageLength != packet.MessageLength || last.messageTypeId != packet.MessageTypeId {
+			chunkFmt = RTMP_HEADER_TYPE_1
+		} else if last.hasDelta && last.delta == timeField {
+			chunkFmt = RTMP_HEADER_TYPE_3
+		} else {
+			chunkFmt = RTMP_HEADER_TYPE_2
+		}
+	}
+	if nil == last {
+		last = &rtmpChunkHeader{}
+		this.sendHeaders[packet.ChunkStreamID] = last
+	}
+	last.timestamp = packet.TimeStamp
+	last.delta = timeField
+	last.hasDelta = chunkFmt != RTMP_HEADER_TYPE_0
+	last.messageLength = packet.MessageLength
+	last.messageTypeId = packet.MessageTypeId
+	last.messageStreamId = packet.MessageStreamId
+
+	hasExt = timeField >= 0xffffff
+	ret = appendBasicHeader(buf, chunkFmt, packet.ChunkStreamID)
+	if chunkFmt <= RTMP_HEADER_TYPE_2 {
+		if hasExt {
+			ret = appendUint24(ret, 0xffffff)
+		} else {
+			ret = appendUint24(ret, timeField)
+		}
+	}
+	if chunkFmt <= RTMP_HEADER_TYPE_1 {
+		ret = appendUint24(ret, packet.MessageLength)
+		ret = append(ret, packet.MessageTypeId)
+	}
+	if chunkFmt == RTMP_HEADER_TYPE_0 {
+		ret = append(ret, byte(packet.MessageStreamId), byte(packet.MessageStreamId>>8),
+			byte(packet.MessageStreamId>>16), byte(packet.MessageStreamId>>24))
+	}
+	if hasExt {
+		ret = appendUint32(ret, timeField)
 	}
 	return
 }
 
 func (this *RTMP) HandleControl(pkt *RTMPPacket) (err error) {
-	ctype, err := AMF0DecodeInt16(pkt.Body)
+	ctype, err := amf.AMF0DecodeInt16(pkt.Body)
 	if err != nil {
 		return
 	}
 	switch ctype {
 	case RTMP_CTRL_streamBegin:
-		streamId, _ := AMF0DecodeInt32(pkt.Body[2:])
+		streamId, _ := amf.AMF0DecodeInt32(pkt.Body[2:])
 		logger.LOGT(fmt.Sprintf("stream begin:%d", streamId))
 	case RTMP_CTRL_streamEof:
-		streamId, _ := AMF0DecodeInt32(pkt.Body[2:])
+		streamId, _ := amf.AMF0DecodeInt32(pkt.Body[2:])
 		logger.LOGT(fmt.Sprintf("stream eof:%d", streamId))
 	case RTMP_CTRL_streamDry:
-		streamId, _ := AMF0DecodeInt32(pkt.Body[2:])
+		streamId, _ := amf.AMF0DecodeInt32(pkt.Body[2:])
 		logger.LOGT(fmt.Sprintf("stream dry:%d", streamId))
 	case RTMP_CTRL_setBufferLength:
-		streamId, _ := AMF0DecodeInt32(pkt.Body[2:])
-		buffMS, _ := AMF0DecodeInt32(pkt.Body[6:])
+		streamId, _ := amf.AMF0DecodeInt32(pkt.Body[2:])
+		buffMS, _ := amf.AMF0DecodeInt32(pkt.Body[6:])
 		this.buffMS = uint32(buffMS)
 		this.StreamId = uint32(streamId)
 		//logger.LOGI(fmt.Sprintf("set buffer length --streamid:%d--buffer length:%d", this.StreamId, this.buffMS))
 	case RTMP_CTRL_streamIsRecorded:
-		streamId, _ := AMF0DecodeInt32(pkt.Body[2:])
+		streamId, _ := amf.AMF0DecodeInt32(pkt.Body[2:])
 		logger.LOGT(fmt.Sprintf("stream %d is recorded", streamId))
 	case RTMP_CTRL_pingRequest:
-		timestamp, _ := AMF0DecodeInt32(pkt.Body[2:])
+		timestamp, _ := amf.AMF0DecodeInt32(pkt.Body[2:])
 		this.pingResponse(timestamp)
 		logger.LOGT(fmt.Sprintf("ping :%d", timestamp))
 	case RTMP_CTRL_pingResponse:
-		timestamp, _ := AMF0DecodeInt32(pkt.Body[2:])
+		timestamp, _ := amf.AMF0DecodeInt32(pkt.Body[2:])
 		logger.LOGF(fmt.Sprintf("pong :%d", timestamp))
 	case RTMP_CTRL_streamBufferEmpty:
 		//logger.LOGT(fmt.Sprintf("buffer empty"))
@@ -490,7 +571,7 @@ func (this *RTMP) pingResponse(timestamp uint32) (err error) {
 	pkt.TimeStamp = 0
 	pkt.MessageStreamId = 0
 
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeInt16(RTMP_CTRL_pingResponse)
 	encoder.EncodeInt32(int32(timestamp))
@@ -511,7 +592,7 @@ func (this *RTMP) sendAcknowledgement() (err error) {
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_BYTES_READ_REPORT
 	pkt.TimeStamp = 0
 	pkt.MessageStreamId = 0
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	param := int32(this.BytesIn & 0xffffffff)
 	encoder.EncodeInt32(param)
@@ -533,7 +614,7 @@ func (this *RTMP) AcknowledgementBW() (err error) {
 	pkt.TimeStamp = 0
 	pkt.MessageStreamId = 0
 
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeInt32(int32(this.TargetBW))
 	pkt.Body, err = encoder.GetData()
@@ -552,7 +633,7 @@ func (this *RTMP) SetPeerBW() (err error) {
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_CLIENT_BW
 
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeInt32(int32(this.SelfBW))
 	encoder.AppendByte(byte(this.LimitType))
@@ -568,67 +649,90 @@ func (this *RTMP) SetPeerBW() (err error) {
 }
 
 func (this *RTMP) SetChunkSize(chunkSize uint32) (err error) {
+	if chunkSize < RTMP_default_chunk_size {
+		chunkSize = RTMP_default_chunk_size
+	}
+	if chunkSize > RTMP_max_chunk_size {
+		chunkSize = RTMP_max_chunk_size
+	}
 	pkt := &RTMPPacket{}
 	pkt.ChunkStreamID = RTMP_channel_control
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_CHUNK_SIZE
 
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeInt32(int32(chunkSize))
-	this.SendChunkSize = chunkSize
-	logger.LOGT(fmt.Sprintf("set chunk size %d", this.SendChunkSize))
 	pkt.Body, err = encoder.GetData()
 	if err != nil {
 		return
 	}
 	pkt.MessageLength = uint32(len(pkt.Body))
-	err = this.SendPacket(pkt, false)
+
+	//这个消息之后的chunk才用新大小，不能和其他线程的发送交错
+	this.mutexSend.Lock()
+	defer this.mutexSend.Unlock()
+	err = this.writePackets([]*RTMPPacket{pkt})
+	if err != nil {
+		return
+	}
+	this.SendChunkSize = chunkSize
+	logger.LOGT(fmt.Sprintf("set chunk size %d", this.SendChunkSize))
 	return
 }
 
-func (this *RTMP) ConnectResult(obj *AMF0Object) (err error) {
+func (this *RTMP) ConnectResult(cmd *RTMPCommand, cmdObj *ConnectCmdObject) (err error) {
 	pkt := &RTMPPacket{}
 	pkt.ChunkStreamID = RTMP_channel_Invoke
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_INVOKE
 
-	encoder := &AMF0Encoder{}
-	encoder.Init()
-	encoder.EncodeString("_result")
-	idx := obj.AMF0GetPropByIndex(1).Value.NumValue
-	encoder.EncodeNumber(idx)
-
-	encoder.AppendByte(AMF0_object)
-	encoder.EncodeNamedString("fmsVer", "FMS/5,0,3,3029")
-	encoder.EncodeNamedNumber("capabilities", 255)
-	encoder.EncodeNamedNumber("mode", 1)
-	encoder.EncodeInt24(AMF0_object_end)
-
-	encoder.AppendByte(AMF0_object)
-	encoder.EncodeNamedString("level", "status")
-	encoder.EncodeNamedString("code", "NetConnection.Connect.Success")
-	encoder.EncodeNamedString("description", "Connection succeeded.")
-	objEncodeNumber := 3.0
-	if obj.Props.Len() == 3 {
-		cmdObj := obj.AMF0GetPropByIndex(2)
-		objEncode := cmdObj.Value.ObjValue.AMF0GetPropByName("objectEncoding")
-		if objEncode != nil {
-			objEncodeNumber = objEncode.Value.NumValue
-			logger.LOGI(objEncodeNumber)
+	idx := cmd.TransactionId
+
+	props := &amf.AMF0Object{}
+	props.AddString("fmsVer", "FMS/5,0,3,3029")
+	props.AddNumber("capabilities", 255)
+	props.AddNumber("mode", 1)
+	//Enhanced RTMP,客户端声明了fourCcList才回复
+	fourCcList := supportedFourCcList(cmdObj.FourCcList)
+	if fourCcList != nil {
+		arr := &amf.AMF0Object{}
+		for _, v := range fourCcList {
+			arr.AddString("", v)
 		}
+		props.AddObject("fourCcList", amf.AMF0_strict_array, arr)
+	}
+	objEncodeNumber := cmdObj.ObjectEncoding
+	if objEncodeNumber != 0 {
+		logger.LOGI(objEncodeNumber)
+	}
+	this.ObjectEncoding = objEncodeNumber
+
+	info := &amf.AMF0Object{}
+	info.AddString("level", "status")
+	info.AddString("code", "NetConnection.Connect.Success")
+	info.AddString("description", "Connection succeeded.")
+	info.AddNumber("objectEncoding", objEncodeNumber)
+	data := &amf.AMF0Object{}
+	data.AddString("version", "5,0,3,3029")
+	info.AddObject("data", amf.AMF0_ecma_array, data)
+
+	encoder := &amf.AMF0Encoder{}
+	encoder.Init()
+	if 3 == objEncodeNumber {
+		//amf3的客户端用flex message回复，对象走AVM+
+		pkt.MessageTypeId = RTMP_PACKET_TYPE_FLEX_MESSAGE
+		encoder.AppendByte(0)
+		encoder.EncodeString("_result")
+		encoder.EncodeNumber(idx)
+		encoder.EncodeAVMPlusObject(props)
+		encoder.EncodeAVMPlusObject(info)
+	} else {
+		encoder.EncodeString("_result")
+		encoder.EncodeNumber(idx)
+		encoder.EncodeObject(props)
+		encoder.EncodeObject(info)
 	}
-	encoder.EncodeNamedNumber("objectEncoding", objEncodeNumber)
-	encoder.EncodeInt16(4)
-	encoder.AppendByte('d')
-	encoder.AppendByte('a')
-	encoder.AppendByte('t')
-	encoder.AppendByte('a')
-	encoder.AppendByte(AMF0_ecma_array)
-	encoder.EncodeInt32(0)
-	encoder.EncodeNamedString("version", "5,0,3,3029")
-	encoder.EncodeInt24(AMF0_object_end)
-	encoder.EncodeInt24(AMF0_object_end)
 
 	pkt.Body, err = encoder.GetData()
 	if err != nil {
@@ -639,22 +743,42 @@ func (this *RTMP) ConnectResult(obj *AMF0Object) (err error) {
 	return
 }
 
+//客户端的fourCcList和服务器支持的取交集，"*"表示都支持
+func supportedFourCcList(clientList []string) (fourCcList []string) {
+	if nil == clientList {
+		return nil
+	}
+	fourCcList = make([]string, 0)
+	for _, fourCC := range clientList {
+		if "*" == fourCC {
+			return flv.SupportedVideoFourCC
+		}
+		for _, v := range flv.SupportedVideoFourCC {
+			if v == fourCC {
+				fourCcList = append(fourCcList, v)
+				break
+			}
+		}
+	}
+	return
+}
+
 func (this *RTMP) CmdError(level string, code string, description string, idx float64) (err error) {
 	pkt := &RTMPPacket{}
 	pkt.ChunkStreamID = RTMP_channel_Invoke
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_INVOKE
 
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeString("_error")
 	encoder.EncodeNumber(idx)
-	encoder.AppendByte(AMF0_null)
-	encoder.AppendByte(AMF0_object)
+	encoder.AppendByte(amf.AMF0_null)
+	encoder.AppendByte(amf.AMF0_object)
 	encoder.EncodeNamedString("level", level)
 	encoder.EncodeNamedString("code", code)
 	encoder.EncodeNamedString("description", description)
-	encoder.EncodeInt24(AMF0_object_end)
+	encoder.EncodeInt24(amf.AMF0_object_end)
 
 	pkt.Body, err = encoder.GetData()
 	if err != nil {
@@ -666,23 +790,30 @@ func (this *RTMP) CmdError(level string, code string, description string, idx fl
 }
 
 func (this *RTMP) CmdStatus(level, code, description, details string, clientId float64, channel int) (err error) {
+	return this.CmdStreamStatus(1, level, code, description, details, clientId, channel)
+}
+
+//NetStream的状态发到对应的流id上
+func (this *RTMP) CmdStreamStatus(streamId uint32, level, code, description, details string, clientId float64, channel int) (err error) {
 	pkt := &RTMPPacket{}
 	pkt.ChunkStreamID = int32(channel)
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_INVOKE
-	pkt.MessageStreamId = 1
-	encoder := &AMF0Encoder{}
+	pkt.MessageStreamId = streamId
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
+	info, err := amf.MarshalObject(&StatusInfo{
+		Level:       level,
+		Code:        code,
+		Description: description,
+		Details:     details,
+		ClientId:    clientId})
+	if err != nil {
+		return
+	}
 	encoder.EncodeString("onStatus")
 	encoder.EncodeNumber(0.0)
-	encoder.AppendByte(AMF0_null)
-	encoder.AppendByte(AMF0_object)
-	encoder.EncodeNamedString("level", level)
-	encoder.EncodeNamedString("code", code)
-	if len(details) > 0 {
-		encoder.EncodeNamedString("details", details)
-	}
-	encoder.EncodeNamedNumber("clientId", clientId)
-	encoder.EncodeInt24(AMF0_object_end)
+	encoder.AppendByte(amf.AMF0_null)
+	encoder.EncodeObject(info)
 	pkt.Body, err = encoder.GetData()
 	if err != nil {
 		return
@@ -698,11 +829,11 @@ func (this *RTMP) CmdNumberResult(idx float64, numValue float64) (err error) {
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_INVOKE
 
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeString("_result")
 	encoder.EncodeNumber(idx)
-	encoder.AppendByte(AMF0_null)
+	encoder.AppendByte(amf.AMF0_null)
 	encoder.EncodeNumber(numValue)
 	pkt.Body, err = encoder.GetData()
 	if err != nil {
@@ -713,11 +844,43 @@ func (this *RTMP) CmdNumberResult(idx float64, numValue float64) (err error) {
 	return
 }
 
+//自定义方法的返回，amf3的客户端和connect一样用flex message
+func (this *RTMP) CmdResult(idx float64, result *amf.AMF0Property) (err error) {
+	pkt := &RTMPPacket{}
+	pkt.ChunkStreamID = RTMP_channel_Invoke
+	pkt.Fmt = 0
+	pkt.MessageTypeId = RTMP_PACKET_TYPE_INVOKE
+
+	encoder := &amf.AMF0Encoder{}
+	encoder.Init()
+	if 3 == this.ObjectEncoding {
+		pkt.MessageTypeId = RTMP_PACKET_TYPE_FLEX_MESSAGE
+		encoder.AppendByte(0)
+	}
+	encoder.EncodeString("_result")
+	encoder.EncodeNumber(idx)
+	encoder.AppendByte(amf.AMF0_null)
+	if 3 == this.ObjectEncoding {
+		encoder.EncodeAVMPlusValue(result)
+	} else {
+		values := &amf.AMF0Object{}
+		values.Props.PushBack(result)
+		encoder.EncodeAMFObj(values)
+	}
+	pkt.Body, err = encoder.GetData()
+	if err != nil {
+		return
+	}
+	pkt.MessageLength = uint32(len(pkt.Body))
+	err = this.SendPacket(pkt, false)
+	return
+}
+
 func (this *RTMP) SendCtrl(ctype int, obj uint32, time uint32) (err error) {
 	pkt := &RTMPPacket{}
 	pkt.ChunkStreamID = RTMP_channel_control
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_CONTROL
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	if ctype == RTMP_CTRL_setBufferLength {
 		encoder.EncodeInt16(int16(ctype))
@@ -743,11 +906,11 @@ func (this *RTMP) OnBWDone() (err error) {
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_INVOKE
 
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeString("onBWDone")
 	encoder.EncodeNumber(0.0)
-	encoder.AppendByte(AMF0_null)
+	encoder.AppendByte(amf.AMF0_null)
 
 	pkt.Body, err = encoder.GetData()
 	if err != nil {
@@ -764,7 +927,7 @@ func (this *RTMP) OnBWCheck() (err error) {
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_INVOKE
 
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeString("_onbwcheck")
 	bwCheckCounts += 1.0
@@ -776,7 +939,7 @@ func (this *RTMP) OnBWCheck() (err error) {
 	}
 	encoder.EncodeString(string(strByte))
 	encoder.EncodeNumber(0)
-	encoder.AppendByte(AMF0_null)
+	encoder.AppendByte(amf.AMF0_null)
 
 	pkt.Body, err = encoder.GetData()
 	if err != nil {
@@ -793,13 +956,13 @@ func (this *RTMP) _OnBWDone() (err error) {
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_INVOKE
 
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeString("_onbwdone")
 	encoder.EncodeNumber(0) //??
 	encoder.EncodeNumber(0) //??
 	encoder.EncodeNumber(0) //??
-	encoder.AppendByte(AMF0_null)
+	encoder.AppendByte(amf.AMF0_null)
 
 	pkt.Body, err = encoder.GetData()
 	if err != nil {
@@ -810,19 +973,19 @@ func (this *RTMP) _OnBWDone() (err error) {
 	return
 }
 
-func (this *RTMP) FCUnpublish() (err error) {
+func (this *RTMP) FCUnpublish(path string) (err error) {
 	pkt := &RTMPPacket{}
 	pkt.ChunkStreamID = RTMP_channel_Invoke
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_INVOKE
 
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeString("FCUnpbulish")
 	this.NumInvokes++
 	encoder.EncodeNumber(float64(this.NumInvokes))
-	encoder.EncodeString(this.Link.Path)
-	encoder.AppendByte(AMF0_null)
+	encoder.EncodeString(path)
+	encoder.AppendByte(amf.AMF0_null)
 	pkt.Body, err = encoder.GetData()
 
 	if err != nil {
@@ -838,7 +1001,7 @@ func (this *RTMP) OnMetadata(data []byte) {
 	pkt.ChunkStreamID = RTMP_channel_Invoke
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_INFO
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeString("onMetaData")
 	encoder.AppendByteArray(data)
@@ -853,12 +1016,12 @@ func (this *RTMP) Connect(publish bool) (err error) {
 	pkt.ChunkStreamID = RTMP_channel_Invoke
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_INVOKE
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeString("connect")
 	this.NumInvokes++
 	encoder.EncodeNumber(float64(this.NumInvokes))
-	encoder.AppendByte(AMF0_object)
+	encoder.AppendByte(amf.AMF0_object)
 	encoder.EncodeNamedString("app", this.Link.App)
 	encoder.EncodeNamedString("flashver", "WIN 18,0,0,232")
 	encoder.EncodeNamedString("tcUrl", this.Link.TcUrl)
@@ -872,7 +1035,7 @@ func (this *RTMP) Connect(publish bool) (err error) {
 		encoder.EncodeNamedNumber("objectEncoding", 3.0)
 	}
 
-	encoder.EncodeInt24(AMF0_object_end)
+	encoder.EncodeInt24(amf.AMF0_object_end)
 
 	pkt.Body, err = encoder.GetData()
 	logger.LOGD(pkt.Body)
@@ -889,12 +1052,12 @@ func (this *RTMP) CreateStream() (err error) {
 	pkt.ChunkStreamID = RTMP_channel_Invoke
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_INVOKE
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeString("createStream")
 	this.NumInvokes++
 	encoder.EncodeNumber(float64(this.NumInvokes))
-	encoder.AppendByte(AMF0_null)
+	encoder.AppendByte(amf.AMF0_null)
 	pkt.Body, err = encoder.GetData()
 
 	if err != nil {
@@ -911,12 +1074,12 @@ func (this *RTMP) SendCheckBW() (err error) {
 	pkt.ChunkStreamID = RTMP_channel_Invoke
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_INVOKE
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeString("_checkbw")
 	this.NumInvokes++
 	encoder.EncodeNumber(float64(this.NumInvokes))
-	encoder.AppendByte(AMF0_null)
+	encoder.AppendByte(amf.AMF0_null)
 	pkt.Body, err = encoder.GetData()
 
 	if err != nil {
@@ -933,12 +1096,12 @@ func (this *RTMP) SendReleaseStream() (err error) {
 	pkt.ChunkStreamID = RTMP_channel_Invoke
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_INVOKE
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeString("releaseStream")
 	this.NumInvokes++
 	encoder.EncodeNumber(float64(this.NumInvokes))
-	encoder.AppendByte(AMF0_null)
+	encoder.AppendByte(amf.AMF0_null)
 	encoder.EncodeString(this.Link.Path)
 	pkt.Body, err = encoder.GetData()
 
@@ -956,12 +1119,12 @@ func (this *RTMP) SendFCPublish() (err error) {
 	pkt.ChunkStreamID = RTMP_channel_Invoke
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_INVOKE
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeString("FCPublish")
 	this.NumInvokes++
 	encoder.EncodeNumber(float64(this.NumInvokes))
-	encoder.AppendByte(AMF0_null)
+	encoder.AppendByte(amf.AMF0_null)
 	encoder.EncodeString(this.Link.Path)
 	pkt.Body, err = encoder.GetData()
 
@@ -980,12 +1143,12 @@ func (this *RTMP) SendPlay() (err error) {
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_INVOKE
 	pkt.MessageStreamId = this.StreamId
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeString("play")
 	this.NumInvokes++
 	encoder.EncodeNumber(float64(this.NumInvokes))
-	encoder.AppendByte(AMF0_null)
+	encoder.AppendByte(amf.AMF0_null)
 	encoder.EncodeString(this.Link.Path)
 	if this.Link.SeekTime > 0 {
 		encoder.EncodeNumber(float64(this.Link.SeekTime))
@@ -1011,11 +1174,11 @@ func (this *RTMP) SendCheckBWResult(transactionId float64) (err error) {
 	pkt.ChunkStreamID = RTMP_channel_Invoke
 	pkt.Fmt = 0
 	pkt.MessageTypeId = RTMP_PACKET_TYPE_INVOKE
-	encoder := &AMF0Encoder{}
+	encoder := &amf.AMF0Encoder{}
 	encoder.Init()
 	encoder.EncodeString("_result")
 	encoder.EncodeNumber(transactionId)
-	encoder.AppendByte(AMF0_null)
+	encoder.AppendByte(amf.AMF0_null)
 	encoder.EncodeNumber(0.0)
 	pkt.Body, err = encoder.GetData()
 
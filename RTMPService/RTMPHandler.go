@@ -1,12 +1,10 @@
 package RTMPService
 
 import (
-	"container/list"
 	"errors"
-	"events/eStreamerEvent"
 	"fmt"
 	"logger"
-	"mediaTypes/flv"
+	"mediaTypes/amf"
 	"strings"
 	"sync"
 	"wssAPI"
@@ -16,40 +14,13 @@ type RTMPHandler struct {
 	parent       wssAPI.Obj
 	mutexStatus  sync.RWMutex
 	rtmpInstance *RTMP
-	source       wssAPI.Obj
-	sinke        wssAPI.Obj
-	srcAdded     bool
-	sinkAdded    bool
-	streamName   string
-	clientId     string
-	playInfo     RTMPPlayInfo
 	app          string
-	player       rtmpPlayer
-	publisher    rtmpPublisher
-	srcId        int64
-}
-type RTMPPlayInfo struct {
-	playReset      bool
-	playing        bool //true for thread send playing data
-	waitPlaying    *sync.WaitGroup
-	mutexCache     sync.RWMutex
-	cache          *list.List
-	audioHeader    *flv.FlvTag
-	videoHeader    *flv.FlvTag
-	metadata       *flv.FlvTag
-	keyFrameWrited bool
-	beginTime      uint32
-	startTime      float32
-	duration       float32
-	reset          bool
+	streams      map[uint32]*rtmpNetStream
 }
 
 func (this *RTMPHandler) Init(msg *wssAPI.Msg) (err error) {
 	this.rtmpInstance = msg.Param1.(*RTMP)
-	msgInit := &wssAPI.Msg{}
-	msgInit.Param1 = this.rtmpInstance
-	this.player.Init(msgInit)
-	this.publisher.Init(msgInit)
+	this.streams = make(map[uint32]*rtmpNetStream)
 	return
 }
 
@@ -58,24 +29,12 @@ func (this *RTMPHandler) Start(msg *wssAPI.Msg) (err error) {
 }
 
 func (this *RTMPHandler) Stop(msg *wssAPI.Msg) (err error) {
-	if this.srcAdded {
-		taskDelSrc := &eStreamerEvent.EveDelSource{}
-		taskDelSrc.StreamName = this.streamName
-		taskDelSrc.Id = this.srcId
-		wssAPI.HandleTask(taskDelSrc)
-		logger.LOGT("del source:" + this.streamName)
-		this.srcAdded = false
+	this.mutexStatus.Lock()
+	defer this.mutexStatus.Unlock()
+	for id, stream := range this.streams {
+		stream.Stop(msg)
+		delete(this.streams, id)
 	}
-	if this.sinkAdded {
-		taskDelSink := &eStreamerEvent.EveDelSink{}
-		taskDelSink.StreamName = this.streamName
-		taskDelSink.SinkId = this.clientId
-		wssAPI.HandleTask(taskDelSink)
-		this.sinkAdded = false
-		logger.LOGT("del sinker:" + this.clientId)
-	}
-	this.player.Stop(msg)
-	this.publisher.Stop(msg)
 	return
 }
 
@@ -91,68 +50,73 @@ func (this *RTMPHandler) HandleTask(task wssAPI.Task) (err error) {
 	return
 }
 
+//发布和播放的消息直接发给各个NetStream
 func (this *RTMPHandler) ProcessMessage(msg *wssAPI.Msg) (err error) {
-
 	if msg == nil {
 		return errors.New("nil message")
 	}
-	switch msg.Type {
-	case wssAPI.MSG_GetSource_NOTIFY:
-		this.sinkAdded = true
-	case wssAPI.MSG_GetSource_Failed:
-		//发送404
-		this.rtmpInstance.CmdStatus("error", "NetStream.Play.StreamNotFound",
-			"paly failed", this.streamName, 0, RTMP_channel_Invoke)
-	case wssAPI.MSG_SourceClosed_Force:
-		this.srcAdded = false
-	case wssAPI.MSG_FLV_TAG:
-		tag := msg.Param1.(*flv.FlvTag)
-		err = this.player.appendFlvTag(tag)
+	logger.LOGW(fmt.Sprintf("msg type: %s not processed", msg.Type))
+	return
+}
 
-	case wssAPI.MSG_PLAY_START:
-		this.player.startPlay()
-		return
-	case wssAPI.MSG_PLAY_STOP:
-		this.mutexStatus.Lock()
-		defer this.mutexStatus.Unlock()
-		this.sourceInvalid()
-		return
-	case wssAPI.MSG_PUBLISH_START:
-		this.mutexStatus.Lock()
-		defer this.mutexStatus.Unlock()
-		if err != nil {
-			logger.LOGE("start publish failed")
-			return
-		}
-		if false == this.publisher.startPublish() {
-			logger.LOGE("start publish falied")
-			if true == this.srcAdded {
-				taskDelSrc := &eStreamerEvent.EveDelSource{}
-				taskDelSrc.StreamName = this.streamName
-				taskDelSrc.Id = this.srcId
-				wssAPI.HandleTask(taskDelSrc)
-			}
+//分配最小的没用过的流id
+func (this *RTMPHandler) createStream() (stream *rtmpNetStream, err error) {
+	this.mutexStatus.Lock()
+	defer this.mutexStatus.Unlock()
+	if len(this.streams) >= rtmp_max_streams {
+		return nil, errors.New(fmt.Sprintf("too many streams:%d", len(this.streams)))
+	}
+	id := uint32(1)
+	for ; ; id++ {
+		if _, exist := this.streams[id]; false == exist {
+			break
 		}
-		return
-	case wssAPI.MSG_PUBLISH_STOP:
-		this.mutexStatus.Lock()
-		defer this.mutexStatus.Unlock()
-		if err != nil {
-			logger.LOGE("stop publish failed")
-			return
+	}
+	return this.addStream(id), nil
+}
+
+func (this *RTMPHandler) addStream(id uint32) (stream *rtmpNetStream) {
+	stream = &rtmpNetStream{id: id, handler: this}
+	msgInit := &wssAPI.Msg{}
+	msgInit.Param1 = this.rtmpInstance
+	stream.Init(msgInit)
+	this.streams[id] = stream
+	logger.LOGT(fmt.Sprintf("create stream %d", id))
+	return
+}
+
+func (this *RTMPHandler) getStream(id uint32) *rtmpNetStream {
+	this.mutexStatus.RLock()
+	defer this.mutexStatus.RUnlock()
+	return this.streams[id]
+}
+
+//有的客户端不createStream直接在流id上发命令，这里也建一个
+func (this *RTMPHandler) getOrAddStream(id uint32) (stream *rtmpNetStream, err error) {
+	if 0 == id {
+		return nil, errors.New("command on stream 0")
+	}
+	this.mutexStatus.Lock()
+	defer this.mutexStatus.Unlock()
+	stream = this.streams[id]
+	if nil == stream {
+		if len(this.streams) >= rtmp_max_streams {
+			return nil, errors.New(fmt.Sprintf("too many streams:%d", len(this.streams)))
 		}
-		this.publisher.stopPublish()
-		return
-	default:
-		logger.LOGW(fmt.Sprintf("msg type: %s not processed", msg.Type))
-		return
+		stream = this.addStream(id)
 	}
 	return
 }
 
-func (this *RTMPHandler) sourceInvalid() {
-	logger.LOGT("stop play,keep sink")
-	this.player.stopPlay()
+func (this *RTMPHandler) deleteStream(id uint32) {
+	this.mutexStatus.Lock()
+	stream := this.streams[id]
+	delete(this.streams, id)
+	this.mutexStatus.Unlock()
+	if stream != nil {
+		logger.LOGT(fmt.Sprintf("delete stream %d", id))
+		stream.Stop(nil)
+	}
 }
 
 func (this *RTMPHandler) HandleRTMPPacket(packet *RTMPPacket) (err error) {
@@ -162,21 +126,12 @@ func (this *RTMPHandler) HandleRTMPPacket(packet *RTMPPacket) (err error) {
 	}
 	switch packet.MessageTypeId {
 	case RTMP_PACKET_TYPE_CHUNK_SIZE:
-		this.rtmpInstance.RecvChunkSize, err = AMF0DecodeInt32(packet.Body)
+		this.rtmpInstance.RecvChunkSize, err = amf.AMF0DecodeInt32(packet.Body)
 		logger.LOGT(fmt.Sprintf("chunk size:%d", this.rtmpInstance.RecvChunkSize))
 	case RTMP_PACKET_TYPE_CONTROL:
 		err = this.rtmpInstance.HandleControl(packet)
-	case RTMP_PACKET_TYPE_BYTES_READ_REPORT:
-		//		logger.LOGT(packet.TimeStamp)
-		//		logger.LOGT(packet.Body)
-		//		logger.LOGT("bytes read repost")
-	case RTMP_PACKET_TYPE_SERVER_BW:
-		this.rtmpInstance.TargetBW, err = AMF0DecodeInt32(packet.Body)
-		logger.LOGT(fmt.Sprintf("确认窗口大小 %d", this.rtmpInstance.TargetBW))
-	case RTMP_PACKET_TYPE_CLIENT_BW:
-		this.rtmpInstance.SelfBW, err = AMF0DecodeInt32(packet.Body)
-		this.rtmpInstance.LimitType = uint32(packet.Body[4])
-		logger.LOGT(fmt.Sprintf("设置对端宽带 %d %d ", this.rtmpInstance.SelfBW, this.rtmpInstance.LimitType))
+	case RTMP_PACKET_TYPE_BYTES_READ_REPORT, RTMP_PACKET_TYPE_SERVER_BW, RTMP_PACKET_TYPE_CLIENT_BW:
+		err = this.rtmpInstance.HandleFlowControl(packet)
 	case RTMP_PACKET_TYPE_FLEX_MESSAGE:
 		err = this.handleInvoke(packet)
 	case RTMP_PACKET_TYPE_INVOKE:
@@ -194,50 +149,32 @@ func (this *RTMPHandler) HandleRTMPPacket(packet *RTMPPacket) (err error) {
 }
 
 func (this *RTMPHandler) sendFlvToSrc(pkt *RTMPPacket) (err error) {
-	if this.publisher.isPublishing() && wssAPI.InterfaceValid(this.source) {
-		msg := &wssAPI.Msg{}
-		msg.Type = wssAPI.MSG_FLV_TAG
-		msg.Param1 = pkt.ToFLVTag()
-		err = this.source.ProcessMessage(msg)
-		if err != nil {
-			logger.LOGE(err.Error())
-			this.Stop(nil)
-		}
+	stream := this.getStream(pkt.MessageStreamId)
+	if nil == stream {
+		logger.LOGE(fmt.Sprintf("media on unknown stream %d", pkt.MessageStreamId))
 		return
-	} else {
-		logger.LOGE("bad status")
 	}
-	return
+	return stream.sendFlvToSrc(pkt)
 }
 
 func (this *RTMPHandler) handleInvoke(packet *RTMPPacket) (err error) {
-	var amfobj *AMF0Object
-	if RTMP_PACKET_TYPE_FLEX_MESSAGE == packet.MessageTypeId {
-		amfobj, err = AMF0DecodeObj(packet.Body[1:])
-	} else {
-		amfobj, err = AMF0DecodeObj(packet.Body)
-	}
+	cmd, err := ParseCommand(packet)
 	if err != nil {
-		logger.LOGE("recved invalid amf0 object")
-		return
-	}
-	if amfobj.Props.Len() == 0 {
-		logger.LOGT(packet.Body)
-		logger.LOGT(string(packet.Body))
+		logger.LOGE("recved invalid rtmp command:" + err.Error())
 		return
 	}
 
-	method := amfobj.Props.Front().Value.(*AMF0Property)
-
-	switch method.Value.StrValue {
+	switch cmd.Name {
 	case "connect":
-		cmdObj := amfobj.AMF0GetPropByIndex(2)
-		if cmdObj != nil {
-			this.app = cmdObj.Value.ObjValue.AMF0GetPropByName("app").Value.StrValue
-			if strings.HasSuffix(this.app, "/") {
-				this.app = strings.TrimSuffix(this.app, "/")
-			}
+		var cmdObj *ConnectCmdObject
+		cmdObj, err = cmd.ConnectObject()
+		if err != nil {
+			logger.LOGE("invalid connect:" + err.Error())
+			this.rtmpInstance.CmdError("error", "NetConnection.Connect.Rejected",
+				err.Error(), cmd.TransactionId)
+			return
 		}
+		this.app = strings.TrimSuffix(cmdObj.App, "/")
 		if this.app != serviceConfig.LivePath {
 			logger.LOGE(this.app)
 			logger.LOGE(serviceConfig.LivePath)
@@ -251,169 +188,95 @@ func (this *RTMPHandler) handleInvoke(packet *RTMPPacket) (err error) {
 		if err != nil {
 			return
 		}
-		//err = this.rtmpInstance.SetChunkSize(RTMP_better_chunk_size)
-		//		if err != nil {
-		//			return
-		//		}
+		err = this.rtmpInstance.SetChunkSize(uint32(serviceConfig.ChunkSize))
+		if err != nil {
+			return
+		}
 		err = this.rtmpInstance.OnBWDone()
 		if err != nil {
 			return
 		}
-		err = this.rtmpInstance.ConnectResult(amfobj)
+		err = this.rtmpInstance.ConnectResult(cmd, cmdObj)
 		if err != nil {
 			return
 		}
 	case "_checkbw":
 		err = this.rtmpInstance.OnBWCheck()
 	case "_result":
-		this.handle_result(amfobj)
+		this.handle_result(cmd)
 	case "releaseStream":
-		//		idx := amfobj.AMF0GetPropByIndex(1).Value.NumValue
 		//		err = this.rtmpInstance.CmdError("error", "NetConnection.Call.Failed",
-		//			fmt.Sprintf("Method not found (%s).", "releaseStream"), idx)
+		//			fmt.Sprintf("Method not found (%s).", "releaseStream"), cmd.TransactionId)
 	case "FCPublish":
-		//		idx := amfobj.AMF0GetPropByIndex(1).Value.NumValue
 		//		err = this.rtmpInstance.CmdError("error", "NetConnection.Call.Failed",
-		//			fmt.Sprintf("Method not found (%s).", "FCPublish"), idx)
+		//			fmt.Sprintf("Method not found (%s).", "FCPublish"), cmd.TransactionId)
 	case "createStream":
-		idx := amfobj.AMF0GetPropByIndex(1).Value.NumValue
-		err = this.rtmpInstance.CmdNumberResult(idx, 1.0)
-	case "publish":
-		//check prop
-		if amfobj.Props.Len() < 4 {
-			logger.LOGE("invalid props length")
-			err = errors.New("invalid amf obj for publish")
-			return
-		}
-
-		this.mutexStatus.Lock()
-		defer this.mutexStatus.Unlock()
-		//check status
-		if true == this.publisher.isPublishing() {
-			logger.LOGE("publish on bad status ")
-			idx := amfobj.AMF0GetPropByIndex(1).Value.NumValue
-			err = this.rtmpInstance.CmdError("error", "NetStream.Publish.Denied",
-				fmt.Sprintf("can not publish (%s).", "publish"), idx)
-			return
-		}
-		//add to source
-		this.streamName = this.app + "/" + amfobj.AMF0GetPropByIndex(3).Value.StrValue
-		taskAddSrc := &eStreamerEvent.EveAddSource{}
-		taskAddSrc.Producer = this
-		taskAddSrc.StreamName = this.streamName
-		taskAddSrc.RemoteIp = this.rtmpInstance.Conn.RemoteAddr()
-		err = wssAPI.HandleTask(taskAddSrc)
+		var stream *rtmpNetStream
+		stream, err = this.createStream()
 		if err != nil {
-			logger.LOGE("add source failed:" + err.Error())
-			err = this.rtmpInstance.CmdStatus("error", "NetStream.Publish.BadName",
-				fmt.Sprintf("publish %s.", this.streamName), "", 0, RTMP_channel_Invoke)
-			this.streamName = ""
-			return err
-		}
-		this.source = taskAddSrc.SrcObj
-		this.srcId = taskAddSrc.Id
-		if this.source == nil {
-			logger.LOGE("add source failed:")
-			err = this.rtmpInstance.CmdStatus("error", "NetStream.Publish.BadName",
-				fmt.Sprintf("publish %s.", this.streamName), "", 0, RTMP_channel_Invoke)
-			this.streamName = ""
-			return errors.New("bad name")
-		}
-		this.srcAdded = true
-		this.rtmpInstance.Link.Path = amfobj.AMF0GetPropByIndex(2).Value.StrValue
-		if false == this.publisher.startPublish() {
-			logger.LOGE("start publish failed:" + this.streamName)
-			//streamer.DelSource(this.streamName)
-			taskDelSrc := &eStreamerEvent.EveDelSource{}
-			taskDelSrc.StreamName = this.streamName
-			taskDelSrc.Id = this.srcId
-			wssAPI.HandleTask(taskDelSrc)
-			return
-		}
-	case "FCUnpublish":
-		this.mutexStatus.Lock()
-		defer this.mutexStatus.Unlock()
-	case "deleteStream":
-		this.mutexStatus.Lock()
-		defer this.mutexStatus.Unlock()
-	//do nothing now
-	case "play":
-		this.streamName = this.app + "/" + amfobj.AMF0GetPropByIndex(3).Value.StrValue
-		this.rtmpInstance.Link.Path = this.streamName
-		startTime := -2
-		duration := -1
-		reset := false
-		this.playInfo.startTime = -2
-		this.playInfo.duration = -1
-		this.playInfo.reset = false
-		if amfobj.Props.Len() >= 5 {
-			this.playInfo.startTime = float32(amfobj.AMF0GetPropByIndex(4).Value.NumValue)
+			logger.LOGE(err.Error())
+			return this.rtmpInstance.CmdError("error", "NetConnection.Call.Failed",
+				err.Error(), cmd.TransactionId)
 		}
-		if amfobj.Props.Len() >= 6 {
-			this.playInfo.duration = float32(amfobj.AMF0GetPropByIndex(5).Value.NumValue)
-			if this.playInfo.duration < 0 {
-				this.playInfo.duration = -1
-			}
+		err = this.rtmpInstance.CmdNumberResult(cmd.TransactionId, float64(stream.id))
+	case "publish", "play":
+		var stream *rtmpNetStream
+		stream, err = this.getOrAddStream(packet.MessageStreamId)
+		if err != nil {
+			logger.LOGE(fmt.Sprintf("%s failed:%s", cmd.Name, err.Error()))
+			return this.rtmpInstance.CmdError("error", "NetStream.Failed",
+				err.Error(), cmd.TransactionId)
 		}
-		if amfobj.Props.Len() >= 7 {
-			this.playInfo.reset = amfobj.AMF0GetPropByIndex(6).Value.BoolValue
+		if "publish" == cmd.Name {
+			err = stream.publish(cmd)
+		} else {
+			err = stream.play(cmd)
 		}
-
-		//check player status,if playing,error
-		if false == this.player.setPlayParams(this.streamName, startTime, duration, reset) {
-			err = this.rtmpInstance.CmdStatus("error", "NetStream.Play.Failed",
-				"paly failed", this.streamName, 0, RTMP_channel_Invoke)
-
+	case "pause", "seek", "receiveAudio", "receiveVideo":
+		stream := this.getStream(packet.MessageStreamId)
+		if nil == stream {
+			logger.LOGE(fmt.Sprintf("%s on invalid stream %d", cmd.Name, packet.MessageStreamId))
 			return nil
 		}
-		err = this.rtmpInstance.SendCtrl(RTMP_CTRL_streamBegin, 1, 0)
-		if err != nil {
-			logger.LOGE(err.Error())
-			return
-		}
-
-		if true == this.playInfo.playReset {
-			err = this.rtmpInstance.CmdStatus("status", "NetStream.Play.Reset",
-				fmt.Sprintf("Playing and resetting %s", this.rtmpInstance.Link.Path),
-				this.rtmpInstance.Link.Path, 0, RTMP_channel_Invoke)
-			if err != nil {
-				logger.LOGE(err.Error())
-				return
-			}
-		}
-
-		err = this.rtmpInstance.CmdStatus("status", "NetStream.Play.Start",
-			fmt.Sprintf("Started playing %s", this.rtmpInstance.Link.Path), this.rtmpInstance.Link.Path, 0, RTMP_channel_Invoke)
-		if err != nil {
-			logger.LOGE(err.Error())
-			return
+		switch cmd.Name {
+		case "pause":
+			err = stream.pause(cmd)
+		case "seek":
+			err = stream.seek(cmd)
+		case "receiveAudio":
+			err = stream.receiveAudio(cmd)
+		case "receiveVideo":
+			err = stream.receiveVideo(cmd)
 		}
-
-		this.clientId = wssAPI.GenerateGUID()
-		taskAddSink := &eStreamerEvent.EveAddSink{}
-		taskAddSink.StreamName = this.streamName
-		taskAddSink.SinkId = this.clientId
-		taskAddSink.Sinker = this
-		err = wssAPI.HandleTask(taskAddSink)
+	case "FCUnpublish":
+	case "deleteStream":
+		var args *DeleteStreamArgs
+		args, err = cmd.DeleteStreamArgs()
 		if err != nil {
-			//404
-			err = this.rtmpInstance.CmdStatus("error", "NetStream.Play.StreamNotFound",
-				"paly failed", this.streamName, 0, RTMP_channel_Invoke)
+			logger.LOGE("invalid deleteStream:" + err.Error())
 			return nil
 		}
-		this.sinkAdded = taskAddSink.Added
-	case "_error":
-		amfobj.Dump()
+		this.deleteStream(uint32(args.StreamId))
 	case "closeStream":
-		amfobj.Dump()
+		//只停这个流上的发布播放，流id保留
+		stream := this.getStream(packet.MessageStreamId)
+		if stream != nil {
+			stream.Stop(nil)
+		}
+	case "_error":
+		cmd.Dump()
 	default:
-		logger.LOGW(fmt.Sprintf("rtmp method <%s> not processed", method.Value.StrValue))
+		streamName := ""
+		if stream := this.getStream(packet.MessageStreamId); stream != nil {
+			streamName = stream.streamName
+		}
+		err = this.handleCall(cmd, streamName)
 	}
 	return
 }
 
-func (this *RTMPHandler) handle_result(amfobj *AMF0Object) {
-	transactionId := int32(amfobj.AMF0GetPropByIndex(1).Value.NumValue)
+func (this *RTMPHandler) handle_result(cmd *RTMPCommand) {
+	transactionId := int32(cmd.TransactionId)
 	resultMethod := this.rtmpInstance.methodCache[transactionId]
 	switch resultMethod {
 	case "_onbwcheck":
@@ -422,24 +285,15 @@ func (this *RTMPHandler) handle_result(amfobj *AMF0Object) {
 	}
 }
 
-func (this *RTMPHandler) startPublishing() (err error) {
-	err = this.rtmpInstance.SendCtrl(RTMP_CTRL_streamBegin, 1, 0)
-	if err != nil {
-		logger.LOGE(err.Error())
-		return nil
-	}
-	err = this.rtmpInstance.CmdStatus("status", "NetStream.Publish.Start",
-		fmt.Sprintf("publish %s", this.rtmpInstance.Link.Path), "", 0, RTMP_channel_Invoke)
-	if err != nil {
-		logger.LOGE(err.Error())
-		return nil
-	}
-	this.publisher.startPublish()
-	return
-}
-
 func (this *RTMPHandler) isPlaying() bool {
-	return this.player.IsPlaying()
+	this.mutexStatus.RLock()
+	defer this.mutexStatus.RUnlock()
+	for _, stream := range this.streams {
+		if stream.isPlaying() {
+			return true
+		}
+	}
+	return false
 }
 
 func (this *RTMPHandler) SetParent(parent wssAPI.Obj) {
@@ -0,0 +1,360 @@
+package RTMPService
+
+import (
+	"errors"
+	"events/eStreamerEvent"
+	"fmt"
+	"logger"
+	"mediaTypes/flv"
+	"sync"
+	"wssAPI"
+)
+
+const (
+	rtmpTypeNetStream = "rtmpNetStream"
+	//一个连接最多创建多少个流
+	rtmp_max_streams = 32
+	//流id大于1的音视频用自己的chunk stream
+	rtmp_channel_stream_base = 0x20
+)
+
+//一个连接上的每个NetStream，用message stream id区分，各自发布或者播放
+type rtmpNetStream struct {
+	id          uint32
+	handler     *RTMPHandler
+	rtmp        *RTMP
+	mutexStatus sync.RWMutex
+	path        string //客户端给的流名字
+	streamName  string //app/path
+	clientId    string
+	source      wssAPI.Obj
+	srcAdded    bool
+	sinkAdded   bool
+	srcId       int64
+	player      rtmpPlayer
+	publisher   rtmpPublisher
+}
+
+//头压缩按chunk stream算，不同的流分开
+func streamChunkId(streamId uint32) int32 {
+	if streamId <= 1 {
+		return RTMP_channel_SendLive
+	}
+	return rtmp_channel_stream_base + int32(streamId)
+}
+
+func (this *rtmpNetStream) Init(msg *wssAPI.Msg) (err error) {
+	this.rtmp = msg.Param1.(*RTMP)
+	this.player.Init(msg)
+	this.player.streamId = this.id
+	this.publisher.Init(msg)
+	this.publisher.streamId = this.id
+	return
+}
+
+func (this *rtmpNetStream) Start(msg *wssAPI.Msg) (err error) {
+	return
+}
+
+//停止发布和播放，流id还保留，deleteStream才删除
+func (this *rtmpNetStream) Stop(msg *wssAPI.Msg) (err error) {
+	if this.srcAdded {
+		taskDelSrc := &eStreamerEvent.EveDelSource{}
+		taskDelSrc.StreamName = this.streamName
+		taskDelSrc.Id = this.srcId
+		wssAPI.HandleTask(taskDelSrc)
+		logger.LOGT("del source:" + this.streamName)
+		this.srcAdded = false
+	}
+	if this.sinkAdded {
+		taskDelSink := &eStreamerEvent.EveDelSink{}
+		taskDelSink.StreamName = this.streamName
+		taskDelSink.SinkId = this.clientId
+		wssAPI.HandleTask(taskDelSink)
+		this.sinkAdded = false
+		logger.LOGT("del sinker:" + this.clientId)
+	}
+	this.player.Stop(msg)
+	this.publisher.Stop(msg)
+	this.source = nil
+	return
+}
+
+func (this *rtmpNetStream) GetType() string {
+	return rtmpTypeNetStream
+}
+
+func (this *rtmpNetStream) HandleTask(task wssAPI.Task) (err error) {
+	return
+}
+
+func (this *rtmpNetStream) ProcessMessage(msg *wssAPI.Msg) (err error) {
+	if msg == nil {
+		return errors.New("nil message")
+	}
+	switch msg.Type {
+	case wssAPI.MSG_GetSource_NOTIFY:
+		this.sinkAdded = true
+	case wssAPI.MSG_GetSource_Failed:
+		//发送404
+		this.status("error", "NetStream.Play.StreamNotFound", "paly failed", this.streamName)
+	case wssAPI.MSG_SourceClosed_Force:
+		this.srcAdded = false
+	case wssAPI.MSG_FLV_TAG:
+		tag := msg.Param1.(*flv.FlvTag)
+		err = this.player.appendFlvTag(tag)
+	case wssAPI.MSG_PLAY_START:
+		this.player.startPlay()
+		return
+	case wssAPI.MSG_PLAY_STOP:
+		this.mutexStatus.Lock()
+		defer this.mutexStatus.Unlock()
+		logger.LOGT("stop play,keep sink")
+		this.player.stopPlay()
+		return
+	case wssAPI.MSG_PUBLISH_START:
+		this.mutexStatus.Lock()
+		defer this.mutexStatus.Unlock()
+		if false == this.publisher.startPublish() {
+			logger.LOGE("start publish falied")
+			if true == this.srcAdded {
+				taskDelSrc := &eStreamerEvent.EveDelSource{}
+				taskDelSrc.StreamName = this.streamName
+				taskDelSrc.Id = this.srcId
+				wssAPI.HandleTask(taskDelSrc)
+			}
+		}
+		return
+	case wssAPI.MSG_PUBLISH_STOP:
+		this.mutexStatus.Lock()
+		defer this.mutexStatus.Unlock()
+		this.publisher.stopPublish()
+		return
+	default:
+		logger.LOGW(fmt.Sprintf("msg type: %s not processed", msg.Type))
+		return
+	}
+	return
+}
+
+func (this *rtmpNetStream) status(level, code, description, details string) error {
+	return this.rtmp.CmdStreamStatus(this.id, level, code, description, details, 0, RTMP_channel_Invoke)
+}
+
+func (this *rtmpNetStream) isPlaying() bool {
+	return this.player.IsPlaying()
+}
+
+func (this *rtmpNetStream) isBusy() bool {
+	return this.srcAdded || this.sinkAdded || this.publisher.isPublishing() || this.player.IsPlaying()
+}
+
+func (this *rtmpNetStream) publish(cmd *RTMPCommand) (err error) {
+	args, err := cmd.PublishArgs()
+	if err != nil {
+		logger.LOGE("invalid publish:" + err.Error())
+		return
+	}
+
+	this.mutexStatus.Lock()
+	defer this.mutexStatus.Unlock()
+	//check status
+	if this.isBusy() {
+		logger.LOGE("publish on bad status ")
+		return this.rtmp.CmdError("error", "NetStream.Publish.Denied",
+			fmt.Sprintf("can not publish (%s).", "publish"), cmd.TransactionId)
+	}
+	//add to source
+	this.path = args.Name
+	this.streamName = this.handler.app + "/" + args.Name
+	this.publisher.path = this.path
+	taskAddSrc := &eStreamerEvent.EveAddSource{}
+	taskAddSrc.Producer = this
+	taskAddSrc.StreamName = this.streamName
+	taskAddSrc.RemoteIp = this.rtmp.Conn.RemoteAddr()
+	err = wssAPI.HandleTask(taskAddSrc)
+	if err != nil || nil == taskAddSrc.SrcObj {
+		if err != nil {
+			logger.LOGE("add source failed:" + err.Error())
+		}
+		//只是这个流失败，连接上其他流不受影响
+		err = this.status("error", "NetStream.Publish.BadName",
+			fmt.Sprintf("publish %s.", this.streamName), "")
+		this.streamName = ""
+		return
+	}
+	this.source = taskAddSrc.SrcObj
+	this.srcId = taskAddSrc.Id
+	this.srcAdded = true
+	if false == this.publisher.startPublish() {
+		logger.LOGE("start publish failed:" + this.streamName)
+		taskDelSrc := &eStreamerEvent.EveDelSource{}
+		taskDelSrc.StreamName = this.streamName
+		taskDelSrc.Id = this.srcId
+		wssAPI.HandleTask(taskDelSrc)
+		this.srcAdded = false
+		return
+	}
+	return
+}
+
+func (this *rtmpNetStream) play(cmd *RTMPCommand) (err error) {
+	args, err := cmd.PlayArgs()
+	if err != nil {
+		logger.LOGE("invalid play:" + err.Error())
+		return
+	}
+	if this.srcAdded || this.publisher.isPublishing() {
+		return this.status("error", "NetStream.Play.Failed", "stream is publishing", args.Name)
+	}
+	this.path = args.Name
+	this.streamName = this.handler.app + "/" + args.Name
+	this.player.path = this.streamName
+	//-2直播优先，-1只要直播，>=0是点播
+	//没有录像，点播退回到正在发布的直播，从当前位置开始播，start的值不用
+	//不在发布时回StreamNotFound，不像直播那样去拉上游
+	if args.Start >= 0 {
+		taskGetSrc := &eStreamerEvent.EveGetSource{}
+		taskGetSrc.StreamName = this.streamName
+		err = wssAPI.HandleTask(taskGetSrc)
+		if err != nil || false == taskGetSrc.HasProducer {
+			return this.status("error", "NetStream.Play.StreamNotFound",
+				fmt.Sprintf("Failed to play %s; stream not found.", this.path), this.streamName)
+		}
+	}
+	duration := args.Duration
+	if duration < 0 {
+		duration = -1
+	}
+
+	//check player status,if playing,error
+	if false == this.player.setPlayParams(duration) {
+		err = this.status("error", "NetStream.Play.Failed", "paly failed", this.streamName)
+		return
+	}
+	err = this.rtmp.SendCtrl(RTMP_CTRL_streamBegin, this.id, 0)
+	if err != nil {
+		logger.LOGE(err.Error())
+		return
+	}
+
+	if true == args.Reset {
+		err = this.status("status", "NetStream.Play.Reset",
+			fmt.Sprintf("Playing and resetting %s", this.streamName), this.streamName)
+		if err != nil {
+			logger.LOGE(err.Error())
+			return
+		}
+	}
+
+	err = this.status("status", "NetStream.Play.Start",
+		fmt.Sprintf("Started playing %s", this.streamName), this.streamName)
+	if err != nil {
+		logger.LOGE(err.Error())
+		return
+	}
+
+	this.clientId = wssAPI.GenerateGUID()
+	taskAddSink := &eStreamerEvent.EveAddSink{}
+	taskAddSink.StreamName = this.streamName
+	taskAddSink.SinkId = this.clientId
+	taskAddSink.Sinker = this
+	err = wssAPI.HandleTask(taskAddSink)
+	if err != nil {
+		//404
+		err = this.status("error", "NetStream.Play.StreamNotFound", "paly failed", this.streamName)
+		return
+	}
+	this.sinkAdded = taskAddSink.Added
+	return
+}
+
+func (this *rtmpNetStream) pause(cmd *RTMPCommand) (err error) {
+	args, err := cmd.PauseArgs()
+	if err != nil {
+		logger.LOGE("invalid pause:" + err.Error())
+		return nil
+	}
+	if false == this.player.pause(args.Pause) {
+		return this.status("error", "NetStream.Failed",
+			fmt.Sprintf("can not pause %s", this.streamName), this.streamName)
+	}
+	if args.Pause {
+		err = this.rtmp.SendCtrl(RTMP_CTRL_streamEof, this.id, 0)
+		if err != nil {
+			return
+		}
+		return this.status("status", "NetStream.Pause.Notify",
+			fmt.Sprintf("Pausing %s.", this.streamName), this.streamName)
+	}
+	err = this.rtmp.SendCtrl(RTMP_CTRL_streamBegin, this.id, 0)
+	if err != nil {
+		return
+	}
+	return this.status("status", "NetStream.Unpause.Notify",
+		fmt.Sprintf("Unpausing %s.", this.streamName), this.streamName)
+}
+
+func (this *rtmpNetStream) seek(cmd *RTMPCommand) (err error) {
+	args, err := cmd.SeekArgs()
+	if err != nil || args.Time < 0 {
+		return this.status("error", "NetStream.Seek.InvalidTime",
+			fmt.Sprintf("invalid seek time (%s).", this.streamName), this.streamName)
+	}
+	if false == this.player.seek() {
+		return this.status("error", "NetStream.Seek.Failed",
+			fmt.Sprintf("can not seek %s", this.streamName), this.streamName)
+	}
+	err = this.rtmp.SendCtrl(RTMP_CTRL_streamBegin, this.id, 0)
+	if err != nil {
+		return
+	}
+	err = this.status("status", "NetStream.Seek.Notify",
+		fmt.Sprintf("Seeking %d (stream ID: %d).", int64(args.Time), this.id), this.streamName)
+	if err != nil {
+		return
+	}
+	return this.status("status", "NetStream.Play.Start",
+		fmt.Sprintf("Started playing %s", this.streamName), this.streamName)
+}
+
+//receiveAudio和receiveVideo不回状态
+func (this *rtmpNetStream) receiveAudio(cmd *RTMPCommand) (err error) {
+	args, err := cmd.ReceiveArgs()
+	if err != nil {
+		logger.LOGE("invalid receiveAudio:" + err.Error())
+		return nil
+	}
+	this.player.receiveAudio(args.Flag)
+	return
+}
+
+func (this *rtmpNetStream) receiveVideo(cmd *RTMPCommand) (err error) {
+	args, err := cmd.ReceiveArgs()
+	if err != nil {
+		logger.LOGE("invalid receiveVideo:" + err.Error())
+		return nil
+	}
+	this.player.receiveVideo(args.Flag)
+	return
+}
+
+func (this *rtmpNetStream) sendFlvToSrc(pkt *RTMPPacket) (err error) {
+	if this.publisher.isPublishing() && wssAPI.InterfaceValid(this.source) {
+		tag := pkt.ToFLVTag()
+		msg := &wssAPI.Msg{}
+		msg.Type = wssAPI.MSG_FLV_TAG
+		msg.Param1 = tag
+		err = this.source.ProcessMessage(msg)
+		tag.Release()
+		if err != nil {
+			logger.LOGE(err.Error())
+			this.Stop(nil)
+			return nil
+		}
+		return
+	} else {
+		logger.LOGE(fmt.Sprintf("stream %d bad status", this.id))
+	}
+	return
+}
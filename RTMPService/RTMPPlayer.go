@@ -7,7 +7,6 @@ import (
 	"logger"
 	"mediaTypes/flv"
 	"sync"
-	"time"
 	"wssAPI"
 )
 
@@ -21,7 +20,6 @@ type rtmpPlayer struct {
 	parent         wssAPI.Obj
 	playStatus     int
 	mutexStatus    sync.RWMutex
-	playing        bool //true for thread send playing data
 	waitPlaying    *sync.WaitGroup
 	mutexCache     sync.RWMutex
 	cache          *list.List
@@ -30,15 +28,29 @@ type rtmpPlayer struct {
 	metadata       *flv.FlvTag
 	keyFrameWrited bool
 	beginTime      uint32
-	startTime      float32
-	duration       float32
-	reset          bool
+	duration       float64 //秒，小于0不限制
+	timeStarted    bool
+	firstTime      uint32
+	lastTime       uint32
+	ended          bool //播满duration了
+	noAudio        bool
+	noVideo        bool
 	rtmp           *RTMP
+	chData         chan bool //有新数据时通知发送线程
+	chStop         chan bool //stopPlay关掉，发送线程退出，也不再等对端确认
+	sendStopped    bool      //发送线程自己退出了，再来数据返回错误让源删掉sink
+	congestion     *flv.CongestionController
+	streamId       uint32
+	path           string
 }
 
+//一次writev最多发多少个tag
+const rtmp_play_batch = 64
+
 func (this *rtmpPlayer) Init(msg *wssAPI.Msg) (err error) {
 	this.playStatus = play_idle
 	this.waitPlaying = new(sync.WaitGroup)
+	this.chData = make(chan bool, 1)
 	this.rtmp = msg.Param1.(*RTMP)
 	this.resetCache()
 	return
@@ -71,12 +83,12 @@ func (this *rtmpPlayer) startPlay() {
 	defer this.mutexStatus.Unlock()
 	switch this.playStatus {
 	case play_idle:
-		go this.threadPlay()
+		this.sendStopped = false
+		this.chStop = make(chan bool)
+		this.waitPlaying.Add(1)
+		go this.threadPlay(this.chStop)
 		this.playStatus = play_playing
-	case play_paused:
-		logger.LOGE("pause not processed")
-		return
-	case play_playing:
+	case play_playing, play_paused:
 		return
 	}
 
@@ -88,13 +100,10 @@ func (this *rtmpPlayer) stopPlay() {
 	switch this.playStatus {
 	case play_idle:
 		return
-	case play_paused:
-		logger.LOGE("pause not processed")
-		return
-	case play_playing:
+	case play_playing, play_paused:
 		//stop play thread
 		//reset
-		this.playing = false
+		close(this.chStop)
 		this.waitPlaying.Wait()
 		this.playStatus = play_idle
 		this.resetCache()
@@ -102,96 +111,304 @@ func (this *rtmpPlayer) stopPlay() {
 
 }
 
-func (this *rtmpPlayer) pause() {
+//暂停时丢掉缓存和新来的数据，恢复时重发头，从关键帧开始
+func (this *rtmpPlayer) pause(paused bool) bool {
+	this.mutexStatus.Lock()
+	defer this.mutexStatus.Unlock()
+	if paused {
+		if this.playStatus != play_playing {
+			return false
+		}
+		this.playStatus = play_paused
+		this.mutexCache.Lock()
+		this.clearCache()
+		this.mutexCache.Unlock()
+		return true
+	}
+	if this.playStatus != play_paused {
+		return false
+	}
+	this.playStatus = play_playing
+	this.mutexCache.Lock()
+	this.keyFrameWrited = false
+	this.pushHeaders()
+	this.mutexCache.Unlock()
+	this.notify()
+	return true
+}
 
+//直播不能真的跳，清掉缓存从下一个关键帧重新开始，duration重新算
+func (this *rtmpPlayer) seek() bool {
+	this.mutexStatus.Lock()
+	defer this.mutexStatus.Unlock()
+	if this.playStatus == play_idle {
+		return false
+	}
+	this.mutexCache.Lock()
+	this.clearCache()
+	this.keyFrameWrited = false
+	this.timeStarted = false
+	this.ended = false
+	if this.playStatus == play_playing {
+		this.pushHeaders()
+	}
+	this.mutexCache.Unlock()
+	this.notify()
+	return true
+}
+
+func (this *rtmpPlayer) receiveAudio(flag bool) {
+	this.mutexCache.Lock()
+	defer this.mutexCache.Unlock()
+	if flag && this.noAudio && this.audioHeader != nil {
+		this.cache.PushBack(this.audioHeader.WithTimestamp(this.lastTime))
+	}
+	this.noAudio = false == flag
+}
+
+func (this *rtmpPlayer) receiveVideo(flag bool) {
+	this.mutexCache.Lock()
+	defer this.mutexCache.Unlock()
+	if flag && this.noVideo {
+		this.keyFrameWrited = false
+		if this.videoHeader != nil {
+			this.cache.PushBack(this.videoHeader.WithTimestamp(this.lastTime))
+		}
+	}
+	this.noVideo = false == flag
 }
 
+//暂停也算在播放
 func (this *rtmpPlayer) IsPlaying() bool {
-	return this.playStatus == play_playing
+	this.mutexStatus.RLock()
+	defer this.mutexStatus.RUnlock()
+	return this.playStatus != play_idle
 }
 
 func (this *rtmpPlayer) appendFlvTag(tag *flv.FlvTag) (err error) {
 	this.mutexStatus.RLock()
 	defer this.mutexStatus.RUnlock()
-	if this.playStatus != play_playing {
+	if this.playStatus == play_idle {
 		err = errors.New("not playing ,can not recv mediaData")
 		logger.LOGE(err.Error())
 		return
 	}
-	tag = tag.Copy()
-	//	if this.beginTime == 0 && tag.Timestamp > 0 {
-	//		this.beginTime = tag.Timestamp
-	//	}
-	//	tag.Timestamp -= this.beginTime
-	if false == this.keyFrameWrited && tag.TagType == flv.FLV_TAG_Video {
-		if this.videoHeader == nil {
-			this.videoHeader = tag
-		} else {
-			if (tag.Data[0] >> 4) == 1 {
-				this.keyFrameWrited = true
-			} else {
+	this.mutexCache.Lock()
+	defer this.mutexCache.Unlock()
+	if this.sendStopped {
+		return errors.New("rtmp play thread stopped")
+	}
+	isHeader := this.saveHeader(tag)
+	if this.playStatus == play_paused || this.ended {
+		return
+	}
+	switch tag.TagType {
+	case flv.FLV_TAG_Audio:
+		if this.noAudio {
+			return
+		}
+	case flv.FLV_TAG_Video:
+		if this.noVideo {
+			return
+		}
+		if false == this.keyFrameWrited && false == isHeader {
+			if len(tag.Data) == 0 || flv.VideoFrameType(tag) != flv.FrameType_Keyframe {
 				return
 			}
+			this.keyFrameWrited = true
+		}
+	}
+	if false == isHeader && tag.TagType != flv.FLV_TAG_ScriptData && this.duration >= 0 {
+		if false == this.timeStarted {
+			this.firstTime = tag.Timestamp
+			this.timeStarted = true
 		}
+		//duration为0只发一个关键帧
+		if 0 == this.duration {
+			if tag.TagType == flv.FLV_TAG_Audio {
+				return
+			}
+			this.ended = true
+		} else if tag.Timestamp-this.firstTime > uint32(this.duration*1000) {
+			this.ended = true
+			this.notify()
+			return
+		}
+	}
+	//缓存太多个也要丢，纯音频没有关键帧可等
+	if this.cache.Len() > serviceConfig.CacheCount && this.videoHeader != nil && this.congestion.SkipToKeyFrame() {
+		logger.LOGW(fmt.Sprintf("rtmp play %s too slow,%d tags cached", this.path, this.cache.Len()))
+		this.congestion.Purge(this.cache)
+	}
+	keep, purge, err := this.congestion.Admit(tag)
+	if err != nil {
+		//发送线程断开
+		logger.LOGW(fmt.Sprintf("rtmp play %s too slow:%s", this.path, err.Error()))
+		this.notify()
+		return nil
+	}
+	if purge {
+		logger.LOGW(fmt.Sprintf("rtmp play %s too slow,skip to next keyframe", this.path))
+		this.congestion.Purge(this.cache)
+	}
+	if false == keep {
+		return
+	}
+	this.lastTime = tag.Timestamp
+	this.cache.PushBack(tag.Retain())
+	this.notify()
+	return
+}
+
+//记下最新的metadata和音视频头，恢复播放时要重发
+func (this *rtmpPlayer) saveHeader(tag *flv.FlvTag) bool {
+	var header **flv.FlvTag
+	switch {
+	case tag.TagType == flv.FLV_TAG_ScriptData:
+		header = &this.metadata
+	case flv.IsAudioSequenceHeader(tag):
+		header = &this.audioHeader
+	case flv.IsVideoSequenceHeader(tag):
+		header = &this.videoHeader
+	default:
+		return false
+	}
+	if *header != nil {
+		(*header).Release()
+	}
+	*header = tag.Retain()
+	return true
+}
+
+func (this *rtmpPlayer) pushHeaders() {
+	if this.metadata != nil {
+		this.cache.PushBack(this.metadata.WithTimestamp(this.lastTime))
+	}
+	if this.audioHeader != nil && false == this.noAudio {
+		this.cache.PushBack(this.audioHeader.WithTimestamp(this.lastTime))
+	}
+	if this.videoHeader != nil && false == this.noVideo {
+		this.cache.PushBack(this.videoHeader.WithTimestamp(this.lastTime))
+	}
+}
+
+func (this *rtmpPlayer) clearCache() {
+	for e := this.cache.Front(); e != nil; e = e.Next() {
+		e.Value.(*flv.FlvTag).Release()
+	}
+	this.cache.Init()
+	this.congestion.Reset()
+}
 
+func (this *rtmpPlayer) notify() {
+	select {
+	case this.chData <- true:
+	default:
 	}
+}
+
+//取出缓存的tag，最多一批
+//缓存空了并且播满duration时ended为true，丢帧也追不上时err不为nil
+func (this *rtmpPlayer) takeCache() (tags []*flv.FlvTag, ended bool, err error) {
 	this.mutexCache.Lock()
 	defer this.mutexCache.Unlock()
-	this.cache.PushBack(tag)
+	ended = this.ended && 0 == this.cache.Len()
+	for e := this.cache.Front(); e != nil && len(tags) < rtmp_play_batch; e = this.cache.Front() {
+		tag := e.Value.(*flv.FlvTag)
+		tags = append(tags, tag)
+		this.cache.Remove(e)
+		this.congestion.Sent(tag)
+	}
+	err = this.congestion.Error()
 	return
 }
 
-func (this *rtmpPlayer) setPlayParams(path string, startTime, duration int, reset bool) bool {
-	this.mutexStatus.RLock()
-	defer this.mutexStatus.RUnlock()
+//start在play命令里已经处理完，没有录像，播放端只管duration
+func (this *rtmpPlayer) setPlayParams(duration float64) bool {
+	this.mutexStatus.Lock()
+	defer this.mutexStatus.Unlock()
 	if this.playStatus != play_idle {
 		return false
 	}
+	this.duration = duration
 	return true
 }
 
 func (this *rtmpPlayer) resetCache() {
+	for _, header := range []*flv.FlvTag{this.audioHeader, this.videoHeader, this.metadata} {
+		if header != nil {
+			header.Release()
+		}
+	}
 	this.audioHeader = nil
 	this.videoHeader = nil
 	this.metadata = nil
 	this.beginTime = 0
 	this.keyFrameWrited = false
+	this.timeStarted = false
+	this.ended = false
+	this.noAudio = false
+	this.noVideo = false
 	this.mutexCache.Lock()
 	defer this.mutexCache.Unlock()
+	if this.cache != nil {
+		this.clearCache()
+	}
 	this.cache = list.New()
+	this.congestion = flv.NewCongestionController(serviceConfig.MaxQueueMs)
 }
 
-func (this *rtmpPlayer) threadPlay() {
-	this.playing = true
-	this.waitPlaying.Add(1)
+//playStatus只在stopPlay里拿着锁改回idle，这里自己退出时只记下来
+func (this *rtmpPlayer) threadPlay(chStop chan bool) {
 	defer func() {
-		this.waitPlaying.Done()
 		this.sendPlayEnds()
-		this.playStatus = play_idle
+		this.mutexCache.Lock()
+		this.sendStopped = true
+		this.mutexCache.Unlock()
+		this.waitPlaying.Done()
 	}()
 	this.sendPlayStarts()
 
-	for this.playing == true {
-		this.mutexCache.Lock()
-		if this.cache == nil || this.cache.Len() == 0 {
-			this.mutexCache.Unlock()
-			time.Sleep(10 * time.Millisecond)
-			continue
+	stopSent := false
+	for {
+		select {
+		case <-chStop:
+			return
+		default:
+		}
+		tags, ended, err := this.takeCache()
+		if ended && false == stopSent {
+			this.sendPlayStop()
+			stopSent = true
 		}
-		if this.cache.Len() > serviceConfig.CacheCount {
-			this.mutexCache.Unlock()
+		if err != nil {
+			for _, tag := range tags {
+				tag.Release()
+			}
 			//bw not enough
-			this.rtmp.CmdStatus("warning", "NetStream.Play.InsufficientBW",
-				"instufficient bw", this.rtmp.Link.Path, 0, RTMP_channel_Invoke)
+			this.rtmp.CmdStreamStatus(this.streamId, "warning", "NetStream.Play.InsufficientBW",
+				"instufficient bw", this.path, 0, RTMP_channel_Invoke)
 			//shutdown
 			return
 		}
-		tag := this.cache.Front().Value.(*flv.FlvTag)
-		this.cache.Remove(this.cache.Front())
-		this.mutexCache.Unlock()
-		//时间错误
-
-		err := this.rtmp.SendPacket(FlvTagToRTMPPacket(tag), false)
+		if len(tags) == 0 {
+			select {
+			case <-this.chData:
+			case <-chStop:
+			}
+			continue
+		}
+		stopSent = false
+		packets := make([]*RTMPPacket, len(tags))
+		for i, tag := range tags {
+			packets[i] = FlvTagToRTMPPacket(tag)
+			packets[i].MessageStreamId = this.streamId
+			packets[i].ChunkStreamID = streamChunkId(this.streamId)
+		}
+		err = this.rtmp.SendPacketsUntil(packets, chStop)
+		for _, tag := range tags {
+			tag.Release()
+		}
 		if err != nil {
 			logger.LOGE("send rtmp packet failed in play")
 			return
@@ -199,23 +416,37 @@ func (this *rtmpPlayer) threadPlay() {
 	}
 }
 
+//duration播完了，sink保留，seek可以重新开始
+func (this *rtmpPlayer) sendPlayStop() {
+	err := this.rtmp.CmdStreamStatus(this.streamId, "status", "NetStream.Play.Stop",
+		fmt.Sprintf("Stopped playing %s.", this.path), this.path, 0, RTMP_channel_Invoke)
+	if err != nil {
+		logger.LOGE(err.Error())
+		return
+	}
+	err = this.rtmp.SendCtrl(RTMP_CTRL_streamEof, this.streamId, 0)
+	if err != nil {
+		logger.LOGE(err.Error())
+	}
+}
+
 func (this *rtmpPlayer) sendPlayEnds() {
 
-	err := this.rtmp.SendCtrl(RTMP_CTRL_streamEof, 1, 0)
+	err := this.rtmp.SendCtrl(RTMP_CTRL_streamEof, this.streamId, 0)
 	if err != nil {
 		logger.LOGE(err.Error())
 		return
 	}
 
-	err = this.rtmp.FCUnpublish()
+	err = this.rtmp.FCUnpublish(this.path)
 	if err != nil {
 		logger.LOGE("FCUnpublish failed:" + err.Error())
 		return
 	}
 
-	err = this.rtmp.CmdStatus("status", "NetStream.Play.UnpublishNotify",
-		fmt.Sprintf("%s is unpublished", this.rtmp.Link.Path),
-		this.rtmp.Link.Path, 0, RTMP_channel_Invoke)
+	err = this.rtmp.CmdStreamStatus(this.streamId, "status", "NetStream.Play.UnpublishNotify",
+		fmt.Sprintf("%s is unpublished", this.path),
+		this.path, 0, RTMP_channel_Invoke)
 
 	if err != nil {
 		logger.LOGE(err.Error())
@@ -223,32 +454,12 @@ func (this *rtmpPlayer) sendPlayEnds() {
 	}
 }
 
+//streamBegin和Play.Start在play命令里已经回过了
 func (this *rtmpPlayer) sendPlayStarts() {
-	err := this.rtmp.CmdStatus("status", "NetStream.Play.PublishNotify",
-		fmt.Sprintf("%s is now unpublished", this.rtmp.Link.Path),
-		this.rtmp.Link.Path,
+	err := this.rtmp.CmdStreamStatus(this.streamId, "status", "NetStream.Play.PublishNotify",
+		fmt.Sprintf("%s is now published", this.path),
+		this.path,
 		0, RTMP_channel_Invoke)
-	if err != nil {
-		logger.LOGE(err.Error())
-	}
-	err = this.rtmp.SendCtrl(RTMP_CTRL_streamBegin, 1, 0)
-	if err != nil {
-		logger.LOGE(err.Error())
-		return
-	}
-
-	if true == this.reset {
-		err = this.rtmp.CmdStatus("status", "NetStream.Play.Reset",
-			fmt.Sprintf("Playing and resetting %s", this.rtmp.Link.Path),
-			this.rtmp.Link.Path, 0, RTMP_channel_Invoke)
-		if err != nil {
-			logger.LOGE(err.Error())
-			return
-		}
-	}
-
-	err = this.rtmp.CmdStatus("status", "NetStream.Play.Start",
-		fmt.Sprintf("Started playing %s", this.rtmp.Link.Path), this.rtmp.Link.Path, 0, RTMP_channel_Invoke)
 	if err != nil {
 		logger.LOGE(err.Error())
 		return
@@ -12,6 +12,8 @@ type rtmpPublisher struct {
 	bPublishing bool
 	mutexStatus sync.RWMutex
 	rtmp        *RTMP
+	streamId    uint32
+	path        string
 }
 
 func (this *rtmpPublisher) Init(msg *wssAPI.Msg) (err error) {
@@ -52,13 +54,13 @@ func (this *rtmpPublisher) startPublish() bool {
 	if this.bPublishing == true {
 		return false
 	}
-	err := this.rtmp.SendCtrl(RTMP_CTRL_streamBegin, 1, 0)
+	err := this.rtmp.SendCtrl(RTMP_CTRL_streamBegin, this.streamId, 0)
 	if err != nil {
 		logger.LOGE(err.Error())
 		return false
 	}
-	err = this.rtmp.CmdStatus("status", "NetStream.Publish.Start",
-		fmt.Sprintf("publish %s", this.rtmp.Link.Path), "", 0, RTMP_channel_Invoke)
+	err = this.rtmp.CmdStreamStatus(this.streamId, "status", "NetStream.Publish.Start",
+		fmt.Sprintf("publish %s", this.path), "", 0, RTMP_channel_Invoke)
 	if err != nil {
 		logger.LOGE(err.Error())
 		return false
@@ -73,13 +75,13 @@ func (this *rtmpPublisher) stopPublish() bool {
 	if this.bPublishing == false {
 		return false
 	}
-	err := this.rtmp.SendCtrl(RTMP_CTRL_streamEof, 1, 0)
+	err := this.rtmp.SendCtrl(RTMP_CTRL_streamEof, this.streamId, 0)
 	if err != nil {
 		logger.LOGE(err.Error())
 		return false
 	}
-	err = this.rtmp.CmdStatus("status", "NetStream.Unpublish.Succes",
-		fmt.Sprintf("unpublish %s", this.rtmp.Link.Path), "", 0, RTMP_channel_Invoke)
+	err = this.rtmp.CmdStreamStatus(this.streamId, "status", "NetStream.Unpublish.Succes",
+		fmt.Sprintf("unpublish %s", this.path), "", 0, RTMP_channel_Invoke)
 	if err != nil {
 		logger.LOGE(err.Error())
 		return false
@@ -8,10 +8,10 @@ import (
 	"events/eStreamerEvent"
 	"fmt"
 	"logger"
-	"math/rand"
+	"mediaTypes/amf"
 	"mediaTypes/flv"
-	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 	"wssAPI"
@@ -39,6 +39,12 @@ func PullRTMPLive(task *eRTMPEvent.EvePullRTMPStream) {
 
 func (this *RTMPPuller) Init(msg *wssAPI.Msg) (err error) {
 	this.pullParams = msg.Param1.(*eRTMPEvent.EvePullRTMPStream).Copy()
+	if 0 == this.pullParams.Port {
+		this.pullParams.Port = 1935
+		if "rtmps" == strings.ToLower(this.pullParams.Protocol) {
+			this.pullParams.Port = rtmpsPortDefault
+		}
+	}
 	this.initRTMPLink()
 	this.waitRead = new(sync.WaitGroup)
 	this.chValid = true
@@ -83,21 +89,25 @@ func (this *RTMPPuller) Start(msg *wssAPI.Msg) (err error) {
 	//connect
 	addr := this.pullParams.Address + ":" + strconv.Itoa(this.pullParams.Port)
 
-	conn, err := net.Dial("tcp", addr)
+	opts := &RTMPDialOptions{ServerName: this.pullParams.TLSServerName,
+		InsecureSkipVerify: this.pullParams.TLSInsecureSkipVerify,
+		CAFile:             this.pullParams.TLSCAFile,
+		TimeoutSec:         serviceConfig.TimeoutSec}
+	conn, err := dialRTMP(this.pullParams.Protocol, addr, opts)
 	logger.LOGT(addr)
 	if err != nil {
 		logger.LOGE("connect failed:" + err.Error())
 		return
 	}
 	this.rtmp.Init(conn)
-	//just simple handshake
 	err = this.handleShake()
 	if err != nil {
 		logger.LOGE("handle shake failed")
 		return
 	}
 	//start read thread
-	this.rtmp.BytesIn = 3073
+	this.rtmp.BytesIn = rtmp_handshake_bytes
+	this.rtmp.BytesOut = rtmp_handshake_bytes
 	go this.threadRead()
 	//play
 	err = this.play()
@@ -134,57 +144,11 @@ func (this *RTMPPuller) Stop(msg *wssAPI.Msg) (err error) {
 }
 
 func (this *RTMPPuller) handleShake() (err error) {
-	randomSize := 1528
-	//send c0
-	conn := this.rtmp.Conn
-	c0 := make([]byte, 1)
-	c0[0] = 3
-	_, err = wssAPI.TcpWriteTimeDuration(conn, c0, time.Duration(serviceConfig.TimeoutSec)*time.Second)
-	if err != nil {
-		logger.LOGE("send c0 failed")
-		return
-	}
-	//send c1
-	c1 := make([]byte, randomSize+4+4)
-	for idx := 8; idx < len(c1); idx++ {
-		c1[idx] = byte(rand.Intn(255))
-	}
-	_, err = wssAPI.TcpWriteTimeDuration(conn, c1, time.Duration(serviceConfig.TimeoutSec)*time.Second)
-	if err != nil {
-		logger.LOGE("send c1 failed")
-		return
-	}
-	//read s0
-	s0, err := wssAPI.TcpReadTimeDuration(conn, 1, time.Duration(serviceConfig.TimeoutSec)*time.Second)
-	if err != nil {
-		logger.LOGE("read s0 failed")
-		return
-	}
-	logger.LOGT(s0)
-	//read s1
-	s1, err := wssAPI.TcpReadTimeDuration(conn, randomSize+8, time.Duration(serviceConfig.TimeoutSec)*time.Second)
-	if err != nil {
-		logger.LOGE("read s1 failed")
-		return
-	}
-	//send c2
-	_, err = wssAPI.TcpWriteTimeDuration(conn, s1, time.Duration(serviceConfig.TimeoutSec)*time.Second)
+	err = rtmpClientHandshake(this.rtmp.Conn)
 	if err != nil {
-		logger.LOGE("send c2 failed")
-		return
-	}
-	//read s2
-	s2, err := wssAPI.TcpReadTimeDuration(conn, randomSize+8, time.Duration(serviceConfig.TimeoutSec)*time.Second)
-	if err != nil {
-		logger.LOGE("read s2 failed")
+		logger.LOGE(err.Error())
 		return
 	}
-	for idx := 0; idx < len(s2); idx++ {
-		if c1[idx] != s2[idx] {
-			logger.LOGE("invalid s2")
-			return errors.New("invalid s2")
-		}
-	}
 	logger.LOGT("handleshake ok")
 	return
 }
@@ -214,36 +178,36 @@ func (this *RTMPPuller) SetParent(parent wssAPI.Obj) {
 }
 
 func (this *RTMPPuller) HandleControl(pkt *RTMPPacket) (err error) {
-	ctype, err := AMF0DecodeInt16(pkt.Body)
+	ctype, err := amf.AMF0DecodeInt16(pkt.Body)
 	if err != nil {
 		return
 	}
 	switch ctype {
 	case RTMP_CTRL_streamBegin:
-		streamId, _ := AMF0DecodeInt32(pkt.Body[2:])
+		streamId, _ := amf.AMF0DecodeInt32(pkt.Body[2:])
 		logger.LOGT(fmt.Sprintf("stream begin:%d", streamId))
 	case RTMP_CTRL_streamEof:
-		streamId, _ := AMF0DecodeInt32(pkt.Body[2:])
+		streamId, _ := amf.AMF0DecodeInt32(pkt.Body[2:])
 		logger.LOGT(fmt.Sprintf("stream eof:%d", streamId))
 		err = errors.New("stream eof ")
 	case RTMP_CTRL_streamDry:
-		streamId, _ := AMF0DecodeInt32(pkt.Body[2:])
+		streamId, _ := amf.AMF0DecodeInt32(pkt.Body[2:])
 		logger.LOGT(fmt.Sprintf("stream dry:%d", streamId))
 	case RTMP_CTRL_setBufferLength:
-		streamId, _ := AMF0DecodeInt32(pkt.Body[2:])
-		buffMS, _ := AMF0DecodeInt32(pkt.Body[6:])
+		streamId, _ := amf.AMF0DecodeInt32(pkt.Body[2:])
+		buffMS, _ := amf.AMF0DecodeInt32(pkt.Body[6:])
 		this.rtmp.buffMS = uint32(buffMS)
 		this.rtmp.StreamId = uint32(streamId)
 		//logger.LOGI(fmt.Sprintf("set buffer length --streamid:%d--buffer length:%d", this.StreamId, this.buffMS))
 	case RTMP_CTRL_streamIsRecorded:
-		streamId, _ := AMF0DecodeInt32(pkt.Body[2:])
+		streamId, _ := amf.AMF0DecodeInt32(pkt.Body[2:])
 		logger.LOGT(fmt.Sprintf("stream %d is recorded", streamId))
 	case RTMP_CTRL_pingRequest:
-		timestamp, _ := AMF0DecodeInt32(pkt.Body[2:])
+		timestamp, _ := amf.AMF0DecodeInt32(pkt.Body[2:])
 		this.rtmp.pingResponse(timestamp)
 		logger.LOGT(fmt.Sprintf("ping :%d", timestamp))
 	case RTMP_CTRL_pingResponse:
-		timestamp, _ := AMF0DecodeInt32(pkt.Body[2:])
+		timestamp, _ := amf.AMF0DecodeInt32(pkt.Body[2:])
 		logger.LOGF(fmt.Sprintf("pong :%d", timestamp))
 	case RTMP_CTRL_streamBufferEmpty:
 		//logger.LOGT(fmt.Sprintf("buffer empty"))
@@ -273,19 +237,12 @@ func (this *RTMPPuller) threadRead() {
 		}
 		switch packet.MessageTypeId {
 		case RTMP_PACKET_TYPE_CHUNK_SIZE:
-			this.rtmp.RecvChunkSize, err = AMF0DecodeInt32(packet.Body)
+			this.rtmp.RecvChunkSize, err = amf.AMF0DecodeInt32(packet.Body)
 			logger.LOGT(fmt.Sprintf("chunk size:%d", this.rtmp.RecvChunkSize))
 		case RTMP_PACKET_TYPE_CONTROL:
 			err = this.rtmp.HandleControl(packet)
-		case RTMP_PACKET_TYPE_BYTES_READ_REPORT:
-			logger.LOGT("bytes read report")
-		case RTMP_PACKET_TYPE_SERVER_BW:
-			this.rtmp.AcknowledgementWindowSize, err = AMF0DecodeInt32(packet.Body)
-			logger.LOGT(fmt.Sprintf("acknowledgment size %d", this.rtmp.TargetBW))
-		case RTMP_PACKET_TYPE_CLIENT_BW:
-			this.rtmp.SelfBW, err = AMF0DecodeInt32(packet.Body)
-			this.rtmp.LimitType = uint32(packet.Body[4])
-			logger.LOGT(fmt.Sprintf("peer band width %d %d ", this.rtmp.SelfBW, this.rtmp.LimitType))
+		case RTMP_PACKET_TYPE_BYTES_READ_REPORT, RTMP_PACKET_TYPE_SERVER_BW, RTMP_PACKET_TYPE_CLIENT_BW:
+			err = this.rtmp.HandleFlowControl(packet)
 		case RTMP_PACKET_TYPE_FLEX_MESSAGE:
 			err = this.handleInvoke(packet)
 		case RTMP_PACKET_TYPE_INVOKE:
@@ -295,7 +252,7 @@ func (this *RTMPPuller) threadRead() {
 		case RTMP_PACKET_TYPE_VIDEO:
 			err = this.sendFlvToSrc(packet)
 		case RTMP_PACKET_TYPE_INFO:
-			this.metaDatas.PushBack(packet.Copy())
+			this.metaDatas.PushBack(packet)
 		case RTMP_PACKET_TYPE_FLASH_VIDEO:
 			err = this.processAggregation(packet)
 		default:
@@ -318,6 +275,7 @@ func (this *RTMPPuller) sendFlvToSrc(pkt *RTMPPacket) (err error) {
 				metaDataPkt := e.Value.(*RTMPPacket).ToFLVTag()
 				msg := &wssAPI.Msg{Type: wssAPI.MSG_FLV_TAG, Param1: metaDataPkt}
 				err = this.src.ProcessMessage(msg)
+				metaDataPkt.Release()
 				if err != nil {
 					logger.LOGE(err.Error())
 					this.Stop(nil)
@@ -325,10 +283,12 @@ func (this *RTMPPuller) sendFlvToSrc(pkt *RTMPPacket) (err error) {
 			}
 			this.metaDatas = list.New()
 		}
+		tag := pkt.ToFLVTag()
 		msg := &wssAPI.Msg{}
 		msg.Type = wssAPI.MSG_FLV_TAG
-		msg.Param1 = pkt.ToFLVTag()
+		msg.Param1 = tag
 		err = this.src.ProcessMessage(msg)
+		tag.Release()
 		if err != nil {
 			logger.LOGE(err.Error())
 			this.Stop(nil)
@@ -344,26 +304,22 @@ func (this *RTMPPuller) processAggregation(pkt *RTMPPacket) (err error) {
 	cur := 0
 	firstAggTime := uint32(0xffffffff)
 	for cur < len(pkt.Body) {
-		flvPkt := &flv.FlvTag{}
-		flvPkt.StreamID = 0
-		flvPkt.Timestamp = 0
-		flvPkt.TagType = pkt.Body[cur]
-		pktLength, _ := AMF0DecodeInt24(pkt.Body[cur+1 : cur+4])
-		TimeStamp, _ := AMF0DecodeInt24(pkt.Body[cur+4 : cur+7])
+		pktLength, _ := amf.AMF0DecodeInt24(pkt.Body[cur+1 : cur+4])
+		TimeStamp, _ := amf.AMF0DecodeInt24(pkt.Body[cur+4 : cur+7])
 		TimeStampExtended := uint32(pkt.Body[7])
 		TimeStamp |= (TimeStampExtended << 24)
 		if 0xffffffff == firstAggTime {
 			firstAggTime = TimeStamp
 		}
-		flvPkt.Timestamp = pkt.TimeStamp + TimeStamp - firstAggTime
-		flvPkt.Data = make([]byte, pktLength)
-
+		flvPkt := flv.NewSharedTag(pkt.Body[cur], pkt.TimeStamp+TimeStamp-firstAggTime,
+			wssAPI.AllocBuffer(int(pktLength)))
 		copy(flvPkt.Data, pkt.Body[cur+11:cur+11+int(pktLength)])
 		cur += 11 + int(pktLength) + 4
 		msg := &wssAPI.Msg{}
 		msg.Type = wssAPI.MSG_FLV_TAG
 		msg.Param1 = flvPkt
 		err = this.src.ProcessMessage(msg)
+		flvPkt.Release()
 		if err != nil {
 			logger.LOGE(fmt.Sprintf("send aggregation pkts failed"))
 			return
@@ -394,67 +350,38 @@ func (this *RTMPPuller) play() (err error) {
 	return
 }
 
-func (this *RTMPPuller) getProp(pkt *RTMPPacket) (amfobj *AMF0Object, err error) {
-	if RTMP_PACKET_TYPE_FLEX_MESSAGE == pkt.MessageTypeId {
-		amfobj, err = AMF0DecodeObj(pkt.Body[1:])
-	} else {
-		amfobj, err = AMF0DecodeObj(pkt.Body)
-	}
-	if err != nil {
-		logger.LOGE("recved invalid amf0 object")
-		return
-	}
-	if amfobj.Props.Len() == 0 {
-		logger.LOGT(pkt.Body)
-		logger.LOGT(string(pkt.Body))
-		return nil, errors.New("no props")
-	}
-	return
-}
-
 func (this *RTMPPuller) handleInvoke(pkt *RTMPPacket) (err error) {
 
-	amfobj, err := this.getProp(pkt)
-	if err != nil || amfobj == nil {
-		logger.LOGE("decode amf failed")
+	cmd, err := ParseCommand(pkt)
+	if err != nil {
+		logger.LOGE("recved invalid rtmp command:" + err.Error())
 		return
 	}
-	methodProp := amfobj.Props.Front().Value.(*AMF0Property)
-	logger.LOGD(methodProp.Value.StrValue)
-	switch methodProp.Value.StrValue {
+	logger.LOGD(cmd.Name)
+	switch cmd.Name {
 
 	case "_result":
-		err = this.handleRTMPResult(amfobj)
+		err = this.handleRTMPResult(cmd)
 		logger.LOGD(pkt.Body,pkt.MessageTypeId)
 	case "onBWDone":
 		this.rtmp.SendCheckBW()
 		this.rtmp.SendReleaseStream()
 		this.rtmp.SendFCPublish()
 	case "_onbwcheck":
-		err = this.rtmp.SendCheckBWResult(amfobj.AMF0GetPropByIndex(1).Value.NumValue)
+		err = this.rtmp.SendCheckBWResult(cmd.TransactionId)
 	case "onFCPublish":
 	case "_error":
 	case "_onbwdone":
 	case "onStatus":
-		code := ""
-		level := ""
-		desc := ""
-		if amfobj.Props.Len() >= 4 {
-			objStatus := amfobj.AMF0GetPropByIndex(3).Value.ObjValue
-			for e := objStatus.Props.Front(); e != nil; e = e.Next() {
-				prop := e.Value.(*AMF0Property)
-				switch prop.Name {
-				case "code":
-					code = prop.Value.StrValue
-				case "level":
-					level = prop.Value.StrValue
-				case "description":
-					desc = prop.Value.StrValue
-				}
-			}
+		var info *StatusInfo
+		info, err = cmd.StatusInfo()
+		if err != nil {
+			logger.LOGE("invalid onStatus:" + err.Error())
+			return
 		}
-		logger.LOGT(level)
-		logger.LOGT(desc)
+		code := info.Code
+		logger.LOGT(info.Level)
+		logger.LOGT(info.Description)
 		//close
 		if code == "NetStream.Failed" || code == "NetStream.Play.Failed" ||
 			code == "NetStream.Play.StreamNotFound" || code == "NetConnection.Connect.InvalidApp" ||
@@ -473,16 +400,16 @@ func (this *RTMPPuller) handleInvoke(pkt *RTMPPacket) (err error) {
 			logger.LOGW("start by media data")
 		}
 	default:
-		logger.LOGW(fmt.Sprintf("method %s not processed", methodProp.Value.StrValue))
-		amfobj.Dump()
+		logger.LOGW(fmt.Sprintf("method %s not processed", cmd.Name))
+		cmd.Dump()
 		logger.LOGD(pkt.Body,pkt.MessageTypeId)
 	}
 
 	return
 }
 
-func (this *RTMPPuller) handleRTMPResult(amfobj *AMF0Object) (err error) {
-	idx := int32(amfobj.AMF0GetPropByIndex(1).Value.NumValue)
+func (this *RTMPPuller) handleRTMPResult(cmd *RTMPCommand) (err error) {
+	idx := int32(cmd.TransactionId)
 	this.rtmp.mutexMethod.Lock()
 	methodRet, ok := this.rtmp.methodCache[idx]
 	if ok == true {
@@ -506,7 +433,13 @@ func (this *RTMPPuller) handleRTMPResult(amfobj *AMF0Object) (err error) {
 			return
 		}
 	case "createStream":
-		this.rtmp.StreamId = uint32(amfobj.AMF0GetPropByIndex(3).Value.NumValue)
+		var streamId float64
+		streamId, err = cmd.NumberResult()
+		if err != nil {
+			logger.LOGE("invalid createStream result:" + err.Error())
+			return
+		}
+		this.rtmp.StreamId = uint32(streamId)
 		err = this.rtmp.SendPlay()
 		logger.LOGD("send play &&&")
 		if err != nil {
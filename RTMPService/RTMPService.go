@@ -1,6 +1,7 @@
 package RTMPService
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"events/eRTMPEvent"
@@ -14,16 +15,20 @@ import (
 )
 
 const (
-	rtmpTypeHandler  = "rtmpHandler"
-	rtmpTypePuller   = "rtmpPuller"
-	livePathDefault  = "live"
-	timeoutDefault   = 3000
-	rtmpCacheDefault = 1000
+	rtmpTypeHandler   = "rtmpHandler"
+	rtmpTypePuller    = "rtmpPuller"
+	livePathDefault   = "live"
+	timeoutDefault    = 3000
+	rtmpCacheDefault  = 1000
+	maxQueueMsDefault = 3000
 )
 
 type RTMPService struct {
-	listener *net.TCPListener
-	parent   wssAPI.Obj
+	listener    *net.TCPListener
+	tlsListener net.Listener
+	certs       *certStore
+	chExit      chan bool
+	parent      wssAPI.Obj
 }
 
 func init() {
@@ -31,10 +36,14 @@ func init() {
 }
 
 type RTMPConfig struct {
-	Port       int    `json:"Port"`
-	TimeoutSec int    `json:"TimeoutSec"`
-	LivePath   string `json:"LivePath"`
-	CacheCount int    `json:"CacheCount"`
+	Port       int            `json:"Port"`
+	TimeoutSec int            `json:"TimeoutSec"`
+	LivePath   string         `json:"LivePath"`
+	CacheCount int            `json:"CacheCount"`
+	MaxQueueMs int            `json:"MaxQueueMs"` //播放端积压超过它丢帧，3倍断开
+	ChunkSize  int            `json:"ChunkSize"`
+	TLS        *RTMPTLSConfig `json:"TLS,omitempty"`
+	RTMPT      *RTMPTConfig   `json:"RTMPT,omitempty"`
 }
 
 var service *RTMPService
@@ -52,6 +61,10 @@ func (this *RTMPService) Init(msg *wssAPI.Msg) (err error) {
 		return errors.New("init rtmp service failed")
 	}
 	service = this
+	//HTTPMUX在服务Start之前就开始监听了，路由要在这里加
+	if serviceConfig.RTMPT != nil {
+		addRTMPTRoutes(serviceConfig.RTMPT.Port)
+	}
 	return
 }
 
@@ -68,12 +81,41 @@ func (this *RTMPService) Start(msg *wssAPI.Msg) (err error) {
 		logger.LOGE(err.Error())
 		return
 	}
-	go this.rtmpLoop()
+	go this.rtmpLoop(this.listener)
+	if serviceConfig.TLS != nil {
+		err = this.startTLS()
+		if err != nil {
+			logger.LOGE(err.Error())
+			return
+		}
+	}
+	return
+}
+
+func (this *RTMPService) startTLS() (err error) {
+	this.certs, err = newCertStore(serviceConfig.TLS.Certs)
+	if err != nil {
+		return
+	}
+	tlsConfig := &tls.Config{GetCertificate: this.certs.getCertificate}
+	listener, err := net.Listen("tcp4", ":"+strconv.Itoa(serviceConfig.TLS.Port))
+	if err != nil {
+		return
+	}
+	this.tlsListener = tls.NewListener(listener, tlsConfig)
+	this.chExit = make(chan bool)
+	go this.certs.threadReload(serviceConfig.TLS.ReloadSec, this.chExit)
+	go this.rtmpLoop(this.tlsListener)
+	logger.LOGI("rtmps listen on " + strconv.Itoa(serviceConfig.TLS.Port))
 	return
 }
 
 func (this *RTMPService) Stop(msg *wssAPI.Msg) (err error) {
 	this.listener.Close()
+	if this.tlsListener != nil {
+		this.tlsListener.Close()
+		close(this.chExit)
+	}
 	return
 }
 
@@ -93,7 +135,7 @@ func (this *RTMPService) HandleTask(task wssAPI.Task) (err error) {
 		}
 		taskPull.Protocol = strings.ToLower(taskPull.Protocol)
 		switch taskPull.Protocol {
-		case "rtmp":
+		case "rtmp", "rtmps":
 			PullRTMPLive(taskPull)
 		default:
 			logger.LOGE(fmt.Sprintf("fmt %s not support now", taskPull.Protocol))
@@ -131,21 +173,41 @@ func (this *RTMPService) loadConfigFile(fileName string) (err error) {
 	if serviceConfig.CacheCount == 0 {
 		serviceConfig.CacheCount = rtmpCacheDefault
 	}
+	if serviceConfig.MaxQueueMs <= 0 {
+		serviceConfig.MaxQueueMs = maxQueueMsDefault
+	}
+	if serviceConfig.ChunkSize == 0 {
+		serviceConfig.ChunkSize = RTMP_better_chunk_size
+	}
 	strPort := ""
 	if serviceConfig.Port != 1935 {
 		strPort = strconv.Itoa(serviceConfig.Port)
 	}
 	logger.LOGI("rtmp://address:" + strPort + "/" + serviceConfig.LivePath + "/streamName")
 	logger.LOGI("rtmp timeout: " + strconv.Itoa(serviceConfig.TimeoutSec) + " s")
+	if serviceConfig.TLS != nil {
+		if serviceConfig.TLS.Port == 0 {
+			serviceConfig.TLS.Port = rtmpsPortDefault
+		}
+		if serviceConfig.TLS.ReloadSec <= 0 {
+			serviceConfig.TLS.ReloadSec = certReloadSecDefault
+		}
+	}
+	if serviceConfig.RTMPT != nil && serviceConfig.RTMPT.Port == 0 {
+		serviceConfig.RTMPT.Port = rtmptPortDefault
+	}
 	return
 }
 
-func (this *RTMPService) rtmpLoop() {
+func (this *RTMPService) rtmpLoop(listener net.Listener) {
 	for {
-		conn, err := this.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			logger.LOGW(err.Error())
-			continue
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			return
 		}
 		go this.handleConnect(conn)
 	}
@@ -163,6 +225,8 @@ func (this *RTMPService) handleConnect(conn net.Conn) {
 
 	rtmp := &RTMP{}
 	rtmp.Init(conn)
+	rtmp.BytesIn = rtmp_handshake_bytes
+	rtmp.BytesOut = rtmp_handshake_bytes
 
 	msgInit := &wssAPI.Msg{}
 	msgInit.Param1 = rtmp
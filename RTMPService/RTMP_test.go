@@ -0,0 +1,144 @@
+package RTMPService
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+//记下读到的字节，用来检查每个消息第一个chunk的fmt
+type recordConn struct {
+	net.Conn
+	data []byte
+}
+
+func (this *recordConn) Read(b []byte) (n int, err error) {
+	n, err = this.Conn.Read(b)
+	this.data = append(this.data, b[:n]...)
+	return
+}
+
+func newTestPacket(ts, length uint32, streamId uint32) *RTMPPacket {
+	pkt := &RTMPPacket{
+		ChunkStreamID:   RTMP_channel_SendLive,
+		TimeStamp:       ts,
+		MessageLength:   length,
+		MessageTypeId:   RTMP_PACKET_TYPE_VIDEO,
+		MessageStreamId: streamId,
+		Body:            make([]byte, length),
+	}
+	for i := range pkt.Body {
+		pkt.Body[i] = byte(i + int(ts))
+	}
+	return pkt
+}
+
+//writePackets压缩的头ReadChunk要能还原，message stream id是小端
+func TestChunkRoundTrip(t *testing.T) {
+	tests := []struct {
+		pkt *RTMPPacket
+		fmt byte
+	}{
+		{newTestPacket(0, 10, 1), RTMP_HEADER_TYPE_0},
+		{newTestPacket(40, 20, 1), RTMP_HEADER_TYPE_1},
+		{newTestPacket(100, 20, 1), RTMP_HEADER_TYPE_2},
+		{newTestPacket(160, 20, 1), RTMP_HEADER_TYPE_3},
+		{newTestPacket(200, 20, 0x01020304), RTMP_HEADER_TYPE_0},
+		{newTestPacket(240, 300, 0x01020304), RTMP_HEADER_TYPE_1},
+		{newTestPacket(0x1000000, 300, 0x01020304), RTMP_HEADER_TYPE_2},
+		{newTestPacket(0x2000000-240, 300, 0x01020304), RTMP_HEADER_TYPE_3},
+		{newTestPacket(10, 300, 0x01020304), RTMP_HEADER_TYPE_0},
+	}
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	sender := &RTMP{}
+	sender.Init(c1)
+	reader := &recordConn{Conn: c2}
+	receiver := &RTMP{}
+	receiver.Init(reader)
+
+	packets := make([]*RTMPPacket, len(tests))
+	for i, test := range tests {
+		packets[i] = test.pkt
+	}
+	chErr := make(chan error, 1)
+	go func() {
+		chErr <- sender.writePackets(packets)
+	}()
+	for i, test := range tests {
+		start := len(reader.data)
+		pkt, err := receiver.ReadPacket()
+		if err != nil {
+			t.Fatalf("packet %d: %s", i, err.Error())
+		}
+		if chunkFmt := reader.data[start] >> 6; chunkFmt != test.fmt {
+			t.Errorf("packet %d: fmt %d want %d", i, chunkFmt, test.fmt)
+		}
+		if pkt.MessageStreamId != test.pkt.MessageStreamId {
+			t.Errorf("packet %d: stream id %x want %x", i, pkt.MessageStreamId, test.pkt.MessageStreamId)
+		}
+		if pkt.TimeStamp != test.pkt.TimeStamp {
+			t.Errorf("packet %d: timestamp %d want %d", i, pkt.TimeStamp, test.pkt.TimeStamp)
+		}
+		if pkt.MessageTypeId != test.pkt.MessageTypeId || false == bytes.Equal(pkt.Body, test.pkt.Body) {
+			t.Errorf("packet %d: body mismatch", i)
+		}
+	}
+	if err := <-chErr; err != nil {
+		t.Fatal(err)
+	}
+}
+
+//同一个chunk stream上连续发，头按上一个消息压缩
+func TestAppendMessageHeader(t *testing.T) {
+	tests := []struct {
+		chunkStreamId int32
+		ts            uint32
+		length        uint32
+		streamId      uint32
+		header        []byte
+		hasExt        bool
+	}{
+		{6, 0, 10, 1, []byte{0x06, 0, 0, 0, 0, 0, 10, 9, 1, 0, 0, 0}, false},
+		//长度变了
+		{6, 40, 20, 1, []byte{0x46, 0, 0, 40, 0, 0, 20, 9}, false},
+		//时间差和上一个一样
+		{6, 80, 20, 1, []byte{0xc6}, false},
+		{6, 130, 20, 1, []byte{0x86, 0, 0, 50}, false},
+		{6, 180, 20, 1, []byte{0xc6}, false},
+		//时间戳回退
+		{6, 100, 20, 1, []byte{0x06, 0, 0, 100, 0, 0, 20, 9, 1, 0, 0, 0}, false},
+		//fmt 0后面没有时间差可以沿用
+		{6, 100, 20, 1, []byte{0x86, 0, 0, 0}, false},
+		//流id变了
+		{6, 200, 20, 2, []byte{0x06, 0, 0, 200, 0, 0, 20, 9, 2, 0, 0, 0}, false},
+		//扩展时间戳，fmt 0是绝对时间，其他是时间差
+		{7, 0x1000000, 20, 2, []byte{0x07, 0xff, 0xff, 0xff, 0, 0, 20, 9, 2, 0, 0, 0, 1, 0, 0, 0}, true},
+		{7, 0x2000000, 20, 2, []byte{0x87, 0xff, 0xff, 0xff, 1, 0, 0, 0}, true},
+		{7, 0x3000000, 20, 2, []byte{0xc7, 1, 0, 0, 0}, true},
+		{7, 0x3000010, 20, 2, []byte{0x87, 0, 0, 0x10}, false},
+		{8, 0, 20, 2, []byte{0x08, 0, 0, 0, 0, 0, 20, 9, 2, 0, 0, 0}, false},
+		{8, 0xffffff, 20, 2, []byte{0x88, 0xff, 0xff, 0xff, 0, 0xff, 0xff, 0xff}, true},
+		{8, 0xfffffe + 0xffffff, 20, 2, []byte{0x88, 0xff, 0xff, 0xfe}, false},
+		//2字节和3字节的basic header
+		{64, 0, 20, 2, []byte{0x00, 0, 0, 0, 0, 0, 0, 20, 9, 2, 0, 0, 0}, false},
+		{320, 0, 20, 2, []byte{0x01, 0, 1, 0, 0, 0, 0, 0, 20, 9, 2, 0, 0, 0}, false},
+		{320, 10, 20, 2, []byte{0x81, 0, 1, 0, 0, 10}, false},
+	}
+	rtmp := &RTMP{}
+	rtmp.Init(nil)
+	for i, test := range tests {
+		pkt := &RTMPPacket{
+			ChunkStreamID:   test.chunkStreamId,
+			TimeStamp:       test.ts,
+			MessageLength:   test.length,
+			MessageTypeId:   RTMP_PACKET_TYPE_VIDEO,
+			MessageStreamId: test.streamId,
+		}
+		header, _, hasExt := rtmp.appendMessageHeader(nil, pkt)
+		if false == bytes.Equal(header, test.header) || hasExt != test.hasExt {
+			t.Errorf("case %d: header % x ext %v want % x ext %v", i, header, hasExt, test.header, test.hasExt)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"logger"
 	"math/rand"
+	"mediaTypes/amf"
 	"net"
 	"strconv"
 	"time"
@@ -18,6 +19,7 @@ const (
 	rtmp_randomsize    = 1536
 	rtmp_digestsize    = 32
 	rtmp_serverVersion = 0x5033029
+	rtmp_clientVersion = 0x80000702
 )
 
 var GENUINE_FMS_KEY = []byte{
@@ -61,7 +63,7 @@ func rtmpHandleshake(conn net.Conn) (err error) {
 	}
 	//判断简单握手还是复杂握手
 	var version uint32
-	version, err = AMF0DecodeInt32(buf[5:])
+	version, err = amf.AMF0DecodeInt32(buf[5:])
 	if version == 0 {
 		err = sampleHandleShake(conn, buf[1:])
 		if err != nil {
@@ -131,21 +133,10 @@ func complexHandleShake(conn net.Conn, c1 []byte) (err error) {
 	//s1
 	s1 := createComplexS1()
 	off := getDigestOffset(s1, scheme)
-	s1p := make([]byte, rtmp_randomsize-rtmp_digestsize)
-	copy(s1p, s1[0:off])
-	copy(s1p[off:], s1[off+rtmp_digestsize:])
-	h := hmac.New(sha256.New, GENUINE_FMS_KEY[:36])
-	h.Write(s1p)
-	s1digest := h.Sum(nil)
-	copy(s1[off:off+rtmp_digestsize], s1digest)
+	copy(s1[off:off+rtmp_digestsize], handshakeDigest(s1, off, GENUINE_FMS_KEY[:36]))
 	//s2
-	h2 := hmac.New(sha256.New, GENUINE_FMS_KEY[:68])
-	h2.Write(digest)
-	s2tmpHash := h2.Sum(nil)
 	s2Random := createComplexS2()
-	h2 = hmac.New(sha256.New, s2tmpHash)
-	h2.Write(s2Random)
-	s2Hash := h2.Sum(nil)
+	s2Hash := handshakeResponse(s2Random, digest, GENUINE_FMS_KEY[:68])
 	//send s0 s1 s2
 	tmp8 := make([]byte, 1)
 	tmp8[0] = 0x03
@@ -200,6 +191,103 @@ func createComplexS1() (s1 []byte) {
 	}
 	return s1
 }
+//c1和s1一样，版本后面是随机数，digest按scheme 0放
+func createComplexC1() (c1 []byte, digest []byte) {
+	c1 = make([]byte, rtmp_randomsize)
+	c1[4] = byte((rtmp_clientVersion >> 24) & 0xff)
+	c1[5] = byte((rtmp_clientVersion >> 16) & 0xff)
+	c1[6] = byte((rtmp_clientVersion >> 8) & 0xff)
+	c1[7] = byte((rtmp_clientVersion >> 0) & 0xff)
+	for i := 8; i < rtmp_randomsize; i++ {
+		c1[i] = byte(rand.Int() % 256)
+	}
+	off := getDigestOffset(c1, 0)
+	digest = handshakeDigest(c1, off, GENUINE_FP_KEY[:30])
+	copy(c1[off:off+rtmp_digestsize], digest)
+	return
+}
+
+//除去digest本身的1504字节做HMAC
+func handshakeDigest(buf []byte, off int, key []byte) []byte {
+	p := make([]byte, 0, rtmp_randomsize-rtmp_digestsize)
+	p = append(p, buf[:off]...)
+	p = append(p, buf[off+rtmp_digestsize:]...)
+	h := hmac.New(sha256.New, key)
+	h.Write(p)
+	return h.Sum(nil)
+}
+
+//s2和c2的最后32字节：先用对端digest算出key，再对前面的随机数做HMAC
+func handshakeResponse(random, peerDigest, key []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(peerDigest)
+	h = hmac.New(sha256.New, h.Sum(nil))
+	h.Write(random)
+	return h.Sum(nil)
+}
+
+//s1的digest用FMS的key，两种scheme都试
+func validServerS1(s1 []byte) (digest []byte, err error) {
+	for _, scheme := range []int{0, 1} {
+		off := getDigestOffset(s1, scheme)
+		if -1 == off {
+			continue
+		}
+		if hmac.Equal(handshakeDigest(s1, off, GENUINE_FMS_KEY[:36]), s1[off:off+rtmp_digestsize]) {
+			digest = make([]byte, rtmp_digestsize)
+			copy(digest, s1[off:off+rtmp_digestsize])
+			return digest, nil
+		}
+	}
+	return nil, errors.New("s1 digest not valid")
+}
+
+//客户端握手，按Flash Player的方式发带digest的c1
+//s1没有有效digest时服务器只支持简单握手，c2直接回s1
+func rtmpClientHandshake(conn net.Conn) (err error) {
+	timeout := time.Duration(serviceConfig.TimeoutSec) * time.Second
+	c1, c1Digest := createComplexC1()
+	c01 := make([]byte, rtmp_randomsize+1)
+	c01[0] = 0x03
+	copy(c01[1:], c1)
+	_, err = wssAPI.TcpWriteTimeDuration(conn, c01, timeout)
+	if err != nil {
+		return
+	}
+	s01, err := wssAPI.TcpReadTimeDuration(conn, rtmp_randomsize+1, timeout)
+	if err != nil {
+		return
+	}
+	if s01[0] != 0x03 {
+		return errors.New("invalid handleshake version")
+	}
+	s1 := s01[1:]
+	s2, err := wssAPI.TcpReadTimeDuration(conn, rtmp_randomsize, timeout)
+	if err != nil {
+		return
+	}
+	var c2 []byte
+	s1Digest, err := validServerS1(s1)
+	if err != nil {
+		logger.LOGT("simple handshake:" + err.Error())
+		if false == bytes.Equal(s2, c1) {
+			return errors.New("rtmp handleshake error:s2 != c1")
+		}
+		c2 = s1
+	} else {
+		size := rtmp_randomsize - rtmp_digestsize
+		//有的服务器s1带digest，s2还是回c1
+		if false == hmac.Equal(handshakeResponse(s2[:size], c1Digest, GENUINE_FMS_KEY[:68]), s2[size:]) &&
+			false == bytes.Equal(s2, c1) {
+			return errors.New("rtmp handleshake error:s2 digest not valid")
+		}
+		c2 = createComplexS2()
+		c2 = append(c2, handshakeResponse(c2, s1Digest, GENUINE_FP_KEY[:62])...)
+	}
+	_, err = wssAPI.TcpWriteTimeDuration(conn, c2, timeout)
+	return
+}
+
 func createComplexS2() (s2 []byte) {
 
 	s2 = make([]byte, rtmp_randomsize-rtmp_digestsize)
@@ -0,0 +1,117 @@
+package RTMPService
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"errors"
+	"math/rand"
+	"net"
+	"testing"
+	"wssAPI"
+)
+
+type handshakeSide func(conn net.Conn) error
+
+//只会简单握手的服务器，s2回c1
+func simpleHandshakeServer(conn net.Conn) (err error) {
+	c01, err := wssAPI.TcpRead(conn, rtmp_randomsize+1)
+	if err != nil {
+		return
+	}
+	return sampleHandleShake(conn, c01[1:])
+}
+
+//简单握手的客户端，版本为0
+func simpleHandshakeClient(conn net.Conn) (err error) {
+	c01 := make([]byte, rtmp_randomsize+1)
+	c01[0] = 0x03
+	rand.Read(c01[9:])
+	_, err = conn.Write(c01)
+	if err != nil {
+		return
+	}
+	s012, err := wssAPI.TcpRead(conn, rtmp_randomsize*2+1)
+	if err != nil {
+		return
+	}
+	if false == bytes.Equal(s012[rtmp_randomsize+1:], c01[1:]) {
+		return errors.New("s2 != c1")
+	}
+	_, err = conn.Write(s012[1 : rtmp_randomsize+1])
+	return
+}
+
+//s2是随机数，既不是c1也没有正确的digest
+func badS2HandshakeServer(digest bool) handshakeSide {
+	return func(conn net.Conn) (err error) {
+		_, err = wssAPI.TcpRead(conn, rtmp_randomsize+1)
+		if err != nil {
+			return
+		}
+		s1 := createComplexS1()
+		if digest {
+			off := getDigestOffset(s1, 1)
+			copy(s1[off:off+rtmp_digestsize], handshakeDigest(s1, off, GENUINE_FMS_KEY[:36]))
+		}
+		s2 := make([]byte, rtmp_randomsize)
+		rand.Read(s2)
+		_, err = conn.Write(append(append([]byte{0x03}, s1...), s2...))
+		return
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	serviceConfig.TimeoutSec = 10
+	tests := []struct {
+		name      string
+		server    handshakeSide
+		client    handshakeSide
+		clientErr bool
+		complex   bool
+	}{
+		{"complex", rtmpHandleshake, rtmpClientHandshake, false, true},
+		{"simple server", simpleHandshakeServer, rtmpClientHandshake, false, false},
+		{"simple client", rtmpHandleshake, simpleHandshakeClient, false, false},
+		{"bad simple s2", badS2HandshakeServer(false), rtmpClientHandshake, true, false},
+		{"bad complex s2", badS2HandshakeServer(true), rtmpClientHandshake, true, false},
+	}
+	for _, test := range tests {
+		c1, c2 := net.Pipe()
+		serverConn := &recordConn{Conn: c1}
+		clientConn := &recordConn{Conn: c2}
+		chErr := make(chan error, 1)
+		go func() {
+			chErr <- test.server(serverConn)
+		}()
+		err := test.client(clientConn)
+		if (err != nil) != test.clientErr {
+			t.Errorf("%s: client err %v", test.name, err)
+		}
+		c2.Close()
+		serverErr := <-chErr
+		c1.Close()
+		if test.clientErr {
+			continue
+		}
+		if serverErr != nil {
+			t.Errorf("%s: server err %v", test.name, serverErr)
+			continue
+		}
+		if false == test.complex {
+			continue
+		}
+		//c2最后32字节是用s1的digest算的
+		s1 := clientConn.data[1 : rtmp_randomsize+1]
+		s1Digest, err := validServerS1(s1)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+		c2Data := serverConn.data[rtmp_randomsize+1:]
+		size := rtmp_randomsize - rtmp_digestsize
+		if len(c2Data) != rtmp_randomsize ||
+			false == hmac.Equal(handshakeResponse(c2Data[:size], s1Digest, GENUINE_FP_KEY[:62]), c2Data[size:]) {
+			t.Errorf("%s: c2 digest not valid", test.name)
+		}
+	}
+}
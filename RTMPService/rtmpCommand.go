@@ -0,0 +1,207 @@
+package RTMPService
+
+import (
+	"errors"
+	"fmt"
+	"mediaTypes/amf"
+)
+
+//命令消息：名字，事务号，命令对象(可以为null)，后面是参数
+type RTMPCommand struct {
+	Name          string
+	TransactionId float64
+	CmdObject     *amf.AMF0Property
+	Args          []*amf.AMF0Property
+	raw           *amf.AMF0Object
+}
+
+type ConnectCmdObject struct {
+	App            string   `amf:"app,required"`
+	FlashVer       string   `amf:"flashVer"`
+	SwfUrl         string   `amf:"swfUrl"`
+	TcUrl          string   `amf:"tcUrl"`
+	Fpad           bool     `amf:"fpad"`
+	Capabilities   float64  `amf:"capabilities"`
+	AudioCodecs    float64  `amf:"audioCodecs"`
+	VideoCodecs    float64  `amf:"videoCodecs"`
+	VideoFunction  float64  `amf:"videoFunction"`
+	PageUrl        string   `amf:"pageUrl"`
+	ObjectEncoding float64  `amf:"objectEncoding"`
+	FourCcList     []string `amf:"fourCcList"`
+}
+
+type PublishArgs struct {
+	Name string `amf:"publishingName,required"`
+	Type string `amf:"publishingType"`
+}
+
+//start -2:直播优先，-1:只要直播，>=0:点播的开始时间
+type PlayArgs struct {
+	Name     string  `amf:"streamName,required"`
+	Start    float64 `amf:"start"`
+	Duration float64 `amf:"duration"`
+	Reset    bool    `amf:"reset"`
+}
+
+//pause true暂停，false恢复，时间是毫秒
+type PauseArgs struct {
+	Pause bool    `amf:"pause,required"`
+	Time  float64 `amf:"milliSeconds"`
+}
+
+type SeekArgs struct {
+	Time float64 `amf:"milliSeconds,required"`
+}
+
+//receiveAudio和receiveVideo
+type ReceiveArgs struct {
+	Flag bool `amf:"flag,required"`
+}
+
+type DeleteStreamArgs struct {
+	StreamId float64 `amf:"streamId,required"`
+}
+
+type StatusInfo struct {
+	Level       string  `amf:"level,required"`
+	Code        string  `amf:"code,required"`
+	Description string  `amf:"description,omitempty"`
+	Details     string  `amf:"details,omitempty"`
+	ClientId    float64 `amf:"clientId"`
+}
+
+//invoke和flex message,flex message第一个字节为0,后面是amf0，对象可以用AVM+切到amf3
+func DecodeCommand(pkt *RTMPPacket) (ret *amf.AMF0Object, err error) {
+	body := pkt.Body
+	if RTMP_PACKET_TYPE_FLEX_MESSAGE == pkt.MessageTypeId {
+		if len(body) < 1 {
+			return nil, errors.New("empty flex message")
+		}
+		body = body[1:]
+	}
+	return amf.AMF0DecodeObj(body)
+}
+
+func ParseCommand(pkt *RTMPPacket) (cmd *RTMPCommand, err error) {
+	obj, err := DecodeCommand(pkt)
+	if err != nil {
+		return
+	}
+	values := make([]*amf.AMF0Property, 0, obj.Props.Len())
+	for e := obj.Props.Front(); e != nil; e = e.Next() {
+		values = append(values, e.Value.(*amf.AMF0Property))
+	}
+	if len(values) == 0 {
+		return nil, errors.New("empty command")
+	}
+	if values[0].PropType != amf.AMF0_string && values[0].PropType != amf.AMF0_long_string {
+		return nil, errors.New("command name is not a string")
+	}
+	cmd = &RTMPCommand{Name: values[0].Value.StrValue, raw: obj}
+	if len(values) > 1 {
+		if values[1].PropType != amf.AMF0_number {
+			return nil, errors.New(fmt.Sprintf("command %s transaction id is not a number", cmd.Name))
+		}
+		cmd.TransactionId = values[1].Value.NumValue
+	}
+	if len(values) > 2 {
+		if values[2].PropType != amf.AMF0_null && values[2].PropType != amf.AMF0_undefined {
+			cmd.CmdObject = values[2]
+		}
+		cmd.Args = values[3:]
+	}
+	return
+}
+
+func (this *RTMPCommand) Dump() {
+	this.raw.Dump()
+}
+
+func (this *RTMPCommand) ConnectObject() (obj *ConnectCmdObject, err error) {
+	if nil == this.CmdObject {
+		return nil, errors.New("connect without command object")
+	}
+	//只有app必须有，其他字段类型不对(fpad给数字、objectEncoding给字符串)不影响连接
+	obj = &ConnectCmdObject{}
+	err = amf.UnmarshalLoose(this.CmdObject, obj)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (this *RTMPCommand) PublishArgs() (args *PublishArgs, err error) {
+	args = &PublishArgs{}
+	err = amf.UnmarshalArgs(this.Args, args)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (this *RTMPCommand) PlayArgs() (args *PlayArgs, err error) {
+	args = &PlayArgs{Start: -2, Duration: -1}
+	err = amf.UnmarshalArgs(this.Args, args)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (this *RTMPCommand) PauseArgs() (args *PauseArgs, err error) {
+	args = &PauseArgs{}
+	err = amf.UnmarshalArgs(this.Args, args)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (this *RTMPCommand) SeekArgs() (args *SeekArgs, err error) {
+	args = &SeekArgs{}
+	err = amf.UnmarshalArgs(this.Args, args)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (this *RTMPCommand) ReceiveArgs() (args *ReceiveArgs, err error) {
+	args = &ReceiveArgs{}
+	err = amf.UnmarshalArgs(this.Args, args)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (this *RTMPCommand) DeleteStreamArgs() (args *DeleteStreamArgs, err error) {
+	args = &DeleteStreamArgs{}
+	err = amf.UnmarshalArgs(this.Args, args)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+//onStatus的info在第一个参数
+func (this *RTMPCommand) StatusInfo() (info *StatusInfo, err error) {
+	if len(this.Args) == 0 {
+		return nil, errors.New("onStatus without info object")
+	}
+	info = &StatusInfo{}
+	err = amf.Unmarshal(this.Args[0], info)
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+//_result里的数字返回值，比如createStream的stream id
+func (this *RTMPCommand) NumberResult() (num float64, err error) {
+	if len(this.Args) == 0 {
+		return 0, errors.New(fmt.Sprintf("%s without result", this.Name))
+	}
+	err = amf.Unmarshal(this.Args[0], &num)
+	return
+}
@@ -0,0 +1,173 @@
+package RTMPService
+
+import (
+	"mediaTypes/amf"
+	"testing"
+)
+
+type testCommandValue func(enc *amf.AMF0Encoder)
+
+func newTestCommand(msgType byte, values ...testCommandValue) *RTMPPacket {
+	enc := &amf.AMF0Encoder{}
+	enc.Init()
+	if RTMP_PACKET_TYPE_FLEX_MESSAGE == msgType {
+		enc.AppendByte(0)
+	}
+	for _, value := range values {
+		value(enc)
+	}
+	body, _ := enc.GetData()
+	return &RTMPPacket{MessageTypeId: msgType, Body: body}
+}
+
+func cmdString(str string) testCommandValue {
+	return func(enc *amf.AMF0Encoder) { enc.EncodeString(str) }
+}
+
+func cmdNumber(num float64) testCommandValue {
+	return func(enc *amf.AMF0Encoder) { enc.EncodeNumber(num) }
+}
+
+func cmdBool(boolean bool) testCommandValue {
+	return func(enc *amf.AMF0Encoder) { enc.EncodeBool(boolean) }
+}
+
+func cmdNull() testCommandValue {
+	return func(enc *amf.AMF0Encoder) { enc.AppendByte(amf.AMF0_null) }
+}
+
+func cmdBytes(data ...byte) testCommandValue {
+	return func(enc *amf.AMF0Encoder) { enc.AppendByteArray(data) }
+}
+
+//props是name,value交替
+func cmdObject(avmPlus bool, props ...interface{}) testCommandValue {
+	return func(enc *amf.AMF0Encoder) {
+		obj := &amf.AMF0Object{}
+		for i := 0; i+1 < len(props); i += 2 {
+			name := props[i].(string)
+			switch v := props[i+1].(type) {
+			case string:
+				obj.AddString(name, v)
+			case float64:
+				obj.AddNumber(name, v)
+			case bool:
+				obj.AddBool(name, v)
+			}
+		}
+		if avmPlus {
+			enc.EncodeAVMPlusObject(obj)
+		} else {
+			enc.EncodeObject(obj)
+		}
+	}
+}
+
+//坏的命令消息要报错，不能panic
+func TestParseCommandMalformed(t *testing.T) {
+	tests := []struct {
+		pkt *RTMPPacket
+		ok  bool
+	}{
+		{newTestCommand(RTMP_PACKET_TYPE_INVOKE, cmdString("connect"), cmdNumber(1), cmdObject(false, "app", "live")), true},
+		{newTestCommand(RTMP_PACKET_TYPE_FLEX_MESSAGE, cmdString("connect"), cmdNumber(1), cmdObject(true, "app", "live")), true},
+		{newTestCommand(RTMP_PACKET_TYPE_INVOKE, cmdString("close")), true},
+		{newTestCommand(RTMP_PACKET_TYPE_INVOKE), false},
+		{&RTMPPacket{MessageTypeId: RTMP_PACKET_TYPE_FLEX_MESSAGE}, false},
+		{newTestCommand(RTMP_PACKET_TYPE_INVOKE, cmdNumber(1), cmdString("connect")), false},
+		{newTestCommand(RTMP_PACKET_TYPE_INVOKE, cmdString("play"), cmdString("1")), false},
+		{newTestCommand(RTMP_PACKET_TYPE_INVOKE, cmdString("play"), cmdBytes(amf.AMF0_number, 0, 0)), false},
+		{newTestCommand(RTMP_PACKET_TYPE_INVOKE, cmdString("play"), cmdNumber(1), cmdBytes(amf.AMF0_object, 0, 5, 'a')), false},
+		{newTestCommand(RTMP_PACKET_TYPE_INVOKE, cmdString("play"), cmdNumber(1), cmdNull(), cmdBytes(amf.AMF0_reference, 0, 3)), false},
+		{newTestCommand(RTMP_PACKET_TYPE_INVOKE, cmdBytes(amf.AMF0_string, 0, 9, 'p')), false},
+	}
+	for i, test := range tests {
+		cmd, err := ParseCommand(test.pkt)
+		if (nil == err) != test.ok {
+			t.Errorf("case %d: err %v", i, err)
+		}
+		if test.ok && nil == cmd {
+			t.Errorf("case %d: nil command", i)
+		}
+	}
+}
+
+//connect只要求app，其他字段类型不对也能连
+func TestConnectObject(t *testing.T) {
+	tests := []struct {
+		pkt *RTMPPacket
+		ok  bool
+		app string
+	}{
+		{newTestCommand(RTMP_PACKET_TYPE_INVOKE, cmdString("connect"), cmdNumber(1),
+			cmdObject(false, "app", "live", "fpad", 0.0, "objectEncoding", "0", "tcUrl", "rtmp://h/live")), true, "live"},
+		{newTestCommand(RTMP_PACKET_TYPE_FLEX_MESSAGE, cmdString("connect"), cmdNumber(1),
+			cmdObject(true, "app", "live", "capabilities", true, "objectEncoding", 3.0)), true, "live"},
+		{newTestCommand(RTMP_PACKET_TYPE_INVOKE, cmdString("connect"), cmdNumber(1), cmdObject(false, "tcUrl", "rtmp://h/live")), false, ""},
+		{newTestCommand(RTMP_PACKET_TYPE_INVOKE, cmdString("connect"), cmdNumber(1), cmdObject(false, "app", 1.0)), false, ""},
+		{newTestCommand(RTMP_PACKET_TYPE_INVOKE, cmdString("connect"), cmdNumber(1), cmdNull()), false, ""},
+		{newTestCommand(RTMP_PACKET_TYPE_INVOKE, cmdString("connect"), cmdNumber(1)), false, ""},
+	}
+	for i, test := range tests {
+		cmd, err := ParseCommand(test.pkt)
+		if err != nil {
+			t.Errorf("case %d: %s", i, err.Error())
+			continue
+		}
+		obj, err := cmd.ConnectObject()
+		if (nil == err) != test.ok {
+			t.Errorf("case %d: err %v", i, err)
+			continue
+		}
+		if test.ok && obj.App != test.app {
+			t.Errorf("case %d: app %s", i, obj.App)
+		}
+	}
+}
+
+//参数严格检查，可选参数缺了用默认值
+func TestCommandArgs(t *testing.T) {
+	play := func(values ...testCommandValue) *RTMPCommand {
+		cmd, err := ParseCommand(newTestCommand(RTMP_PACKET_TYPE_INVOKE,
+			append([]testCommandValue{cmdString("play"), cmdNumber(0), cmdNull()}, values...)...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmd
+	}
+	tests := []struct {
+		cmd  *RTMPCommand
+		ok   bool
+		args PlayArgs
+	}{
+		{play(cmdString("live")), true, PlayArgs{Name: "live", Start: -2, Duration: -1}},
+		{play(cmdString("live"), cmdNumber(0), cmdNull(), cmdBool(false)), true, PlayArgs{Name: "live", Start: 0, Duration: -1}},
+		{play(), false, PlayArgs{}},
+		{play(cmdNull()), false, PlayArgs{}},
+		{play(cmdNumber(1)), false, PlayArgs{}},
+		{play(cmdString("live"), cmdString("-2")), false, PlayArgs{}},
+		{play(cmdString("live"), cmdNumber(0), cmdNumber(-1), cmdNumber(1)), false, PlayArgs{}},
+	}
+	for i, test := range tests {
+		args, err := test.cmd.PlayArgs()
+		if (nil == err) != test.ok {
+			t.Errorf("case %d: err %v", i, err)
+			continue
+		}
+		if test.ok && *args != test.args {
+			t.Errorf("case %d: %+v want %+v", i, *args, test.args)
+		}
+	}
+	if _, err := play(cmdString("live"), cmdString("true")).PauseArgs(); nil == err {
+		t.Error("pause flag as string accepted")
+	}
+	if _, err := play().DeleteStreamArgs(); nil == err {
+		t.Error("deleteStream without stream id accepted")
+	}
+	if _, err := play().StatusInfo(); nil == err {
+		t.Error("onStatus without info accepted")
+	}
+	if _, err := play(cmdString("1")).NumberResult(); nil == err {
+		t.Error("string result accepted as number")
+	}
+}
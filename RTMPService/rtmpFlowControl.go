@@ -0,0 +1,160 @@
+package RTMPService
+
+import (
+	"errors"
+	"fmt"
+	"logger"
+	"mediaTypes/amf"
+	"net"
+	"time"
+)
+
+//Set Peer Bandwidth的限制类型
+const (
+	RTMP_limit_hard    = 0
+	RTMP_limit_soft    = 1
+	RTMP_limit_dynamic = 2
+)
+
+//握手收发的字节也算在序号里 c0+c1+c2
+const rtmp_handshake_bytes = 1 + rtmp_randomsize*2
+
+//确认，确认窗口，对端带宽三种消息
+func (this *RTMP) HandleFlowControl(pkt *RTMPPacket) (err error) {
+	if len(pkt.Body) < 4 {
+		return errors.New(fmt.Sprintf("flow control message %d too short", pkt.MessageTypeId))
+	}
+	value, _ := amf.AMF0DecodeInt32(pkt.Body)
+	switch pkt.MessageTypeId {
+	case RTMP_PACKET_TYPE_BYTES_READ_REPORT:
+		this.onAcknowledgement(value)
+	case RTMP_PACKET_TYPE_SERVER_BW:
+		//对端每发这么多字节要收到一次确认
+		this.AcknowledgementWindowSize = value
+		logger.LOGT(fmt.Sprintf("acknowledgement window %d", value))
+	case RTMP_PACKET_TYPE_CLIENT_BW:
+		if len(pkt.Body) < 5 {
+			return errors.New("set peer bandwidth too short")
+		}
+		err = this.setPeerBandwidth(value, uint32(pkt.Body[4]))
+	}
+	return
+}
+
+//对端没设置确认窗口时用我们告诉对端的窗口
+//半个窗口就确认，对端卡在窗口边界时我们可能正在等一个没收完的chunk
+func (this *RTMP) countBytesIn(size int) (err error) {
+	this.BytesIn += int64(size)
+	window := int64(this.AcknowledgementWindowSize)
+	if 0 == window {
+		window = int64(this.TargetBW)
+	}
+	if window > 0 && this.BytesIn-this.BytesInLast >= window/2 {
+		this.BytesInLast = this.BytesIn
+		err = this.sendAcknowledgement()
+	}
+	return
+}
+
+//序号是32位的，会回绕
+func (this *RTMP) onAcknowledgement(seq uint32) {
+	this.mutexAck.Lock()
+	defer this.mutexAck.Unlock()
+	this.bytesAcked += int64(seq - uint32(this.bytesAcked))
+	this.ackReceived = true
+	select {
+	case this.chAck <- true:
+	default:
+	}
+}
+
+func (this *RTMP) setPeerBandwidth(size, limitType uint32) (err error) {
+	this.mutexAck.Lock()
+	switch limitType {
+	case RTMP_limit_hard:
+		this.PeerBW = size
+		this.PeerLimitType = RTMP_limit_hard
+	case RTMP_limit_soft:
+		if 0 == this.PeerBW || size < this.PeerBW {
+			this.PeerBW = size
+		}
+		this.PeerLimitType = RTMP_limit_soft
+	case RTMP_limit_dynamic:
+		//上一次是hard时当hard处理，否则忽略
+		if RTMP_limit_hard == this.PeerLimitType {
+			this.PeerBW = size
+		}
+	default:
+		this.mutexAck.Unlock()
+		return errors.New(fmt.Sprintf("invalid peer bandwidth limit type %d", limitType))
+	}
+	peerBW := this.PeerBW
+	this.mutexAck.Unlock()
+	logger.LOGT(fmt.Sprintf("peer bandwidth %d limit type %d", size, limitType))
+	//和上次发给对端的确认窗口不同时要重新发
+	if peerBW > 0 && peerBW != this.TargetBW {
+		this.TargetBW = peerBW
+		err = this.AcknowledgementBW()
+	}
+	return
+}
+
+func isMediaPacket(packet *RTMPPacket) bool {
+	switch packet.MessageTypeId {
+	case RTMP_PACKET_TYPE_AUDIO, RTMP_PACKET_TYPE_VIDEO, RTMP_PACKET_TYPE_INFO,
+		RTMP_PACKET_TYPE_FLASH_VIDEO:
+		return true
+	}
+	return false
+}
+
+//音视频数据发送前等对端确认，未确认的字节不能超过对端带宽
+//返回窗口里能发的前count个包，至少一个；一批里的音视频都算，越过窗口的那个包也发，对端收满窗口才回确认
+//控制消息和命令不等，读线程要靠它们回复，等了就收不到确认了
+//对端从来没回过确认的不限制，不然不回确认的客户端会一直卡住
+//chStop关掉时不等了，停止播放不会被对端卡住
+func (this *RTMP) waitSendWindow(packets []*RTMPPacket, chStop chan bool) (count int, err error) {
+	//前面的控制消息直接发
+	for count < len(packets) && false == isMediaPacket(packets[count]) {
+		count++
+	}
+	if count > 0 {
+		return
+	}
+	timeout := time.After(time.Duration(serviceConfig.TimeoutSec) * time.Second)
+	for {
+		this.mutexAck.Lock()
+		limited := this.ackReceived && this.PeerBW > 0
+		unacked := this.BytesOut - this.bytesAcked
+		peerBW := int64(this.PeerBW)
+		this.mutexAck.Unlock()
+		if false == limited {
+			return len(packets), nil
+		}
+		if unacked < peerBW {
+			for count < len(packets) && unacked < peerBW {
+				if isMediaPacket(packets[count]) {
+					unacked += int64(packets[count].MessageLength)
+				}
+				count++
+			}
+			return
+		}
+		select {
+		case <-this.chAck:
+		case <-chStop:
+			return 0, errors.New("send stopped")
+		case <-timeout:
+			return 0, errors.New("wait peer acknowledgement timeout")
+		}
+	}
+}
+
+//所有写socket都走这里，记录发出的字节
+func (this *RTMP) rtmpSocketWriteBuffers(bufs net.Buffers) (err error) {
+	size, err := bufs.WriteTo(this.Conn)
+	this.mutexAck.Lock()
+	this.BytesOut += size
+	this.mutexAck.Unlock()
+	return
+}
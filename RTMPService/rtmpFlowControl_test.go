@@ -0,0 +1,93 @@
+package RTMPService
+
+import (
+	"testing"
+	"time"
+)
+
+func newWindowTestRTMP(peerBW uint32, unacked int64) *RTMP {
+	serviceConfig.TimeoutSec = 10
+	rtmp := &RTMP{}
+	rtmp.Init(nil)
+	rtmp.ackReceived = true
+	rtmp.PeerBW = peerBW
+	rtmp.BytesOut = unacked
+	return rtmp
+}
+
+func newWindowTestPackets(msgType byte, sizes ...uint32) (packets []*RTMPPacket) {
+	for _, size := range sizes {
+		packets = append(packets, &RTMPPacket{MessageTypeId: msgType, MessageLength: size})
+	}
+	return
+}
+
+//一批里的音视频都算进窗口，越过窗口的那个也发
+func TestSendWindowBatch(t *testing.T) {
+	tests := []struct {
+		peerBW  uint32
+		unacked int64
+		sizes   []uint32
+		count   int
+	}{
+		{100, 0, []uint32{60, 60, 60}, 2},
+		{100, 50, []uint32{10, 10, 10}, 3},
+		{100, 90, []uint32{60, 60}, 1},
+		{0, 1000, []uint32{60, 60}, 2},
+	}
+	for i, test := range tests {
+		rtmp := newWindowTestRTMP(test.peerBW, test.unacked)
+		count, err := rtmp.waitSendWindow(newWindowTestPackets(RTMP_PACKET_TYPE_VIDEO, test.sizes...), nil)
+		if err != nil || count != test.count {
+			t.Errorf("case %d: count %d err %v want %d", i, count, err, test.count)
+		}
+	}
+}
+
+//控制消息不等窗口
+func TestSendWindowControl(t *testing.T) {
+	rtmp := newWindowTestRTMP(100, 100)
+	packets := newWindowTestPackets(RTMP_PACKET_TYPE_INVOKE, 10)
+	packets = append(packets, newWindowTestPackets(RTMP_PACKET_TYPE_VIDEO, 10)...)
+	count, err := rtmp.waitSendWindow(packets, nil)
+	if err != nil || count != 1 {
+		t.Fatalf("count %d err %v", count, err)
+	}
+}
+
+//窗口满了等确认，确认来了或者停止都要返回
+func TestSendWindowWait(t *testing.T) {
+	rtmp := newWindowTestRTMP(100, 100)
+	chStop := make(chan bool)
+	chErr := make(chan error, 1)
+	go func() {
+		_, err := rtmp.waitSendWindow(newWindowTestPackets(RTMP_PACKET_TYPE_AUDIO, 10), chStop)
+		chErr <- err
+	}()
+	close(chStop)
+	select {
+	case err := <-chErr:
+		if err == nil {
+			t.Fatal("stopped wait returned no error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait not stopped")
+	}
+
+	go func() {
+		count, err := rtmp.waitSendWindow(newWindowTestPackets(RTMP_PACKET_TYPE_AUDIO, 10), nil)
+		if count != 1 {
+			t.Errorf("count %d after acknowledgement", count)
+		}
+		chErr <- err
+	}()
+	rtmp.onAcknowledgement(100)
+	select {
+	case err := <-chErr:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acknowledgement did not wake the wait")
+	}
+}
@@ -0,0 +1,164 @@
+package RTMPService
+
+import (
+	"errors"
+	"fmt"
+	"logger"
+	"mediaTypes/amf"
+	"net"
+	"sync"
+)
+
+//客户端NetConnection.call调用的自定义方法
+//处理函数返回的Value编码进_result，Status不为nil时另外发onStatus，返回error时回_error
+type RTMPCallHandler func(ctx *RTMPCallContext) (result *RTMPCallResult, err error)
+
+type RTMPCallContext struct {
+	App        string
+	StreamName string
+	RemoteAddr net.Addr
+	Cmd        *RTMPCommand
+	rtmp       *RTMP
+}
+
+type RTMPCallResult struct {
+	Value  interface{}
+	Status *StatusInfo
+}
+
+//需要指定错误码时返回这个，其他error用NetConnection.Call.Failed
+type RTMPCallError struct {
+	Code        string
+	Description string
+}
+
+func (this *RTMPCallError) Error() string {
+	return this.Code + ":" + this.Description
+}
+
+//服务器自己处理的方法，不能注册
+var builtinMethods = map[string]bool{
+	"connect":       true,
+	"_checkbw":      true,
+	"_result":       true,
+	"_error":        true,
+	"releaseStream": true,
+	"FCPublish":     true,
+	"FCUnpublish":   true,
+	"createStream":  true,
+	"deleteStream":  true,
+	"closeStream":   true,
+	"publish":       true,
+	"play":          true,
+	"pause":         true,
+	"seek":          true,
+	"receiveAudio":  true,
+	"receiveVideo":  true,
+}
+
+var callHandlers = make(map[string]RTMPCallHandler)
+var mutexCallHandlers sync.RWMutex
+
+func RegisterCallHandler(method string, handler RTMPCallHandler) (err error) {
+	if len(method) == 0 || nil == handler {
+		return errors.New("invalid call handler")
+	}
+	if builtinMethods[method] {
+		return errors.New(fmt.Sprintf("method %s is builtin", method))
+	}
+	mutexCallHandlers.Lock()
+	defer mutexCallHandlers.Unlock()
+	_, exist := callHandlers[method]
+	if exist {
+		return errors.New(fmt.Sprintf("method %s already registered", method))
+	}
+	callHandlers[method] = handler
+	return
+}
+
+func UnregisterCallHandler(method string) {
+	mutexCallHandlers.Lock()
+	defer mutexCallHandlers.Unlock()
+	delete(callHandlers, method)
+}
+
+func getCallHandler(method string) (handler RTMPCallHandler, ok bool) {
+	mutexCallHandlers.RLock()
+	defer mutexCallHandlers.RUnlock()
+	handler, ok = callHandlers[method]
+	return
+}
+
+//按顺序把参数解到结构体
+func (this *RTMPCallContext) Args(v interface{}) error {
+	return amf.UnmarshalArgs(this.Cmd.Args, v)
+}
+
+func (this *RTMPCallContext) SendStatus(info *StatusInfo) error {
+	return this.rtmp.CmdStatus(info.Level, info.Code, info.Description, info.Details,
+		info.ClientId, RTMP_channel_Invoke)
+}
+
+//事务号为0的调用不需要回复
+func (this *RTMPHandler) handleCall(cmd *RTMPCommand, streamName string) (err error) {
+	handler, ok := getCallHandler(cmd.Name)
+	if false == ok {
+		logger.LOGW(fmt.Sprintf("rtmp method <%s> not processed", cmd.Name))
+		if 0 == cmd.TransactionId {
+			return
+		}
+		return this.rtmpInstance.CmdError("error", "NetConnection.Call.Failed",
+			fmt.Sprintf("Method not found (%s).", cmd.Name), cmd.TransactionId)
+	}
+	ctx := &RTMPCallContext{
+		App:        this.app,
+		StreamName: streamName,
+		RemoteAddr: this.rtmpInstance.Conn.RemoteAddr(),
+		Cmd:        cmd,
+		rtmp:       this.rtmpInstance}
+	result, callErr := invokeCallHandler(handler, ctx)
+	if callErr != nil {
+		logger.LOGW(fmt.Sprintf("rtmp method <%s> failed:%s", cmd.Name, callErr.Error()))
+		if 0 == cmd.TransactionId {
+			return
+		}
+		code := "NetConnection.Call.Failed"
+		desc := callErr.Error()
+		if rpcErr, ok := callErr.(*RTMPCallError); ok {
+			code = rpcErr.Code
+			desc = rpcErr.Description
+		}
+		return this.rtmpInstance.CmdError("error", code, desc, cmd.TransactionId)
+	}
+	if nil == result {
+		result = &RTMPCallResult{}
+	}
+	if cmd.TransactionId != 0 {
+		var value *amf.AMF0Property
+		value, err = amf.Marshal(result.Value)
+		if err != nil {
+			logger.LOGE(fmt.Sprintf("rtmp method <%s> bad result:%s", cmd.Name, err.Error()))
+			return this.rtmpInstance.CmdError("error", "NetConnection.Call.Failed",
+				err.Error(), cmd.TransactionId)
+		}
+		err = this.rtmpInstance.CmdResult(cmd.TransactionId, value)
+		if err != nil {
+			return
+		}
+	}
+	if result.Status != nil {
+		err = ctx.SendStatus(result.Status)
+	}
+	return
+}
+
+//处理函数panic不能带掉整个连接
+func invokeCallHandler(handler RTMPCallHandler, ctx *RTMPCallContext) (result *RTMPCallResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = nil
+			err = errors.New(fmt.Sprintf("%v", r))
+		}
+	}()
+	return handler(ctx)
+}
@@ -0,0 +1,212 @@
+package RTMPService
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"logger"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	rtmpsPortDefault     = 443
+	certReloadSecDefault = 60
+)
+
+// RTMPS，证书按SNI选择，文件有修改时自动重新加载
+type RTMPTLSConfig struct {
+	Port      int              `json:"Port"`
+	Certs     []RTMPCertConfig `json:"Certs"` //第一个为默认证书
+	ReloadSec int              `json:"ReloadSec"`
+}
+
+type RTMPCertConfig struct {
+	CertFile string `json:"CertFile"`
+	KeyFile  string `json:"KeyFile"`
+}
+
+type certEntry struct {
+	cfg     RTMPCertConfig
+	cert    *tls.Certificate
+	names   []string
+	modTime time.Time
+}
+
+type certStore struct {
+	mutex   sync.RWMutex
+	entries []*certEntry
+}
+
+func newCertStore(certs []RTMPCertConfig) (store *certStore, err error) {
+	if len(certs) == 0 {
+		return nil, errors.New("no certificate for rtmps")
+	}
+	store = &certStore{}
+	for _, v := range certs {
+		entry := &certEntry{cfg: v}
+		err = entry.load()
+		if err != nil {
+			return nil, err
+		}
+		store.entries = append(store.entries, entry)
+	}
+	return
+}
+
+func (this *certEntry) load() (err error) {
+	modTime, err := this.filesModTime()
+	if err != nil {
+		return
+	}
+	cert, err := tls.LoadX509KeyPair(this.cfg.CertFile, this.cfg.KeyFile)
+	if err != nil {
+		return errors.New(fmt.Sprintf("load cert %s failed:%s", this.cfg.CertFile, err.Error()))
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return
+	}
+	cert.Leaf = leaf
+	this.names = leaf.DNSNames
+	if len(this.names) == 0 && len(leaf.Subject.CommonName) > 0 {
+		this.names = []string{leaf.Subject.CommonName}
+	}
+	this.cert = &cert
+	this.modTime = modTime
+	return
+}
+
+//证书和私钥较晚的修改时间，只换了私钥也要重新加载
+func (this *certEntry) filesModTime() (modTime time.Time, err error) {
+	certInfo, err := os.Stat(this.cfg.CertFile)
+	if err != nil {
+		return
+	}
+	keyInfo, err := os.Stat(this.cfg.KeyFile)
+	if err != nil {
+		return
+	}
+	modTime = certInfo.ModTime()
+	if keyInfo.ModTime().After(modTime) {
+		modTime = keyInfo.ModTime()
+	}
+	return
+}
+
+func (this *certEntry) modified() bool {
+	modTime, err := this.filesModTime()
+	if err != nil {
+		return false
+	}
+	return modTime.After(this.modTime)
+}
+
+func (this *certEntry) match(serverName string) bool {
+	for _, name := range this.names {
+		name = strings.ToLower(name)
+		if name == serverName {
+			return true
+		}
+		//*.example.com只匹配一级
+		if strings.HasPrefix(name, "*.") {
+			idx := strings.Index(serverName, ".")
+			if idx > 0 && serverName[idx:] == name[1:] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (this *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+	serverName := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if len(serverName) > 0 {
+		for _, v := range this.entries {
+			if v.match(serverName) {
+				return v.cert, nil
+			}
+		}
+	}
+	return this.entries[0].cert, nil
+}
+
+// 加载失败继续用旧证书
+func (this *certStore) reload() {
+	for idx, v := range this.entries {
+		if false == v.modified() {
+			continue
+		}
+		entry := &certEntry{cfg: v.cfg}
+		err := entry.load()
+		if err != nil {
+			logger.LOGE(err.Error())
+			continue
+		}
+		this.mutex.Lock()
+		this.entries[idx] = entry
+		this.mutex.Unlock()
+		logger.LOGI("rtmps cert reloaded:" + v.cfg.CertFile)
+	}
+}
+
+func (this *certStore) threadReload(reloadSec int, chExit chan bool) {
+	ticker := time.NewTicker(time.Duration(reloadSec) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			this.reload()
+		case <-chExit:
+			return
+		}
+	}
+}
+
+// 拉流和转推共用，protocol为rtmps时走tls
+type RTMPDialOptions struct {
+	ServerName         string
+	InsecureSkipVerify bool
+	CAFile             string
+	TimeoutSec         int
+}
+
+func dialRTMP(protocol, addr string, opts *RTMPDialOptions) (conn net.Conn, err error) {
+	dialer := &net.Dialer{}
+	if opts != nil && opts.TimeoutSec > 0 {
+		dialer.Timeout = time.Duration(opts.TimeoutSec) * time.Second
+	}
+	if "rtmps" != strings.ToLower(protocol) {
+		return dialer.Dial("tcp", addr)
+	}
+	cfg := &tls.Config{}
+	if opts != nil {
+		cfg.ServerName = opts.ServerName
+		cfg.InsecureSkipVerify = opts.InsecureSkipVerify
+		if len(opts.CAFile) > 0 {
+			pem, err := ioutil.ReadFile(opts.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			cfg.RootCAs = x509.NewCertPool()
+			if false == cfg.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, errors.New("invalid ca file:" + opts.CAFile)
+			}
+		}
+	}
+	if len(cfg.ServerName) == 0 {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ServerName = host
+	}
+	return tls.DialWithDialer(dialer, "tcp", addr, cfg)
+}
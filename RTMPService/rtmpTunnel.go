@@ -0,0 +1,309 @@
+package RTMPService
+
+import (
+	"HTTPMUX"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"logger"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"wssAPI"
+)
+
+//RTMPT:RTMP数据放在HTTP POST里
+//open建会话，send带上行数据，idle轮询，close关闭，响应第一个字节是下次轮询的间隔
+const (
+	rtmptPortDefault   = 80
+	rtmpt_content_type = "application/x-fcs"
+	//没被取走的下行数据上限，超过了写的时候等
+	rtmpt_max_pending  = 1 << 20
+	rtmpt_interval_max = 0x21
+	rtmpt_max_body     = 1 << 20
+)
+
+type RTMPTConfig struct {
+	Port int `json:"Port"`
+}
+
+//一个会话当成一个连接，握手和RTMPHandler都不用改
+type rtmptConn struct {
+	id            string
+	mutex         sync.Mutex
+	in            bytes.Buffer
+	out           bytes.Buffer
+	chIn          chan bool
+	chOut         chan bool
+	closed        bool
+	readDeadline  time.Time
+	writeDeadline time.Time
+	interval      byte
+	timer         *time.Timer //太久没有请求就关掉
+	localAddr     net.Addr
+	remoteAddr    net.Addr
+}
+
+type rtmptTimeoutError struct{}
+
+func (this *rtmptTimeoutError) Error() string {
+	return "rtmpt i/o timeout"
+}
+
+func (this *rtmptTimeoutError) Timeout() bool {
+	return true
+}
+
+func (this *rtmptTimeoutError) Temporary() bool {
+	return true
+}
+
+var rtmptSessions = make(map[string]*rtmptConn)
+var mutexRtmptSessions sync.RWMutex
+
+func addRTMPTRoutes(port int) {
+	strPort := ":" + strconv.Itoa(port)
+	for _, route := range []string{"/fcs/", "/open/", "/send/", "/idle/", "/close/"} {
+		HTTPMUX.AddRoute(strPort, route, serveRTMPT)
+	}
+	logger.LOGI("rtmpt listen on " + strconv.Itoa(port))
+}
+
+func newRTMPTConn(req *http.Request) (conn *rtmptConn) {
+	conn = &rtmptConn{}
+	conn.id = wssAPI.GenerateGUID()
+	conn.chIn = make(chan bool, 1)
+	conn.chOut = make(chan bool, 1)
+	conn.interval = 1
+	conn.remoteAddr, _ = net.ResolveTCPAddr("tcp", req.RemoteAddr)
+	if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		conn.localAddr = addr
+	}
+	conn.timer = time.AfterFunc(time.Duration(serviceConfig.TimeoutSec)*time.Second, func() {
+		logger.LOGT("rtmpt session timeout:" + conn.id)
+		conn.Close()
+	})
+	mutexRtmptSessions.Lock()
+	rtmptSessions[conn.id] = conn
+	mutexRtmptSessions.Unlock()
+	return
+}
+
+func getRTMPTConn(id string) *rtmptConn {
+	mutexRtmptSessions.RLock()
+	defer mutexRtmptSessions.RUnlock()
+	return rtmptSessions[id]
+}
+
+func serveRTMPT(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	//  /open/1  /send/id/seq  /idle/id/seq  /close/id/seq
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch parts[0] {
+	case "open":
+		conn := newRTMPTConn(req)
+		logger.LOGT("new rtmpt session:" + conn.id)
+		go service.handleConnect(conn)
+		writeRTMPT(w, []byte(conn.id+"\n"))
+	case "send", "idle", "close":
+		if len(parts) < 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn := getRTMPTConn(parts[1])
+		if nil == conn {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		conn.timer.Reset(time.Duration(serviceConfig.TimeoutSec) * time.Second)
+		//chunked的没有长度，多读一个字节看有没有超
+		var data []byte
+		var err error
+		if req.ContentLength <= rtmpt_max_body {
+			data, err = ioutil.ReadAll(io.LimitReader(req.Body, rtmpt_max_body+1))
+			if err != nil {
+				logger.LOGE(err.Error())
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		//截断的数据不能交给rtmp，后面的chunk就乱了，整个会话关掉
+		if req.ContentLength > rtmpt_max_body || len(data) > rtmpt_max_body {
+			logger.LOGW(fmt.Sprintf("rtmpt session %s body bigger than %d", conn.id, rtmpt_max_body))
+			conn.Close()
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		if "close" == parts[0] {
+			conn.Close()
+			writeRTMPT(w, []byte{0})
+			return
+		}
+		conn.push(data)
+		writeRTMPT(w, conn.poll())
+	default:
+		//fcs/ident2之类的探测
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeRTMPT(w http.ResponseWriter, data []byte) {
+	w.Header().Set("Content-Type", rtmpt_content_type)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "Keep-Alive")
+	_, err := w.Write(data)
+	if err != nil {
+		logger.LOGE(err.Error())
+	}
+}
+
+func (this *rtmptConn) push(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	this.mutex.Lock()
+	this.in.Write(data)
+	this.mutex.Unlock()
+	signalRTMPT(this.chIn)
+}
+
+//取走所有下行数据，没数据时轮询间隔逐渐加大
+func (this *rtmptConn) poll() (data []byte) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.out.Len() > 0 {
+		this.interval = 1
+	} else if this.interval < rtmpt_interval_max {
+		this.interval = this.interval*2 + 1
+		if this.interval > rtmpt_interval_max {
+			this.interval = rtmpt_interval_max
+		}
+	}
+	data = make([]byte, 1+this.out.Len())
+	data[0] = this.interval
+	this.out.Read(data[1:])
+	signalRTMPT(this.chOut)
+	return
+}
+
+func signalRTMPT(ch chan bool) {
+	select {
+	case ch <- true:
+	default:
+	}
+}
+
+//等通知或者超时
+func waitRTMPT(ch chan bool, deadline time.Time) error {
+	if deadline.IsZero() {
+		<-ch
+		return nil
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return &rtmptTimeoutError{}
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+		return &rtmptTimeoutError{}
+	}
+}
+
+func (this *rtmptConn) Read(b []byte) (n int, err error) {
+	for {
+		this.mutex.Lock()
+		if this.in.Len() > 0 {
+			n, err = this.in.Read(b)
+			this.mutex.Unlock()
+			return
+		}
+		if this.closed {
+			this.mutex.Unlock()
+			return 0, io.EOF
+		}
+		deadline := this.readDeadline
+		this.mutex.Unlock()
+		err = waitRTMPT(this.chIn, deadline)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (this *rtmptConn) Write(b []byte) (n int, err error) {
+	for {
+		this.mutex.Lock()
+		if this.closed {
+			this.mutex.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+		if this.out.Len() < rtmpt_max_pending {
+			n, err = this.out.Write(b)
+			this.mutex.Unlock()
+			return
+		}
+		deadline := this.writeDeadline
+		this.mutex.Unlock()
+		err = waitRTMPT(this.chOut, deadline)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (this *rtmptConn) Close() error {
+	this.mutex.Lock()
+	if this.closed {
+		this.mutex.Unlock()
+		return nil
+	}
+	this.closed = true
+	this.mutex.Unlock()
+	this.timer.Stop()
+	mutexRtmptSessions.Lock()
+	delete(rtmptSessions, this.id)
+	mutexRtmptSessions.Unlock()
+	signalRTMPT(this.chIn)
+	signalRTMPT(this.chOut)
+	return nil
+}
+
+func (this *rtmptConn) LocalAddr() net.Addr {
+	return this.localAddr
+}
+
+func (this *rtmptConn) RemoteAddr() net.Addr {
+	return this.remoteAddr
+}
+
+func (this *rtmptConn) SetDeadline(t time.Time) error {
+	this.SetReadDeadline(t)
+	return this.SetWriteDeadline(t)
+}
+
+func (this *rtmptConn) SetReadDeadline(t time.Time) error {
+	this.mutex.Lock()
+	this.readDeadline = t
+	this.mutex.Unlock()
+	signalRTMPT(this.chIn)
+	return nil
+}
+
+func (this *rtmptConn) SetWriteDeadline(t time.Time) error {
+	this.mutex.Lock()
+	this.writeDeadline = t
+	this.mutex.Unlock()
+	signalRTMPT(this.chOut)
+	return nil
+}
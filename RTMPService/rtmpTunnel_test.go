@@ -0,0 +1,47 @@
+package RTMPService
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//超过上限的send回413并关掉会话，不能截断以后交给rtmp
+func TestRTMPTSendBodyLimit(t *testing.T) {
+	serviceConfig.TimeoutSec = 10
+	tests := []struct {
+		size    int
+		chunked bool
+		status  int
+	}{
+		{rtmpt_max_body, false, http.StatusOK},
+		{rtmpt_max_body + 1, false, http.StatusRequestEntityTooLarge},
+		{rtmpt_max_body, true, http.StatusOK},
+		{rtmpt_max_body + 1, true, http.StatusRequestEntityTooLarge},
+	}
+	for i, test := range tests {
+		conn := newRTMPTConn(httptest.NewRequest("POST", "/open/1", nil))
+		body := bytes.Repeat([]byte{3}, test.size)
+		req := httptest.NewRequest("POST", "/send/"+conn.id+"/1", bytes.NewReader(body))
+		if test.chunked {
+			//没有长度，只能读的时候判断
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		serveRTMPT(w, req)
+		if w.Code != test.status {
+			t.Errorf("case %d: status %d want %d", i, w.Code, test.status)
+		}
+		closed := nil == getRTMPTConn(conn.id)
+		if closed != (http.StatusOK != test.status) {
+			t.Errorf("case %d: session closed %v", i, closed)
+		}
+		if http.StatusOK == test.status && conn.in.Len() != test.size {
+			t.Errorf("case %d: %d bytes pushed want %d", i, conn.in.Len(), test.size)
+		}
+		conn.Close()
+	}
+}
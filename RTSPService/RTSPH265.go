@@ -0,0 +1,135 @@
+package RTSPService
+
+import (
+	"container/list"
+	"encoding/base64"
+	"fmt"
+	"logger"
+	"mediaTypes/amf"
+	"mediaTypes/flv"
+	"mediaTypes/h265"
+)
+
+//rfc7798
+const (
+	Payload_h265 = 96
+)
+
+func genH265sdp(data []byte) (sdp string) {
+	if len(data) < 5 {
+		return
+	}
+	hvcc, err := h265.ParseHVCC(data[5:])
+	if err != nil {
+		logger.LOGE(err.Error())
+		return
+	}
+	if len(hvcc.VPS) == 0 || len(hvcc.SPS) == 0 || len(hvcc.PPS) == 0 {
+		logger.LOGE("hevc parameter sets not found")
+		return
+	}
+	fmtpFmt := fmt.Sprintf("a=fmtp:%d sprop-vps=%s;sprop-sps=%s;sprop-pps=%s\r\n", Payload_h265,
+		base64.StdEncoding.EncodeToString(hvcc.VPS[0]),
+		base64.StdEncoding.EncodeToString(hvcc.SPS[0]),
+		base64.StdEncoding.EncodeToString(hvcc.PPS[0]))
+	sdp = fmt.Sprintf("m=video 0 RTP/AVP %d\r\nc=IN IP4 0.0.0.0\r\nb=AS:500\r\na=rtpmap:%d H265/90000\r\na=range:npt=0-\r\n%sa=control:%s\r\n",
+		Payload_h265, Payload_h265, fmtpFmt, ctrl_track_video)
+	return
+}
+
+//单个nal一个包，超过mtu用FU,IRAP前用AP带上VPS SPS PPS
+func (this *RTSPHandler) generateH265RTPPackets(tag *flv.FlvTag, beginTime uint32, track *trackInfo) (rtpPkts *list.List) {
+	payLoadSize := RTP_MTU - 12 - 3 //rtp header,payload header,fu header
+	if track.transPort == "tcp" {
+		payLoadSize -= 4
+	}
+	if len(tag.Data) < 5 || tag.Data[1] != flv.AVC_NALU {
+		return
+	}
+	timestamp := tag.Timestamp - beginTime
+	if tag.Timestamp < beginTime {
+		timestamp = 0
+	}
+	tmp64 := int64(track.clockRate / 1000)
+	timestamp = uint32((tmp64 * int64(timestamp)) & 0xffffffff)
+
+	rtpPkts = list.New()
+	cur := 5
+	for cur+4 <= len(tag.Data) {
+		nalSize, _ := amf.AMF0DecodeInt32(tag.Data[cur:])
+		cur += 4
+		if nalSize < 2 || cur+int(nalSize) > len(tag.Data) {
+			break
+		}
+		nalData := tag.Data[cur : cur+int(nalSize)]
+		cur += int(nalSize)
+		nalType := h265.NalType(nalData[0])
+		if nalType == h265.Nal_type_vps || nalType == h265.Nal_type_sps ||
+			nalType == h265.Nal_type_pps || nalType == h265.Nal_type_aud {
+			continue
+		}
+		if h265.IsIRAP(nalType) && this.videoHeader != nil {
+			hvcc, err := h265.ParseHVCC(this.videoHeader.Data[5:])
+			if err == nil && len(hvcc.VPS) > 0 && len(hvcc.SPS) > 0 && len(hvcc.PPS) > 0 {
+				ap := &amf.AMF0Encoder{}
+				ap.Init()
+				ap.AppendByteArray(createRTPHeader(Payload_h265, this.nextSeq(track), timestamp, track.ssrc))
+				ap.AppendByte(h265.Nal_type_ap << 1)
+				ap.AppendByte(1)
+				for _, ps := range [][]byte{hvcc.VPS[0], hvcc.SPS[0], hvcc.PPS[0]} {
+					ap.EncodeInt16(int16(len(ps)))
+					ap.AppendByteArray(ps)
+				}
+				pktData, _ := ap.GetData()
+				rtpPkts.PushBack(pktData)
+			}
+		}
+		if len(nalData) <= payLoadSize {
+			single := &amf.AMF0Encoder{}
+			single.Init()
+			single.AppendByteArray(createRTPHeader(Payload_h265, this.nextSeq(track), timestamp, track.ssrc))
+			single.AppendByteArray(nalData)
+			pktData, _ := single.GetData()
+			rtpPkts.PushBack(pktData)
+			continue
+		}
+		//FU,payload header沿用F LayerId TID,类型换成49
+		payloadHdr0 := (nalData[0] & 0x81) | (h265.Nal_type_fu << 1)
+		payloadHdr1 := nalData[1]
+		curNalData := 2
+		for curNalData < len(nalData) {
+			end := curNalData + payLoadSize
+			fuHeader := byte(nalType)
+			if curNalData == 2 {
+				fuHeader |= 0x80
+			}
+			if end >= len(nalData) {
+				end = len(nalData)
+				fuHeader |= 0x40
+			}
+			fu := &amf.AMF0Encoder{}
+			fu.Init()
+			fu.AppendByteArray(createRTPHeader(Payload_h265, this.nextSeq(track), timestamp, track.ssrc))
+			fu.AppendByte(payloadHdr0)
+			fu.AppendByte(payloadHdr1)
+			fu.AppendByte(fuHeader)
+			fu.AppendByteArray(nalData[curNalData:end])
+			pktData, _ := fu.GetData()
+			rtpPkts.PushBack(pktData)
+			curNalData = end
+		}
+	}
+	//一帧的最后一个包设置marker
+	if rtpPkts.Len() > 0 {
+		rtpPkts.Back().Value.([]byte)[1] |= 0x80
+	}
+	return
+}
+
+func (this *RTSPHandler) nextSeq(track *trackInfo) uint32 {
+	track.seq++
+	if track.seq > 0xffff {
+		track.seq = 0
+	}
+	return uint32(track.seq)
+}
@@ -17,23 +17,25 @@ import (
 )
 
 type RTSPHandler struct {
-	conn        net.Conn
-	mutexConn   sync.Mutex
-	session     string
-	streamName  string
-	sinkAdded   bool
-	sinkRunning bool
-	audioHeader *flv.FlvTag
-	videoHeader *flv.FlvTag
-	isPlaying   bool
-	waitPlaying *sync.WaitGroup
-	videoCache  *list.List
-	mutexVideo  sync.RWMutex
-	audioCache  *list.List
-	mutexAudio  sync.RWMutex
-	tracks      map[string]*trackInfo
-	mutexTracks sync.RWMutex
-	tcpTimeout  bool //just for vlc(live555) no heart beat
+	conn            net.Conn
+	mutexConn       sync.Mutex
+	session         string
+	streamName      string
+	sinkAdded       bool
+	sinkRunning     bool
+	audioHeader     *flv.FlvTag
+	videoHeader     *flv.FlvTag
+	isPlaying       bool
+	waitPlaying     *sync.WaitGroup
+	videoCache      *list.List
+	mutexVideo      sync.RWMutex
+	audioCache      *list.List
+	mutexAudio      sync.RWMutex
+	videoCongestion *flv.CongestionController
+	audioCongestion *flv.CongestionController //音频不丢，只用来判断要不要断开
+	tracks          map[string]*trackInfo
+	mutexTracks     sync.RWMutex
+	tcpTimeout      bool //just for vlc(live555) no heart beat
 }
 
 type trackInfo struct {
@@ -98,6 +100,8 @@ func (this *RTSPHandler) Init(msg *wssAPI.Msg) (err error) {
 	this.tracks = make(map[string]*trackInfo)
 	this.waitPlaying = new(sync.WaitGroup)
 	this.tcpTimeout = true
+	this.videoCongestion = flv.NewCongestionController(serviceConfig.MaxQueueMs)
+	this.audioCongestion = flv.NewCongestionController(serviceConfig.MaxQueueMs)
 	return
 }
 
@@ -137,7 +141,12 @@ func (this *RTSPHandler) ProcessMessage(msg *wssAPI.Msg) (err error) {
 }
 
 func (this *RTSPHandler) appendFlvTag(msg *wssAPI.Msg) (err error) {
-	tag := msg.Param1.(*flv.FlvTag)
+	//rtp打包按老格式解析Data，Enhanced RTMP的tag换成老格式的拷贝
+	tag, err := flv.NormalizedVideoTag(msg.Param1.(*flv.FlvTag))
+	if err != nil {
+		logger.LOGW(err.Error())
+		return nil
+	}
 
 	if this.audioHeader == nil && tag.TagType == flv.FLV_TAG_Audio {
 		this.audioHeader = tag.Copy()
@@ -150,25 +159,65 @@ func (this *RTSPHandler) appendFlvTag(msg *wssAPI.Msg) (err error) {
 
 	if tag.TagType == flv.FLV_TAG_Video {
 		this.mutexVideo.Lock()
-		if this.videoCache == nil || this.videoCache.Len() > 0xff {
+		if this.videoCache == nil {
 			this.videoCache = list.New()
 		}
-		this.videoCache.PushBack(tag.Copy())
+		this.appendCache(this.videoCache, this.videoCongestion, tag)
 		this.mutexVideo.Unlock()
 	}
 
 	if flv.FLV_TAG_Audio == tag.TagType {
 		this.mutexAudio.Lock()
-		if this.audioCache == nil || this.audioCache.Len() > 0xff {
+		if this.audioCache == nil {
 			this.audioCache = list.New()
 		}
-		this.audioCache.PushBack(tag.Copy())
+		this.appendCache(this.audioCache, this.audioCongestion, tag)
 		this.mutexAudio.Unlock()
 	}
 
 	return
 }
 
+//没在播放时只留最新的一些，播放时交给拥塞控制丢帧
+func (this *RTSPHandler) appendCache(cache *list.List, congestion *flv.CongestionController, tag *flv.FlvTag) {
+	tag = tag.Copy()
+	if false == this.isPlaying {
+		if cache.Len() > 0xff {
+			cache.Init()
+		}
+		cache.PushBack(tag)
+		return
+	}
+	keep, purge, err := congestion.Admit(tag)
+	if err != nil {
+		//发送线程断开
+		logger.LOGW("rtsp play too slow:" + err.Error())
+		return
+	}
+	if purge {
+		logger.LOGW("rtsp play too slow,skip to next keyframe:" + this.streamName)
+		congestion.Purge(cache)
+	}
+	if keep {
+		cache.PushBack(tag)
+	}
+}
+
+//丢帧也追不上，断开
+func (this *RTSPHandler) checkCongestion() bool {
+	err := this.videoCongestion.Error()
+	if nil == err {
+		err = this.audioCongestion.Error()
+	}
+	if nil == err {
+		return true
+	}
+	logger.LOGE("rtsp play too slow,disconnect:" + err.Error())
+	this.isPlaying = false
+	this.conn.Close()
+	return false
+}
+
 func (this *RTSPHandler) handlePacket(data []byte) (err error) {
 	//连接关闭
 	if nil == data {
@@ -352,10 +401,12 @@ func (this *RTSPHandler) threadUdp(ch chan int, track *trackInfo) {
 		//清空之前累计的亢余数据
 		this.mutexVideo.Lock()
 		this.videoCache = list.New()
+		this.videoCongestion.Reset()
 		this.mutexVideo.Unlock()
 	} else if track.trackId == ctrl_track_audio {
 		this.mutexAudio.Lock()
 		this.audioCache = list.New()
+		this.audioCongestion.Reset()
 		this.mutexAudio.Unlock()
 	}
 	beginTime := uint32(0)
@@ -406,9 +457,12 @@ func (this *RTSPHandler) threadUdp(ch chan int, track *trackInfo) {
 				time.Sleep(10 * time.Millisecond)
 				continue
 			}
-			tag := this.videoCache.Front().Value.(*flv.FlvTag).Copy()
-			this.videoCache.Remove(this.videoCache.Front())
+			tag := this.videoCache.Remove(this.videoCache.Front()).(*flv.FlvTag)
+			this.videoCongestion.Sent(tag)
 			this.mutexVideo.Unlock()
+			if false == this.checkCongestion() {
+				return
+			}
 			err := this.sendFlvH264(track, tag, beginTime)
 			if err != nil {
 				logger.LOGE(err.Error())
@@ -422,9 +476,12 @@ func (this *RTSPHandler) threadUdp(ch chan int, track *trackInfo) {
 				time.Sleep(10 * time.Millisecond)
 				continue
 			}
-			tag := this.audioCache.Front().Value.(*flv.FlvTag).Copy()
-			this.audioCache.Remove(this.audioCache.Front())
+			tag := this.audioCache.Remove(this.audioCache.Front()).(*flv.FlvTag)
+			this.audioCongestion.Sent(tag)
 			this.mutexAudio.Unlock()
+			if false == this.checkCongestion() {
+				return
+			}
 			if audioBeginTime == 0 {
 				audioBeginTime = tag.Timestamp
 			}
@@ -460,10 +517,12 @@ func (this *RTSPHandler) threadTCP(ch chan int, track *trackInfo) {
 		//清空之前累计的亢余数据
 		this.mutexVideo.Lock()
 		this.videoCache = list.New()
+		this.videoCongestion.Reset()
 		this.mutexVideo.Unlock()
 	} else if track.trackId == ctrl_track_audio {
 		this.mutexAudio.Lock()
 		this.audioCache = list.New()
+		this.audioCongestion.Reset()
 		this.mutexAudio.Unlock()
 	}
 	beginTime := uint32(0)
@@ -487,9 +546,12 @@ func (this *RTSPHandler) threadTCP(ch chan int, track *trackInfo) {
 				time.Sleep(10 * time.Millisecond)
 				continue
 			}
-			tag := this.videoCache.Front().Value.(*flv.FlvTag).Copy()
-			this.videoCache.Remove(this.videoCache.Front())
+			tag := this.videoCache.Remove(this.videoCache.Front()).(*flv.FlvTag)
+			this.videoCongestion.Sent(tag)
 			this.mutexVideo.Unlock()
+			if false == this.checkCongestion() {
+				return
+			}
 			err := this.sendFlvH264(track, tag, beginTime)
 			if err != nil {
 				logger.LOGE(err.Error())
@@ -503,9 +565,12 @@ func (this *RTSPHandler) threadTCP(ch chan int, track *trackInfo) {
 				time.Sleep(10 * time.Millisecond)
 				continue
 			}
-			tag := this.audioCache.Front().Value.(*flv.FlvTag).Copy()
-			this.audioCache.Remove(this.audioCache.Front())
+			tag := this.audioCache.Remove(this.audioCache.Front()).(*flv.FlvTag)
+			this.audioCongestion.Sent(tag)
 			this.mutexAudio.Unlock()
+			if false == this.checkCongestion() {
+				return
+			}
 			if audioBeginTime == 0 {
 				audioBeginTime = tag.Timestamp
 			}
@@ -532,7 +597,7 @@ func (this *RTSPHandler) threadTCP(ch chan int, track *trackInfo) {
 
 func (this *RTSPHandler) sendFlvH264(track *trackInfo, tag *flv.FlvTag, beginSend uint32) (err error) {
 	if "udp" == track.transPort {
-		pkts := this.generateH264RTPPackets(tag, beginSend, track)
+		pkts := this.generateVideoRTPPackets(tag, beginSend, track)
 		if nil == pkts {
 			return
 		}
@@ -549,7 +614,7 @@ func (this *RTSPHandler) sendFlvH264(track *trackInfo, tag *flv.FlvTag, beginSen
 			}
 		}
 	} else {
-		pkts := this.generateH264RTPPackets(tag, beginSend, track)
+		pkts := this.generateVideoRTPPackets(tag, beginSend, track)
 		if nil == pkts {
 			return
 		}
@@ -642,6 +707,16 @@ func (this *RTSPHandler) sendRTCP(track *trackInfo, rtpTime uint32) {
 	}
 }
 
+func (this *RTSPHandler) generateVideoRTPPackets(tag *flv.FlvTag, beginTime uint32, track *trackInfo) (rtpPkts *list.List) {
+	switch flv.VideoCodecId(tag) {
+	case flv.CodecID_HEVC:
+		return this.generateH265RTPPackets(tag, beginTime, track)
+	case flv.CodecID_AVC:
+		return this.generateH264RTPPackets(tag, beginTime, track)
+	}
+	return nil
+}
+
 //packetization-mode 1
 func (this *RTSPHandler) generateH264RTPPackets(tag *flv.FlvTag, beginTime uint32, track *trackInfo) (rtpPkts *list.List) {
 	payLoadSize := RTP_MTU
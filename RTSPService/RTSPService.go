@@ -15,6 +15,7 @@ type RTSPService struct {
 type RTSPConfig struct {
 	Port       int `json:"port"`
 	TimeoutSec int `json:"timeoutSec"`
+	MaxQueueMs int `json:"maxQueueMs"` //积压超过它丢视频帧，3倍断开
 }
 
 var service *RTSPService
@@ -54,6 +55,9 @@ func (this *RTSPService) loadConfigFile(fileName string) (err error) {
 	if serviceConfig.Port == 0 {
 		serviceConfig.Port = 554
 	}
+	if serviceConfig.MaxQueueMs <= 0 {
+		serviceConfig.MaxQueueMs = 3000
+	}
 	return
 }
 
@@ -175,8 +175,15 @@ func removeSpace(data string) string {
 
 func generateSDP(videoHeader, audioHeader *flv.FlvTag) (sdp string, ok bool) {
 	if videoHeader != nil {
-		//读取视频类型，目前仅支持h264
-		sdp += genH264sdp(videoHeader.Data)
+		//读取视频类型，支持h264 h265
+		switch flv.VideoCodecId(videoHeader) {
+		case flv.CodecID_HEVC:
+			sdp += genH265sdp(videoHeader.Data)
+		case flv.CodecID_AVC:
+			sdp += genH264sdp(videoHeader.Data)
+		default:
+			logger.LOGW(fmt.Sprintf("video codec %d not supported by rtsp", flv.VideoCodecId(videoHeader)))
+		}
 		if len(sdp) > 0 {
 			ok = true
 		}
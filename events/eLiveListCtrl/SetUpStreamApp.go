@@ -13,6 +13,10 @@ type EveSetUpStreamApp struct {
 	Port     int    `json:"port"`
 	Addr     string `json:"addr"`
 	Weight   int    `json:"weight"`
+	//rtmps上游的证书校验
+	TLSServerName         string `json:"tlsServerName,omitempty"`
+	TLSInsecureSkipVerify bool   `json:"tlsInsecureSkipVerify,omitempty"`
+	TLSCAFile             string `json:"tlsCAFile,omitempty"`
 }
 
 func (this *EveSetUpStreamApp) Receiver() string {
@@ -46,6 +50,9 @@ func (this *EveSetUpStreamApp) Copy() (out *EveSetUpStreamApp) {
 	out.Addr = this.Addr
 	out.Port = this.Port
 	out.Weight = this.Weight
+	out.TLSServerName = this.TLSServerName
+	out.TLSInsecureSkipVerify = this.TLSInsecureSkipVerify
+	out.TLSCAFile = this.TLSCAFile
 	return
 }
 
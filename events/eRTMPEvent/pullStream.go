@@ -17,6 +17,10 @@ type EvePullRTMPStream struct {
 	Port       int
 	StreamName string
 	Src        chan wssAPI.Obj
+	//rtmps
+	TLSServerName         string
+	TLSInsecureSkipVerify bool
+	TLSCAFile             string
 }
 
 func (this *EvePullRTMPStream) Receiver() string {
@@ -48,5 +52,8 @@ func (this *EvePullRTMPStream) Copy() (out *EvePullRTMPStream) {
 	out.StreamName = this.StreamName
 	out.SourceName = this.SourceName
 	out.Src = this.Src
+	out.TLSServerName = this.TLSServerName
+	out.TLSInsecureSkipVerify = this.TLSInsecureSkipVerify
+	out.TLSCAFile = this.TLSCAFile
 	return
 }
@@ -44,6 +44,7 @@ type EveGetSource struct {
 	StreamName  string
 	SrcObj      wssAPI.Obj
 	HasProducer bool
+	SinkCount   int //out,当前观看数
 }
 
 func (this *EveGetSource) Receiver() string {
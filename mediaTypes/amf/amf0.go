@@ -44,6 +44,7 @@ type AMF0Data struct {
 	S16Value  int16
 	BoolValue bool
 	ObjValue  AMF0Object
+	ClassName string //typed object
 }
 
 type AMF0Encoder struct {
@@ -56,9 +57,6 @@ func (this *AMF0Encoder) Init() {
 
 func (this *AMF0Encoder) EncodeString(str string) (err error) {
 	length := uint32(len(str))
-	if length == 0 {
-		return errors.New("invald string")
-	}
 	if length >= 0xffff {
 		err = this.writer.WriteByte(AMF0_long_string)
 		if err != nil {
@@ -100,10 +98,6 @@ func (this *AMF0Encoder) EncodeNumber(num float64) (err error) {
 	return nil
 }
 
-func (this *AMF0Encoder)EncodeNU(){
-
-}
-
 func (this *AMF0Encoder) EncodeBool(boo bool) (err error) {
 	err = this.writer.WriteByte(AMF0_boolean)
 	if err != nil {
@@ -219,6 +213,12 @@ func (this *AMF0Encoder) GetData() (data []byte, err error) {
 	return this.writer.Bytes(), nil
 }
 
+//object marker+props+object end
+func (this *AMF0Encoder) EncodeObject(obj *AMF0Object) {
+	this.AppendByte(AMF0_object)
+	this.writer.Write(this.encodeObj(obj))
+}
+
 func (this *AMF0Encoder) GetDataSize() int {
 	return len(this.writer.Bytes())
 }
@@ -249,9 +249,10 @@ func (this *AMF0Encoder) encodeProp(prop *AMF0Property) (data []byte) {
 	enc := &AMF0Encoder{}
 	enc.Init()
 
-	//has name
+	//has name,对象成员的名字不带类型
 	if len(prop.Name) > 0 {
-		enc.EncodeString(prop.Name)
+		enc.EncodeInt16(int16(len(prop.Name)))
+		enc.AppendByteArray([]byte(prop.Name))
 	}
 	//encode type
 	switch prop.PropType {
@@ -264,16 +265,20 @@ func (this *AMF0Encoder) encodeProp(prop *AMF0Property) (data []byte) {
 	case AMF0_object:
 		enc.AppendByte(AMF0_object)
 		enc.AppendByteArray(this.encodeObj(&prop.Value.ObjValue))
+	case AMF0_typed_object:
+		enc.AppendByte(AMF0_typed_object)
+		enc.EncodeInt16(int16(len(prop.Value.ClassName)))
+		enc.AppendByteArray([]byte(prop.Value.ClassName))
+		enc.AppendByteArray(this.encodeObj(&prop.Value.ObjValue))
 	case AMF0_null:
 		enc.AppendByte(AMF0_null)
+	case AMF0_undefined:
+		enc.AppendByte(AMF0_undefined)
 	case AMF0_ecma_array:
 		enc.AppendByte(AMF0_ecma_array)
-		//size
-		//object
-		tmp := enc.encodeObj(&prop.Value.ObjValue)
-		tmpSize := int32(len(tmp))
-		enc.EncodeInt32(tmpSize)
-		enc.AppendByteArray(tmp)
+		//count+object
+		enc.EncodeInt32(int32(prop.Value.ObjValue.Props.Len()))
+		enc.AppendByteArray(enc.encodeObj(&prop.Value.ObjValue))
 	case AMF0_strict_array:
 		enc.AppendByte(AMF0_strict_array)
 		enc.EncodeInt32(int32(prop.Value.ObjValue.Props.Len()))
@@ -287,6 +292,10 @@ func (this *AMF0Encoder) encodeProp(prop *AMF0Property) (data []byte) {
 		enc.EncodeInt16(prop.Value.S16Value)
 	case AMF0_long_string:
 		enc.EncodeString(prop.Value.StrValue)
+	case AMF0_xml_document:
+		enc.AppendByte(AMF0_xml_document)
+		enc.EncodeInt32(int32(len(prop.Value.StrValue)))
+		enc.AppendByteArray([]byte(prop.Value.StrValue))
 	default:
 		logger.LOGW(fmt.Sprintf("not support amf type:%d", prop.PropType))
 	}
@@ -310,9 +319,13 @@ func AMF0DecodeInt32(data []byte) (ret uint32, err error) {
 	return ret, err
 }
 
+//chunk消息头里的message stream id是小端
 func AMF0DecodeInt32LE(data []byte) (ret uint32, err error) {
-	err = binary.Read(bytes.NewReader(data), binary.LittleEndian, &err)
-	return ret, err
+	if len(data) < 4 {
+		return 0, errors.New("amf0 int32 le too short")
+	}
+	ret = binary.LittleEndian.Uint32(data)
+	return ret, nil
 }
 
 func AMF0DecodeNumber(data []byte) (ret float64, err error) {
@@ -352,148 +365,182 @@ func AMFDecodeLongString(data []byte) (ret string, err error) {
 }
 
 func AMF0DecodeObj(data []byte) (ret *AMF0Object, err error) {
-	ret, _, err = amf0DecodeObj(data, false)
+	dec := &amf0Decoder{}
+	ret, _, err = dec.decodeObj(data, false)
 	return ret, err
 }
 
-func amf0DecodeObj(data []byte, decodeName bool) (ret *AMF0Object, sizeUsed int32, err error) {
+//一条消息一个，object ecma_array strict_array typed_object按出现顺序进引用表
+type amf0Decoder struct {
+	refs []*AMF0Property
+}
+
+func (this *amf0Decoder) decodeObj(data []byte, decodeName bool) (ret *AMF0Object, sizeUsed int32, err error) {
 	ret = &AMF0Object{}
 	var start, end int32
 	start = 0
 	end = int32(len(data))
 	for start < end {
-		if end-start >= 3 {
+		if decodeName && end-start >= 3 {
 			endType, _ := AMF0DecodeInt24(data[start:])
 			if endType == AMF0_object_end {
 				start += 3
-				err = nil
 				break
 			}
 		}
+		propGeted, bytesUsed, err := this.decodeProp(data[start:], decodeName)
 		if err != nil {
-			break
-		}
-		propGeted, bytesUsed, err := amf0DecodeProp(data[start:], decodeName)
-		if err != nil {
-			break
+			return nil, -1, err
 		}
 		if propGeted == nil || bytesUsed < 1 {
 			break
-		} else {
-			start += bytesUsed
-			ret.Props.PushBack(propGeted)
 		}
-
+		start += bytesUsed
+		ret.Props.PushBack(propGeted)
 	}
-	if err != nil {
-		return nil, -1, err
+	sizeUsed = start
+	return ret, sizeUsed, err
+}
+
+func amf0NeedData(data []byte, size int32) error {
+	if int32(len(data)) < size {
+		return errors.New("no enough data for amf0")
 	}
+	return nil
+}
 
-	sizeUsed = start
+func amf0ReadUTF8(data []byte) (str string, sizeUsed int32, err error) {
+	if err = amf0NeedData(data, 2); err != nil {
+		return
+	}
+	length := int32(data[0])<<8 | int32(data[1])
+	if err = amf0NeedData(data, 2+length); err != nil {
+		return
+	}
+	return string(data[2 : 2+length]), 2 + length, nil
+}
 
-	return ret, sizeUsed, err
+func amf0ReadUTF8Long(data []byte) (str string, sizeUsed int32, err error) {
+	if err = amf0NeedData(data, 4); err != nil {
+		return
+	}
+	length, _ := AMF0DecodeInt32(data)
+	if uint64(len(data)) < 4+uint64(length) {
+		return "", 0, errors.New("no enough data for amf0 long string")
+	}
+	return string(data[4 : 4+length]), 4 + int32(length), nil
 }
 
-func amf0DecodeProp(data []byte, decodeName bool) (ret *AMF0Property, sizeUsed int32, err error) {
+func (this *amf0Decoder) decodeProp(data []byte, decodeName bool) (ret *AMF0Property, sizeUsed int32, err error) {
 	ret = &AMF0Property{}
 	sizeUsed = 0
-	err = nil
 
 	if decodeName {
-		if len(data) < 4 {
-			return ret, sizeUsed, errors.New("no enough data for decode name")
-		}
-		nameSize, err := AMF0DecodeInt16(data[sizeUsed:])
+		name, size, err := amf0ReadUTF8(data)
 		if err != nil {
-			return ret, sizeUsed, nil
-		}
-		name, err := AMF0DecodeString(data[sizeUsed:])
-		if err != nil {
-			return ret, sizeUsed, nil
+			return ret, sizeUsed, err
 		}
 		ret.Name = name
-		sizeUsed += int32(2 + nameSize)
+		sizeUsed += size
+	}
+	if err = amf0NeedData(data, sizeUsed+1); err != nil {
+		return
 	}
-
 	ret.PropType = int32(data[sizeUsed])
 	sizeUsed += 1
 
 	switch ret.PropType {
 	case AMF0_number:
-		err = binary.Read(bytes.NewReader(data[sizeUsed:]), binary.BigEndian, &ret.Value.NumValue)
-		if err != nil {
-			return ret, sizeUsed, err
+		if err = amf0NeedData(data[sizeUsed:], 8); err != nil {
+			return
 		}
+		ret.Value.NumValue, _ = AMF0DecodeNumber(data[sizeUsed:])
 		sizeUsed += 8
 	case AMF0_boolean:
-		if data[sizeUsed] == 0 {
-			ret.Value.BoolValue = false
-		} else {
-			ret.Value.BoolValue = true
+		if err = amf0NeedData(data[sizeUsed:], 1); err != nil {
+			return
 		}
+		ret.Value.BoolValue = data[sizeUsed] != 0
 		sizeUsed += 1
 	case AMF0_string:
-		stringLength, err := AMF0DecodeInt16(data[sizeUsed:])
+		str, size, err := amf0ReadUTF8(data[sizeUsed:])
 		if err != nil {
 			return ret, sizeUsed, err
 		}
-		if stringLength == 0 {
-			ret.Value.StrValue = ""
-		} else {
-			ret.Value.StrValue, err = AMF0DecodeString(data[sizeUsed:])
+		ret.Value.StrValue = str
+		sizeUsed += size
+	case AMF0_object, AMF0_ecma_array, AMF0_typed_object:
+		//先占位，子对象的引用序号在后面
+		this.refs = append(this.refs, ret)
+		if AMF0_ecma_array == ret.PropType {
+			//count不可靠，以object end为准
+			if err = amf0NeedData(data[sizeUsed:], 4); err != nil {
+				return
+			}
+			sizeUsed += 4
+		}
+		if AMF0_typed_object == ret.PropType {
+			className, size, err := amf0ReadUTF8(data[sizeUsed:])
 			if err != nil {
 				return ret, sizeUsed, err
 			}
+			ret.Value.ClassName = className
+			sizeUsed += size
 		}
-		sizeUsed += int32(2 + stringLength)
-	case AMF0_object:
-		tmpObj, size, err := amf0DecodeObj(data[sizeUsed:], true)
+		tmpObj, size, err := this.decodeObj(data[sizeUsed:], true)
 		if err != nil {
 			return ret, sizeUsed, err
 		}
 		sizeUsed += size
 		ret.Value.ObjValue = *tmpObj
-	case AMF0_null:
-	case AMF0_ecma_array:
-		sizeUsed += 4
-		tmpObj, size, err := amf0DecodeObj(data[sizeUsed:], true)
-		if err != nil {
-			return ret, sizeUsed, err
+	case AMF0_null, AMF0_undefined, AMF0_unsupported:
+	case AMF0_reference:
+		if err = amf0NeedData(data[sizeUsed:], 2); err != nil {
+			return
 		}
-		sizeUsed += size
-		ret.Value.ObjValue = *tmpObj
+		idx, _ := AMF0DecodeInt16(data[sizeUsed:])
+		sizeUsed += 2
+		if int(idx) >= len(this.refs) {
+			return ret, sizeUsed, errors.New(fmt.Sprintf("invalid amf0 reference %d", idx))
+		}
+		//直接展开成引用的对象
+		name := ret.Name
+		*ret = *this.refs[idx]
+		ret.Name = name
 	case AMF0_strict_array:
-		size, err := amf0ReadStrictArray(data[sizeUsed:], ret)
+		this.refs = append(this.refs, ret)
+		size, err := this.readStrictArray(data[sizeUsed:], ret)
 		if err != nil {
 			return ret, sizeUsed, err
 		}
 		sizeUsed += size
 	case AMF0_date:
-		ret.Value.NumValue, err = AMF0DecodeNumber(data[sizeUsed:])
-		if err != nil {
-			return ret, sizeUsed, err
+		if err = amf0NeedData(data[sizeUsed:], 10); err != nil {
+			return
 		}
+		ret.Value.NumValue, _ = AMF0DecodeNumber(data[sizeUsed:])
 		sizeUsed += 8
-		tmpu16, err := (AMF0DecodeInt16(data[sizeUsed:]))
+		tmpu16, _ := AMF0DecodeInt16(data[sizeUsed:])
 		ret.Value.S16Value = int16(tmpu16)
+		sizeUsed += 2
+	case AMF0_long_string, AMF0_xml_document:
+		str, size, err := amf0ReadUTF8Long(data[sizeUsed:])
 		if err != nil {
 			return ret, sizeUsed, err
 		}
-		sizeUsed += 2
-	case AMF0_long_string:
-		stringLength, err := AMF0DecodeInt32(data[sizeUsed:])
+		ret.Value.StrValue = str
+		sizeUsed += size
+	case AMF0_avmplus_object:
+		//切换到amf3，每次切换新的引用表
+		amf3 := &amf3Decoder{}
+		value, size, err := amf3.decodeValue(data[sizeUsed:])
 		if err != nil {
 			return ret, sizeUsed, err
 		}
-		if stringLength == 0 {
-			ret.Value.StrValue = ""
-		} else {
-			ret.Value.StrValue, err = AMFDecodeLongString(data[sizeUsed:])
-			if err != nil {
-				return ret, sizeUsed, err
-			}
-		}
-		sizeUsed += int32(4 + stringLength)
+		name := ret.Name
+		*ret = *value
+		ret.Name = name
+		sizeUsed += size
 	default:
 		err = errors.New(fmt.Sprintf("not support amf type:%d", ret.PropType))
 	}
@@ -501,30 +548,24 @@ func amf0DecodeProp(data []byte, decodeName bool) (ret *AMF0Property, sizeUsed i
 	return ret, sizeUsed, err
 }
 
-func amf0ReadStrictArray(data []byte, prop *AMF0Property) (sizeUsed int32, err error) {
+func (this *amf0Decoder) readStrictArray(data []byte, prop *AMF0Property) (sizeUsed int32, err error) {
 	if prop == nil {
 		return 0, errors.New("invalid prop in amfReadStrictArray")
 	}
-	var arrayCount uint32
-	sizeUsed = 0
-	arrayCount, err = AMF0DecodeInt32(data)
-	if err != nil {
-		return 0, err
-	}
-	sizeUsed += 4
-	if arrayCount == 0 {
-		return sizeUsed, err
+	if err = amf0NeedData(data, 4); err != nil {
+		return
 	}
+	arrayCount, _ := AMF0DecodeInt32(data)
+	sizeUsed = 4
 	for arrayCount > 0 {
 		arrayCount--
-		tmpProp, size, err := amf0DecodeProp(data[sizeUsed:], false)
+		tmpProp, size, err := this.decodeProp(data[sizeUsed:], false)
 		if err != nil {
 			return sizeUsed, err
 		}
 		prop.Value.ObjValue.Props.PushBack(tmpProp)
 		sizeUsed += size
 	}
-
 	return sizeUsed, err
 }
 
@@ -543,6 +584,34 @@ func (this *AMF0Object) AMF0GetPropByIndex(index int) (prop *AMF0Property) {
 	return
 }
 
+func (this *AMF0Object) AddString(name, str string) {
+	prop := &AMF0Property{PropType: AMF0_string, Name: name}
+	if len(str) >= 0xffff {
+		prop.PropType = AMF0_long_string
+	}
+	prop.Value.StrValue = str
+	this.Props.PushBack(prop)
+}
+
+func (this *AMF0Object) AddNumber(name string, num float64) {
+	prop := &AMF0Property{PropType: AMF0_number, Name: name}
+	prop.Value.NumValue = num
+	this.Props.PushBack(prop)
+}
+
+func (this *AMF0Object) AddBool(name string, boolean bool) {
+	prop := &AMF0Property{PropType: AMF0_boolean, Name: name}
+	prop.Value.BoolValue = boolean
+	this.Props.PushBack(prop)
+}
+
+//propType:object ecma_array strict_array
+func (this *AMF0Object) AddObject(name string, propType int32, obj *AMF0Object) {
+	prop := &AMF0Property{PropType: propType, Name: name}
+	prop.Value.ObjValue = *obj
+	this.Props.PushBack(prop)
+}
+
 func (this *AMF0Object) AMF0GetPropByName(name string) (prop *AMF0Property) {
 	for e := this.Props.Front(); e != nil; e = e.Next() {
 		v := e.Value.(*AMF0Property)
@@ -568,14 +637,12 @@ func (this *AMF0Object) dumpProp(prop *AMF0Property) {
 	switch prop.PropType {
 	case AMF0_ecma_array:
 		logger.LOGT("ecma array")
-		this.dumpProp(prop)
 	case AMF0_object:
 		logger.LOGT("object")
 
 		prop.Value.ObjValue.Dump()
 	case AMF0_strict_array:
 		logger.LOGT("static array")
-		this.dumpProp(prop)
 	case AMF0_string:
 		logger.LOGT("string:" + prop.Value.StrValue)
 	case AMF0_number:
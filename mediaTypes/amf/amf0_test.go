@@ -0,0 +1,219 @@
+package amf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+//把解出来的值转成字符串比较，list不能直接DeepEqual
+func propString(prop *AMF0Property) string {
+	str := fmt.Sprintf("%d:%s=", prop.PropType, prop.Name)
+	switch prop.PropType {
+	case AMF0_number:
+		str += fmt.Sprint(prop.Value.NumValue)
+	case AMF0_boolean:
+		str += fmt.Sprint(prop.Value.BoolValue)
+	case AMF0_string, AMF0_long_string, AMF0_xml_document:
+		str += prop.Value.StrValue
+	case AMF0_date:
+		str += fmt.Sprintf("%v/%d", prop.Value.NumValue, prop.Value.S16Value)
+	case AMF0_object, AMF0_ecma_array, AMF0_strict_array, AMF0_typed_object:
+		str += prop.Value.ClassName + objString(&prop.Value.ObjValue)
+	}
+	return str
+}
+
+func objString(obj *AMF0Object) string {
+	props := make([]string, 0, obj.Props.Len())
+	for e := obj.Props.Front(); e != nil; e = e.Next() {
+		props = append(props, propString(e.Value.(*AMF0Property)))
+	}
+	return "{" + strings.Join(props, ",") + "}"
+}
+
+func newTestObject(propType int32, className string, props ...*AMF0Property) *AMF0Property {
+	return newTestNamedObject("", propType, className, props...)
+}
+
+func newTestNamedObject(name string, propType int32, className string, props ...*AMF0Property) *AMF0Property {
+	prop := &AMF0Property{PropType: propType, Name: name}
+	prop.Value.ClassName = className
+	for _, v := range props {
+		prop.Value.ObjValue.Props.PushBack(v)
+	}
+	return prop
+}
+
+func newTestString(name, str string) *AMF0Property {
+	prop := &AMF0Property{PropType: AMF0_string, Name: name}
+	prop.Value.StrValue = str
+	return prop
+}
+
+func newTestNumber(name string, num float64) *AMF0Property {
+	prop := &AMF0Property{PropType: AMF0_number, Name: name}
+	prop.Value.NumValue = num
+	return prop
+}
+
+func newTestDate(ms float64, timezone int16) *AMF0Property {
+	prop := &AMF0Property{PropType: AMF0_date}
+	prop.Value.NumValue = ms
+	prop.Value.S16Value = timezone
+	return prop
+}
+
+func encodeTestProps(props ...*AMF0Property) []byte {
+	enc := &AMF0Encoder{}
+	enc.Init()
+	for _, prop := range props {
+		enc.AppendByteArray(enc.encodeProp(prop))
+	}
+	data, _ := enc.GetData()
+	return data
+}
+
+//编码再解码，值和类型都不变
+func TestAMF0RoundTrip(t *testing.T) {
+	longStr := &AMF0Property{PropType: AMF0_long_string}
+	longStr.Value.StrValue = strings.Repeat("l", 0x10000)
+	xml := &AMF0Property{PropType: AMF0_xml_document}
+	xml.Value.StrValue = "<a/>"
+	tests := []*AMF0Property{
+		newTestNumber("", 1.5),
+		{PropType: AMF0_boolean, Value: AMF0Data{BoolValue: true}},
+		newTestString("", "connect"),
+		newTestString("", ""),
+		longStr,
+		xml,
+		{PropType: AMF0_null},
+		{PropType: AMF0_undefined},
+		newTestDate(1500000000000, 0),
+		newTestDate(-1000, -480),
+		newTestObject(AMF0_object, "", newTestString("app", "live"), newTestNumber("objectEncoding", 3)),
+		newTestObject(AMF0_typed_object, "flex.Msg", newTestString("body", "x"),
+			newTestNamedObject("headers", AMF0_object, "", newTestNumber("n", 2))),
+		newTestObject(AMF0_ecma_array, "", newTestNumber("width", 1280), newTestNumber("height", 720)),
+		newTestObject(AMF0_strict_array, "", newTestNumber("", 1), newTestString("", "b"),
+			newTestObject(AMF0_object, "", newTestNumber("c", 3))),
+	}
+	for i, test := range tests {
+		obj, err := AMF0DecodeObj(encodeTestProps(test))
+		if err != nil {
+			t.Errorf("case %d: %s", i, err.Error())
+			continue
+		}
+		if obj.Props.Len() != 1 {
+			t.Errorf("case %d: %d values", i, obj.Props.Len())
+			continue
+		}
+		got := obj.Props.Front().Value.(*AMF0Property)
+		if propString(got) != propString(test) {
+			t.Errorf("case %d:\n got %.100s\nwant %.100s", i, propString(got), propString(test))
+		}
+	}
+}
+
+//对象按出现顺序编号，引用展开成原来的对象，名字保留自己的
+func TestAMF0Reference(t *testing.T) {
+	inner := newTestObject(AMF0_object, "", newTestNumber("n", 1))
+	tests := []struct {
+		data []byte
+		want string
+	}{
+		//object, reference 0
+		{append(encodeTestProps(inner), AMF0_reference, 0, 0),
+			propString(inner) + propString(inner)},
+		//strict array[object], reference 1是数组里的object
+		{append(encodeTestProps(newTestObject(AMF0_strict_array, "", inner)), AMF0_reference, 0, 1),
+			propString(newTestObject(AMF0_strict_array, "", inner)) + propString(inner)},
+		//object{a:object, b:reference 1}
+		{[]byte{AMF0_object, 0, 1, 'a', AMF0_object, 0, 1, 'n', AMF0_number, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0, 0, 0, AMF0_object_end,
+			0, 1, 'b', AMF0_reference, 0, 1, 0, 0, AMF0_object_end},
+			"3:={3:a={0:n=1},3:b={0:n=1}}"},
+	}
+	for i, test := range tests {
+		obj, err := AMF0DecodeObj(test.data)
+		if err != nil {
+			t.Errorf("case %d: %s", i, err.Error())
+			continue
+		}
+		got := ""
+		for e := obj.Props.Front(); e != nil; e = e.Next() {
+			got += propString(e.Value.(*AMF0Property))
+		}
+		if got != test.want {
+			t.Errorf("case %d:\n got %s\nwant %s", i, got, test.want)
+		}
+	}
+}
+
+//错误的引用和截断的数据返回错误，不能panic
+func TestAMF0Malformed(t *testing.T) {
+	tests := [][]byte{
+		{AMF0_reference, 0, 0},
+		append(encodeTestProps(newTestObject(AMF0_object, "")), AMF0_reference, 0, 1),
+		{AMF0_avmplus_object},
+		{0x20},
+	}
+	full := encodeTestProps(
+		newTestNumber("", 1),
+		newTestObject(AMF0_typed_object, "c", newTestString("s", "str"), newTestDate(1, 1)),
+		newTestObject(AMF0_ecma_array, "", newTestNumber("w", 1)),
+		newTestObject(AMF0_strict_array, "", newTestNumber("", 1)),
+	)
+	//截断在值中间的，结尾刚好是完整值的不算
+	for _, size := range []int{1, 5, 10, 12, 14, 20, 30, 40, 45, len(full) - 1} {
+		tests = append(tests, full[:size])
+	}
+	for i, test := range tests {
+		if _, err := AMF0DecodeObj(test); nil == err {
+			t.Errorf("case %d: % x decoded", i, test)
+		}
+	}
+}
+
+//message stream id是小端的
+func TestAMF0DecodeInt32LE(t *testing.T) {
+	tests := []struct {
+		data  []byte
+		value uint32
+		err   bool
+	}{
+		{[]byte{1, 0, 0, 0}, 1, false},
+		{[]byte{4, 3, 2, 1}, 0x01020304, false},
+		{[]byte{4, 3, 2, 1, 0xff}, 0x01020304, false},
+		{[]byte{1, 0, 0}, 0, true},
+		{nil, 0, true},
+	}
+	for i, test := range tests {
+		value, err := AMF0DecodeInt32LE(test.data)
+		if value != test.value || (err != nil) != test.err {
+			t.Errorf("case %d: %x %v", i, value, err)
+		}
+	}
+}
+
+//命令里混着AVM+，切换后接着按amf0解
+func TestAMF0AVMPlusSwitch(t *testing.T) {
+	enc := &AMF0Encoder{}
+	enc.Init()
+	enc.EncodeString("_result")
+	enc.EncodeNumber(1)
+	enc.EncodeAVMPlusObject(&newTestObject(AMF0_object, "", newTestString("fmsVer", "FMS/3"), newTestNumber("capabilities", 31)).Value.ObjValue)
+	enc.AppendByte(AMF0_null)
+	data, _ := enc.GetData()
+	obj, err := AMF0DecodeObj(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "2:=_result,0:=1,3:={2:fmsVer=FMS/3,0:capabilities=31},5:="
+	if got := objString(obj); got != "{"+want+"}" {
+		t.Fatalf("\n got %s\nwant {%s}", got, want)
+	}
+	if false == bytes.Contains(data, []byte{AMF0_avmplus_object, AMF3_object}) {
+		t.Fatal("object not encoded as amf3")
+	}
+}
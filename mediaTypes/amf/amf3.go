@@ -0,0 +1,515 @@
+package amf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+//amf3只在AVM+切换后出现，解出来转成AMF0Property，上层不用区分
+const (
+	AMF3_undefined     = 0x00
+	AMF3_null          = 0x01
+	AMF3_false         = 0x02
+	AMF3_true          = 0x03
+	AMF3_integer       = 0x04
+	AMF3_double        = 0x05
+	AMF3_string        = 0x06
+	AMF3_xml_doc       = 0x07
+	AMF3_date          = 0x08
+	AMF3_array         = 0x09
+	AMF3_object        = 0x0a
+	AMF3_xml           = 0x0b
+	AMF3_byte_array    = 0x0c
+	AMF3_vector_int    = 0x0d
+	AMF3_vector_uint   = 0x0e
+	AMF3_vector_double = 0x0f
+	AMF3_vector_object = 0x10
+	AMF3_dictionary    = 0x11
+)
+
+const (
+	amf3_int_max = 0x0fffffff
+	amf3_int_min = -0x10000000
+)
+
+type amf3Traits struct {
+	className      string
+	dynamic        bool
+	externalizable bool
+	members        []string
+}
+
+type amf3Decoder struct {
+	strings []string
+	objects []*AMF0Property
+	traits  []*amf3Traits
+}
+
+func amf3ReadU29(data []byte) (value uint32, sizeUsed int32, err error) {
+	for i := 0; i < 4; i++ {
+		if i >= len(data) {
+			return 0, 0, errors.New("no enough data for amf3 u29")
+		}
+		if 3 == i {
+			value = (value << 8) | uint32(data[i])
+			return value, 4, nil
+		}
+		value = (value << 7) | uint32(data[i]&0x7f)
+		if (data[i] & 0x80) == 0 {
+			return value, int32(i + 1), nil
+		}
+	}
+	return
+}
+
+func (this *amf3Decoder) readString(data []byte) (str string, sizeUsed int32, err error) {
+	header, sizeUsed, err := amf3ReadU29(data)
+	if err != nil {
+		return
+	}
+	if (header & 1) == 0 {
+		idx := int(header >> 1)
+		if idx >= len(this.strings) {
+			return "", sizeUsed, errors.New(fmt.Sprintf("invalid amf3 string reference %d", idx))
+		}
+		return this.strings[idx], sizeUsed, nil
+	}
+	length := int32(header >> 1)
+	if err = amf0NeedData(data[sizeUsed:], length); err != nil {
+		return
+	}
+	str = string(data[sizeUsed : sizeUsed+length])
+	sizeUsed += length
+	//空字符串不进引用表
+	if length > 0 {
+		this.strings = append(this.strings, str)
+	}
+	return
+}
+
+//对象引用，header第一位为0时返回引用的值
+func (this *amf3Decoder) readRef(header uint32) (ret *AMF0Property, err error) {
+	idx := int(header >> 1)
+	if idx >= len(this.objects) {
+		return nil, errors.New(fmt.Sprintf("invalid amf3 object reference %d", idx))
+	}
+	ret = &AMF0Property{}
+	*ret = *this.objects[idx]
+	ret.Name = ""
+	return
+}
+
+func (this *amf3Decoder) decodeValue(data []byte) (ret *AMF0Property, sizeUsed int32, err error) {
+	if err = amf0NeedData(data, 1); err != nil {
+		return
+	}
+	ret = &AMF0Property{}
+	marker := data[0]
+	sizeUsed = 1
+	switch marker {
+	case AMF3_undefined:
+		ret.PropType = AMF0_undefined
+	case AMF3_null:
+		ret.PropType = AMF0_null
+	case AMF3_false, AMF3_true:
+		ret.PropType = AMF0_boolean
+		ret.Value.BoolValue = AMF3_true == marker
+	case AMF3_integer:
+		value, size, err := amf3ReadU29(data[sizeUsed:])
+		if err != nil {
+			return ret, sizeUsed, err
+		}
+		sizeUsed += size
+		//29位有符号
+		if (value & 0x10000000) != 0 {
+			ret.Value.NumValue = float64(int32(value) - 0x20000000)
+		} else {
+			ret.Value.NumValue = float64(value)
+		}
+		ret.PropType = AMF0_number
+	case AMF3_double:
+		if err = amf0NeedData(data[sizeUsed:], 8); err != nil {
+			return
+		}
+		ret.PropType = AMF0_number
+		ret.Value.NumValue, _ = AMF0DecodeNumber(data[sizeUsed:])
+		sizeUsed += 8
+	case AMF3_string:
+		str, size, err := this.readString(data[sizeUsed:])
+		if err != nil {
+			return ret, sizeUsed, err
+		}
+		ret.PropType = AMF0_string
+		if len(str) >= 0xffff {
+			ret.PropType = AMF0_long_string
+		}
+		ret.Value.StrValue = str
+		sizeUsed += size
+	default:
+		size, err := this.decodeComplex(marker, data[sizeUsed:], ret)
+		if err != nil {
+			return ret, sizeUsed, err
+		}
+		sizeUsed += size
+	}
+	return
+}
+
+//进对象引用表的类型
+func (this *amf3Decoder) decodeComplex(marker byte, data []byte, ret *AMF0Property) (sizeUsed int32, err error) {
+	header, sizeUsed, err := amf3ReadU29(data)
+	if err != nil {
+		return
+	}
+	if (header & 1) == 0 {
+		ref, err := this.readRef(header)
+		if err != nil {
+			return sizeUsed, err
+		}
+		*ret = *ref
+		return sizeUsed, nil
+	}
+	if marker != AMF3_object {
+		this.objects = append(this.objects, ret)
+	}
+	size := int32(0)
+	switch marker {
+	case AMF3_xml_doc, AMF3_xml, AMF3_byte_array:
+		length := int32(header >> 1)
+		if err = amf0NeedData(data[sizeUsed:], length); err != nil {
+			return
+		}
+		//byte array放在StrValue里
+		ret.PropType = AMF0_long_string
+		if AMF3_byte_array != marker {
+			ret.PropType = AMF0_xml_document
+		}
+		ret.Value.StrValue = string(data[sizeUsed : sizeUsed+length])
+		size = length
+	case AMF3_date:
+		if err = amf0NeedData(data[sizeUsed:], 8); err != nil {
+			return
+		}
+		ret.PropType = AMF0_date
+		ret.Value.NumValue, _ = AMF0DecodeNumber(data[sizeUsed:])
+		size = 8
+	case AMF3_array:
+		size, err = this.readArray(int(header>>1), data[sizeUsed:], ret)
+	case AMF3_object:
+		size, err = this.readObject(header, data[sizeUsed:], ret)
+	case AMF3_vector_int, AMF3_vector_uint, AMF3_vector_double, AMF3_vector_object:
+		size, err = this.readVector(marker, int(header>>1), data[sizeUsed:], ret)
+	case AMF3_dictionary:
+		size, err = this.readDictionary(int(header>>1), data[sizeUsed:], ret)
+	default:
+		err = errors.New(fmt.Sprintf("not support amf3 type:%d", marker))
+	}
+	sizeUsed += size
+	return
+}
+
+//只有dense部分为strict array，否则为ecma array，dense部分名字为下标
+func (this *amf3Decoder) readArray(count int, data []byte, ret *AMF0Property) (sizeUsed int32, err error) {
+	assoc := &AMF0Object{}
+	for {
+		key, size, err := this.readString(data[sizeUsed:])
+		if err != nil {
+			return sizeUsed, err
+		}
+		sizeUsed += size
+		if len(key) == 0 {
+			break
+		}
+		value, size, err := this.decodeValue(data[sizeUsed:])
+		if err != nil {
+			return sizeUsed, err
+		}
+		sizeUsed += size
+		value.Name = key
+		assoc.Props.PushBack(value)
+	}
+	ret.PropType = AMF0_strict_array
+	if assoc.Props.Len() > 0 {
+		ret.PropType = AMF0_ecma_array
+	}
+	for i := 0; i < count; i++ {
+		value, size, err := this.decodeValue(data[sizeUsed:])
+		if err != nil {
+			return sizeUsed, err
+		}
+		sizeUsed += size
+		if AMF0_ecma_array == ret.PropType {
+			value.Name = strconv.Itoa(i)
+		}
+		ret.Value.ObjValue.Props.PushBack(value)
+	}
+	for e := assoc.Props.Front(); e != nil; e = e.Next() {
+		ret.Value.ObjValue.Props.PushBack(e.Value)
+	}
+	return
+}
+
+func (this *amf3Decoder) readObject(header uint32, data []byte, ret *AMF0Property) (sizeUsed int32, err error) {
+	this.objects = append(this.objects, ret)
+	var traits *amf3Traits
+	if (header & 2) == 0 {
+		idx := int(header >> 2)
+		if idx >= len(this.traits) {
+			return 0, errors.New(fmt.Sprintf("invalid amf3 traits reference %d", idx))
+		}
+		traits = this.traits[idx]
+	} else {
+		traits = &amf3Traits{}
+		traits.externalizable = (header & 4) != 0
+		traits.dynamic = (header & 8) != 0
+		className, size, err := this.readString(data[sizeUsed:])
+		if err != nil {
+			return sizeUsed, err
+		}
+		sizeUsed += size
+		traits.className = className
+		if false == traits.externalizable {
+			for i := 0; i < int(header>>4); i++ {
+				member, size, err := this.readString(data[sizeUsed:])
+				if err != nil {
+					return sizeUsed, err
+				}
+				sizeUsed += size
+				traits.members = append(traits.members, member)
+			}
+		}
+		this.traits = append(this.traits, traits)
+	}
+	ret.PropType = AMF0_object
+	if len(traits.className) > 0 {
+		ret.PropType = AMF0_typed_object
+		ret.Value.ClassName = traits.className
+	}
+	if traits.externalizable {
+		//flex的几个集合类里面只有一个值，其他的格式未知
+		switch traits.className {
+		case "flex.messaging.io.ArrayCollection", "flex.messaging.io.ObjectProxy":
+			value, size, err := this.decodeValue(data[sizeUsed:])
+			if err != nil {
+				return sizeUsed, err
+			}
+			sizeUsed += size
+			value.Name = "source"
+			ret.Value.ObjValue.Props.PushBack(value)
+			return sizeUsed, nil
+		default:
+			return sizeUsed, errors.New("amf3 externalizable class not supported:" + traits.className)
+		}
+	}
+	for _, member := range traits.members {
+		value, size, err := this.decodeValue(data[sizeUsed:])
+		if err != nil {
+			return sizeUsed, err
+		}
+		sizeUsed += size
+		value.Name = member
+		ret.Value.ObjValue.Props.PushBack(value)
+	}
+	if traits.dynamic {
+		for {
+			key, size, err := this.readString(data[sizeUsed:])
+			if err != nil {
+				return sizeUsed, err
+			}
+			sizeUsed += size
+			if len(key) == 0 {
+				break
+			}
+			value, size, err := this.decodeValue(data[sizeUsed:])
+			if err != nil {
+				return sizeUsed, err
+			}
+			sizeUsed += size
+			value.Name = key
+			ret.Value.ObjValue.Props.PushBack(value)
+		}
+	}
+	return
+}
+
+//vector都转成strict array
+func (this *amf3Decoder) readVector(marker byte, count int, data []byte, ret *AMF0Property) (sizeUsed int32, err error) {
+	//fixed-vector flag
+	if err = amf0NeedData(data, 1); err != nil {
+		return
+	}
+	sizeUsed = 1
+	ret.PropType = AMF0_strict_array
+	if AMF3_vector_object == marker {
+		_, size, err := this.readString(data[sizeUsed:])
+		if err != nil {
+			return sizeUsed, err
+		}
+		sizeUsed += size
+	}
+	for i := 0; i < count; i++ {
+		value := &AMF0Property{PropType: AMF0_number}
+		switch marker {
+		case AMF3_vector_int, AMF3_vector_uint:
+			if err = amf0NeedData(data[sizeUsed:], 4); err != nil {
+				return
+			}
+			tmp := binary.BigEndian.Uint32(data[sizeUsed:])
+			if AMF3_vector_int == marker {
+				value.Value.NumValue = float64(int32(tmp))
+			} else {
+				value.Value.NumValue = float64(tmp)
+			}
+			sizeUsed += 4
+		case AMF3_vector_double:
+			if err = amf0NeedData(data[sizeUsed:], 8); err != nil {
+				return
+			}
+			value.Value.NumValue = math.Float64frombits(binary.BigEndian.Uint64(data[sizeUsed:]))
+			sizeUsed += 8
+		default:
+			var size int32
+			value, size, err = this.decodeValue(data[sizeUsed:])
+			if err != nil {
+				return
+			}
+			sizeUsed += size
+		}
+		ret.Value.ObjValue.Props.PushBack(value)
+	}
+	return
+}
+
+//key转成字符串做名字
+func (this *amf3Decoder) readDictionary(count int, data []byte, ret *AMF0Property) (sizeUsed int32, err error) {
+	//weak keys
+	if err = amf0NeedData(data, 1); err != nil {
+		return
+	}
+	sizeUsed = 1
+	ret.PropType = AMF0_ecma_array
+	for i := 0; i < count; i++ {
+		key, size, err := this.decodeValue(data[sizeUsed:])
+		if err != nil {
+			return sizeUsed, err
+		}
+		sizeUsed += size
+		value, size, err := this.decodeValue(data[sizeUsed:])
+		if err != nil {
+			return sizeUsed, err
+		}
+		sizeUsed += size
+		switch key.PropType {
+		case AMF0_number:
+			value.Name = strconv.FormatFloat(key.Value.NumValue, 'f', -1, 64)
+		case AMF0_boolean:
+			value.Name = strconv.FormatBool(key.Value.BoolValue)
+		default:
+			value.Name = key.Value.StrValue
+		}
+		ret.Value.ObjValue.Props.PushBack(value)
+	}
+	return
+}
+
+//编码,只用内联的traits和字符串，不生成引用
+func amf3WriteU29(enc *AMF0Encoder, value uint32) {
+	value &= 0x1fffffff
+	switch {
+	case value < 0x80:
+		enc.AppendByte(byte(value))
+	case value < 0x4000:
+		enc.AppendByte(byte(value>>7) | 0x80)
+		enc.AppendByte(byte(value & 0x7f))
+	case value < 0x200000:
+		enc.AppendByte(byte(value>>14) | 0x80)
+		enc.AppendByte(byte(value>>7) | 0x80)
+		enc.AppendByte(byte(value & 0x7f))
+	default:
+		enc.AppendByte(byte(value>>22) | 0x80)
+		enc.AppendByte(byte(value>>15) | 0x80)
+		enc.AppendByte(byte(value>>8) | 0x80)
+		enc.AppendByte(byte(value))
+	}
+}
+
+func amf3WriteUTF8(enc *AMF0Encoder, str string) {
+	amf3WriteU29(enc, uint32(len(str))<<1|1)
+	enc.AppendByteArray([]byte(str))
+}
+
+func (this *AMF0Encoder) encodeAMF3Value(prop *AMF0Property) {
+	switch prop.PropType {
+	case AMF0_number:
+		num := prop.Value.NumValue
+		if num == math.Trunc(num) && num >= amf3_int_min && num <= amf3_int_max {
+			this.AppendByte(AMF3_integer)
+			amf3WriteU29(this, uint32(int32(num)))
+		} else {
+			this.AppendByte(AMF3_double)
+			binary.Write(this.writer, binary.BigEndian, &num)
+		}
+	case AMF0_boolean:
+		if prop.Value.BoolValue {
+			this.AppendByte(AMF3_true)
+		} else {
+			this.AppendByte(AMF3_false)
+		}
+	case AMF0_string, AMF0_long_string:
+		this.AppendByte(AMF3_string)
+		amf3WriteUTF8(this, prop.Value.StrValue)
+	case AMF0_xml_document:
+		this.AppendByte(AMF3_xml_doc)
+		amf3WriteUTF8(this, prop.Value.StrValue)
+	case AMF0_date:
+		this.AppendByte(AMF3_date)
+		amf3WriteU29(this, 1)
+		binary.Write(this.writer, binary.BigEndian, &prop.Value.NumValue)
+	case AMF0_undefined:
+		this.AppendByte(AMF3_undefined)
+	case AMF0_object, AMF0_typed_object:
+		//内联traits,dynamic,没有sealed成员
+		this.AppendByte(AMF3_object)
+		amf3WriteU29(this, 0x0b)
+		amf3WriteUTF8(this, prop.Value.ClassName)
+		for e := prop.Value.ObjValue.Props.Front(); e != nil; e = e.Next() {
+			v := e.Value.(*AMF0Property)
+			amf3WriteUTF8(this, v.Name)
+			this.encodeAMF3Value(v)
+		}
+		amf3WriteUTF8(this, "")
+	case AMF0_ecma_array:
+		this.AppendByte(AMF3_array)
+		amf3WriteU29(this, 1)
+		for e := prop.Value.ObjValue.Props.Front(); e != nil; e = e.Next() {
+			v := e.Value.(*AMF0Property)
+			amf3WriteUTF8(this, v.Name)
+			this.encodeAMF3Value(v)
+		}
+		amf3WriteUTF8(this, "")
+	case AMF0_strict_array:
+		this.AppendByte(AMF3_array)
+		amf3WriteU29(this, uint32(prop.Value.ObjValue.Props.Len())<<1|1)
+		amf3WriteUTF8(this, "")
+		for e := prop.Value.ObjValue.Props.Front(); e != nil; e = e.Next() {
+			this.encodeAMF3Value(e.Value.(*AMF0Property))
+		}
+	default:
+		this.AppendByte(AMF3_null)
+	}
+}
+
+//AVM+切换，后面跟一个amf3对象
+func (this *AMF0Encoder) EncodeAVMPlusObject(obj *AMF0Object) {
+	prop := &AMF0Property{PropType: AMF0_object}
+	prop.Value.ObjValue = *obj
+	this.EncodeAVMPlusValue(prop)
+}
+
+func (this *AMF0Encoder) EncodeAVMPlusValue(prop *AMF0Property) {
+	this.AppendByte(AMF0_avmplus_object)
+	this.encodeAMF3Value(prop)
+}
@@ -0,0 +1,124 @@
+package amf
+
+import (
+	"testing"
+)
+
+func decodeTestAVMPlus(data []byte) (prop *AMF0Property, err error) {
+	obj, err := AMF0DecodeObj(append([]byte{AMF0_avmplus_object}, data...))
+	if err != nil {
+		return
+	}
+	return obj.Props.Front().Value.(*AMF0Property), nil
+}
+
+//经AVM+编码再解码，amf3没有的类型换成对应的amf0类型
+func TestAMF3RoundTrip(t *testing.T) {
+	tests := []struct {
+		prop *AMF0Property
+		want string
+	}{
+		{newTestNumber("", 0), "0:=0"},
+		{newTestNumber("", 127), "0:=127"},
+		{newTestNumber("", 0x3fff), "0:=16383"},
+		{newTestNumber("", amf3_int_max), "0:=2.68435455e+08"},
+		{newTestNumber("", amf3_int_min), "0:=-2.68435456e+08"},
+		{newTestNumber("", -1), "0:=-1"},
+		{newTestNumber("", amf3_int_max+1), "0:=2.68435456e+08"},
+		{newTestNumber("", 0.5), "0:=0.5"},
+		{&AMF0Property{PropType: AMF0_boolean}, "1:=false"},
+		{&AMF0Property{PropType: AMF0_boolean, Value: AMF0Data{BoolValue: true}}, "1:=true"},
+		{newTestString("", "play"), "2:=play"},
+		{newTestString("", ""), "2:="},
+		{&AMF0Property{PropType: AMF0_null}, "5:="},
+		{&AMF0Property{PropType: AMF0_undefined}, "6:="},
+		//amf3的date没有时区
+		{newTestDate(1500000000000, -480), "11:=1.5e+12/0"},
+		{newTestObject(AMF0_object, "", newTestString("app", "live"), newTestNumber("objectEncoding", 3)),
+			"3:={2:app=live,0:objectEncoding=3}"},
+		{newTestObject(AMF0_typed_object, "flex.Msg", newTestString("body", "x"),
+			newTestNamedObject("headers", AMF0_object, "", newTestNumber("n", 2))),
+			"16:=flex.Msg{2:body=x,3:headers={0:n=2}}"},
+		{newTestObject(AMF0_ecma_array, "", newTestNumber("width", 1280)), "8:={0:width=1280}"},
+		{newTestObject(AMF0_strict_array, "", newTestNumber("", 1), newTestString("", "b")), "10:={0:=1,2:=b}"},
+	}
+	for i, test := range tests {
+		enc := &AMF0Encoder{}
+		enc.Init()
+		enc.encodeAMF3Value(test.prop)
+		data, _ := enc.GetData()
+		got, err := decodeTestAVMPlus(data)
+		if err != nil {
+			t.Errorf("case %d: %s", i, err.Error())
+			continue
+		}
+		if propString(got) != test.want {
+			t.Errorf("case %d:\n got %s\nwant %s", i, propString(got), test.want)
+		}
+	}
+}
+
+//字符串、对象、traits三张引用表
+func TestAMF3Reference(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want string
+	}{
+		//array[obj{a:1}, obj{a:2}用traits引用, obj引用1, 字符串引用"a"]
+		{[]byte{AMF3_array, 0x09, 0x01,
+			AMF3_object, 0x13, 0x01, 0x03, 'a', AMF3_integer, 1,
+			AMF3_object, 0x01, AMF3_integer, 2,
+			AMF3_object, 0x02,
+			AMF3_string, 0x00},
+			"10:={3:={0:a=1},3:={0:a=2},3:={0:a=1},2:=a}"},
+		//typed object，类名和dynamic成员名走字符串引用
+		{[]byte{AMF3_array, 0x05, 0x01,
+			AMF3_object, 0x0b, 0x03, 'C', 0x03, 'k', AMF3_string, 0x00, 0x01,
+			AMF3_object, 0x01, 0x02, AMF3_true, 0x01},
+			"10:={16:=C{2:k=C},16:=C{1:k=true}}"},
+		//date进对象引用表
+		{[]byte{AMF3_array, 0x05, 0x01,
+			AMF3_date, 0x01, 0x3f, 0xf0, 0, 0, 0, 0, 0, 0,
+			AMF3_date, 0x02},
+			"10:={11:=1/0,11:=1/0}"},
+		//有关联部分是ecma array，dense部分名字是下标
+		{[]byte{AMF3_array, 0x03, 0x03, 'k', AMF3_integer, 5, 0x01, AMF3_string, 0x03, 'v'},
+			"8:={2:0=v,0:k=5}"},
+		//flex的ArrayCollection
+		{[]byte{AMF3_object, 0x07, 0x43, 'f', 'l', 'e', 'x', '.', 'm', 'e', 's', 's', 'a', 'g', 'i', 'n', 'g', '.', 'i', 'o', '.',
+			'A', 'r', 'r', 'a', 'y', 'C', 'o', 'l', 'l', 'e', 'c', 't', 'i', 'o', 'n',
+			AMF3_array, 0x03, 0x01, AMF3_integer, 7},
+			"16:=flex.messaging.io.ArrayCollection{10:source={0:=7}}"},
+	}
+	for i, test := range tests {
+		got, err := decodeTestAVMPlus(test.data)
+		if err != nil {
+			t.Errorf("case %d: %s", i, err.Error())
+			continue
+		}
+		if propString(got) != test.want {
+			t.Errorf("case %d:\n got %s\nwant %s", i, propString(got), test.want)
+		}
+	}
+}
+
+//每次AVM+切换都是新的引用表，引用不能跨切换，截断的数据返回错误
+func TestAMF3Malformed(t *testing.T) {
+	tests := [][]byte{
+		{AMF0_avmplus_object, AMF3_string, 0x03, 'a', AMF0_avmplus_object, AMF3_string, 0x00},
+		{AMF0_avmplus_object, AMF3_object, 0x0b, 0x01, 0x01, AMF0_avmplus_object, AMF3_object, 0x02},
+		{AMF0_avmplus_object, AMF3_object, 0x01},
+		{AMF0_avmplus_object, AMF3_object, 0x07, 0x03, 'X'},
+		{AMF0_avmplus_object, AMF3_integer, 0x80, 0x80},
+		{AMF0_avmplus_object, AMF3_double, 0, 0},
+		{AMF0_avmplus_object, AMF3_string, 0x09, 'a'},
+		{AMF0_avmplus_object, AMF3_array, 0x05, 0x01, AMF3_integer, 1},
+		{AMF0_avmplus_object, AMF3_vector_double, 0x03, 0x00, 0, 0, 0},
+		{AMF0_avmplus_object, 0x20},
+	}
+	for i, test := range tests {
+		if _, err := AMF0DecodeObj(test); nil == err {
+			t.Errorf("case %d: % x decoded", i, test)
+		}
+	}
+}
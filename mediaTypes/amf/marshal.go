@@ -0,0 +1,462 @@
+package amf
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+//go结构体和AMF0Property互转，字段用 amf:"name,omitempty,required" 标记，"-"忽略
+//struct对应object，map对应ecma array，slice对应strict array，time.Time对应date
+//没有tag时用字段名，解码时名字不区分大小写
+
+type fieldInfo struct {
+	index     int
+	name      string
+	omitEmpty bool
+	required  bool
+}
+
+var propertyType = reflect.TypeOf(AMF0Property{})
+var timeType = reflect.TypeOf(time.Time{})
+
+func typeName(propType int32) string {
+	switch propType {
+	case AMF0_number:
+		return "number"
+	case AMF0_boolean:
+		return "boolean"
+	case AMF0_string, AMF0_long_string:
+		return "string"
+	case AMF0_object:
+		return "object"
+	case AMF0_typed_object:
+		return "typed object"
+	case AMF0_null:
+		return "null"
+	case AMF0_undefined:
+		return "undefined"
+	case AMF0_ecma_array:
+		return "ecma array"
+	case AMF0_strict_array:
+		return "strict array"
+	case AMF0_date:
+		return "date"
+	case AMF0_xml_document:
+		return "xml"
+	}
+	return fmt.Sprintf("type %d", propType)
+}
+
+func structFields(t reflect.Type) (fields []fieldInfo) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) > 0 {
+			//未导出
+			continue
+		}
+		info := fieldInfo{index: i, name: f.Name}
+		tag := f.Tag.Get("amf")
+		if "-" == tag {
+			continue
+		}
+		if len(tag) > 0 {
+			opts := strings.Split(tag, ",")
+			if len(opts[0]) > 0 {
+				info.name = opts[0]
+			}
+			for _, opt := range opts[1:] {
+				switch opt {
+				case "omitempty":
+					info.omitEmpty = true
+				case "required":
+					info.required = true
+				}
+			}
+		}
+		fields = append(fields, info)
+	}
+	return
+}
+
+func Marshal(v interface{}) (prop *AMF0Property, err error) {
+	return marshalValue(reflect.ValueOf(v))
+}
+
+//结构体或map转成object，给EncodeObject用
+func MarshalObject(v interface{}) (obj *AMF0Object, err error) {
+	prop, err := Marshal(v)
+	if err != nil {
+		return
+	}
+	if prop.PropType != AMF0_object && prop.PropType != AMF0_ecma_array {
+		return nil, errors.New(fmt.Sprintf("amf: %T is not an object", v))
+	}
+	return &prop.Value.ObjValue, nil
+}
+
+func marshalValue(rv reflect.Value) (prop *AMF0Property, err error) {
+	prop = &AMF0Property{}
+	if false == rv.IsValid() {
+		prop.PropType = AMF0_null
+		return
+	}
+	if rv.Type() == propertyType {
+		*prop = rv.Interface().(AMF0Property)
+		return
+	}
+	if rv.Type() == timeType {
+		prop.PropType = AMF0_date
+		prop.Value.NumValue = float64(rv.Interface().(time.Time).UnixNano() / int64(time.Millisecond))
+		return
+	}
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			prop.PropType = AMF0_null
+			return
+		}
+		return marshalValue(rv.Elem())
+	case reflect.Bool:
+		prop.PropType = AMF0_boolean
+		prop.Value.BoolValue = rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		prop.PropType = AMF0_number
+		prop.Value.NumValue = float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		prop.PropType = AMF0_number
+		prop.Value.NumValue = float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		prop.PropType = AMF0_number
+		prop.Value.NumValue = rv.Float()
+	case reflect.String:
+		prop.PropType = AMF0_string
+		prop.Value.StrValue = rv.String()
+		if len(prop.Value.StrValue) >= 0xffff {
+			prop.PropType = AMF0_long_string
+		}
+	case reflect.Struct:
+		prop.PropType = AMF0_object
+		for _, f := range structFields(rv.Type()) {
+			fv := rv.Field(f.index)
+			if f.omitEmpty && isEmptyValue(fv) {
+				continue
+			}
+			child, err := marshalValue(fv)
+			if err != nil {
+				return nil, err
+			}
+			child.Name = f.name
+			prop.Value.ObjValue.Props.PushBack(child)
+		}
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, errors.New("amf: map key must be string")
+		}
+		prop.PropType = AMF0_ecma_array
+		for _, key := range rv.MapKeys() {
+			child, err := marshalValue(rv.MapIndex(key))
+			if err != nil {
+				return nil, err
+			}
+			child.Name = key.String()
+			prop.Value.ObjValue.Props.PushBack(child)
+		}
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			prop.PropType = AMF0_long_string
+			prop.Value.StrValue = string(rv.Bytes())
+			return
+		}
+		prop.PropType = AMF0_strict_array
+		for i := 0; i < rv.Len(); i++ {
+			child, err := marshalValue(rv.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			prop.Value.ObjValue.Props.PushBack(child)
+		}
+	default:
+		return nil, errors.New(fmt.Sprintf("amf: unsupported type %s", rv.Type()))
+	}
+	return
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+//v必须是指针
+func Unmarshal(prop *AMF0Property, v interface{}) (err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("amf: unmarshal needs a non-nil pointer")
+	}
+	if nil == prop {
+		return errors.New("amf: unmarshal nil property")
+	}
+	return unmarshalValue(prop, rv.Elem(), false)
+}
+
+//和Unmarshal一样，但非required的字段类型不对时跳过，保留零值
+//客户端的可选字段类型不统一，比如connect的fpad给数字
+func UnmarshalLoose(prop *AMF0Property, v interface{}) (err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("amf: unmarshal needs a non-nil pointer")
+	}
+	if nil == prop {
+		return errors.New("amf: unmarshal nil property")
+	}
+	return unmarshalValue(prop, rv.Elem(), true)
+}
+
+//object的成员按名字填到结构体或map
+func UnmarshalObject(obj *AMF0Object, v interface{}) (err error) {
+	if nil == obj {
+		return errors.New("amf: unmarshal nil object")
+	}
+	prop := &AMF0Property{PropType: AMF0_object}
+	prop.Value.ObjValue = *obj
+	return Unmarshal(prop, v)
+}
+
+//命令参数这种按顺序排列的值，依次填到结构体的字段里
+func UnmarshalArgs(values []*AMF0Property, v interface{}) (err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("amf: unmarshal args needs a pointer to struct")
+	}
+	rv = rv.Elem()
+	for i, f := range structFields(rv.Type()) {
+		if i >= len(values) {
+			if f.required {
+				return errors.New(fmt.Sprintf("amf: required arg %s missing", f.name))
+			}
+			continue
+		}
+		if isNull(values[i]) {
+			if f.required {
+				return errors.New(fmt.Sprintf("amf: required arg %s is null", f.name))
+			}
+			//保留默认值
+			continue
+		}
+		err = unmarshalValue(values[i], rv.Field(f.index), false)
+		if err != nil {
+			return errors.New(fmt.Sprintf("amf: arg %s:%s", f.name, err.Error()))
+		}
+	}
+	return
+}
+
+func isNull(prop *AMF0Property) bool {
+	return prop.PropType == AMF0_null || prop.PropType == AMF0_undefined
+}
+
+func typeError(prop *AMF0Property, rv reflect.Value) error {
+	return errors.New(fmt.Sprintf("amf: cannot unmarshal %s into %s", typeName(prop.PropType), rv.Type()))
+}
+
+func unmarshalValue(prop *AMF0Property, rv reflect.Value, loose bool) (err error) {
+	if rv.Type() == propertyType {
+		rv.Set(reflect.ValueOf(*prop))
+		return
+	}
+	if rv.Type() == timeType {
+		if isNull(prop) {
+			return
+		}
+		if prop.PropType != AMF0_date {
+			return typeError(prop, rv)
+		}
+		ms := int64(prop.Value.NumValue)
+		rv.Set(reflect.ValueOf(time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))))
+		return
+	}
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if isNull(prop) {
+			rv.Set(reflect.Zero(rv.Type()))
+			return
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		return unmarshalValue(prop, rv.Elem(), loose)
+	case reflect.Interface:
+		if rv.NumMethod() != 0 {
+			return typeError(prop, rv)
+		}
+		value := naturalValue(prop)
+		if value != nil {
+			rv.Set(reflect.ValueOf(value))
+		}
+		return
+	}
+	if isNull(prop) {
+		//null给零值
+		rv.Set(reflect.Zero(rv.Type()))
+		return
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		if prop.PropType != AMF0_boolean {
+			return typeError(prop, rv)
+		}
+		rv.SetBool(prop.Value.BoolValue)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if prop.PropType != AMF0_number {
+			return typeError(prop, rv)
+		}
+		num := int64(prop.Value.NumValue)
+		if rv.OverflowInt(num) {
+			return errors.New(fmt.Sprintf("amf: number %v overflows %s", prop.Value.NumValue, rv.Type()))
+		}
+		rv.SetInt(num)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if prop.PropType != AMF0_number {
+			return typeError(prop, rv)
+		}
+		if prop.Value.NumValue < 0 || rv.OverflowUint(uint64(prop.Value.NumValue)) {
+			return errors.New(fmt.Sprintf("amf: number %v overflows %s", prop.Value.NumValue, rv.Type()))
+		}
+		rv.SetUint(uint64(prop.Value.NumValue))
+	case reflect.Float32, reflect.Float64:
+		if prop.PropType != AMF0_number {
+			return typeError(prop, rv)
+		}
+		rv.SetFloat(prop.Value.NumValue)
+	case reflect.String:
+		switch prop.PropType {
+		case AMF0_string, AMF0_long_string, AMF0_xml_document:
+			rv.SetString(prop.Value.StrValue)
+		default:
+			return typeError(prop, rv)
+		}
+	case reflect.Struct:
+		if prop.PropType != AMF0_object && prop.PropType != AMF0_typed_object && prop.PropType != AMF0_ecma_array {
+			return typeError(prop, rv)
+		}
+		for _, f := range structFields(rv.Type()) {
+			child := findProp(&prop.Value.ObjValue, f.name)
+			if nil == child || isNull(child) {
+				if f.required {
+					return errors.New(fmt.Sprintf("amf: required field %s missing", f.name))
+				}
+				continue
+			}
+			err = unmarshalValue(child, rv.Field(f.index), loose)
+			if err != nil && loose && false == f.required {
+				rv.Field(f.index).Set(reflect.Zero(rv.Field(f.index).Type()))
+				err = nil
+				continue
+			}
+			if err != nil {
+				return errors.New(fmt.Sprintf("amf: field %s:%s", f.name, err.Error()))
+			}
+		}
+	case reflect.Map:
+		if prop.PropType != AMF0_object && prop.PropType != AMF0_typed_object && prop.PropType != AMF0_ecma_array {
+			return typeError(prop, rv)
+		}
+		if rv.Type().Key().Kind() != reflect.String {
+			return errors.New("amf: map key must be string")
+		}
+		if rv.IsNil() {
+			rv.Set(reflect.MakeMap(rv.Type()))
+		}
+		for e := prop.Value.ObjValue.Props.Front(); e != nil; e = e.Next() {
+			child := e.Value.(*AMF0Property)
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			err = unmarshalValue(child, elem, loose)
+			if err != nil {
+				return
+			}
+			rv.SetMapIndex(reflect.ValueOf(child.Name).Convert(rv.Type().Key()), elem)
+		}
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			if prop.PropType != AMF0_string && prop.PropType != AMF0_long_string {
+				return typeError(prop, rv)
+			}
+			rv.SetBytes([]byte(prop.Value.StrValue))
+			return
+		}
+		if prop.PropType != AMF0_strict_array {
+			return typeError(prop, rv)
+		}
+		slice := reflect.MakeSlice(rv.Type(), 0, prop.Value.ObjValue.Props.Len())
+		for e := prop.Value.ObjValue.Props.Front(); e != nil; e = e.Next() {
+			elem := reflect.New(rv.Type().Elem()).Elem()
+			err = unmarshalValue(e.Value.(*AMF0Property), elem, loose)
+			if err != nil {
+				return
+			}
+			slice = reflect.Append(slice, elem)
+		}
+		rv.Set(slice)
+	default:
+		return errors.New(fmt.Sprintf("amf: unsupported type %s", rv.Type()))
+	}
+	return
+}
+
+func findProp(obj *AMF0Object, name string) (prop *AMF0Property) {
+	prop = obj.AMF0GetPropByName(name)
+	if prop != nil {
+		return
+	}
+	for e := obj.Props.Front(); e != nil; e = e.Next() {
+		v := e.Value.(*AMF0Property)
+		if strings.EqualFold(v.Name, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+//解到interface{}时用的类型：float64 bool string time.Time map[string]interface{} []interface{}
+func naturalValue(prop *AMF0Property) interface{} {
+	switch prop.PropType {
+	case AMF0_number:
+		return prop.Value.NumValue
+	case AMF0_boolean:
+		return prop.Value.BoolValue
+	case AMF0_string, AMF0_long_string, AMF0_xml_document:
+		return prop.Value.StrValue
+	case AMF0_date:
+		ms := int64(prop.Value.NumValue)
+		return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+	case AMF0_object, AMF0_typed_object, AMF0_ecma_array:
+		m := make(map[string]interface{})
+		for e := prop.Value.ObjValue.Props.Front(); e != nil; e = e.Next() {
+			child := e.Value.(*AMF0Property)
+			m[child.Name] = naturalValue(child)
+		}
+		return m
+	case AMF0_strict_array:
+		arr := make([]interface{}, 0, prop.Value.ObjValue.Props.Len())
+		for e := prop.Value.ObjValue.Props.Front(); e != nil; e = e.Next() {
+			arr = append(arr, naturalValue(e.Value.(*AMF0Property)))
+		}
+		return arr
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package amf
+
+import (
+	"testing"
+	"time"
+)
+
+type testConnect struct {
+	App      string  `amf:"app,required"`
+	Fpad     bool    `amf:"fpad"`
+	Encoding float64 `amf:"objectEncoding"`
+	Codecs   uint8   `amf:"codecs"`
+	Ignored  string  `amf:"-"`
+}
+
+func newTestBool(name string, boolean bool) *AMF0Property {
+	prop := &AMF0Property{PropType: AMF0_boolean, Name: name}
+	prop.Value.BoolValue = boolean
+	return prop
+}
+
+//Unmarshal类型不对就失败，UnmarshalLoose只跳过非required的字段
+func TestUnmarshalMalformed(t *testing.T) {
+	tests := []struct {
+		prop     *AMF0Property
+		strict   bool //Unmarshal成功
+		loose    bool //UnmarshalLoose成功
+		expected testConnect
+	}{
+		{newTestObject(AMF0_object, "", newTestString("app", "live"), newTestBool("fpad", true), newTestNumber("objectEncoding", 3)),
+			true, true, testConnect{App: "live", Fpad: true, Encoding: 3}},
+		//名字不区分大小写，ecma array也行
+		{newTestObject(AMF0_ecma_array, "", newTestString("APP", "live"), newTestNumber("codecs", 255)),
+			true, true, testConnect{App: "live", Codecs: 255}},
+		//可选字段类型不对
+		{newTestObject(AMF0_object, "", newTestString("app", "live"), newTestNumber("fpad", 0)),
+			false, true, testConnect{App: "live"}},
+		{newTestObject(AMF0_object, "", newTestString("app", "live"), newTestString("objectEncoding", "3"), newTestBool("fpad", true)),
+			false, true, testConnect{App: "live", Fpad: true}},
+		{newTestObject(AMF0_object, "", newTestString("app", "live"), newTestNumber("codecs", 256)),
+			false, true, testConnect{App: "live"}},
+		{newTestObject(AMF0_object, "", newTestString("app", "live"), newTestNumber("codecs", -1)),
+			false, true, testConnect{App: "live"}},
+		//null的可选字段是零值
+		{newTestObject(AMF0_object, "", newTestString("app", "live"), &AMF0Property{PropType: AMF0_null, Name: "fpad"}),
+			true, true, testConnect{App: "live"}},
+		//required的字段缺了、null、类型不对都不行
+		{newTestObject(AMF0_object, "", newTestBool("fpad", true)), false, false, testConnect{}},
+		{newTestObject(AMF0_object, "", &AMF0Property{PropType: AMF0_undefined, Name: "app"}), false, false, testConnect{}},
+		{newTestObject(AMF0_object, "", newTestNumber("app", 1)), false, false, testConnect{}},
+		//不是对象
+		{newTestString("", "live"), false, false, testConnect{}},
+		{newTestObject(AMF0_strict_array, "", newTestString("", "live")), false, false, testConnect{}},
+	}
+	for i, test := range tests {
+		strict := testConnect{Ignored: "keep"}
+		err := Unmarshal(test.prop, &strict)
+		if (nil == err) != test.strict {
+			t.Errorf("case %d: strict err %v", i, err)
+		}
+		loose := testConnect{Ignored: "keep"}
+		err = UnmarshalLoose(test.prop, &loose)
+		if (nil == err) != test.loose {
+			t.Errorf("case %d: loose err %v", i, err)
+		}
+		test.expected.Ignored = "keep"
+		if test.loose && loose != test.expected {
+			t.Errorf("case %d: loose %+v want %+v", i, loose, test.expected)
+		}
+	}
+}
+
+//参数不能是nil指针，nil的prop也不行
+func TestUnmarshalInvalidTarget(t *testing.T) {
+	prop := newTestNumber("", 1)
+	var num float64
+	var nilPtr *float64
+	if nil == Unmarshal(prop, num) || nil == UnmarshalLoose(prop, num) {
+		t.Error("non-pointer accepted")
+	}
+	if nil == Unmarshal(prop, nilPtr) || nil == UnmarshalLoose(prop, nilPtr) {
+		t.Error("nil pointer accepted")
+	}
+	if nil == Unmarshal(nil, &num) || nil == UnmarshalLoose(nil, &num) {
+		t.Error("nil property accepted")
+	}
+	if nil == UnmarshalObject(nil, &num) {
+		t.Error("nil object accepted")
+	}
+}
+
+type testPlayArgs struct {
+	Name  string  `amf:"streamName,required"`
+	Start float64 `amf:"start"`
+}
+
+//按顺序的参数，缺了或null的可选参数保留默认值
+func TestUnmarshalArgs(t *testing.T) {
+	null := &AMF0Property{PropType: AMF0_null}
+	tests := []struct {
+		values   []*AMF0Property
+		ok       bool
+		expected testPlayArgs
+	}{
+		{[]*AMF0Property{newTestString("", "live"), newTestNumber("", 0)}, true, testPlayArgs{"live", 0}},
+		{[]*AMF0Property{newTestString("", "live")}, true, testPlayArgs{"live", -2}},
+		{[]*AMF0Property{newTestString("", "live"), null}, true, testPlayArgs{"live", -2}},
+		{[]*AMF0Property{newTestString("", "live"), newTestNumber("", 1), newTestNumber("", 2)}, true, testPlayArgs{"live", 1}},
+		{nil, false, testPlayArgs{}},
+		{[]*AMF0Property{null}, false, testPlayArgs{}},
+		{[]*AMF0Property{newTestNumber("", 1)}, false, testPlayArgs{}},
+		{[]*AMF0Property{newTestString("", "live"), newTestString("", "0")}, false, testPlayArgs{}},
+	}
+	for i, test := range tests {
+		args := testPlayArgs{Start: -2}
+		err := UnmarshalArgs(test.values, &args)
+		if (nil == err) != test.ok {
+			t.Errorf("case %d: err %v", i, err)
+			continue
+		}
+		if test.ok && args != test.expected {
+			t.Errorf("case %d: %+v want %+v", i, args, test.expected)
+		}
+	}
+	var notStruct float64
+	if nil == UnmarshalArgs(nil, &notStruct) {
+		t.Error("args into non-struct accepted")
+	}
+}
+
+type testInfo struct {
+	Level   string            `amf:"level"`
+	Details string            `amf:"details,omitempty"`
+	Time    time.Time         `amf:"time"`
+	Tags    []string          `amf:"tags"`
+	Extra   map[string]string `amf:"extra"`
+	Data    []byte            `amf:"data"`
+	Any     interface{}       `amf:"any"`
+}
+
+//Marshal出来的经过编码解码再Unmarshal回去不变
+func TestMarshalRoundTrip(t *testing.T) {
+	src := testInfo{
+		Level: "status",
+		Time:  time.Unix(1500000000, 123*int64(time.Millisecond)),
+		Tags:  []string{"a", "b"},
+		Extra: map[string]string{"k": "v"},
+		Data:  []byte{0, 1, 2},
+		Any:   []interface{}{1.5, "s", true},
+	}
+	prop, err := Marshal(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nil != findProp(&prop.Value.ObjValue, "details") {
+		t.Error("omitempty field marshaled")
+	}
+	obj, err := AMF0DecodeObj(encodeTestProps(prop))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := testInfo{}
+	err = Unmarshal(obj.Props.Front().Value.(*AMF0Property), &dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.Level != src.Level || false == dst.Time.Equal(src.Time) || len(dst.Tags) != 2 || dst.Tags[1] != "b" ||
+		dst.Extra["k"] != "v" || string(dst.Data) != string(src.Data) {
+		t.Errorf("%+v want %+v", dst, src)
+	}
+	if any, ok := dst.Any.([]interface{}); false == ok || len(any) != 3 || any[0] != 1.5 || any[1] != "s" || any[2] != true {
+		t.Errorf("any %#v", dst.Any)
+	}
+	if _, err = MarshalObject(1); nil == err {
+		t.Error("number marshaled as object")
+	}
+	if _, err = Marshal(map[int]string{1: "a"}); nil == err {
+		t.Error("int map key accepted")
+	}
+}
@@ -0,0 +1,163 @@
+package av1
+
+import (
+	"errors"
+	"wssAPI"
+)
+
+const (
+	OBU_sequence_header        = 1
+	OBU_temporal_delimiter     = 2
+	OBU_frame_header           = 3
+	OBU_tile_group             = 4
+	OBU_metadata               = 5
+	OBU_frame                  = 6
+	OBU_redundant_frame_header = 7
+	OBU_tile_list              = 8
+	OBU_padding                = 15
+)
+
+//av1C
+type AV1CodecConfigurationRecord struct {
+	SeqProfile           byte
+	SeqLevelIdx0         byte
+	SeqTier0             byte
+	HighBitdepth         byte
+	TwelveBit            byte
+	Monochrome           byte
+	ChromaSubsamplingX   byte
+	ChromaSubsamplingY   byte
+	ChromaSamplePosition byte
+	ConfigOBUs           []byte
+}
+
+func ParseAV1C(data []byte) (av1c *AV1CodecConfigurationRecord, err error) {
+	if len(data) < 4 {
+		return nil, errors.New("av1C too short")
+	}
+	if (data[0]&0x80) == 0 || (data[0]&0x7f) != 1 {
+		return nil, errors.New("invalid av1C marker or version")
+	}
+	av1c = &AV1CodecConfigurationRecord{}
+	av1c.SeqProfile = data[1] >> 5
+	av1c.SeqLevelIdx0 = data[1] & 0x1f
+	av1c.SeqTier0 = data[2] >> 7
+	av1c.HighBitdepth = (data[2] >> 6) & 1
+	av1c.TwelveBit = (data[2] >> 5) & 1
+	av1c.Monochrome = (data[2] >> 4) & 1
+	av1c.ChromaSubsamplingX = (data[2] >> 3) & 1
+	av1c.ChromaSubsamplingY = (data[2] >> 2) & 1
+	av1c.ChromaSamplePosition = data[2] & 0x3
+	av1c.ConfigOBUs = data[4:]
+	return
+}
+
+//leb128,返回值和占用的字节数
+func readLeb128(data []byte) (value uint64, size int) {
+	for i := 0; i < 8 && i < len(data); i++ {
+		value |= uint64(data[i]&0x7f) << (uint(i) * 7)
+		if (data[i] & 0x80) == 0 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
+
+//低开销格式的obu，找到sequence header的payload
+func FindSequenceHeader(obus []byte) (payload []byte, err error) {
+	cur := 0
+	for cur < len(obus) {
+		header := obus[cur]
+		obuType := int(header>>3) & 0xf
+		cur++
+		if (header & 0x4) != 0 {
+			//extension
+			cur++
+		}
+		obuSize := len(obus) - cur
+		if (header & 0x2) != 0 {
+			size, n := readLeb128(obus[cur:])
+			if n == 0 {
+				return nil, errors.New("invalid obu size")
+			}
+			cur += n
+			obuSize = int(size)
+		}
+		if cur+obuSize > len(obus) || obuSize < 0 {
+			return nil, errors.New("obu truncated")
+		}
+		if OBU_sequence_header == obuType {
+			return obus[cur : cur+obuSize], nil
+		}
+		cur += obuSize
+	}
+	return nil, errors.New("sequence header obu not found")
+}
+
+//只解析到max_frame_width/height
+func ParseSequenceHeader(seq []byte) (width, height int, err error) {
+	if len(seq) < 3 {
+		return 0, 0, errors.New("av1 sequence header too short")
+	}
+	bit := &wssAPI.BitReader{}
+	bit.Init(seq)
+	bit.ReadBits(3) //seq_profile
+	bit.ReadBit()   //still_picture
+	reducedStillPictureHeader := bit.ReadBit()
+	if 1 == reducedStillPictureHeader {
+		bit.ReadBits(5) //seq_level_idx[0]
+	} else {
+		decoderModelInfoPresent := 0
+		bufferDelayLength := 0
+		if 1 == bit.ReadBit() {
+			//timing_info
+			bit.ReadBits(32) //num_units_in_display_tick
+			bit.ReadBits(32) //time_scale
+			if 1 == bit.ReadBit() {
+				//num_ticks_per_picture_minus_1,uvlc
+				leadingZeros := 0
+				for bit.BitsLeft() > 0 && 0 == bit.ReadBit() {
+					leadingZeros++
+				}
+				if leadingZeros < 32 {
+					bit.ReadBits(leadingZeros)
+				}
+			}
+			decoderModelInfoPresent = bit.ReadBit()
+			if 1 == decoderModelInfoPresent {
+				bufferDelayLength = bit.ReadBits(5) + 1
+				bit.ReadBits(32) //num_units_in_decoding_tick
+				bit.ReadBits(5)  //buffer_removal_time_length_minus_1
+				bit.ReadBits(5)  //frame_presentation_time_length_minus_1
+			}
+		}
+		initialDisplayDelayPresent := bit.ReadBit()
+		operatingPointsCnt := bit.ReadBits(5) + 1
+		for i := 0; i < operatingPointsCnt; i++ {
+			bit.ReadBits(12) //operating_point_idc
+			seqLevelIdx := bit.ReadBits(5)
+			if seqLevelIdx > 7 {
+				bit.ReadBit() //seq_tier
+			}
+			if 1 == decoderModelInfoPresent && 1 == bit.ReadBit() {
+				bit.ReadBits(bufferDelayLength) //decoder_buffer_delay
+				bit.ReadBits(bufferDelayLength) //encoder_buffer_delay
+				bit.ReadBit()                   //low_delay_mode_flag
+			}
+			if 1 == initialDisplayDelayPresent && 1 == bit.ReadBit() {
+				bit.ReadBits(4)
+			}
+		}
+	}
+	if bit.BitsLeft() < 8 {
+		return 0, 0, errors.New("av1 sequence header truncated")
+	}
+	widthBits := bit.ReadBits(4) + 1
+	heightBits := bit.ReadBits(4) + 1
+	if bit.BitsLeft() < widthBits+heightBits {
+		return 0, 0, errors.New("av1 sequence header truncated")
+	}
+	width = bit.ReadBits(widthBits) + 1
+	height = bit.ReadBits(heightBits) + 1
+	return
+}
@@ -0,0 +1,246 @@
+package flv
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"mediaTypes/h265"
+	"sync"
+	"time"
+)
+
+//播放端发送队列的拥塞控制，RTMP WebSocket RTSP HTTP-FLV共用
+//排队时延:队列里最早的帧放进来以后等了多久，加入时一次塞进来的GOP不算积压
+//超过maxDelay的一半开始丢可丢弃帧和非参考帧，不影响后面的解码
+//超过maxDelay清掉队列里的视频，丢到下一个关键帧
+//音频一直保留，超过3倍maxDelay还追不上才断开
+//头和metadata不丢，也不参与计算
+const (
+	Congestion_normal = iota
+	Congestion_drop_nonref
+	Congestion_wait_keyframe
+)
+
+type CongestionController struct {
+	mutex     sync.Mutex
+	maxDelay  time.Duration
+	state     int
+	purged    bool       //清过一次视频，时延降下来之前不再清
+	frames    *list.List //还没发出去的帧，和播放端的队列一样的顺序
+	dropped   int
+	lastError error
+}
+
+type congestionFrame struct {
+	tag     *FlvTag
+	arrival time.Time
+}
+
+func NewCongestionController(maxDelayMs int) (this *CongestionController) {
+	this = &CongestionController{}
+	this.maxDelay = time.Duration(maxDelayMs) * time.Millisecond
+	this.frames = list.New()
+	return
+}
+
+//tag放进队列之前调用，放进队列的必须是这个tag
+//keep为false时不要放进队列;purge为true时先用Purge清掉队列里的视频
+//err不为nil时怎么丢都追不上了，断开播放端
+func (this *CongestionController) Admit(tag *FlvTag) (keep, purge bool, err error) {
+	if false == IsMediaFrame(tag) {
+		return true, false, nil
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	delay := this.delay()
+	if delay > this.maxDelay*3 {
+		this.lastError = errors.New(fmt.Sprintf("queue delay %d ms,dropped %d frames",
+			delay/time.Millisecond, this.dropped))
+		return false, false, this.lastError
+	}
+	if delay < this.maxDelay/2 {
+		this.purged = false
+	}
+	switch {
+	case delay > this.maxDelay && false == this.purged:
+		this.state = Congestion_wait_keyframe
+		this.purged = true
+		purge = true
+	case this.state == Congestion_wait_keyframe:
+	case delay > this.maxDelay/2:
+		this.state = Congestion_drop_nonref
+	case delay < this.maxDelay/4:
+		this.state = Congestion_normal
+	}
+	keep = true
+	if tag.TagType == FLV_TAG_Video {
+		switch this.state {
+		case Congestion_wait_keyframe:
+			if IsVideoKeyFrame(tag) {
+				this.state = Congestion_drop_nonref
+			} else {
+				keep = false
+			}
+		case Congestion_drop_nonref:
+			keep = false == IsDisposableFrame(tag)
+		}
+	}
+	if false == keep {
+		this.dropped++
+		return
+	}
+	this.frames.PushBack(&congestionFrame{tag: tag, arrival: time.Now()})
+	return
+}
+
+//时延以外的原因(比如个数、字节数)要清视频，返回true时调用者用Purge清队列
+func (this *CongestionController) SkipToKeyFrame() bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	if this.state == Congestion_wait_keyframe {
+		return false
+	}
+	this.state = Congestion_wait_keyframe
+	return true
+}
+
+//清掉队列里的视频帧，视频头、音频和metadata保留，返回清掉的字节数
+func (this *CongestionController) Purge(cache *list.List) (bytes int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for e := cache.Front(); e != nil; {
+		next := e.Next()
+		tag, ok := e.Value.(*FlvTag)
+		if ok && tag.TagType == FLV_TAG_Video && IsMediaFrame(tag) {
+			cache.Remove(e)
+			bytes += len(tag.Data)
+			tag.Release()
+			this.dropped++
+		}
+		e = next
+	}
+	for e := this.frames.Front(); e != nil; {
+		next := e.Next()
+		if e.Value.(*congestionFrame).tag.TagType == FLV_TAG_Video {
+			this.frames.Remove(e)
+		}
+		e = next
+	}
+	return
+}
+
+//tag从队列里取出来发送时调用，前面没对上的是被播放端自己丢掉的
+func (this *CongestionController) Sent(tag *FlvTag) {
+	if false == IsMediaFrame(tag) {
+		return
+	}
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	for e := this.frames.Front(); e != nil; e = e.Next() {
+		if e.Value.(*congestionFrame).tag != tag {
+			continue
+		}
+		for this.frames.Front() != e {
+			this.frames.Remove(this.frames.Front())
+		}
+		this.frames.Remove(e)
+		return
+	}
+}
+
+//队列清空重新开始时调用，比如暂停、seek
+func (this *CongestionController) Reset() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	this.state = Congestion_normal
+	this.purged = false
+	this.frames.Init()
+	this.lastError = nil
+}
+
+//Admit返回过的断开原因，发送线程据此断开
+func (this *CongestionController) Error() error {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.lastError
+}
+
+func (this *CongestionController) State() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.state
+}
+
+func (this *CongestionController) Dropped() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+	return this.dropped
+}
+
+func (this *CongestionController) delay() time.Duration {
+	e := this.frames.Front()
+	if nil == e {
+		return 0
+	}
+	return time.Since(e.Value.(*congestionFrame).arrival)
+}
+
+//头、metadata不算帧
+func IsMediaFrame(tag *FlvTag) bool {
+	switch tag.TagType {
+	case FLV_TAG_Audio:
+		return false == IsAudioSequenceHeader(tag)
+	case FLV_TAG_Video:
+		return false == IsVideoSequenceHeader(tag)
+	}
+	return false
+}
+
+//丢掉不影响其他帧解码:H263的disposable inter frame，AVC nal_ref_idc为0、HEVC子层非参考的帧
+//nalu是4字节长度前缀，Enhanced RTMP的tag按ex header的偏移取
+func IsDisposableFrame(tag *FlvTag) bool {
+	header, err := ParseVideoTagHeader(tag)
+	if err != nil {
+		return false
+	}
+	if header.FrameType == FrameType_DisposableInterFrame {
+		return true
+	}
+	if header.FrameType != FrameType_InterFrame || header.PktType != AVC_NALU {
+		return false
+	}
+	codecId := header.CodecId
+	if codecId != CodecID_AVC && codecId != CodecID_HEVC {
+		return false
+	}
+	data := tag.Data[header.PayloadOffset:]
+	hasSlice := false
+	for len(data) > 4 {
+		size := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+		if size <= 0 || size > len(data)-4 {
+			return false
+		}
+		header := data[4]
+		data = data[4+size:]
+		if codecId == CodecID_AVC {
+			nalType := int(header & 0x1f)
+			if nalType < 1 || nalType > 5 {
+				continue
+			}
+			if (header>>5)&3 != 0 {
+				return false
+			}
+		} else {
+			//0-14的偶数是TRAIL_N RADL_N这些子层非参考帧
+			nalType := h265.NalType(header)
+			if nalType > 31 {
+				continue
+			}
+			if nalType > 14 || nalType%2 != 0 {
+				return false
+			}
+		}
+		hasSlice = true
+	}
+	return hasSlice
+}
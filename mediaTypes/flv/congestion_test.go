@@ -0,0 +1,163 @@
+package flv
+
+import (
+	"container/list"
+	"testing"
+	"time"
+)
+
+//AVC的tag，nalu头决定是不是参考帧
+func newTestVideoTag(frameType byte, pktType byte, nalHeader byte) *FlvTag {
+	data := []byte{frameType<<4 | CodecID_AVC, pktType, 0, 0, 0, 0, 0, 0, 2, nalHeader, 0x88}
+	return &FlvTag{TagType: FLV_TAG_Video, Data: data}
+}
+
+var (
+	testKeyFrame    = func() *FlvTag { return newTestVideoTag(FrameType_Keyframe, AVC_NALU, 0x65) }
+	testRefFrame    = func() *FlvTag { return newTestVideoTag(FrameType_InterFrame, AVC_NALU, 0x41) }
+	testNonRefFrame = func() *FlvTag { return newTestVideoTag(FrameType_InterFrame, AVC_NALU, 0x01) }
+	testVideoHeader = func() *FlvTag { return newTestVideoTag(FrameType_Keyframe, AVC_Header, 0) }
+	testAudioFrame  = func() *FlvTag { return &FlvTag{TagType: FLV_TAG_Audio, Data: []byte{0xaf, 1, 0x21}} }
+	testAudioHeader = func() *FlvTag { return &FlvTag{TagType: FLV_TAG_Audio, Data: []byte{0xaf, 0, 0x12, 0x10}} }
+	testDisposable  = func() *FlvTag { return &FlvTag{TagType: FLV_TAG_Video, Data: []byte{FrameType_DisposableInterFrame<<4 | 2, 0}} }
+)
+
+//队列最早的帧等了age
+func ageCongestionQueue(this *CongestionController, age time.Duration) {
+	if e := this.frames.Front(); e != nil {
+		e.Value.(*congestionFrame).arrival = time.Now().Add(-age)
+	}
+}
+
+//maxDelay 1000ms:500ms以上丢非参考帧，1000ms以上清视频等关键帧，3000ms以上断开
+func TestCongestionAdmit(t *testing.T) {
+	const ms = time.Millisecond
+	tests := []struct {
+		age   time.Duration
+		tag   *FlvTag
+		keep  bool
+		purge bool
+		err   bool
+		state int
+	}{
+		{0, testKeyFrame(), true, false, false, Congestion_normal},
+		{0, testAudioFrame(), true, false, false, Congestion_normal},
+		{100 * ms, testNonRefFrame(), true, false, false, Congestion_normal},
+		{600 * ms, testNonRefFrame(), false, false, false, Congestion_drop_nonref},
+		{600 * ms, testRefFrame(), true, false, false, Congestion_drop_nonref},
+		{600 * ms, testDisposable(), false, false, false, Congestion_drop_nonref},
+		{600 * ms, testAudioFrame(), true, false, false, Congestion_drop_nonref},
+		//清一次视频，之后等关键帧
+		{1100 * ms, testRefFrame(), false, true, false, Congestion_wait_keyframe},
+		{1100 * ms, testAudioFrame(), true, false, false, Congestion_wait_keyframe},
+		{1100 * ms, testRefFrame(), false, false, false, Congestion_wait_keyframe},
+		{1100 * ms, testVideoHeader(), true, false, false, Congestion_wait_keyframe},
+		{1100 * ms, testKeyFrame(), true, false, false, Congestion_drop_nonref},
+		//时延没降到一半以下，不再清
+		{1100 * ms, testRefFrame(), true, false, false, Congestion_drop_nonref},
+		{400 * ms, testNonRefFrame(), false, false, false, Congestion_drop_nonref},
+		//降到1/4以下恢复
+		{200 * ms, testNonRefFrame(), true, false, false, Congestion_normal},
+		//降过一半又能清
+		{1100 * ms, testRefFrame(), false, true, false, Congestion_wait_keyframe},
+		//头不算帧，也不会断开
+		{5000 * ms, testAudioHeader(), true, false, false, Congestion_wait_keyframe},
+		{3100 * ms, testAudioFrame(), false, false, true, Congestion_wait_keyframe},
+	}
+	congestion := NewCongestionController(1000)
+	cache := list.New()
+	for i, test := range tests {
+		ageCongestionQueue(congestion, test.age)
+		keep, purge, err := congestion.Admit(test.tag)
+		if keep != test.keep || purge != test.purge || (err != nil) != test.err {
+			t.Fatalf("case %d: keep %v purge %v err %v", i, keep, purge, err)
+		}
+		if congestion.State() != test.state {
+			t.Fatalf("case %d: state %d want %d", i, congestion.State(), test.state)
+		}
+		if purge {
+			congestion.Purge(cache)
+			for e := cache.Front(); e != nil; e = e.Next() {
+				if tag := e.Value.(*FlvTag); tag.TagType == FLV_TAG_Video && IsMediaFrame(tag) {
+					t.Fatalf("case %d: video frame left after purge", i)
+				}
+			}
+		}
+		if keep {
+			cache.PushBack(test.tag)
+		}
+	}
+	if nil == congestion.Error() || 0 == congestion.Dropped() {
+		t.Fatalf("error %v dropped %d", congestion.Error(), congestion.Dropped())
+	}
+	congestion.Reset()
+	if congestion.Error() != nil || congestion.State() != Congestion_normal || congestion.frames.Len() != 0 {
+		t.Fatal("reset did not clear the state")
+	}
+}
+
+//清掉的是队列里的视频帧，视频头、音频、metadata都留下
+func TestCongestionPurge(t *testing.T) {
+	congestion := NewCongestionController(1000)
+	cache := list.New()
+	tags := []*FlvTag{testVideoHeader(), testAudioHeader(), testKeyFrame(), testAudioFrame(),
+		testRefFrame(), {TagType: FLV_TAG_ScriptData, Data: []byte{2}}, testNonRefFrame()}
+	for _, tag := range tags {
+		congestion.Admit(tag)
+		cache.PushBack(tag)
+	}
+	bytes := congestion.Purge(cache)
+	if bytes != 3*len(testKeyFrame().Data) || cache.Len() != 4 {
+		t.Fatalf("purged %d bytes, %d left", bytes, cache.Len())
+	}
+	if congestion.frames.Len() != 1 || congestion.frames.Front().Value.(*congestionFrame).tag != tags[3] {
+		t.Fatalf("%d frames tracked after purge", congestion.frames.Len())
+	}
+	if congestion.Dropped() != 3 {
+		t.Fatalf("dropped %d", congestion.Dropped())
+	}
+}
+
+//发出去的帧从队列里拿掉，前面没对上的是播放端自己丢的
+func TestCongestionSent(t *testing.T) {
+	congestion := NewCongestionController(1000)
+	tags := []*FlvTag{testKeyFrame(), testAudioFrame(), testRefFrame(), testAudioFrame()}
+	for _, tag := range tags {
+		congestion.Admit(tag)
+	}
+	congestion.Sent(testVideoHeader())
+	congestion.Sent(testRefFrame())
+	if congestion.frames.Len() != 4 {
+		t.Fatalf("unknown tag removed %d frames", 4-congestion.frames.Len())
+	}
+	congestion.Sent(tags[2])
+	if congestion.frames.Len() != 1 || congestion.frames.Front().Value.(*congestionFrame).tag != tags[3] {
+		t.Fatalf("%d frames left", congestion.frames.Len())
+	}
+	//发完了时延归零，恢复正常
+	ageCongestionQueue(congestion, 600*time.Millisecond)
+	if keep, _, _ := congestion.Admit(testNonRefFrame()); keep {
+		t.Fatal("non-reference frame kept while congested")
+	}
+	congestion.Sent(tags[3])
+	if keep, _, _ := congestion.Admit(testNonRefFrame()); false == keep || congestion.State() != Congestion_normal {
+		t.Fatalf("keep %v state %d", keep, congestion.State())
+	}
+}
+
+//个数字节数超了也要清视频，已经在等关键帧时不用再清
+func TestCongestionSkipToKeyFrame(t *testing.T) {
+	congestion := NewCongestionController(1000)
+	if false == congestion.SkipToKeyFrame() || congestion.SkipToKeyFrame() {
+		t.Fatal("skip twice")
+	}
+	if keep, _, _ := congestion.Admit(testRefFrame()); keep {
+		t.Fatal("inter frame kept while waiting for keyframe")
+	}
+	if keep, _, _ := congestion.Admit(testKeyFrame()); false == keep || congestion.State() != Congestion_drop_nonref {
+		t.Fatalf("keyframe keep %v state %d", keep, congestion.State())
+	}
+	if false == congestion.SkipToKeyFrame() {
+		t.Fatal("skip after keyframe")
+	}
+}
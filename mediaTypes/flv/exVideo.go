@@ -0,0 +1,142 @@
+package flv
+
+import (
+	"errors"
+	"fmt"
+)
+
+//Enhanced RTMP的视频tag
+func IsExVideoHeader(data []byte) bool {
+	return len(data) > 0 && (data[0]&0x80) != 0
+}
+
+//视频tag头，老格式和Enhanced RTMP解析成一样的字段
+//CodecId里ex header的fourcc换成内部的CodecID，PktType换成AVC_Header AVC_NALU 2(end of sequence)
+type VideoTagHeader struct {
+	FrameType     int
+	CodecId       int
+	PktType       int
+	Cts           int32
+	PayloadOffset int
+}
+
+//只解析，不改tag，ex tag原样发给flv的播放端
+func ParseVideoTagHeader(tag *FlvTag) (header VideoTagHeader, err error) {
+	if tag.TagType != FLV_TAG_Video || len(tag.Data) == 0 {
+		return header, errors.New("not a video tag")
+	}
+	if false == IsExVideoHeader(tag.Data) {
+		header.FrameType = int(tag.Data[0] >> 4)
+		header.CodecId = int(tag.Data[0] & 0xf)
+		header.PayloadOffset = 1
+		if header.CodecId != CodecID_AVC && header.CodecId != CodecID_HEVC {
+			//其他老编码没有pktType，每个tag都是一帧
+			header.PktType = AVC_NALU
+			return
+		}
+		if len(tag.Data) < 5 {
+			return header, errors.New("avc tag too short")
+		}
+		header.PktType = int(tag.Data[1])
+		//composition time,有符号24位
+		header.Cts = int32(uint32(tag.Data[2])<<16|uint32(tag.Data[3])<<8|uint32(tag.Data[4])) << 8 >> 8
+		header.PayloadOffset = 5
+		return
+	}
+	if len(tag.Data) < 5 {
+		return header, errors.New("ex video tag too short")
+	}
+	header.FrameType = int((tag.Data[0] >> 4) & 0x7)
+	fourCC := string(tag.Data[1:5])
+	switch fourCC {
+	case FourCC_HEVC:
+		header.CodecId = CodecID_HEVC
+	case FourCC_AV1:
+		header.CodecId = CodecID_AV1
+	case FourCC_VP9:
+		header.CodecId = CodecID_VP9
+	default:
+		return header, errors.New(fmt.Sprintf("video fourcc %s not supported", fourCC))
+	}
+	header.PayloadOffset = 5
+	pktType := int(tag.Data[0] & 0xf)
+	switch pktType {
+	case VideoPacketType_SequenceStart:
+		header.PktType = AVC_Header
+	case VideoPacketType_CodedFrames:
+		header.PktType = AVC_NALU
+		if CodecID_HEVC == header.CodecId {
+			//只有hevc带cts，在5-7
+			if len(tag.Data) < 8 {
+				return header, errors.New("ex video coded frames too short")
+			}
+			header.Cts = int32(uint32(tag.Data[5])<<16|uint32(tag.Data[6])<<8|uint32(tag.Data[7])) << 8 >> 8
+			header.PayloadOffset = 8
+		}
+	case VideoPacketType_CodedFramesX:
+		header.PktType = AVC_NALU
+	case VideoPacketType_SequenceEnd:
+		header.PktType = 2
+	default:
+		return header, errors.New(fmt.Sprintf("ex video packet type %d not supported", pktType))
+	}
+	return
+}
+
+//给按老格式解析Data的封装(fmp4 rtsp)用，ex tag拷贝一份改成老格式(frameType|codecId,pktType,cts)
+//老格式的tag直接返回，不拷贝;返回的拷贝没有引用计数，不用Release
+func NormalizedVideoTag(tag *FlvTag) (out *FlvTag, err error) {
+	if tag.TagType != FLV_TAG_Video || false == IsExVideoHeader(tag.Data) {
+		return tag, nil
+	}
+	header, err := ParseVideoTagHeader(tag)
+	if err != nil {
+		return nil, err
+	}
+	payload := tag.Data[header.PayloadOffset:]
+	out = &FlvTag{TagType: tag.TagType, Timestamp: tag.Timestamp, StreamID: tag.StreamID}
+	out.Data = make([]byte, 5+len(payload))
+	out.Data[0] = byte(header.FrameType<<4) | byte(header.CodecId)
+	out.Data[1] = byte(header.PktType)
+	out.Data[2] = byte(header.Cts >> 16)
+	out.Data[3] = byte(header.Cts >> 8)
+	out.Data[4] = byte(header.Cts)
+	copy(out.Data[5:], payload)
+	return
+}
+
+func VideoFrameType(tag *FlvTag) int {
+	if len(tag.Data) == 0 {
+		return 0
+	}
+	return int((tag.Data[0] >> 4) & 0x7)
+}
+
+//视频关键帧，不是sequence header
+func IsVideoKeyFrame(tag *FlvTag) bool {
+	header, err := ParseVideoTagHeader(tag)
+	return err == nil && header.FrameType == FrameType_Keyframe && header.PktType == AVC_NALU
+}
+
+//AVC/HEVC/AV1/VP9的sequence header
+func IsVideoSequenceHeader(tag *FlvTag) bool {
+	header, err := ParseVideoTagHeader(tag)
+	return err == nil && header.PktType == AVC_Header
+}
+
+func IsAudioSequenceHeader(tag *FlvTag) bool {
+	return tag.TagType == FLV_TAG_Audio && len(tag.Data) > 1 &&
+		(tag.Data[0]>>4) == SoundFormat_AAC && tag.Data[1] == AACSequenceHeader
+}
+
+//ex header的返回fourcc对应的内部CodecID，不支持的fourcc返回0
+func VideoCodecId(tag *FlvTag) int {
+	if len(tag.Data) == 0 {
+		return 0
+	}
+	if IsExVideoHeader(tag.Data) {
+		header, _ := ParseVideoTagHeader(tag)
+		return header.CodecId
+	}
+	return int(tag.Data[0] & 0xf)
+}
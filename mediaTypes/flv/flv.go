@@ -1,5 +1,9 @@
 package flv
 
+import (
+	"wssAPI"
+)
+
 const (
 	FLV_TAG_Audio      = 8
 	FLV_TAG_Video      = 9
@@ -61,8 +65,29 @@ const (
 	CodecID_On2Vp6AlphaChannel = 5
 	CodecID_ScreenVideoV2      = 6
 	CodecID_AVC                = 7
+	CodecID_HEVC               = 12 //非标准，国内通用
+	CodecID_AV1                = 13 //内部使用，Enhanced RTMP的av01转成这个
+	CodecID_VP9                = 14 //内部使用，Enhanced RTMP的vp09转成这个
+)
+
+//Enhanced RTMP,视频第一个字节最高位为1时，低4位是PacketType，后面跟FourCC
+const (
+	VideoPacketType_SequenceStart = 0
+	VideoPacketType_CodedFrames   = 1
+	VideoPacketType_SequenceEnd   = 2
+	VideoPacketType_CodedFramesX  = 3
+	VideoPacketType_Metadata      = 4
 )
 
+const (
+	FourCC_HEVC = "hvc1"
+	FourCC_AV1  = "av01"
+	FourCC_VP9  = "vp09"
+)
+
+//connect回复fourCcList时用
+var SupportedVideoFourCC = []string{FourCC_HEVC, FourCC_AV1, FourCC_VP9}
+
 const (
 	AVC_Header = 0
 	AVC_NALU   = 1
@@ -73,6 +98,7 @@ type FlvTag struct {
 	Timestamp uint32
 	StreamID  uint32
 	Data      []byte
+	ref       *wssAPI.BufferRef
 }
 
 type AudioTag struct {
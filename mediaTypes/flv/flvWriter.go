@@ -0,0 +1,36 @@
+package flv
+
+//flv文件头，后面跟着PreviousTagSize0
+func FlvHeader(hasAudio, hasVideo bool) (data []byte) {
+	data = []byte{'F', 'L', 'V', 1, 0, 0, 0, 0, 9, 0, 0, 0, 0}
+	if hasAudio {
+		data[4] |= 0x04
+	}
+	if hasVideo {
+		data[4] |= 0x01
+	}
+	return
+}
+
+//tag头+data+PreviousTagSize，stream id总是0
+func EncodeFlvTag(tag *FlvTag) (data []byte) {
+	size := len(tag.Data)
+	data = make([]byte, 0, 11+size+4)
+	data = AppendTagHeader(data, tag.TagType, size, tag.Timestamp)
+	data = append(data, tag.Data...)
+	data = AppendPreviousTagSize(data, size)
+	return
+}
+
+//11字节的tag头，data和PreviousTagSize分开写时用，不用拷贝data
+func AppendTagHeader(buf []byte, tagType uint8, size int, timestamp uint32) []byte {
+	return append(buf, tagType, byte(size>>16), byte(size>>8), byte(size),
+		byte(timestamp>>16), byte(timestamp>>8), byte(timestamp), byte(timestamp>>24),
+		0, 0, 0)
+}
+
+//size是tag data的长度
+func AppendPreviousTagSize(buf []byte, size int) []byte {
+	tagSize := 11 + size
+	return append(buf, byte(tagSize>>24), byte(tagSize>>16), byte(tagSize>>8), byte(tagSize))
+}
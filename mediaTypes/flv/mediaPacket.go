@@ -0,0 +1,233 @@
+package flv
+
+import (
+	"errors"
+	"fmt"
+	"mediaTypes/aac"
+	"mediaTypes/av1"
+	"mediaTypes/h264"
+	"mediaTypes/h265"
+	"mediaTypes/mp3"
+	"mediaTypes/vp9"
+	"wssAPI"
+)
+
+//flv tag转wssAPI.MediaPacket，每路流一个，记住当前的编码参数
+//Data直接引用tag的Data，不拷贝
+type PacketConverter struct {
+	audioConfig *wssAPI.CodecConfig
+	videoConfig *wssAPI.CodecConfig
+}
+
+//pkt为nil表示这个tag不产生包，比如AVC end of sequence
+func (this *PacketConverter) Convert(tag *FlvTag) (pkt *wssAPI.MediaPacket, err error) {
+	if len(tag.Data) == 0 {
+		return nil, errors.New("empty flv tag")
+	}
+	pkt = &wssAPI.MediaPacket{}
+	pkt.Dts = tag.Timestamp
+	pkt.Pts = tag.Timestamp
+	switch tag.TagType {
+	case FLV_TAG_Audio:
+		err = this.convertAudio(tag, pkt)
+	case FLV_TAG_Video:
+		err = this.convertVideo(tag, pkt)
+	case FLV_TAG_ScriptData:
+		pkt.MediaType = wssAPI.MEDIA_TYPE_DATA
+		pkt.CodecId = wssAPI.CODEC_SCRIPT
+		pkt.Keyframe = true
+		pkt.Data = tag.Data
+	default:
+		err = errors.New(fmt.Sprintf("flv tag type %d not supported", tag.TagType))
+	}
+	if err != nil || pkt.Data == nil {
+		return nil, err
+	}
+	pkt.Ref = tag.ref
+	pkt.Ref.Retain()
+	return
+}
+
+func (this *PacketConverter) convertAudio(tag *FlvTag, pkt *wssAPI.MediaPacket) (err error) {
+	pkt.MediaType = wssAPI.MEDIA_TYPE_AUDIO
+	pkt.Keyframe = true
+	soundFormat := int(tag.Data[0] >> 4)
+	sampleSize := 8
+	if SoundSize_16Bit == ((tag.Data[0] >> 1) & 1) {
+		sampleSize = 16
+	}
+	switch soundFormat {
+	case SoundFormat_AAC:
+		pkt.CodecId = wssAPI.CODEC_AAC
+		if len(tag.Data) < 2 {
+			return errors.New("aac tag too short")
+		}
+		if AACSequenceHeader == tag.Data[1] {
+			if len(tag.Data) < 4 {
+				return errors.New("invalid aac sequence header")
+			}
+			cfg := &wssAPI.CodecConfig{CodecId: wssAPI.CODEC_AAC, SampleSize: sampleSize}
+			cfg.Data = make([]byte, len(tag.Data)-2)
+			copy(cfg.Data, tag.Data[2:])
+			asc := aac.MP4AudioGetConfig(cfg.Data)
+			cfg.Profile = asc.Object_type
+			cfg.SampleRate = asc.Sample_rate
+			if asc.Ext_sample_rate > 0 {
+				cfg.SampleRate = asc.Ext_sample_rate
+			}
+			cfg.Channels = asc.Channels
+			this.audioConfig = cfg
+			pkt.IsConfig = true
+		}
+		pkt.Data = tag.Data[2:]
+	case SoundFormat_MP3, SoundFormat_MP3_8KHz:
+		pkt.CodecId = wssAPI.CODEC_MP3
+		pkt.Data = tag.Data[1:]
+		if this.audioConfig == nil || this.audioConfig.CodecId != wssAPI.CODEC_MP3 {
+			header, err := mp3.ParseMP3Header(pkt.Data)
+			if err != nil {
+				return err
+			}
+			cfg := &wssAPI.CodecConfig{CodecId: wssAPI.CODEC_MP3, SampleSize: sampleSize}
+			cfg.SampleRate = header.SampleRate
+			cfg.Channels = header.Channel
+			this.audioConfig = cfg
+		}
+	default:
+		pkt.CodecId = wssAPI.CODEC_UNKNOWN
+		pkt.Data = tag.Data[1:]
+	}
+	pkt.Config = this.audioConfig
+	return
+}
+
+func (this *PacketConverter) convertVideo(tag *FlvTag, pkt *wssAPI.MediaPacket) (err error) {
+	pkt.MediaType = wssAPI.MEDIA_TYPE_VIDEO
+	header, err := ParseVideoTagHeader(tag)
+	if err != nil {
+		return err
+	}
+	codecId := header.CodecId
+	pkt.Keyframe = header.FrameType == FrameType_Keyframe
+	switch codecId {
+	case CodecID_AVC:
+		pkt.CodecId = wssAPI.CODEC_H264
+	case CodecID_HEVC:
+		pkt.CodecId = wssAPI.CODEC_H265
+	case CodecID_AV1:
+		pkt.CodecId = wssAPI.CODEC_AV1
+	case CodecID_VP9:
+		pkt.CodecId = wssAPI.CODEC_VP9
+	default:
+		pkt.CodecId = wssAPI.CODEC_UNKNOWN
+		pkt.Data = tag.Data[1:]
+		pkt.Config = this.videoConfig
+		return
+	}
+	pkt.Pts = uint32(int32(tag.Timestamp) + header.Cts)
+	payload := tag.Data[header.PayloadOffset:]
+	switch header.PktType {
+	case AVC_Header:
+		var cfg *wssAPI.CodecConfig
+		switch codecId {
+		case CodecID_AVC:
+			cfg, err = parseAVCConfig(payload)
+		case CodecID_HEVC:
+			cfg, err = parseHEVCConfig(payload)
+		case CodecID_AV1:
+			cfg, err = parseAV1Config(payload)
+		case CodecID_VP9:
+			cfg, err = parseVP9Config(payload)
+		}
+		if err != nil {
+			return err
+		}
+		this.videoConfig = cfg
+		pkt.IsConfig = true
+		pkt.Keyframe = true
+		pkt.Data = payload
+	case AVC_NALU:
+		pkt.Data = payload
+		if CodecID_VP9 == codecId && pkt.Keyframe && this.videoConfig != nil && 0 == this.videoConfig.Width {
+			//vpcC里没有分辨率，已经发出去的包还引用旧的config，换一个
+			cfg := *this.videoConfig
+			cfg.Width, cfg.Height, _ = vp9.ParseKeyFrameSize(pkt.Data)
+			this.videoConfig = &cfg
+		}
+	default:
+		//end of sequence
+		return
+	}
+	pkt.Config = this.videoConfig
+	return
+}
+
+func parseAVCConfig(avc []byte) (cfg *wssAPI.CodecConfig, err error) {
+	if len(avc) < 11 {
+		return nil, errors.New("invalid avc sequence header")
+	}
+	spsSize := int(avc[6])<<8 | int(avc[7])
+	if 8+spsSize+3 > len(avc) {
+		return nil, errors.New("invalid avc sps size")
+	}
+	ppsSize := int(avc[8+spsSize+1])<<8 | int(avc[8+spsSize+2])
+	if 8+spsSize+3+ppsSize > len(avc) {
+		return nil, errors.New("invalid avc pps size")
+	}
+	cfg = &wssAPI.CodecConfig{CodecId: wssAPI.CODEC_H264}
+	cfg.Data = make([]byte, len(avc))
+	copy(cfg.Data, avc)
+	cfg.Profile = int(avc[1])
+	cfg.Level = int(avc[3])
+	sps, _ := h264.GetSpsPpsFromAVC(cfg.Data)
+	cfg.Width, cfg.Height, cfg.Fps = h264.ParseSPS(sps)
+	return
+}
+
+func parseHEVCConfig(data []byte) (cfg *wssAPI.CodecConfig, err error) {
+	hvcc, err := h265.ParseHVCC(data)
+	if err != nil {
+		return
+	}
+	cfg = &wssAPI.CodecConfig{CodecId: wssAPI.CODEC_H265}
+	cfg.Data = make([]byte, len(data))
+	copy(cfg.Data, data)
+	cfg.Profile = int(hvcc.GeneralProfileIdc)
+	cfg.Level = int(hvcc.GeneralLevelIdc)
+	cfg.Fps = int(hvcc.AvgFrameRate) / 256
+	if len(hvcc.SPS) > 0 {
+		cfg.Width, cfg.Height, _ = h265.ParseSPS(hvcc.SPS[0])
+	}
+	return
+}
+
+func parseAV1Config(data []byte) (cfg *wssAPI.CodecConfig, err error) {
+	av1c, err := av1.ParseAV1C(data)
+	if err != nil {
+		return
+	}
+	cfg = &wssAPI.CodecConfig{CodecId: wssAPI.CODEC_AV1}
+	cfg.Data = make([]byte, len(data))
+	copy(cfg.Data, data)
+	cfg.Profile = int(av1c.SeqProfile)
+	cfg.Level = int(av1c.SeqLevelIdx0)
+	seq, err := av1.FindSequenceHeader(av1c.ConfigOBUs)
+	if err == nil {
+		cfg.Width, cfg.Height, _ = av1.ParseSequenceHeader(seq)
+	}
+	return cfg, nil
+}
+
+//FLV里的vpcC不带FullBox头
+func parseVP9Config(data []byte) (cfg *wssAPI.CodecConfig, err error) {
+	vpcc, err := vp9.ParseVPCC(data)
+	if err != nil {
+		return
+	}
+	cfg = &wssAPI.CodecConfig{CodecId: wssAPI.CODEC_VP9}
+	cfg.Data = make([]byte, len(data))
+	copy(cfg.Data, data)
+	cfg.Profile = int(vpcc.Profile)
+	cfg.Level = int(vpcc.Level)
+	return
+}
@@ -0,0 +1,37 @@
+package flv
+
+import (
+	"wssAPI"
+)
+
+//源和所有sink共享同一个tag，不再逐个拷贝，Data只读
+//NewSharedTag创建的tag带引用计数，每个持有者Retain一次，用完Release
+//计数归零后Data放回池中；普通tag的Retain/Release什么都不做
+
+//data的所有权交给tag，data需由wssAPI.AllocBuffer申请
+func NewSharedTag(tagType uint8, timestamp uint32, data []byte) (tag *FlvTag) {
+	tag = &FlvTag{}
+	tag.TagType = tagType
+	tag.Timestamp = timestamp
+	tag.Data = data
+	tag.ref = wssAPI.NewBufferRef(data)
+	return
+}
+
+func (this *FlvTag) Retain() *FlvTag {
+	this.ref.Retain()
+	return this
+}
+
+func (this *FlvTag) Release() {
+	this.ref.Release()
+}
+
+//共享Data，只改时间戳，返回的tag也要Release
+func (this *FlvTag) WithTimestamp(timestamp uint32) (dst *FlvTag) {
+	dst = &FlvTag{}
+	*dst = *this
+	dst.Timestamp = timestamp
+	dst.Retain()
+	return
+}
@@ -0,0 +1,163 @@
+package flv
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"wssAPI"
+)
+
+const testTagSize = 4 << 10
+
+func newTestSharedTag(timestamp uint32, fill byte) (tag *FlvTag) {
+	data := wssAPI.AllocBuffer(testTagSize)
+	for i := range data {
+		data[i] = fill
+	}
+	return NewSharedTag(FLV_TAG_Video, timestamp, data)
+}
+
+//池里同一级的buffer都拿出来写一遍，还被持有的Data不能被拿到
+func scribblePool() {
+	bufs := make([][]byte, 64)
+	for i := range bufs {
+		bufs[i] = wssAPI.AllocBuffer(testTagSize)
+		for j := range bufs[i] {
+			bufs[i][j] = 0xff
+		}
+	}
+	for _, buf := range bufs {
+		wssAPI.FreeBuffer(buf)
+	}
+}
+
+//源Release以后sink还拿着，最后一个sink放掉之前Data不能回池被别人改
+func TestSharedTagFanOut(t *testing.T) {
+	tests := []struct {
+		sinks    int
+		released int //先放掉的sink数
+	}{
+		{1, 0},
+		{10, 0},
+		{10, 9},
+		{100, 99},
+	}
+	for _, test := range tests {
+		tag := newTestSharedTag(100, 0x5a)
+		expected := bytes.Repeat([]byte{0x5a}, testTagSize)
+		sinks := newBenchSinks(test.sinks)
+		for _, sink := range sinks {
+			sink.accept(tag.Retain())
+		}
+		tag.Release()
+		for _, sink := range sinks[:test.released] {
+			sink.accept(nil)
+		}
+		scribblePool()
+		for i, sink := range sinks[test.released:] {
+			if sink.last != tag || false == bytes.Equal(sink.last.Data, expected) {
+				t.Fatalf("%d sinks: sink %d data reused while held", test.sinks, test.released+i)
+			}
+		}
+		releaseBenchSinks(sinks)
+	}
+}
+
+//WithTimestamp只换时间戳，共享的tag不动，Data是同一块，自己持有一份引用
+func TestSharedTagWithTimestamp(t *testing.T) {
+	tag := newTestSharedTag(100, 0x33)
+	rebased := tag.WithTimestamp(0)
+	if tag.Timestamp != 100 || rebased.Timestamp != 0 {
+		t.Fatalf("timestamp %d rebased %d", tag.Timestamp, rebased.Timestamp)
+	}
+	if &rebased.Data[0] != &tag.Data[0] {
+		t.Fatal("data copied")
+	}
+	tag.Release()
+	scribblePool()
+	if false == bytes.Equal(rebased.Data, bytes.Repeat([]byte{0x33}, testTagSize)) {
+		t.Fatal("data reused while the rebased tag holds it")
+	}
+	rebased.Release()
+}
+
+//普通tag没有引用计数，Retain/Release不影响Data
+func TestPlainTagRetain(t *testing.T) {
+	tag := &FlvTag{TagType: FLV_TAG_Audio, Timestamp: 20, Data: []byte{0xaf, 1}}
+	tag.Retain().Release()
+	tag.Release()
+	if false == bytes.Equal(tag.Data, []byte{0xaf, 1}) {
+		t.Fatal("plain tag data changed")
+	}
+}
+
+//一个视频tag分发给N个sink:老的每个sink Copy一份，和共享同一个带引用计数的tag对比
+//go test -bench FanOut -benchmem mediaTypes/flv
+
+const benchTagSize = 16 << 10
+
+var benchSinkCounts = []int{1, 10, 100, 1000}
+
+//sink只是把tag存下来，下一个tag来时放掉上一个，和播放端cache差不多
+type benchSink struct {
+	last *FlvTag
+}
+
+func (this *benchSink) accept(tag *FlvTag) {
+	if this.last != nil {
+		this.last.Release()
+	}
+	this.last = tag
+}
+
+func newBenchSinks(n int) (sinks []*benchSink) {
+	sinks = make([]*benchSink, n)
+	for i := range sinks {
+		sinks[i] = &benchSink{}
+	}
+	return
+}
+
+func releaseBenchSinks(sinks []*benchSink) {
+	for _, sink := range sinks {
+		sink.accept(nil)
+	}
+}
+
+func BenchmarkFanOutCopy(b *testing.B) {
+	for _, n := range benchSinkCounts {
+		b.Run(fmt.Sprintf("sinks=%d", n), func(b *testing.B) {
+			sinks := newBenchSinks(n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				data := make([]byte, benchTagSize)
+				tag := &FlvTag{TagType: FLV_TAG_Video, Timestamp: uint32(i), Data: data}
+				for _, sink := range sinks {
+					sink.accept(tag.Copy())
+				}
+			}
+			b.StopTimer()
+			releaseBenchSinks(sinks)
+		})
+	}
+}
+
+func BenchmarkFanOutShared(b *testing.B) {
+	for _, n := range benchSinkCounts {
+		b.Run(fmt.Sprintf("sinks=%d", n), func(b *testing.B) {
+			sinks := newBenchSinks(n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tag := NewSharedTag(FLV_TAG_Video, uint32(i), wssAPI.AllocBuffer(benchTagSize))
+				for _, sink := range sinks {
+					sink.accept(tag.Retain())
+				}
+				tag.Release()
+			}
+			b.StopTimer()
+			releaseBenchSinks(sinks)
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package h265
+
+import (
+	"errors"
+	"wssAPI"
+)
+
+const (
+	Nal_type_trail_n    = 0
+	Nal_type_trail_r    = 1
+	Nal_type_bla_w_lp   = 16
+	Nal_type_bla_w_radl = 17
+	Nal_type_bla_n_lp   = 18
+	Nal_type_idr_w_radl = 19
+	Nal_type_idr_n_lp   = 20
+	Nal_type_cra        = 21
+	Nal_type_vps        = 32
+	Nal_type_sps        = 33
+	Nal_type_pps        = 34
+	Nal_type_aud        = 35
+	Nal_type_eos        = 36
+	Nal_type_eob        = 37
+	Nal_type_fd         = 38
+	Nal_type_sei_prefix = 39
+	Nal_type_sei_suffix = 40
+	Nal_type_ap         = 48 //rtp aggregation packet
+	Nal_type_fu         = 49 //rtp fragmentation unit
+)
+
+//hvcC
+type HEVCDecoderConfigurationRecord struct {
+	ConfigurationVersion             byte
+	GeneralProfileSpace              byte
+	GeneralTierFlag                  byte
+	GeneralProfileIdc                byte
+	GeneralProfileCompatibilityFlags uint32
+	GeneralConstraintIndicatorFlags  uint64
+	GeneralLevelIdc                  byte
+	ChromaFormat                     byte
+	BitDepthLumaMinus8               byte
+	BitDepthChromaMinus8             byte
+	AvgFrameRate                     uint16
+	LengthSizeMinusOne               byte
+	VPS                              [][]byte
+	SPS                              [][]byte
+	PPS                              [][]byte
+}
+
+//nal头两个字节，类型在第一个字节的1-6位
+func NalType(header byte) int {
+	return int(header>>1) & 0x3f
+}
+
+//BLA IDR CRA，可以从这里开始解码
+func IsIRAP(nalType int) bool {
+	return nalType >= Nal_type_bla_w_lp && nalType <= 23
+}
+
+func ParseHVCC(data []byte) (hvcc *HEVCDecoderConfigurationRecord, err error) {
+	if len(data) < 23 {
+		return nil, errors.New("hvcC too short")
+	}
+	hvcc = &HEVCDecoderConfigurationRecord{}
+	hvcc.ConfigurationVersion = data[0]
+	hvcc.GeneralProfileSpace = data[1] >> 6
+	hvcc.GeneralTierFlag = (data[1] >> 5) & 1
+	hvcc.GeneralProfileIdc = data[1] & 0x1f
+	hvcc.GeneralProfileCompatibilityFlags = uint32(data[2])<<24 | uint32(data[3])<<16 |
+		uint32(data[4])<<8 | uint32(data[5])
+	for i := 6; i < 12; i++ {
+		hvcc.GeneralConstraintIndicatorFlags = (hvcc.GeneralConstraintIndicatorFlags << 8) | uint64(data[i])
+	}
+	hvcc.GeneralLevelIdc = data[12]
+	hvcc.ChromaFormat = data[16] & 0x3
+	hvcc.BitDepthLumaMinus8 = data[17] & 0x7
+	hvcc.BitDepthChromaMinus8 = data[18] & 0x7
+	hvcc.AvgFrameRate = uint16(data[19])<<8 | uint16(data[20])
+	hvcc.LengthSizeMinusOne = data[21] & 0x3
+	numOfArrays := int(data[22])
+	cur := 23
+	for i := 0; i < numOfArrays; i++ {
+		if cur+3 > len(data) {
+			return nil, errors.New("hvcC array truncated")
+		}
+		nalType := int(data[cur] & 0x3f)
+		numNalus := int(data[cur+1])<<8 | int(data[cur+2])
+		cur += 3
+		for j := 0; j < numNalus; j++ {
+			if cur+2 > len(data) {
+				return nil, errors.New("hvcC nalu truncated")
+			}
+			size := int(data[cur])<<8 | int(data[cur+1])
+			cur += 2
+			if cur+size > len(data) {
+				return nil, errors.New("hvcC nalu truncated")
+			}
+			nal := make([]byte, size)
+			copy(nal, data[cur:cur+size])
+			cur += size
+			switch nalType {
+			case Nal_type_vps:
+				hvcc.VPS = append(hvcc.VPS, nal)
+			case Nal_type_sps:
+				hvcc.SPS = append(hvcc.SPS, nal)
+			case Nal_type_pps:
+				hvcc.PPS = append(hvcc.PPS, nal)
+			}
+		}
+	}
+	return
+}
+
+//去掉防竞争字节 00 00 03
+func RemoveEmulationPrevention(src []byte) (ret []byte) {
+	ret = make([]byte, 0, len(src))
+	zeros := 0
+	for _, v := range src {
+		if zeros >= 2 && v == 3 {
+			zeros = 0
+			continue
+		}
+		if v == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		ret = append(ret, v)
+	}
+	return
+}
+
+//sps带两字节nal头，只解析到分辨率
+func ParseSPS(sps []byte) (width, height int, err error) {
+	if len(sps) < 16 {
+		return 0, 0, errors.New("hevc sps too short")
+	}
+	bit := &wssAPI.BitReader{}
+	bit.Init(RemoveEmulationPrevention(sps))
+	bit.ReadBits(16)
+	bit.ReadBits(4) //sps_video_parameter_set_id
+	maxSubLayersMinus1 := bit.ReadBits(3)
+	bit.ReadBit()
+	//profile_tier_level
+	bit.ReadBits(88)
+	bit.ReadBits(8) //general_level_idc
+	subLayerProfilePresent := make([]int, maxSubLayersMinus1)
+	subLayerLevelPresent := make([]int, maxSubLayersMinus1)
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		subLayerProfilePresent[i] = bit.ReadBit()
+		subLayerLevelPresent[i] = bit.ReadBit()
+	}
+	if maxSubLayersMinus1 > 0 {
+		for i := maxSubLayersMinus1; i < 8; i++ {
+			bit.ReadBits(2)
+		}
+	}
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		if subLayerProfilePresent[i] == 1 {
+			bit.ReadBits(88)
+		}
+		if subLayerLevelPresent[i] == 1 {
+			bit.ReadBits(8)
+		}
+	}
+	if bit.BitsLeft() < 16 {
+		return 0, 0, errors.New("hevc sps truncated")
+	}
+	bit.ReadExponentialGolombCode() //sps_seq_parameter_set_id
+	chromaFormatIdc := bit.ReadExponentialGolombCode()
+	if 3 == chromaFormatIdc {
+		bit.ReadBit()
+	}
+	width = bit.ReadExponentialGolombCode()
+	height = bit.ReadExponentialGolombCode()
+	if 1 == bit.ReadBit() {
+		left := bit.ReadExponentialGolombCode()
+		right := bit.ReadExponentialGolombCode()
+		top := bit.ReadExponentialGolombCode()
+		bottom := bit.ReadExponentialGolombCode()
+		subWidth, subHeight := 1, 1
+		if 1 == chromaFormatIdc || 2 == chromaFormatIdc {
+			subWidth = 2
+		}
+		if 1 == chromaFormatIdc {
+			subHeight = 2
+		}
+		width -= subWidth * (left + right)
+		height -= subHeight * (top + bottom)
+	}
+	return
+}
@@ -1,6 +1,128 @@
 package mp4
+
+import (
+	"fmt"
+	"mediaTypes/av1"
+	"mediaTypes/h264"
+	"mediaTypes/h265"
+	"mediaTypes/vp9"
+	"strings"
+	"wssAPI"
+)
+
 //MP4a.40.2
 //40:ObjectTypeIndication (OTI) :代表 MPEG-4 audio
 //2:ObjectTypeIndication (OTI) :代表aac-lc
 //avc1.42.c0.0d   hex
 //avc的前三个字节的16进制，即SPS的1-3三个字节.0 base
+
+//RFC 6381的codecs参数，给MSE的addSourceBuffer用，和fMP4 init里的sample entry一致
+func CodecString(cfg *wssAPI.CodecConfig) (codec string) {
+	if nil == cfg {
+		return
+	}
+	switch cfg.CodecId {
+	case wssAPI.CODEC_H264:
+		return avcCodecString(cfg.Data)
+	case wssAPI.CODEC_H265:
+		return hevcCodecString(cfg.Data)
+	case wssAPI.CODEC_AV1:
+		return av1CodecString(cfg.Data)
+	case wssAPI.CODEC_VP9:
+		return vp9CodecString(cfg.Data)
+	case wssAPI.CODEC_AAC:
+		return fmt.Sprintf("mp4a.40.%d", cfg.Profile)
+	case wssAPI.CODEC_MP3:
+		//和stsdA里的OTI一样，MPEG-1的采样率是32k 44.1k 48k
+		if cfg.SampleRate >= 32000 {
+			return fmt.Sprintf("mp4a.%X", CODEC_ID_MP3_MPEG1)
+		}
+		return fmt.Sprintf("mp4a.%X", CODEC_ID_MP3_MPEG2)
+	}
+	return
+}
+
+//avc1.PPCCLL:profile_idc constraint_flags level_idc
+func avcCodecString(avcc []byte) string {
+	sps, _ := h264.GetSpsPpsFromAVC(avcc)
+	if len(sps) >= 4 {
+		return fmt.Sprintf("avc1.%02x%02x%02x", sps[1], sps[2], sps[3])
+	}
+	if len(avcc) >= 4 {
+		return fmt.Sprintf("avc1.%02x%02x%02x", avcc[1], avcc[2], avcc[3])
+	}
+	return "avc1"
+}
+
+//hvcC里有完整的VPS SPS PPS用hvc1，否则参数集在码流里，用hev1
+func hevcSampleEntry(hvcc *h265.HEVCDecoderConfigurationRecord) string {
+	if len(hvcc.VPS) == 0 || len(hvcc.SPS) == 0 || len(hvcc.PPS) == 0 {
+		return "hev1"
+	}
+	return "hvc1"
+}
+
+//ISO 14496-15 E.3:hvc1.[A-C]profile.compat倒序.L|H level.constraint去掉末尾的0
+func hevcCodecString(data []byte) string {
+	hvcc, err := h265.ParseHVCC(data)
+	if err != nil {
+		return "hev1"
+	}
+	codec := hevcSampleEntry(hvcc) + "."
+	if hvcc.GeneralProfileSpace > 0 {
+		codec += string(rune('A' + hvcc.GeneralProfileSpace - 1))
+	}
+	var compat uint32
+	for i := uint(0); i < 32; i++ {
+		if hvcc.GeneralProfileCompatibilityFlags&(1<<i) != 0 {
+			compat |= 1 << (31 - i)
+		}
+	}
+	tier := "L"
+	if hvcc.GeneralTierFlag != 0 {
+		tier = "H"
+	}
+	codec += fmt.Sprintf("%d.%X.%s%d", hvcc.GeneralProfileIdc, compat, tier, hvcc.GeneralLevelIdc)
+	constraints := make([]string, 6)
+	last := -1
+	for i := 0; i < 6; i++ {
+		b := byte(hvcc.GeneralConstraintIndicatorFlags >> uint(40-8*i))
+		constraints[i] = fmt.Sprintf("%X", b)
+		if b != 0 {
+			last = i
+		}
+	}
+	if last >= 0 {
+		codec += "." + strings.Join(constraints[:last+1], ".")
+	}
+	return codec
+}
+
+//av01.profile.level tier.bitDepth
+func av1CodecString(data []byte) string {
+	av1c, err := av1.ParseAV1C(data)
+	if err != nil {
+		return "av01"
+	}
+	tier := "M"
+	if av1c.SeqTier0 != 0 {
+		tier = "H"
+	}
+	bitDepth := 8
+	if av1c.HighBitdepth != 0 {
+		bitDepth = 10
+		if av1c.TwelveBit != 0 {
+			bitDepth = 12
+		}
+	}
+	return fmt.Sprintf("av01.%d.%02d%s.%02d", av1c.SeqProfile, av1c.SeqLevelIdx0, tier, bitDepth)
+}
+
+//vp09.profile.level.bitDepth
+func vp9CodecString(data []byte) string {
+	vpcc, err := vp9.ParseVPCC(data)
+	if err != nil {
+		return "vp09"
+	}
+	return fmt.Sprintf("vp09.%02d.%02d.%02d", vpcc.Profile, vpcc.Level, vpcc.BitDepth)
+}
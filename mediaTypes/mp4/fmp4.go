@@ -5,9 +5,12 @@ import (
 	"logger"
 	"mediaTypes/aac"
 	"mediaTypes/amf"
+	"mediaTypes/av1"
 	"mediaTypes/flv"
 	"mediaTypes/h264"
+	"mediaTypes/h265"
 	"mediaTypes/mp3"
+	"mediaTypes/vp9"
 	"os"
 	"strconv"
 	"strings"
@@ -49,6 +52,8 @@ type FMP4Slice struct {
 type FMP4Creater struct {
 	videoIdx      int
 	videoInited   bool
+	videoCodec    int
+	vp9Header     *flv.FlvTag //vp9的init要等第一个关键帧的分辨率
 	videoLastTime uint32
 	audioIdx      int
 	audioInited   bool
@@ -76,11 +81,18 @@ type FMP4Flags struct {
 }
 
 func (this *FMP4Creater) AddFlvTag(tag *flv.FlvTag) (slice *FMP4Slice) {
-
+	//Enhanced RTMP的tag换成老格式的拷贝，后面按codecId处理
+	tag, err := flv.NormalizedVideoTag(tag)
+	if err != nil {
+		logger.LOGW(err.Error())
+		return
+	}
 	if 0 == this.firstNoZeroTime && tag.Timestamp != 0 {
 		this.firstNoZeroTime = tag.Timestamp
 	}
-	tmpTag := tag.Copy()
+	//只改时间戳，Data共享，不拷贝
+	tmpTag := &flv.FlvTag{}
+	*tmpTag = *tag
 	if 0 != this.firstNoZeroTime {
 		if tmpTag.Timestamp >= this.firstNoZeroTime {
 			tmpTag.Timestamp -= this.firstNoZeroTime
@@ -97,6 +109,14 @@ func (this *FMP4Creater) AddFlvTag(tag *flv.FlvTag) (slice *FMP4Slice) {
 	return
 }
 
+//换流后codec参数变了，重新生成init，时间戳和序号接着走
+func (this *FMP4Creater) ResetInit() {
+	this.videoInited = false
+	this.audioInited = false
+	this.keyframeGeted = false
+	this.vp9Header = nil
+}
+
 func (this *FMP4Creater) handleAudioTag(tag *flv.FlvTag) (slice *FMP4Slice) {
 	if this.audioInited == false {
 		this.audioInited = true
@@ -108,7 +128,11 @@ func (this *FMP4Creater) handleAudioTag(tag *flv.FlvTag) (slice *FMP4Slice) {
 }
 
 func (this *FMP4Creater) handleVideoTag(tag *flv.FlvTag) (slice *FMP4Slice) {
-	if tag.Data[0] != 0x17 && tag.Data[0] != 0x27 {
+	frameType := tag.Data[0] >> 4
+	codecId := flv.VideoCodecId(tag)
+	if (codecId != flv.CodecID_AVC && codecId != flv.CodecID_HEVC &&
+		codecId != flv.CodecID_AV1 && codecId != flv.CodecID_VP9) ||
+		(frameType != flv.FrameType_Keyframe && frameType != flv.FrameType_InterFrame) {
 		logger.LOGW(fmt.Sprintf("%d not support now", int(tag.Data[0])))
 		return
 	}
@@ -119,13 +143,24 @@ func (this *FMP4Creater) handleVideoTag(tag *flv.FlvTag) (slice *FMP4Slice) {
 			return
 		}
 		this.videoInited = true
+		this.videoCodec = codecId
+		if flv.CodecID_VP9 == codecId {
+			this.vp9Header = &flv.FlvTag{}
+			*this.vp9Header = *tag
+			this.vp9Header.Data = make([]byte, len(tag.Data))
+			copy(this.vp9Header.Data, tag.Data)
+			return
+		}
 		return this.createVideoInitSeg(tag)
 	} else {
 		if this.keyframeGeted {
 			return this.createVideoSeg(tag)
 		} else {
-			if tag.Data[0] == 0x17 && tag.Data[1] == 0x1 {
+			if flv.IsVideoKeyFrame(tag) {
 				this.keyframeGeted = true
+				if this.vp9Header != nil {
+					return this.createVP9InitSeg(tag)
+				}
 				return this.createVideoSeg(tag)
 			}
 		}
@@ -133,6 +168,25 @@ func (this *FMP4Creater) handleVideoTag(tag *flv.FlvTag) (slice *FMP4Slice) {
 	return
 }
 
+//init和第一个关键帧的segment合在一起发，MSE按顺序append没有问题
+func (this *FMP4Creater) createVP9InitSeg(keyframe *flv.FlvTag) (slice *FMP4Slice) {
+	var err error
+	this.width, this.height, err = vp9.ParseKeyFrameSize(keyframe.Data[5:])
+	if err != nil {
+		logger.LOGE(err.Error())
+	}
+	slice = this.createVideoInitSeg(this.vp9Header)
+	this.vp9Header = nil
+	if slice == nil {
+		return
+	}
+	seg := this.createVideoSeg(keyframe)
+	if seg != nil {
+		slice.Data = append(slice.Data, seg.Data...)
+	}
+	return
+}
+
 func (this *FMP4Creater) createAudioInitSeg(tag *flv.FlvTag) (slice *FMP4Slice) {
 	this.audioType = int(tag.Data[0] >> 4)
 	//logger.LOGT(tag.Data)
@@ -181,7 +235,9 @@ func (this *FMP4Creater) createAudioInitSeg(tag *flv.FlvTag) (slice *FMP4Slice)
 		logger.LOGT(this.audioSampleDuration)
 		logger.LOGT(this.audioSampleRate)
 		if mpeg4Asc.Ext_object_type == 0 {
-			this.ascData = tag.Data[2:]
+			//tag的Data会被回收复用，这里要保留，拷贝一份
+			this.ascData = make([]byte, len(tag.Data)-2)
+			copy(this.ascData, tag.Data[2:])
 			switch mpeg4Asc.Object_type {
 			case aac.AAC_Main:
 				this.audioCodecId = CODEC_ID_AAC_MAIN
@@ -555,7 +611,7 @@ func (this *FMP4Creater) createVideoInitSeg(tag *flv.FlvTag) (slice *FMP4Slice)
 	ftyp.PushBytes([]byte("isom"))
 	ftyp.Push4Bytes(1)
 	ftyp.PushBytes([]byte("isom"))
-	ftyp.PushBytes([]byte("avc1"))
+	ftyp.PushBytes([]byte(this.videoSampleEntry(tag)))
 	ftyp.Pop()
 	err := segEncoder.AppendByteArray(ftyp.Flush())
 	if err != nil {
@@ -623,9 +679,22 @@ func (this *FMP4Creater) createVideoInitSeg(tag *flv.FlvTag) (slice *FMP4Slice)
 	moovBox.Push4Bytes(0x0)
 	moovBox.Push4Bytes(0x40000000) //matrix
 	//parse sps ,get w h fps
-	tmpTagData := make([]byte, len(tag.Data))
-	copy(tmpTagData, tag.Data)
-	this.width, this.height, this.fps = h264.ParseSPS(tmpTagData[13:])
+	switch this.videoCodec {
+	case flv.CodecID_HEVC:
+		this.parseHEVCHeader(tag)
+	case flv.CodecID_AV1:
+		this.parseAV1Header(tag)
+	case flv.CodecID_VP9:
+		//createVP9InitSeg里已经取到
+	default:
+		tmpTagData := make([]byte, len(tag.Data))
+		copy(tmpTagData, tag.Data)
+		this.width, this.height, this.fps = h264.ParseSPS(tmpTagData[13:])
+	}
+	if this.fps <= 0 {
+		//sps里没有帧率，第一帧的duration用默认值
+		this.fps = 25
+	}
 	moovBox.Push4Bytes(uint32(this.width << 16))  //width
 	moovBox.Push4Bytes(uint32(this.height << 16)) //height
 	//!tkhd
@@ -771,11 +840,11 @@ func (this *FMP4Creater) createVideoSeg(tag *flv.FlvTag) (slice *FMP4Slice) {
 	flags.IsLeading = 0
 	flags.SampleHasRedundancy = 0
 
-	if tag.Data[0] == 0x17 {
+	if (tag.Data[0] >> 4) == flv.FrameType_Keyframe {
 		flags.SampleDependsOn = 2
 		flags.SampleIsDependedOn = 1
 		flags.IsAsync = 0
-	} else if tag.Data[0] == 0x27 {
+	} else if (tag.Data[0] >> 4) == flv.FrameType_InterFrame {
 		flags.SampleDependsOn = 1
 		flags.SampleIsDependedOn = 0
 		flags.IsAsync = 1
@@ -883,8 +952,8 @@ func (this *FMP4Creater) stsdV(box *MP4Box, tag *flv.FlvTag) {
 	box.Push([]byte("stsd"))
 	box.Push4Bytes(0)
 	box.Push4Bytes(1)
-	//avc1
-	box.Push([]byte("avc1"))
+	//avc1 hvc1 hev1 av01 vp09
+	box.Push([]byte(this.videoSampleEntry(tag)))
 	box.Push4Bytes(0)
 	box.Push2Bytes(0)
 	box.Push2Bytes(1)
@@ -905,8 +974,19 @@ func (this *FMP4Creater) stsdV(box *MP4Box, tag *flv.FlvTag) {
 	box.PushBytes(spaceEnd)
 	box.Push2Bytes(0x18)
 	box.Push2Bytes(0xffff)
-	//avcC
-	box.Push([]byte("avcC"))
+	//avcC hvcC av1C vpcC
+	switch this.videoCodec {
+	case flv.CodecID_HEVC:
+		box.Push([]byte("hvcC"))
+	case flv.CodecID_AV1:
+		box.Push([]byte("av1C"))
+	case flv.CodecID_VP9:
+		//FullBox,version 1
+		box.Push([]byte("vpcC"))
+		box.Push4Bytes(0x01000000)
+	default:
+		box.Push([]byte("avcC"))
+	}
 	box.PushBytes(tag.Data[5:])
 	//!avcC
 	box.Pop()
@@ -917,6 +997,55 @@ func (this *FMP4Creater) stsdV(box *MP4Box, tag *flv.FlvTag) {
 	return
 }
 
+func (this *FMP4Creater) parseHEVCHeader(tag *flv.FlvTag) {
+	hvcc, err := h265.ParseHVCC(tag.Data[5:])
+	if err != nil {
+		logger.LOGE(err.Error())
+		return
+	}
+	this.fps = int(hvcc.AvgFrameRate) / 256
+	if len(hvcc.SPS) > 0 {
+		this.width, this.height, err = h265.ParseSPS(hvcc.SPS[0])
+		if err != nil {
+			logger.LOGE(err.Error())
+		}
+	}
+}
+
+func (this *FMP4Creater) parseAV1Header(tag *flv.FlvTag) {
+	av1c, err := av1.ParseAV1C(tag.Data[5:])
+	if err != nil {
+		logger.LOGE(err.Error())
+		return
+	}
+	seq, err := av1.FindSequenceHeader(av1c.ConfigOBUs)
+	if err != nil {
+		logger.LOGE(err.Error())
+		return
+	}
+	this.width, this.height, err = av1.ParseSequenceHeader(seq)
+	if err != nil {
+		logger.LOGE(err.Error())
+	}
+}
+
+func (this *FMP4Creater) videoSampleEntry(tag *flv.FlvTag) string {
+	switch this.videoCodec {
+	case flv.CodecID_AV1:
+		return flv.FourCC_AV1
+	case flv.CodecID_VP9:
+		return flv.FourCC_VP9
+	}
+	if flv.CodecID_HEVC != this.videoCodec {
+		return "avc1"
+	}
+	hvcc, err := h265.ParseHVCC(tag.Data[5:])
+	if err != nil {
+		return "hev1"
+	}
+	return hevcSampleEntry(hvcc)
+}
+
 func (this *FMP4Creater) stsdA(box *MP4Box, tag *flv.FlvTag) {
 	//stsd
 	box.Push([]byte("stsd"))
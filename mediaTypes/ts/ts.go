@@ -6,7 +6,8 @@ import (
 	"mediaTypes/aac"
 	"mediaTypes/flv"
 	"mediaTypes/h264"
-	"mediaTypes/mp3"
+	"mediaTypes/h265"
+	"wssAPI"
 )
 
 var crc32Table []uint32
@@ -48,10 +49,11 @@ func Crc32Calculate(buffer []uint8) (crc32reg uint32) {
 type TsCreater struct {
 	tsVcount    int16
 	tsAcount    int16
-	audioHeader []byte
+	audioConfig *wssAPI.CodecConfig
 	//asc                      aac.AudioSpecificConfig
 	asc                      *aac.MP4AACAudioSpecificConfig
-	videoHeader              []byte
+	videoConfig              *wssAPI.CodecConfig
+	vps                      []byte
 	sps                      []byte
 	pps                      []byte
 	sei                      []byte
@@ -66,6 +68,7 @@ type TsCreater struct {
 	keyframeWrited           bool
 	encodeAudio              bool
 	data_alignment_indicator bool
+	converter                flv.PacketConverter //AddTag用
 }
 
 func (this *TsCreater) Reset() {
@@ -93,8 +96,23 @@ func (this *TsCreater) writePCR(buf []byte, pcr, pcrExt uint64) {
 	cur++
 }
 
+//flv tag先转成MediaPacket，源已经转好的直接用AddPacket
 func (this *TsCreater) AddTag(tag *flv.FlvTag) {
-	if flv.FLV_TAG_ScriptData == tag.TagType {
+	pkt, err := this.converter.Convert(tag)
+	if err != nil {
+		logger.LOGE(err.Error())
+		return
+	}
+	if nil == pkt {
+		return
+	}
+	this.AddPacket(pkt)
+	pkt.Release()
+}
+
+//payload都拷贝出来，调用者可以马上Release
+func (this *TsCreater) AddPacket(pkt *wssAPI.MediaPacket) {
+	if wssAPI.MEDIA_TYPE_DATA == pkt.MediaType {
 		return
 	}
 	if this.tsCache == nil {
@@ -102,11 +120,11 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 		this.encodeAudio = TS_VIDEO_ONLY
 		this.tsCache = list.New()
 	}
-	this.nowTime = tag.Timestamp
-	if true == this.avHeaderAdded(tag) {
+	this.nowTime = pkt.Dts
+	if true == this.avHeaderAdded(pkt) {
 		if 0xffffffff == this.beginTime {
-			this.beginTime = tag.Timestamp
-			if this.audioHeader == nil || TS_VIDEO_ONLY {
+			this.beginTime = pkt.Dts
+			if this.audioConfig == nil || TS_VIDEO_ONLY {
 				this.encodeAudio = false
 			} else {
 				this.encodeAudio = true
@@ -114,7 +132,7 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 			this.addPatPmt()
 		}
 		var addDts, addPCR bool
-		if flv.FLV_TAG_Audio == tag.TagType {
+		if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType {
 			addDts = false
 			addPCR = false
 			if TS_VIDEO_ONLY {
@@ -131,13 +149,13 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 		var dataPayload []byte
 		var payloadSize int
 
-		if flv.FLV_TAG_Audio == tag.TagType {
-			dataPayload, payloadSize = this.audioPayload(tag)
+		if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType {
+			dataPayload, payloadSize = this.audioPayload(pkt)
 			if 0 == payloadSize || nil == dataPayload {
 				return
 			}
-		} else if flv.FLV_TAG_Video == tag.TagType {
-			dataPayload = this.videoPayload(tag)
+		} else if wssAPI.MEDIA_TYPE_VIDEO == pkt.MediaType {
+			dataPayload = this.videoPayload(pkt)
 			if nil == dataPayload {
 				logger.LOGE(dataPayload)
 				return
@@ -146,14 +164,14 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 		}
 
 		tsCount, padSize = this.getTsCount(payloadSize, addPCR, addDts)
-		if tag.TagType==flv.FLV_TAG_Audio{
-			this.calAudioTime(tag)
+		if pkt.MediaType==wssAPI.MEDIA_TYPE_AUDIO{
+			this.calAudioTime(pkt)
 
 		}
 		tsBuf := make([]byte, TS_length)
 		cur := 0
 
-		pcr, pcrExt, pcrPts, pcrDts := this.calPcrPtsDts(tag)
+		pcr, pcrExt, pcrPts, pcrDts := this.calPcrPtsDts(pkt)
 
 		//logger.LOGD(pcr,pcrPts,pcrDts-pcrPts)
 		if 1 == tsCount {
@@ -163,7 +181,7 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 			cur = 0
 			tsBuf[cur] = 0x47
 			cur++
-			if flv.FLV_TAG_Audio == tag.TagType {
+			if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType {
 				tmp16 = uint16(0x4000 | Audio_Id)
 			} else {
 				tmp16 = uint16(0x4000 | Video_Id)
@@ -173,7 +191,7 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 			tsBuf[cur] = byte(tmp16 & 0xff)
 			cur++
 			if addPCR || padSize > 0 {
-				if flv.FLV_TAG_Audio == tag.TagType {
+				if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType {
 					tsBuf[cur] = byte(0x30 | this.tsAcount)
 					cur++
 				} else {
@@ -181,7 +199,7 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 					cur++
 				}
 			} else {
-				if flv.FLV_TAG_Audio == tag.TagType {
+				if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType {
 					tsBuf[cur] = byte(0x10 | this.tsAcount)
 					cur++
 				} else {
@@ -189,7 +207,7 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 					cur++
 				}
 			}
-			if flv.FLV_TAG_Audio == tag.TagType {
+			if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType {
 				this.tsAcount++
 				if this.tsAcount == 16 {
 					this.tsAcount = 0
@@ -231,7 +249,7 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 			cur++
 			tsBuf[cur] = 0x01
 			cur++
-			if flv.FLV_TAG_Audio == tag.TagType {
+			if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType {
 				tsBuf[cur] = 0xc0
 				cur++
 				tmp16 = uint16(payloadSize + 8)
@@ -322,7 +340,7 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 				if 0 == i {
 					tsBuf[cur] = 0x47
 					cur++
-					if flv.FLV_TAG_Audio == tag.TagType {
+					if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType {
 						tmp16 = uint16(0x4000 | Audio_Id)
 					} else {
 						tmp16 = uint16(0x4000 | Video_Id)
@@ -332,7 +350,7 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 					tsBuf[cur] = byte(tmp16 & 0xff)
 					cur++
 					if addPCR {
-						if flv.FLV_TAG_Audio == tag.TagType {
+						if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType {
 							tsBuf[cur] = byte(0x30 | this.tsAcount)
 							cur++
 						} else {
@@ -340,7 +358,7 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 							cur++
 						}
 					} else {
-						if flv.FLV_TAG_Audio == tag.TagType {
+						if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType {
 							tsBuf[cur] = byte(0x10 | this.tsAcount)
 							cur++
 						} else {
@@ -349,7 +367,7 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 						}
 					}
 
-					if flv.FLV_TAG_Audio == tag.TagType {
+					if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType {
 						this.tsAcount++
 						if this.tsAcount == 16 {
 							this.tsAcount = 0
@@ -382,7 +400,7 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 					cur++
 					tsBuf[cur] = 0x01
 					cur++
-					if flv.FLV_TAG_Audio == tag.TagType {
+					if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType {
 						tsBuf[cur] = 0xc0
 						cur++
 						tmp16 = uint16(payloadSize + 8)
@@ -462,7 +480,7 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 					//四字节头
 					tsBuf[cur] = 0x47
 					cur++
-					if flv.FLV_TAG_Audio == tag.TagType {
+					if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType {
 						tmp16 = uint16(Audio_Id)
 					} else {
 						tmp16 = uint16(Video_Id)
@@ -474,7 +492,7 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 					//!3字节头
 					if i == tsCount-1 && padSize != 0 {
 						//最后一帧，且有pad
-						if flv.FLV_TAG_Audio == tag.TagType {
+						if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType {
 							tsBuf[cur] = byte(0x30 | this.tsAcount)
 							cur++
 						} else {
@@ -491,7 +509,7 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 						payloadCur += TS_length - 4 - padSize
 					} else {
 						//普通添加数据
-						if flv.FLV_TAG_Audio == tag.TagType {
+						if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType {
 							tsBuf[cur] = byte(0x10 | this.tsAcount)
 							cur++
 						} else {
@@ -503,7 +521,7 @@ func (this *TsCreater) AddTag(tag *flv.FlvTag) {
 						copy(tsBuf[cur:], tmps)
 						payloadCur += TS_length - cur
 					}
-					if flv.FLV_TAG_Audio == tag.TagType {
+					if wssAPI.MEDIA_TYPE_AUDIO == pkt.MediaType {
 						this.tsAcount++
 						if this.tsAcount == 16 {
 							this.tsAcount = 0
@@ -533,72 +551,149 @@ func (this *TsCreater) FlushTsList() (tsList *list.List) {
 	return tsList
 }
 
-func (this *TsCreater) avHeaderAdded(tag *flv.FlvTag) (headerGeted bool) {
-	if TS_VIDEO_ONLY && this.videoHeader != nil {
+//编码参数来自MediaPacket的Config，sequence header本身不封装
+func (this *TsCreater) avHeaderAdded(pkt *wssAPI.MediaPacket) (headerGeted bool) {
+	if TS_VIDEO_ONLY && this.videoConfig != nil {
 		return true
 	}
-	if this.audioHeader != nil && this.videoHeader != nil {
+	if this.audioConfig != nil && this.videoConfig != nil {
 		return true
 	}
 	this.beginTime = 0xffffffff
-	if tag.TagType == flv.FLV_TAG_Audio {
-		if this.audioHeader != nil {
+	if nil == pkt.Config {
+		return false
+	}
+	if pkt.MediaType == wssAPI.MEDIA_TYPE_AUDIO {
+		if this.audioConfig != nil {
 			//防止只有音频的情况
 			return true
 		}
-		this.audioHeader = make([]byte, len(tag.Data))
-		copy(this.audioHeader, tag.Data)
-		this.parseAudioType(this.audioHeader)
-		return false
+		this.audioConfig = pkt.Config
+		this.parseAudioType(this.audioConfig)
+		//mp3没有头，第一帧就要封装
+		return false == pkt.IsConfig
 	}
-	if tag.TagType == flv.FLV_TAG_Video {
-		if this.videoHeader != nil {
+	if pkt.MediaType == wssAPI.MEDIA_TYPE_VIDEO {
+		if this.videoConfig != nil {
 			//防止没有音频的情况
 			return true
 		}
-		this.videoHeader = make([]byte, len(tag.Data))
-		copy(this.videoHeader, tag.Data)
-		this.videoTypeId = 0x1b
-		this.parseAVC(this.videoHeader)
+		this.videoConfig = pkt.Config
+		if wssAPI.CODEC_H265 == this.videoConfig.CodecId {
+			this.videoTypeId = 0x24
+			this.parseHEVC(this.videoConfig.Data)
+		} else {
+			this.videoTypeId = 0x1b
+			this.parseAVC(this.videoConfig.Data)
+		}
 		return false
 	}
 	return false
 }
 
-func (this *TsCreater) parseAudioType(data []byte) {
-	audioCodec := data[0] >> 4
-	switch audioCodec {
-	case flv.SoundFormat_AAC:
+func (this *TsCreater) parseAudioType(cfg *wssAPI.CodecConfig) {
+	switch cfg.CodecId {
+	case wssAPI.CODEC_AAC:
 		this.audioFrameSize = 1024
 		//this.asc = aac.GenerateAudioSpecificConfig(data[2:])
-		this.asc = aac.MP4AudioGetConfig(data[2:])
+		this.asc = aac.MP4AudioGetConfig(cfg.Data)
 		this.audioSampleHz = int(this.asc.Sample_rate)
 		this.audioTypeId = 0x0f
-	case flv.SoundFormat_MP3:
+	case wssAPI.CODEC_MP3:
 		this.audioFrameSize = 1152
-		mp3Header, err := mp3.ParseMP3Header(data[1:])
-		if err != nil {
-			logger.LOGE(err.Error())
-			return
-		}
-		this.audioSampleHz = mp3Header.SampleRate
-		if mp3Header.Version == 3 {
+		this.audioSampleHz = cfg.SampleRate
+		//MPEG-1的采样率是32k 44.1k 48k
+		if cfg.SampleRate >= 32000 {
 			this.audioTypeId = 0x03
 		} else {
 			this.audioTypeId = 0x04
 		}
 	default:
-		logger.LOGE("ts audio type not supported", audioCodec)
+		logger.LOGE("ts audio type not supported", cfg.CodecId)
 		return
 	}
 
 }
 
+//avcC
 func (this *TsCreater) parseAVC(data []byte) {
-	if data[0] == 0x17 && data[1] == 0 {
-		//avc
-		this.sps, this.pps = h264.GetSpsPpsFromAVC(data[5:])
+	this.sps, this.pps = h264.GetSpsPpsFromAVC(data)
+}
+
+//hvcC
+func (this *TsCreater) parseHEVC(data []byte) {
+	hvcc, err := h265.ParseHVCC(data)
+	if err != nil {
+		logger.LOGE(err.Error())
+		return
+	}
+	if len(hvcc.VPS) > 0 {
+		this.vps = hvcc.VPS[0]
+	}
+	if len(hvcc.SPS) > 0 {
+		this.sps = hvcc.SPS[0]
+	}
+	if len(hvcc.PPS) > 0 {
+		this.pps = hvcc.PPS[0]
+	}
+}
+
+//hevc转annexb，IRAP前加AUD和VPS SPS PPS
+func (this *TsCreater) hevcPayload(pkt *wssAPI.MediaPacket) (payload []byte) {
+	if pkt.IsConfig {
+		this.parseHEVC(pkt.Data)
+		return nil
+	}
+	startCode := []byte{0x00, 0x00, 0x01}
+	getKeyframe := false
+	nals := make([]byte, 0, len(pkt.Data))
+	nalCur := 0
+	for nalCur+4 <= len(pkt.Data) {
+		nalSize := (int(pkt.Data[nalCur]) << 24) | (int(pkt.Data[nalCur+1]) << 16) |
+			(int(pkt.Data[nalCur+2]) << 8) | (int(pkt.Data[nalCur+3]) << 0)
+		nalCur += 4
+		if nalSize <= 0 || nalCur+nalSize > len(pkt.Data) {
+			break
+		}
+		nal := pkt.Data[nalCur : nalCur+nalSize]
+		nalCur += nalSize
+		switch nalType := h265.NalType(nal[0]); {
+		case nalType == h265.Nal_type_vps:
+			this.vps = append([]byte(nil), nal...)
+		case nalType == h265.Nal_type_sps:
+			this.sps = append([]byte(nil), nal...)
+		case nalType == h265.Nal_type_pps:
+			this.pps = append([]byte(nil), nal...)
+		case nalType == h265.Nal_type_aud:
+		default:
+			if h265.IsIRAP(nalType) {
+				getKeyframe = true
+				this.keyframeWrited = true
+			}
+			nals = append(nals, startCode...)
+			nals = append(nals, nal...)
+		}
+	}
+	if false == getKeyframe && this.keyframeWrited == false {
+		logger.LOGE("no keyframe")
+		return nil
 	}
+	if len(nals) == 0 {
+		logger.LOGE("no frame")
+		return nil
+	}
+	//aud,pic_type=2
+	payload = append(payload, 0x00, 0x00, 0x01, 0x46, 0x01, 0x50)
+	if getKeyframe {
+		for _, ps := range [][]byte{this.vps, this.sps, this.pps} {
+			if len(ps) > 0 {
+				payload = append(payload, startCode...)
+				payload = append(payload, ps...)
+			}
+		}
+	}
+	payload = append(payload, nals...)
+	return payload
 }
 
 func (this *TsCreater) addPatPmt() {
@@ -788,51 +883,61 @@ func (this *TsCreater) getTsCount(dataSize int, addPCR, addDts bool) (tsCount, p
 	return
 }
 
-func (this *TsCreater) videoPayload(tag *flv.FlvTag) (payload []byte) {
-	if tag.Data[0] == 0x17 && tag.Data[1] == 0 {
-		this.parseAVC(tag.Data)
+func (this *TsCreater) videoPayload(pkt *wssAPI.MediaPacket) (payload []byte) {
+	if wssAPI.CODEC_H265 == pkt.CodecId {
+		return this.hevcPayload(pkt)
+	}
+	if wssAPI.CODEC_H264 != pkt.CodecId {
+		//ts只封装h264 h265
 		return nil
 	}
-	nalCur := 5
+	if pkt.IsConfig {
+		this.parseAVC(pkt.Data)
+		return nil
+	}
+	nalCur := 0
 	getKeyframe := false
 	nalList := list.New()
 	totalNalSize := 0
-	for nalCur < len(tag.Data) {
+	for nalCur+4 <= len(pkt.Data) {
 		nalSize := 0
-		nalSizeSlice := tag.Data[nalCur : nalCur+4]
+		nalSizeSlice := pkt.Data[nalCur : nalCur+4]
 		nalSize = (int(nalSizeSlice[0]) << 24) | (int(nalSizeSlice[1]) << 16) |
 			(int(nalSizeSlice[2]) << 8) | (int(nalSizeSlice[3]) << 0)
 		nalCur += 4
-		nalType := tag.Data[nalCur] & 0x1f
+		if nalSize <= 0 || nalCur+nalSize > len(pkt.Data) {
+			break
+		}
+		nalType := pkt.Data[nalCur] & 0x1f
 
 		switch nalType {
 		case h264.Nal_type_sei:
 			this.sei = make([]byte, nalSize)
-			copy(this.sei, tag.Data[nalCur:nalCur+nalSize])
+			copy(this.sei, pkt.Data[nalCur:nalCur+nalSize])
 		case h264.Nal_type_sps:
 			this.sps = make([]byte, nalSize)
-			copy(this.sps, tag.Data[nalCur:nalCur+nalSize])
+			copy(this.sps, pkt.Data[nalCur:nalCur+nalSize])
 		case h264.Nal_type_pps:
 			this.pps = make([]byte, nalSize)
-			copy(this.pps, tag.Data[nalCur:nalCur+nalSize])
+			copy(this.pps, pkt.Data[nalCur:nalCur+nalSize])
 		case h264.Nal_type_idr:
 			getKeyframe = true
 			this.keyframeWrited = true
 			totalNalSize += nalSize + 3
 			tmp := make([]byte, nalSize)
-			copy(tmp, tag.Data[nalCur:nalCur+nalSize])
+			copy(tmp, pkt.Data[nalCur:nalCur+nalSize])
 			nalList.PushBack(tmp)
 		case h264.Nal_type_aud:
 			if /*0!=totalNalSize&&*/ nalSize != 2 {
 				totalNalSize += nalSize + 3
 				tmp := make([]byte, nalSize)
-				copy(tmp, tag.Data[nalCur:nalCur+nalSize])
+				copy(tmp, pkt.Data[nalCur:nalCur+nalSize])
 				nalList.PushBack(tmp)
 			}
 		default:
 			totalNalSize += nalSize + 3
 			tmp := make([]byte, nalSize)
-			copy(tmp, tag.Data[nalCur:nalCur+nalSize])
+			copy(tmp, pkt.Data[nalCur:nalCur+nalSize])
 			nalList.PushBack(tmp)
 		}
 		nalCur += nalSize
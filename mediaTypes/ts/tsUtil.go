@@ -3,23 +3,26 @@ package ts
 import (
 	"logger"
 	"mediaTypes/aac"
-	"mediaTypes/amf"
-	"mediaTypes/flv"
+	"wssAPI"
 )
 
-func (this *TsCreater) audioPayload(tag *flv.FlvTag) (payload []byte, size int) {
+//pkt.Data是去掉flv头的raw帧
+func (this *TsCreater) audioPayload(pkt *wssAPI.MediaPacket) (payload []byte, size int) {
+	if pkt.IsConfig {
+		return
+	}
 	if this.audioTypeId == 0xf {
 		//adth:=aac.GenerateADTHeader(this.asc,len(tag.Data)-2)
-		adth := aac.CreateAACADTHeader(this.asc, len(tag.Data)-2)
-		size = len(adth) + len(tag.Data) - 2
+		adth := aac.CreateAACADTHeader(this.asc, len(pkt.Data))
+		size = len(adth) + len(pkt.Data)
 		payload = make([]byte, size)
 		copy(payload, adth)
-		copy(payload[len(adth):], tag.Data[2:])
+		copy(payload[len(adth):], pkt.Data)
 		return
 	} else if this.audioTypeId == 0x03 || this.audioTypeId == 0x04 {
-		size = len(tag.Data) - 1
+		size = len(pkt.Data)
 		payload = make([]byte, size)
-		copy(payload, tag.Data[1:])
+		copy(payload, pkt.Data)
 		return
 	} else {
 		logger.LOGF(this.audioTypeId)
@@ -28,26 +31,22 @@ func (this *TsCreater) audioPayload(tag *flv.FlvTag) (payload []byte, size int)
 	return
 }
 
-func (this *TsCreater) calPcrPtsDts(tag *flv.FlvTag) (pcr, pcrExt, pts, dts uint64) {
-	timeMS := uint64(tag.Timestamp )
+//pts已经加上了composition time
+func (this *TsCreater) calPcrPtsDts(pkt *wssAPI.MediaPacket) (pcr, pcrExt, pts, dts uint64) {
+	timeMS := uint64(pkt.Dts)
 	pcr = (timeMS * 90) & 0x1ffffffff
 	pcrExt = (timeMS * PCR_HZ / 1000) & 0x1ff
-	if len(tag.Data) < 5 {
-		logger.LOGF("wtf")
-	}
-	compositionTime, _ := amf.AMF0DecodeInt24(tag.Data[2:])
-	u64 := uint64(compositionTime)
 	dts = timeMS*90 + 90
-	pts = dts + u64*90
+	pts = uint64(pkt.Pts)*90 + 90
 	return
 }
 
-func (this *TsCreater)calAudioTime(tag *flv.FlvTag)  {
+func (this *TsCreater)calAudioTime(pkt *wssAPI.MediaPacket)  {
 	//tmp:=int64(90*this.audioSampleHz*int(tag.Timestamp-this.beginTime))
-	tmp:=int64(90*int(tag.Timestamp))
+	tmp:=int64(90*int(pkt.Dts))
 	//logger.LOGT(tmp,tag.Timestamp-this.beginTime)
 	//audioPtsDelta := int64(90000 * int64(this.audioFrameSize) / int64(this.audioSampleHz))
 	//this.audioPts += audioPtsDelta
 	//logger.LOGD(this.audioPts)
 	this.audioPts=tmp
-}
\ No newline at end of file
+}
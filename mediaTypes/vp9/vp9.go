@@ -0,0 +1,90 @@
+package vp9
+
+import (
+	"errors"
+	"wssAPI"
+)
+
+const (
+	Frame_type_key     = 0
+	Frame_type_non_key = 1
+
+	cs_rgb = 7
+)
+
+//vpcC，FullBox的version和flags已经去掉
+type VPCodecConfigurationRecord struct {
+	Profile                 byte
+	Level                   byte
+	BitDepth                byte
+	ChromaSubsampling       byte
+	VideoFullRangeFlag      byte
+	ColourPrimaries         byte
+	TransferCharacteristics byte
+	MatrixCoefficients      byte
+	CodecInitData           []byte
+}
+
+func ParseVPCC(data []byte) (vpcc *VPCodecConfigurationRecord, err error) {
+	if len(data) < 8 {
+		return nil, errors.New("vpcC too short")
+	}
+	vpcc = &VPCodecConfigurationRecord{}
+	vpcc.Profile = data[0]
+	vpcc.Level = data[1]
+	vpcc.BitDepth = data[2] >> 4
+	vpcc.ChromaSubsampling = (data[2] >> 1) & 0x7
+	vpcc.VideoFullRangeFlag = data[2] & 1
+	vpcc.ColourPrimaries = data[3]
+	vpcc.TransferCharacteristics = data[4]
+	vpcc.MatrixCoefficients = data[5]
+	size := int(data[6])<<8 | int(data[7])
+	if 8+size > len(data) {
+		return nil, errors.New("vpcC init data truncated")
+	}
+	vpcc.CodecInitData = data[8 : 8+size]
+	return
+}
+
+//vpcC里没有分辨率，只能从关键帧的uncompressed header里取
+func ParseKeyFrameSize(frame []byte) (width, height int, err error) {
+	if len(frame) < 10 {
+		return 0, 0, errors.New("vp9 frame too short")
+	}
+	bit := &wssAPI.BitReader{}
+	bit.Init(frame)
+	if 2 != bit.ReadBits(2) {
+		return 0, 0, errors.New("invalid vp9 frame marker")
+	}
+	profile := bit.ReadBit()
+	profile |= bit.ReadBit() << 1
+	if 3 == profile {
+		bit.ReadBit()
+	}
+	if 1 == bit.ReadBit() {
+		return 0, 0, errors.New("vp9 show existing frame")
+	}
+	if Frame_type_key != bit.ReadBit() {
+		return 0, 0, errors.New("not a vp9 key frame")
+	}
+	bit.ReadBit() //show_frame
+	bit.ReadBit() //error_resilient_mode
+	if 0x498342 != bit.ReadBits(24) {
+		return 0, 0, errors.New("invalid vp9 sync code")
+	}
+	//color_config
+	if profile >= 2 {
+		bit.ReadBit() //ten_or_twelve_bit
+	}
+	if cs_rgb != bit.ReadBits(3) {
+		bit.ReadBit() //color_range
+		if 1 == profile || 3 == profile {
+			bit.ReadBits(3)
+		}
+	} else if 1 == profile || 3 == profile {
+		bit.ReadBit()
+	}
+	width = bit.ReadBits(16) + 1
+	height = bit.ReadBits(16) + 1
+	return
+}
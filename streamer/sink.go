@@ -7,9 +7,11 @@ import (
 )
 
 type streamSink struct {
-	id     string
-	sinker wssAPI.Obj
-	parent wssAPI.Obj
+	id           string
+	sinker       wssAPI.Obj
+	parent       wssAPI.Obj
+	acceptPacket bool //true 收MSG_MEDIA_PACKET,false 收MSG_FLV_TAG
+	acceptGop    bool //加入时要最近的GOP
 }
 
 func (this *streamSink) Init(msg *wssAPI.Msg) (err error) {
@@ -18,6 +20,12 @@ func (this *streamSink) Init(msg *wssAPI.Msg) (err error) {
 	}
 	this.id = msg.Param1.(string)
 	this.sinker = msg.Param2.(wssAPI.Obj)
+	if pktSinker, ok := this.sinker.(wssAPI.MediaPacketSinker); ok {
+		this.acceptPacket = pktSinker.AcceptMediaPacket()
+	}
+	if gopSinker, ok := this.sinker.(wssAPI.GopSinker); ok {
+		this.acceptGop = gopSinker.AcceptGop()
+	}
 	return
 }
 
@@ -58,7 +66,7 @@ func (this *streamSink) HandleTask(task *wssAPI.Task) (err error) {
 
 func (this *streamSink) ProcessMessage(msg *wssAPI.Msg) (err error) {
 
-	if this.sinker != nil && msg.Type == wssAPI.MSG_FLV_TAG {
+	if this.sinker != nil && (msg.Type == wssAPI.MSG_FLV_TAG || msg.Type == wssAPI.MSG_MEDIA_PACKET) {
 		return this.sinker.ProcessMessage(msg)
 	}
 	return
@@ -1,6 +1,7 @@
 package streamer
 
 import (
+	"container/list"
 	"errors"
 	"fmt"
 	"logger"
@@ -21,13 +22,20 @@ type streamSource struct {
 	audioHeader  *flv.FlvTag
 	videoHeader  *flv.FlvTag
 	lastKeyFrame *flv.FlvTag
+	gop          *list.List //最近一个关键帧开始的音视频
+	converter    flv.PacketConverter
+	packetSinks  int //要MediaPacket的sink个数，没有就不转换
 	createId     int64
 	mutexId      sync.RWMutex
 	dataProducer wssAPI.Obj
 }
 
+//GOP太长就不缓存了，等下一个关键帧
+const stream_gop_max = 2048
+
 func (this *streamSource) Init(msg *wssAPI.Msg) (err error) {
 	this.sinks = make(map[string]*streamSink)
+	this.gop = list.New()
 	this.streamName = msg.Param1.(string)
 	return
 }
@@ -55,37 +63,89 @@ func (this *streamSource) ProcessMessage(msg *wssAPI.Msg) (err error) {
 			return errors.New("src may closed or invalid")
 		}
 		tag := msg.Param1.(*flv.FlvTag)
+		//Enhanced RTMP的tag原样转发，flv的播放端收到的和推上来的一样
+		//要老格式的封装自己用flv.NormalizedVideoTag
+		if flv.FLV_TAG_Video == tag.TagType {
+			if _, err = flv.ParseVideoTagHeader(tag); err != nil {
+				logger.LOGW(err.Error())
+				return nil
+			}
+		}
+		//缓存和sink共享同一个tag，不拷贝
+		this.mutexSink.Lock()
 		switch tag.TagType {
 		case flv.FLV_TAG_Audio:
 			if this.audioHeader == nil {
-				this.audioHeader = tag.Copy()
-				this.audioHeader.Timestamp = 0
+				this.audioHeader = tag.WithTimestamp(0)
+			} else if this.gop.Len() > 0 && false == flv.IsAudioSequenceHeader(tag) {
+				this.appendGop(tag)
 			}
 		case flv.FLV_TAG_Video:
 			if this.videoHeader == nil {
-				this.videoHeader = tag.Copy()
-				this.videoHeader.Timestamp = 0
+				this.videoHeader = tag.WithTimestamp(0)
 			}
-			if (tag.Data[0] >> 4) == 1 {
-				this.lastKeyFrame = tag.Copy()
+			if flv.VideoFrameType(tag) == flv.FrameType_Keyframe {
+				if this.lastKeyFrame != nil {
+					this.lastKeyFrame.Release()
+				}
+				this.lastKeyFrame = tag.Retain()
+			}
+			if flv.IsVideoKeyFrame(tag) {
+				this.clearGop()
+				this.gop.PushBack(tag.Retain())
+			} else if this.gop.Len() > 0 && false == flv.IsVideoSequenceHeader(tag) {
+				this.appendGop(tag)
 			}
 
 		case flv.FLV_TAG_ScriptData:
 			if this.metadata == nil {
-				this.metadata = tag.Copy()
+				this.metadata = tag.Retain()
 			}
 		}
+		this.mutexSink.Unlock()
 		this.mutexSink.RLock()
-		defer this.mutexSink.RUnlock()
+		//只转换一次，所有要MediaPacket的sink共享
+		//没有这样的sink时只转头，记住编码参数
+		pktMsg := &wssAPI.Msg{Type: wssAPI.MSG_MEDIA_PACKET}
+		var pkt *wssAPI.MediaPacket
+		if this.packetSinks > 0 || flv.IsAudioSequenceHeader(tag) || flv.IsVideoSequenceHeader(tag) {
+			pkt, err = this.converter.Convert(tag)
+			if err != nil {
+				logger.LOGW(err.Error())
+				err = nil
+			}
+			if pkt != nil {
+				pktMsg.Param1 = pkt
+				defer pkt.Release()
+			}
+		}
+		var failed []string
 		for k, v := range this.sinks {
-			err = v.ProcessMessage(msg)
+			if v.acceptPacket {
+				if pkt == nil {
+					continue
+				}
+				err = v.ProcessMessage(pktMsg)
+			} else {
+				err = v.ProcessMessage(msg)
+			}
 			if err != nil {
 				logger.LOGE("send msg to sink failed,delete it:" + k)
-				delete(this.sinks, k)
-				v.Stop(nil)
+				failed = append(failed, k)
 				err = nil //这不是源的锅
 			}
 		}
+		this.mutexSink.RUnlock()
+		//sink的Stop可能会回来删sink，不能拿着锁调
+		for _, k := range failed {
+			this.mutexSink.Lock()
+			sink, exist := this.sinks[k]
+			this.removeSink(k)
+			this.mutexSink.Unlock()
+			if exist {
+				sink.Stop(nil)
+			}
+		}
 		return
 	default:
 		logger.LOGW(fmt.Sprintf("msg type %d not processed", msg.Type))
@@ -97,6 +157,12 @@ func (this *streamSource) HasProducer() bool {
 	return this.bProducer
 }
 
+func (this *streamSource) SinkCount() int {
+	this.mutexSink.RLock()
+	defer this.mutexSink.RUnlock()
+	return len(this.sinks)
+}
+
 func (this *streamSource) SetProducer(status bool) (remove bool) {
 	if status == this.bProducer {
 		return
@@ -153,22 +219,21 @@ func (this *streamSource) AddSink(id string, sinker wssAPI.Obj) (err error) {
 	}
 
 	this.sinks[id] = sink
+	if sink.acceptPacket {
+		this.packetSinks++
+	}
 	if this.bProducer {
 		err = sink.Start(nil)
+		//头和GOP用同一个converter，GOP里的包才有编码参数
+		converter := &flv.PacketConverter{}
 		if this.metadata != nil {
-			msg.Param1 = this.metadata
-			msg.Type = wssAPI.MSG_FLV_TAG
-			sink.ProcessMessage(msg)
+			this.sendCachedTag(sink, this.metadata, converter)
 		}
 		if this.audioHeader != nil {
-			msg.Param1 = this.audioHeader
-			msg.Type = wssAPI.MSG_FLV_TAG
-			sink.ProcessMessage(msg)
+			this.sendCachedTag(sink, this.audioHeader, converter)
 		}
 		if this.videoHeader != nil {
-			msg.Param1 = this.videoHeader
-			msg.Type = wssAPI.MSG_FLV_TAG
-			sink.ProcessMessage(msg)
+			this.sendCachedTag(sink, this.videoHeader, converter)
 		}
 		if this.lastKeyFrame != nil {
 			msg.Param1 = this.lastKeyFrame
@@ -176,16 +241,72 @@ func (this *streamSource) AddSink(id string, sinker wssAPI.Obj) (err error) {
 			logger.LOGD("not send last keyframe")
 			//			sink.ProcessMessage(msg)
 		}
+		if sink.acceptGop {
+			for e := this.gop.Front(); e != nil; e = e.Next() {
+				this.sendCachedTag(sink, e.Value.(*flv.FlvTag), converter)
+			}
+		}
 	}
 	return
 }
 
+//调用者持有mutexSink
+func (this *streamSource) removeSink(id string) {
+	sink, exist := this.sinks[id]
+	if false == exist {
+		return
+	}
+	if sink.acceptPacket {
+		this.packetSinks--
+	}
+	delete(this.sinks, id)
+}
+
+func (this *streamSource) appendGop(tag *flv.FlvTag) {
+	if this.gop.Len() >= stream_gop_max {
+		this.clearGop()
+		return
+	}
+	this.gop.PushBack(tag.Retain())
+}
+
+func (this *streamSource) clearGop() {
+	for e := this.gop.Front(); e != nil; e = e.Next() {
+		e.Value.(*flv.FlvTag).Release()
+	}
+	this.gop.Init()
+}
+
+//按sink要的格式发送缓存的头
+func (this *streamSource) sendCachedTag(sink *streamSink, tag *flv.FlvTag, converter *flv.PacketConverter) {
+	msg := &wssAPI.Msg{Type: wssAPI.MSG_FLV_TAG, Param1: tag}
+	if sink.acceptPacket {
+		pkt, err := converter.Convert(tag)
+		if err != nil || pkt == nil {
+			return
+		}
+		defer pkt.Release()
+		msg.Type = wssAPI.MSG_MEDIA_PACKET
+		msg.Param1 = pkt
+	}
+	sink.ProcessMessage(msg)
+}
+
 func (this *streamSource) clearCache() {
 	logger.LOGT("clear cache")
+	this.mutexSink.Lock()
+	defer this.mutexSink.Unlock()
+	for _, tag := range []*flv.FlvTag{this.metadata, this.audioHeader, this.videoHeader, this.lastKeyFrame} {
+		if tag != nil {
+			tag.Release()
+		}
+	}
 	this.metadata = nil
 	this.audioHeader = nil
 	this.videoHeader = nil
 	this.lastKeyFrame = nil
+	this.clearGop()
+	this.converter = flv.PacketConverter{}
 }
 
 func (this *streamSource) SetParent(parent wssAPI.Obj) {
@@ -278,7 +278,7 @@ func (this *StreamerService) pullStreamExec(app, streamName string, addr *eLiveL
 	chRet := make(chan wssAPI.Obj) //这个ch由任务执行者来关闭
 	protocol := strings.ToLower(addr.Protocol)
 	switch protocol {
-	case "rtmp":
+	case "rtmp", "rtmps":
 		task := &eRTMPEvent.EvePullRTMPStream{}
 		task.App = addr.App
 		if strings.Contains(app,"/"){
@@ -292,6 +292,9 @@ func (this *StreamerService) pullStreamExec(app, streamName string, addr *eLiveL
 		task.Address = addr.Addr
 		task.Port = addr.Port
 		task.Protocol = addr.Protocol
+		task.TLSServerName = addr.TLSServerName
+		task.TLSInsecureSkipVerify = addr.TLSInsecureSkipVerify
+		task.TLSCAFile = addr.TLSCAFile
 		task.StreamName = streamName
 		task.Src = chRet
 		task.SourceName = app + "/" + streamName
@@ -120,8 +120,8 @@ func (this *StreamerService) HandleTask(task wssAPI.Task) (err error) {
 			return errors.New("not found:" + taskGetSrc.StreamName)
 		} else {
 			taskGetSrc.HasProducer = this.sources[taskGetSrc.StreamName].bProducer
+			taskGetSrc.SinkCount = this.sources[taskGetSrc.StreamName].SinkCount()
 		}
-		logger.LOGT(taskGetSrc.StreamName)
 		//id zero
 		return
 	case eStreamerEvent.DelSource:
@@ -335,7 +335,7 @@ func (this *StreamerService) delSink(path, sinkId string) (err error) {
 		logger.LOGD("delete sinker:" + path + " " + sinkId)
 		src.mutexSink.Lock()
 		defer src.mutexSink.Unlock()
-		delete(src.sinks, sinkId)
+		src.removeSink(sinkId)
 		if 0 == len(src.sinks) && src.bProducer == false {
 			delete(this.sources, path)
 		}
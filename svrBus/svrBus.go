@@ -18,6 +18,7 @@ import (
 	"HTTPMUX"
 	"DASH"
 	"RTSPService"
+	"HTTPFLVService"
 )
 
 type busConfig struct {
@@ -29,6 +30,7 @@ type busConfig struct {
 	HLSConfigName           string `json:"HLS"`
 	DASHConfigName 			string `json:"DASH,omitempty"`
 	RTSPConfigName string `json:"RTSP,omitempty"`
+	HTTPFLVConfigName string `json:"HTTPFLV,omitempty"`
 }
 
 type SvrBus struct {
@@ -180,6 +182,19 @@ func (this *SvrBus) loadConfig() (err error) {
 			this.mutexServices.Unlock()
 		}
 	}
+
+	if len(cfg.HTTPFLVConfigName) > 0 {
+		httpFlv := &HTTPFLVService.HTTPFLVService{}
+		msg := &wssAPI.Msg{Param1: cfg.HTTPFLVConfigName}
+		err = httpFlv.Init(msg)
+		if err != nil {
+			logger.LOGE(err.Error())
+		} else {
+			this.mutexServices.Lock()
+			this.services[httpFlv.GetType()] = httpFlv
+			this.mutexServices.Unlock()
+		}
+	}
 	return
 }
 
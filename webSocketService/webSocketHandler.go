@@ -12,6 +12,7 @@ import (
 	"mediaTypes/mp4"
 	"sync"
 	"time"
+	"webSocketService/wsProtocol"
 	"wssAPI"
 
 	"github.com/gorilla/websocket"
@@ -42,7 +43,17 @@ type websocketHandler struct {
 	source       wssAPI.Obj
 	sourceIdx    int
 	lastCmd      int
-	mutexWs      sync.Mutex
+	mutexWs      *sync.Mutex //所有通道共用一个连接
+	pendingSwitch *playSwitch //play2等目标流的关键帧
+	activeSwitch  *playSwitch //切换过以后数据从这里来
+	flvOutput     bool        //发flv tag，不转fMP4
+	rawFlv        bool        //url指定的flv，给flv.js用，不发控制消息
+	textCtrl      bool        //客户端用文本消息控制，回复和事件也用文本
+	viewersStop   chan bool   //关掉让观看数推送线程退出
+	mutexViewers  sync.Mutex
+	channel       int         //通道号，0是连接本身
+	channels      map[int]*websocketHandler
+	mutexChannels sync.Mutex
 }
 
 type playInfo struct {
@@ -53,13 +64,22 @@ type playInfo struct {
 	metadata       *flv.FlvTag
 	keyFrameWrited bool
 	beginTime      uint32
+	lastTime       uint32 //最后放进cache的时间戳，换流时接着它
+	paused         bool   //暂停时cache只保留最新的GOP
+	newSegment     bool   //发送线程要重建fMP4，重发init
+	cacheBytes     int    //cache里数据的字节数
+	congestion     *flv.CongestionController
+	chData         chan bool //有新数据时通知发送线程
 }
 
 func (this *websocketHandler) Init(msg *wssAPI.Msg) (err error) {
 	this.conn = msg.Param1.(*websocket.Conn)
 	this.app = msg.Param2.(string)
 	this.waitPlaying = new(sync.WaitGroup)
-	this.lastCmd = WSC_close
+	this.stPlay.chData = make(chan bool, 1)
+	this.lastCmd = wsProtocol.WSC_close
+	this.mutexWs = new(sync.Mutex)
+	this.channels = make(map[int]*websocketHandler)
 	return
 }
 
@@ -69,6 +89,7 @@ func (this *websocketHandler) Start(msg *wssAPI.Msg) (err error) {
 
 func (this *websocketHandler) Stop(msg *wssAPI.Msg) (err error) {
 	this.doClose()
+	this.closeChannels()
 	return
 }
 
@@ -81,20 +102,33 @@ func (this *websocketHandler) HandleTask(task wssAPI.Task) (err error) {
 }
 
 func (this *websocketHandler) ProcessMessage(msg *wssAPI.Msg) (err error) {
+	active, _ := this.currentSwitch()
 	switch msg.Type {
 	case wssAPI.MSG_GetSource_NOTIFY:
 		this.hasSink = true
 	case wssAPI.MSG_GetSource_Failed:
 		this.hasSink = false
-		this.sendWsStatus(this.conn, WS_status_error, NETSTREAM_PLAY_FAILED, 0)
+		this.sendWsStatus(this.conn, wsProtocol.WS_status_error, wsProtocol.NETSTREAM_PLAY_FAILED, 0)
 	case wssAPI.MSG_FLV_TAG:
+		//已经切到别的流了，原来的sink还没删掉
+		if active != nil {
+			return
+		}
 		tag := msg.Param1.(*flv.FlvTag)
 		err = this.appendFlvTag(tag)
 	case wssAPI.MSG_PLAY_START:
 		this.startPlay()
+		this.sendWsEvent(WS_event_publish, this.streamName, nil)
 	case wssAPI.MSG_PLAY_STOP:
+		if active != nil {
+			return
+		}
 		this.stopPlay()
+		this.sendWsEvent(WS_event_unpublish, this.streamName, nil)
 		logger.LOGT("play stop message")
+	case wssAPI.MSG_SourceClosed_Force:
+		this.hasSource = false
+		this.stopPublish()
 	case wssAPI.MSG_PUBLISH_START:
 	case wssAPI.MSG_PUBLISH_STOP:
 	}
@@ -107,7 +141,6 @@ func (this *websocketHandler) appendFlvTag(tag *flv.FlvTag) (err error) {
 		logger.LOGE(err.Error())
 		return
 	}
-	tag = tag.Copy()
 
 	//tag.Timestamp -= this.stPlay.beginTime
 	//if false == this.stPlay.keyFrameWrited && tag.TagType == flv.FLV_TAG_Video {
@@ -124,33 +157,75 @@ func (this *websocketHandler) appendFlvTag(tag *flv.FlvTag) (err error) {
 	//}
 
 	if this.stPlay.audioHeader == nil && tag.TagType == flv.FLV_TAG_Audio {
-		this.stPlay.audioHeader = tag
+		this.stPlay.audioHeader = tag.Retain()
 		this.stPlay.mutexCache.Lock()
-		this.stPlay.cache.PushBack(tag)
+		this.stPlay.pushBack(tag.Retain())
 		this.stPlay.mutexCache.Unlock()
 		return
 	}
 	if this.stPlay.videoHeader == nil && tag.TagType == flv.FLV_TAG_Video {
-		this.stPlay.videoHeader = tag
+		this.stPlay.videoHeader = tag.Retain()
 		this.stPlay.mutexCache.Lock()
-		this.stPlay.cache.PushBack(tag)
+		this.stPlay.pushBack(tag.Retain())
 		this.stPlay.mutexCache.Unlock()
 		return
 	}
 	if false == this.stPlay.keyFrameWrited && tag.TagType == flv.FLV_TAG_Video {
-		if false == this.stPlay.keyFrameWrited && ((tag.Data[0] >> 4) == 1) {
-			this.stPlay.beginTime = tag.Timestamp
+		if false == this.stPlay.keyFrameWrited && flv.VideoFrameType(tag) == flv.FrameType_Keyframe {
+			this.stPlay.mutexCache.Lock()
+			if this.stPlay.lastTime > 0 {
+				//暂停恢复时缓存是空的，和play2切换一样接着原来的时间戳走，flv输出不能回退
+				this.stPlay.beginTime = tag.Timestamp - (this.stPlay.lastTime + 1)
+			} else {
+				this.stPlay.beginTime = tag.Timestamp
+			}
 			this.stPlay.keyFrameWrited = true
+			this.stPlay.mutexCache.Unlock()
 		}
 	}
 	if false == this.stPlay.keyFrameWrited {
 		return
 	}
 
-	tag.Timestamp -= this.stPlay.beginTime
 	this.stPlay.mutexCache.Lock()
 	defer this.stPlay.mutexCache.Unlock()
-	this.stPlay.cache.PushBack(tag)
+	timestamp := tag.Timestamp - this.stPlay.beginTime
+	if this.stPlay.paused {
+		//直播暂停，从最新的关键帧开始存，恢复时从这里播
+		if flv.IsVideoKeyFrame(tag) {
+			this.stPlay.clearCache()
+		} else if 0 == this.stPlay.cache.Len() {
+			return
+		}
+		this.stPlay.lastTime = timestamp
+		this.stPlay.pushBack(tag.WithTimestamp(timestamp))
+		return
+	}
+	//纯音频没有关键帧可等
+	if this.stPlay.cacheBytes > serviceConfig.MaxQueueBytes && this.stPlay.videoHeader != nil &&
+		this.stPlay.congestion.SkipToKeyFrame() {
+		logger.LOGW(fmt.Sprintf("websocket play %s too slow,%d bytes queued", this.streamName, this.stPlay.cacheBytes))
+		this.stPlay.cacheBytes -= this.stPlay.congestion.Purge(this.stPlay.cache)
+	}
+	tag = tag.WithTimestamp(timestamp)
+	keep, purge, err := this.stPlay.congestion.Admit(tag)
+	if err != nil {
+		//发送线程断开
+		tag.Release()
+		logger.LOGW(fmt.Sprintf("websocket play %s too slow:%s", this.streamName, err.Error()))
+		this.stPlay.notify()
+		return nil
+	}
+	if purge {
+		logger.LOGW(fmt.Sprintf("websocket play %s too slow,skip to next keyframe", this.streamName))
+		this.stPlay.cacheBytes -= this.stPlay.congestion.Purge(this.stPlay.cache)
+	}
+	if false == keep {
+		tag.Release()
+		return
+	}
+	this.stPlay.lastTime = timestamp
+	this.stPlay.pushBack(tag)
 
 	return
 }
@@ -162,12 +237,14 @@ func (this *websocketHandler) processWSMessage(data []byte) (err error) {
 	}
 	msgType := int(data[0])
 	switch msgType {
-	case WS_pkt_audio:
-	case WS_pkt_video:
-	case WS_pkt_control:
+	case wsProtocol.WS_pkt_audio, wsProtocol.WS_pkt_video:
+		return this.publishFlvTag(uint8(msgType), data[1:])
+	case wsProtocol.WS_pkt_control:
 		logger.LOGD("recv control data:")
 		logger.LOGD(data)
 		return this.controlMsg(data[1:])
+	case wsProtocol.WS_pkt_channel:
+		return this.channelMsg(data[1:])
 	default:
 		err = errors.New(fmt.Sprintf("msg type %d not supported", msgType))
 		logger.LOGW("invalid binary data")
@@ -186,25 +263,29 @@ func (this *websocketHandler) controlMsg(data []byte) (err error) {
 		return
 	}
 	logger.LOGT(ctrlType)
+	return this.dispatchCtrl(int(ctrlType), data[3:])
+}
+
+func (this *websocketHandler) dispatchCtrl(ctrlType int, data []byte) (err error) {
 	switch ctrlType {
-	case WSC_play:
-		return this.ctrlPlay(data[3:])
-	case WSC_play2:
-		return this.ctrlPlay2(data[3:])
-	case WSC_resume:
-		return this.ctrlResume(data[3:])
-	case WSC_pause:
-		return this.ctrlPause(data[3:])
-	case WSC_seek:
-		return this.ctrlSeek(data[3:])
-	case WSC_close:
-		return this.ctrlClose(data[3:])
-	case WSC_stop:
-		return this.ctrlStop(data[3:])
-	case WSC_publish:
-		return this.ctrlPublish(data[3:])
-	case WSC_onMetaData:
-		return this.ctrlOnMetadata(data[3:])
+	case wsProtocol.WSC_play:
+		return this.ctrlPlay(data)
+	case wsProtocol.WSC_play2:
+		return this.ctrlPlay2(data)
+	case wsProtocol.WSC_resume:
+		return this.ctrlResume(data)
+	case wsProtocol.WSC_pause:
+		return this.ctrlPause(data)
+	case wsProtocol.WSC_seek:
+		return this.ctrlSeek(data)
+	case wsProtocol.WSC_close:
+		return this.ctrlClose(data)
+	case wsProtocol.WSC_stop:
+		return this.ctrlStop(data)
+	case wsProtocol.WSC_publish:
+		return this.ctrlPublish(data)
+	case wsProtocol.WSC_onMetaData:
+		return this.ctrlOnMetadata(data)
 	default:
 		logger.LOGE("unknowd websocket control type")
 		return errors.New("invalid ctrl msg type")
@@ -216,7 +297,7 @@ func (this *websocketHandler) sendSlice(slice *mp4.FMP4Slice) (err error) {
 	dataSend := make([]byte, len(slice.Data)+1)
 	dataSend[0] = byte(slice.Type)
 	copy(dataSend[1:], slice.Data)
-	return this.conn.WriteMessage(websocket.BinaryMessage, dataSend)
+	return this.writeMessage(websocket.BinaryMessage, dataSend)
 }
 
 func (this *websocketHandler) SetParent(parent wssAPI.Obj) {
@@ -224,15 +305,18 @@ func (this *websocketHandler) SetParent(parent wssAPI.Obj) {
 }
 
 func (this *websocketHandler) addSource(streamName string) (id int, src wssAPI.Obj, err error) {
-	taskAddSrc := &eStreamerEvent.EveAddSource{StreamName: streamName}
+	taskAddSrc := &eStreamerEvent.EveAddSource{StreamName: streamName, Producer: this}
 	taskAddSrc.RemoteIp = this.conn.RemoteAddr()
 	err = wssAPI.HandleTask(taskAddSrc)
-	if err != nil {
+	if err != nil || nil == taskAddSrc.SrcObj {
+		if nil == err {
+			err = errors.New("add source " + streamName + " failed")
+		}
 		logger.LOGE("add source " + streamName + " failed")
 		return
 	}
 	this.hasSource = true
-	return
+	return int(taskAddSrc.Id), taskAddSrc.SrcObj, nil
 }
 
 func (this *websocketHandler) delSource(streamName string, id int) (err error) {
@@ -268,28 +352,112 @@ func (this *websocketHandler) delSink(streamName, clientId string) (err error) {
 	return
 }
 
+func (this *playInfo) clearCache() {
+	for e := this.cache.Front(); e != nil; e = e.Next() {
+		if tag, ok := e.Value.(*flv.FlvTag); ok {
+			tag.Release()
+		}
+	}
+	this.cache.Init()
+	this.cacheBytes = 0
+}
+
+//cache的增删都走这里，好统计字节数，放进去的时候通知发送线程
+func (this *playInfo) pushBack(tag *flv.FlvTag) {
+	this.cache.PushBack(tag)
+	this.cacheBytes += len(tag.Data)
+	this.notify()
+}
+
+func (this *playInfo) pushFront(tag *flv.FlvTag) {
+	this.cache.PushFront(tag)
+	this.cacheBytes += len(tag.Data)
+	this.notify()
+}
+
+func (this *playInfo) pushReinit() {
+	this.cache.PushBack(cacheReinit{})
+	this.notify()
+}
+
+func (this *playInfo) notify() {
+	select {
+	case this.chData <- true:
+	default:
+	}
+}
+
+//reinit为true时tag为nil
+func (this *playInfo) popFront() (tag *flv.FlvTag, reinit bool) {
+	switch value := this.cache.Remove(this.cache.Front()).(type) {
+	case *flv.FlvTag:
+		tag = value
+		this.cacheBytes -= len(tag.Data)
+	case cacheReinit:
+		reinit = true
+	}
+	return
+}
+
 func (this *playInfo) reset() {
 	this.mutexCache.Lock()
 	defer this.mutexCache.Unlock()
+	if this.cache != nil {
+		this.clearCache()
+	}
 	this.cache = list.New()
+	this.paused = false
+	this.newSegment = false
+	this.congestion = flv.NewCongestionController(serviceConfig.MaxQueueMs)
+	for _, tag := range []*flv.FlvTag{this.audioHeader, this.videoHeader, this.metadata} {
+		if tag != nil {
+			tag.Release()
+		}
+	}
 	this.audioHeader = nil
 	this.videoHeader = nil
 	this.metadata = nil
 	this.keyFrameWrited = false
 	this.beginTime = 0
+	this.lastTime = 0
+}
+
+//所有的源都是直播，暂停只停发送，没有点播位置要保持
+func (this *playInfo) pause() {
+	this.mutexCache.Lock()
+	defer this.mutexCache.Unlock()
+	this.paused = true
+	this.clearCache()
+}
+
+//恢复时重发头和暂停期间最新的GOP，发送线程换新的fMP4，时间戳接着暂停前的走
+func (this *playInfo) resume() {
+	this.mutexCache.Lock()
+	defer this.mutexCache.Unlock()
+	this.paused = false
+	this.newSegment = true
+	this.congestion.Reset()
+	if 0 == this.cache.Len() {
+		this.keyFrameWrited = false
+	}
+	for _, tag := range []*flv.FlvTag{this.videoHeader, this.audioHeader, this.metadata} {
+		if tag != nil {
+			this.pushFront(tag.Retain())
+		}
+	}
 }
 
 func (this *playInfo) addInitPkts() {
 	this.mutexCache.Lock()
 	defer this.mutexCache.Unlock()
 	if this.audioHeader != nil {
-		this.cache.PushBack(this.audioHeader)
+		this.pushBack(this.audioHeader.Retain())
 	}
 	if this.videoHeader != nil {
-		this.cache.PushBack(this.videoHeader)
+		this.pushBack(this.videoHeader.Retain())
 	}
 	if this.metadata != nil {
-		this.cache.PushBack(this.metadata)
+		this.pushBack(this.metadata.Retain())
 	}
 }
 
@@ -297,6 +465,7 @@ func (this *websocketHandler) startPlay() {
 	this.stPlay.reset()
 	this.isPlaying = true
 	go this.threadPlay()
+	this.startViewers()
 }
 
 func (this *websocketHandler) threadPlay() {
@@ -307,28 +476,93 @@ func (this *websocketHandler) threadPlay() {
 		this.stPlay.reset()
 	}()
 	fmp4Creater := &mp4.FMP4Creater{}
+	tracker := &streamInfoTracker{}
+	defer tracker.release()
+	flvHeaderSent := false
 	for true == this.isPlaying {
 		this.stPlay.mutexCache.Lock()
-		if this.stPlay.cache == nil || this.stPlay.cache.Len() == 0 {
+		//cache空了也要看，追不上时新来的tag都进不了cache
+		if this.stPlay.congestion != nil && this.stPlay.congestion.Error() != nil {
+			this.stPlay.mutexCache.Unlock()
+			this.playInsufficientBW()
+			continue
+		}
+		if this.stPlay.cache == nil || this.stPlay.cache.Len() == 0 || this.stPlay.paused {
+			this.stPlay.mutexCache.Unlock()
+			<-this.stPlay.chData
+			continue
+		}
+		tag, reinit := this.stPlay.popFront()
+		if reinit {
 			this.stPlay.mutexCache.Unlock()
-			time.Sleep(10 * time.Millisecond)
+			fmp4Creater.ResetInit()
 			continue
 		}
-		tag := this.stPlay.cache.Front().Value.(*flv.FlvTag)
-		this.stPlay.cache.Remove(this.stPlay.cache.Front())
+		newSegment := this.stPlay.newSegment
+		this.stPlay.newSegment = false
+		this.stPlay.congestion.Sent(tag)
 		this.stPlay.mutexCache.Unlock()
-		if WSC_pause == this.lastCmd {
+		if newSegment {
+			fmp4Creater = &mp4.FMP4Creater{}
+			tracker.reset()
+			err := this.sendWsStatus(this.conn, wsProtocol.WS_status_status, wsProtocol.NETSTREAM_PLAY_RESET, 0)
+			if err != nil {
+				logger.LOGE(err.Error())
+				tag.Release()
+				this.isPlaying = false
+				continue
+			}
+		}
+		if tag.TagType == flv.FLV_TAG_ScriptData {
+			this.sendMetadataEvent(tag.Data)
+		}
+		if this.flvOutput {
+			var err error
+			if false == flvHeaderSent {
+				//第一个tag出来时头基本都到了，据此设置有没有音视频
+				this.stPlay.mutexCache.RLock()
+				hasAudio := this.stPlay.audioHeader != nil || tag.TagType == flv.FLV_TAG_Audio
+				hasVideo := this.stPlay.videoHeader != nil || tag.TagType == flv.FLV_TAG_Video
+				this.stPlay.mutexCache.RUnlock()
+				err = this.sendFlvData(flv.FlvHeader(hasAudio, hasVideo))
+				flvHeaderSent = true
+			}
+			if nil == err {
+				err = this.sendFlvData(flv.EncodeFlvTag(tag))
+			}
+			tag.Release()
+			if err != nil {
+				logger.LOGE(err.Error())
+				this.isPlaying = false
+			}
 			continue
 		}
 		if tag.TagType == flv.FLV_TAG_ScriptData {
-			err := this.sendWsControl(this.conn, WSC_onMetaData, tag.Data)
+			err := this.sendWsControl(this.conn, wsProtocol.WSC_onMetaData, tag.Data)
+			tag.Release()
 			if err != nil {
 				logger.LOGE(err.Error())
 				this.isPlaying = false
 			}
 			continue
 		}
+		if tracker.needUpdate(tag) {
+			tracker.update(tag)
+		}
+		if flv.IsAudioSequenceHeader(tag) || flv.IsVideoSequenceHeader(tag) {
+			//头先攒着，到第一帧时先发流信息再出init
+			tracker.pending = append(tracker.pending, tag)
+			continue
+		}
+		err := this.flushStreamInfo(fmp4Creater, tracker)
+		if err != nil {
+			logger.LOGE(err.Error())
+			tag.Release()
+			this.isPlaying = false
+			continue
+		}
 		slice := fmp4Creater.AddFlvTag(tag)
+		tag.Release()
 		if slice != nil {
 			err := this.sendFmp4Slice(slice)
 			if err != nil {
@@ -339,54 +573,134 @@ func (this *websocketHandler) threadPlay() {
 	}
 }
 
+//丢帧也追不上，告诉客户端带宽不够，只停这一路的播放
+//通道的sink在源发下一个tag时删掉，其他通道接着播；连接本身的播放直接断开
+func (this *websocketHandler) playInsufficientBW() {
+	this.sendWsStatus(this.conn, wsProtocol.WS_status_warning, wsProtocol.NETSTREAM_PLAY_INSUFFICIENTBW, 0)
+	this.isPlaying = false
+	if 0 == this.channel {
+		this.conn.Close()
+	}
+}
+
+//flv模式每个二进制消息是一个完整的tag，没有类型前缀
+func (this *websocketHandler) sendFlvData(data []byte) (err error) {
+	this.mutexWs.Lock()
+	defer this.mutexWs.Unlock()
+	return this.writeMessage(websocket.BinaryMessage, data)
+}
+
 func (this *websocketHandler) sendFmp4Slice(slice *mp4.FMP4Slice) (err error) {
 	this.mutexWs.Lock()
 	defer this.mutexWs.Unlock()
 	dataSend := make([]byte, len(slice.Data)+1)
 	dataSend[0] = byte(slice.Type)
 	copy(dataSend[1:], slice.Data)
-	err = this.conn.WriteMessage(websocket.BinaryMessage, dataSend)
+	err = this.writeMessage(websocket.BinaryMessage, dataSend)
+	return
+}
+
+func (this *websocketHandler) writeDeadline() time.Time {
+	return time.Now().Add(time.Duration(serviceConfig.WriteTimeoutSec) * time.Second)
+}
+
+//调用者持有mutexWs，写超时以后连接不能再用，直接关掉让读循环退出
+func (this *websocketHandler) writeMessage(messageType int, data []byte) (err error) {
+	if this.channel != 0 && websocket.BinaryMessage == messageType {
+		data = wsProtocol.ChannelPacket(this.channel, data)
+	}
+	this.conn.SetWriteDeadline(this.writeDeadline())
+	err = this.conn.WriteMessage(messageType, data)
+	if err != nil {
+		logger.LOGW("websocket write failed:" + err.Error())
+		this.conn.Close()
+	}
 	return
 }
 
 func (this *websocketHandler) stopPlay() {
+	this.stopViewers()
 	this.isPlaying = false
+	this.stPlay.notify()
 	this.waitPlaying.Wait()
 	this.stPlay.reset()
-	this.sendWsStatus(this.conn, WS_status_status, NETSTREAM_PLAY_STOP, 0)
+	this.sendWsStatus(this.conn, wsProtocol.WS_status_status, wsProtocol.NETSTREAM_PLAY_STOP, 0)
 }
 
 func (this *websocketHandler) stopPublish() {
-	logger.LOGE("stop publish not code")
+	this.mutexPublish.Lock()
+	defer this.mutexPublish.Unlock()
+	if false == this.isPublish {
+		return
+	}
+	this.stopViewers()
+	this.isPublish = false
+	this.source = nil
+	this.sendWsStatus(this.conn, wsProtocol.WS_status_status, wsProtocol.NETSTREAM_UNPUBLISH_SUCCESS, 0)
+}
+
+//客户端发来的音视频包交给源
+func (this *websocketHandler) publishFlvTag(tagType uint8, data []byte) (err error) {
+	if len(data) < 5 {
+		return errors.New("invalid publish packet")
+	}
+	timestamp, _ := amf.AMF0DecodeInt32(data)
+	return this.sendToSource(tagType, timestamp, data[4:])
+}
+
+func (this *websocketHandler) sendToSource(tagType uint8, timestamp uint32, data []byte) (err error) {
+	this.mutexPublish.RLock()
+	defer this.mutexPublish.RUnlock()
+	if false == this.isPublish || false == wssAPI.InterfaceValid(this.source) {
+		logger.LOGW("recv media data but not publishing")
+		return
+	}
+	body := wssAPI.AllocBuffer(len(data))
+	copy(body, data)
+	tag := flv.NewSharedTag(tagType, timestamp, body)
+	msg := &wssAPI.Msg{Type: wssAPI.MSG_FLV_TAG, Param1: tag}
+	err = this.source.ProcessMessage(msg)
+	tag.Release()
+	return
 }
 
 func (this *websocketHandler) sendWsControl(conn *websocket.Conn, ctrlType int, data []byte) (err error) {
 	this.mutexWs.Lock()
 	defer this.mutexWs.Unlock()
 	dataSend := make([]byte, len(data)+4)
-	dataSend[0] = WS_pkt_control
+	dataSend[0] = wsProtocol.WS_pkt_control
 	dataSend[1] = byte((ctrlType >> 16) & 0xff)
 	dataSend[2] = byte((ctrlType >> 8) & 0xff)
 	dataSend[3] = byte((ctrlType >> 0) & 0xff)
 	copy(dataSend[4:], data)
-	return conn.WriteMessage(websocket.BinaryMessage, dataSend)
+	return this.writeMessage(websocket.BinaryMessage, dataSend)
 }
 
 func (this *websocketHandler) sendWsStatus(conn *websocket.Conn, level, code string, req int) (err error) {
+	if this.rawFlv {
+		return
+	}
 	this.mutexWs.Lock()
 	defer this.mutexWs.Unlock()
-	st := &stResult{Level: level, Code: code, Req: req}
+	st := &stResult{Level: level, Code: code, Req: req, Channel: this.channel}
+	if this.textCtrl {
+		st.Cmd = ws_text_status
+	}
 	dataJson, err := json.Marshal(st)
 	if err != nil {
 		logger.LOGE(err.Error())
 		return
 	}
+	//flv模式二进制消息都是tag，状态用文本消息
+	if this.flvOutput || this.textCtrl {
+		return this.writeMessage(websocket.TextMessage, dataJson)
+	}
 	dataSend := make([]byte, len(dataJson)+4)
-	dataSend[0] = WS_pkt_control
+	dataSend[0] = wsProtocol.WS_pkt_control
 	dataSend[1] = 0
 	dataSend[2] = 0
 	dataSend[3] = 0
 	copy(dataSend[4:], dataJson)
-	err = conn.WriteMessage(websocket.BinaryMessage, dataSend)
+	err = this.writeMessage(websocket.BinaryMessage, dataSend)
 	return
 }
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 	"wssAPI"
 
 	"github.com/gorilla/websocket"
@@ -19,10 +20,26 @@ type WebSocketService struct {
 }
 
 type WebSocketConfig struct {
-	Port int `json:"Port"`
+	Port  int    `json:"Port"`
 	Route string `json:"Route"`
+	//服务器主动ping的间隔，超过IdleTimeoutSec收不到任何数据就断开
+	PingIntervalSec int `json:"PingIntervalSec"`
+	IdleTimeoutSec  int `json:"IdleTimeoutSec"`
+	WriteTimeoutSec int `json:"WriteTimeoutSec"`
+	//待发送时延超过MaxQueueMs一半丢非参考帧，超过MaxQueueMs或字节数超过MaxQueueBytes丢到下个关键帧
+	//音频不丢，时延超过3倍MaxQueueMs断开
+	MaxQueueBytes int `json:"MaxQueueBytes"`
+	MaxQueueMs    int `json:"MaxQueueMs"`
 }
 
+const (
+	pingIntervalSecDefault = 10
+	idleTimeoutSecDefault  = 30
+	writeTimeoutSecDefault = 10
+	maxQueueBytesDefault   = 8 << 20
+	maxQueueMsDefault      = 10000
+)
+
 var service *WebSocketService
 var serviceConfig WebSocketConfig
 
@@ -86,7 +103,25 @@ func (this *WebSocketService) loadConfigFile(fileName string) (err error) {
 	if err != nil {
 		return
 	}
-
+	if serviceConfig.PingIntervalSec <= 0 {
+		serviceConfig.PingIntervalSec = pingIntervalSecDefault
+	}
+	if serviceConfig.IdleTimeoutSec <= 0 {
+		serviceConfig.IdleTimeoutSec = idleTimeoutSecDefault
+	}
+	if serviceConfig.IdleTimeoutSec <= serviceConfig.PingIntervalSec {
+		//至少给一次pong的机会
+		serviceConfig.IdleTimeoutSec = serviceConfig.PingIntervalSec * 2
+	}
+	if serviceConfig.WriteTimeoutSec <= 0 {
+		serviceConfig.WriteTimeoutSec = writeTimeoutSecDefault
+	}
+	if serviceConfig.MaxQueueBytes <= 0 {
+		serviceConfig.MaxQueueBytes = maxQueueBytesDefault
+	}
+	if serviceConfig.MaxQueueMs <= 0 {
+		serviceConfig.MaxQueueMs = maxQueueMsDefault
+	}
 	return
 }
 
@@ -121,20 +156,45 @@ func (this *WebSocketService) handleConn(conn *websocket.Conn, req *http.Request
 	msg.Param2 = path
 
 	handler.Init(msg)
+	chClose := make(chan bool)
 	defer func() {
+		close(chClose)
 		handler.processWSMessage(nil)
 	}()
+	idleTimeout := time.Duration(serviceConfig.IdleTimeoutSec) * time.Second
+	conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	})
+	conn.SetPingHandler(func(appData string) error {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		err := conn.WriteControl(websocket.PongMessage, []byte(appData), handler.writeDeadline())
+		if err == websocket.ErrCloseSent {
+			return nil
+		}
+		return err
+	})
+	go this.threadPing(conn, handler, chClose)
+	if strings.HasSuffix(path, ws_flv_suffix) {
+		err := handler.playFlvUrl(path)
+		if err != nil {
+			logger.LOGE("play flv failed:" + err.Error())
+			return
+		}
+	}
 	for {
 		messageType, data, err := conn.ReadMessage()
 		if err != nil {
 			logger.LOGE(err.Error())
 			return
 		}
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
 		switch messageType {
 		case websocket.TextMessage:
-			err = conn.WriteMessage(websocket.TextMessage, data)
+			err = handler.processTextMessage(data)
 			if err != nil {
-				logger.LOGI("send text msg failed:" + err.Error())
+				logger.LOGE(err.Error())
+				logger.LOGE("ws text error")
 				return
 			}
 		case websocket.BinaryMessage:
@@ -148,14 +208,32 @@ func (this *WebSocketService) handleConn(conn *websocket.Conn, req *http.Request
 			err = errors.New("websocket closed:" + conn.RemoteAddr().String())
 			return
 		case websocket.PingMessage:
-			//conn.WriteMessage()
-			conn.WriteMessage(websocket.PongMessage, []byte(" "))
+			//ping pong在SetPingHandler和SetPongHandler里处理
 		case websocket.PongMessage:
 		default:
 		}
 	}
 }
 
+//半死的连接ping写不出去或者收不到pong，读超时后连接关闭
+func (this *WebSocketService) threadPing(conn *websocket.Conn, handler *websocketHandler, chClose chan bool) {
+	ticker := time.NewTicker(time.Duration(serviceConfig.PingIntervalSec) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-chClose:
+			return
+		case <-ticker.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, handler.writeDeadline())
+			if err != nil {
+				logger.LOGW("send ping failed:" + err.Error())
+				conn.Close()
+				return
+			}
+		}
+	}
+}
+
 func (this *WebSocketService) SetParent(parent wssAPI.Obj) {
 	this.parent = parent
 }
@@ -0,0 +1,98 @@
+package webSocketService
+
+import (
+	"errors"
+	"fmt"
+	"logger"
+	"mediaTypes/amf"
+	"sync"
+	"webSocketService/wsProtocol"
+)
+
+//一个连接上多路播放，比如监控墙，浏览器对连接数有限制
+//每个通道是一个独立的websocketHandler，有自己的流、cache、fMP4和控制状态，共用连接
+//通道第一次收到控制消息时创建，close或stop以后删除
+const ws_channel_max = 64
+
+func (this *websocketHandler) channelMsg(data []byte) (err error) {
+	if this.channel != 0 || len(data) < 2 {
+		return errors.New("invalid channel msg")
+	}
+	id, _ := amf.AMF0DecodeInt16(data)
+	if 0 == id {
+		return this.processWSMessage(data[2:])
+	}
+	ch, err := this.getChannel(int(id))
+	if err != nil {
+		logger.LOGW(err.Error())
+		return this.sendChannelError(int(id), 0)
+	}
+	err = ch.processWSMessage(data[2:])
+	this.releaseChannel(ch)
+	return
+}
+
+func (this *websocketHandler) textChannelMsg(st *stTextCmd, data []byte) (err error) {
+	if st.Channel < 0 || st.Channel > 0xffff {
+		return this.sendWsStatus(this.conn, wsProtocol.WS_status_error, wsProtocol.NETCONNECTION_CALL_FAILED, st.Req)
+	}
+	ch, err := this.getChannel(st.Channel)
+	if err != nil {
+		logger.LOGW(err.Error())
+		return this.sendChannelError(st.Channel, st.Req)
+	}
+	err = ch.processTextMessage(data)
+	this.releaseChannel(ch)
+	return
+}
+
+//通道建不了，用那个通道号回错误
+func (this *websocketHandler) sendChannelError(id, req int) (err error) {
+	tmp := &websocketHandler{conn: this.conn, mutexWs: this.mutexWs, channel: id, textCtrl: this.textCtrl}
+	return tmp.sendWsStatus(this.conn, wsProtocol.WS_status_error, wsProtocol.NETCONNECTION_CALL_FAILED, req)
+}
+
+func (this *websocketHandler) getChannel(id int) (ch *websocketHandler, err error) {
+	this.mutexChannels.Lock()
+	defer this.mutexChannels.Unlock()
+	ch, ok := this.channels[id]
+	if ok {
+		return
+	}
+	if len(this.channels) >= ws_channel_max {
+		return nil, errors.New(fmt.Sprintf("too many channels,max %d", ws_channel_max))
+	}
+	ch = &websocketHandler{}
+	ch.conn = this.conn
+	ch.app = this.app
+	ch.waitPlaying = new(sync.WaitGroup)
+	ch.stPlay.chData = make(chan bool, 1)
+	ch.lastCmd = wsProtocol.WSC_close
+	ch.mutexWs = this.mutexWs
+	ch.channel = id
+	ch.textCtrl = this.textCtrl
+	this.channels[id] = ch
+	logger.LOGT(fmt.Sprintf("websocket channel %d created", id))
+	return
+}
+
+//退订以后通道回到初始状态，删掉
+func (this *websocketHandler) releaseChannel(ch *websocketHandler) {
+	if ch.lastCmd != wsProtocol.WSC_close || ch.isPlaying || ch.isPublish || ch.hasSink || ch.hasSource {
+		return
+	}
+	this.mutexChannels.Lock()
+	delete(this.channels, ch.channel)
+	this.mutexChannels.Unlock()
+	logger.LOGT(fmt.Sprintf("websocket channel %d released", ch.channel))
+}
+
+func (this *websocketHandler) closeChannels() {
+	this.mutexChannels.Lock()
+	channels := this.channels
+	this.channels = make(map[int]*websocketHandler)
+	this.mutexChannels.Unlock()
+	for _, ch := range channels {
+		ch.doClose()
+	}
+}
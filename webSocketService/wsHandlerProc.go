@@ -3,7 +3,11 @@ package webSocketService
 import (
 	"encoding/json"
 	"errors"
+	"events/eStreamerEvent"
 	"logger"
+	"mediaTypes/flv"
+	"strings"
+	"webSocketService/wsProtocol"
 	"wssAPI"
 )
 
@@ -12,9 +16,9 @@ func (this *websocketHandler) ctrlPlay(data []byte) (err error) {
 	defer func() {
 		if err != nil {
 			logger.LOGE("play failed")
-			err = this.sendWsStatus(this.conn, WS_status_error, NETSTREAM_PLAY_FAILED, st.Req)
+			err = this.sendWsStatus(this.conn, wsProtocol.WS_status_error, wsProtocol.NETSTREAM_PLAY_FAILED, st.Req)
 		} else {
-			this.lastCmd = WSC_play
+			this.lastCmd = wsProtocol.WSC_play
 		}
 	}()
 	err = json.Unmarshal(data, st)
@@ -22,7 +26,7 @@ func (this *websocketHandler) ctrlPlay(data []byte) (err error) {
 		logger.LOGE("invalid params")
 		return err
 	}
-	if false == supportNewCmd(this.lastCmd, WSC_play) {
+	if false == supportNewCmd(this.lastCmd, wsProtocol.WSC_play) {
 		logger.LOGE("bad cmd")
 		err = errors.New("bad cmd")
 		return
@@ -30,23 +34,23 @@ func (this *websocketHandler) ctrlPlay(data []byte) (err error) {
 	//清除之前的
 
 	switch this.lastCmd {
-	case WSC_close:
+	case wsProtocol.WSC_close:
 		err = this.doPlay(st)
-	case WSC_play:
+	case wsProtocol.WSC_play:
 		err = this.doClose()
 		if err != nil {
 			logger.LOGE("close failed ")
 			return
 		}
 		err = this.doPlay(st)
-	case WSC_play2:
+	case wsProtocol.WSC_play2:
 		err = this.doClose()
 		if err != nil {
 			logger.LOGE("close failed ")
 			return
 		}
 		err = this.doPlay(st)
-	case WSC_pause:
+	case wsProtocol.WSC_pause:
 		err = this.doClose()
 		if err != nil {
 			logger.LOGE("close failed ")
@@ -62,11 +66,33 @@ func (this *websocketHandler) ctrlPlay(data []byte) (err error) {
 
 func (this *websocketHandler) ctrlPlay2(data []byte) (err error) {
 	st := &stPlay2{}
+	defer func() {
+		if err != nil {
+			logger.LOGE("play2 failed")
+			err = this.sendWsStatus(this.conn, wsProtocol.WS_status_error, wsProtocol.NETSTREAM_PLAY_FAILED, st.Req)
+		}
+	}()
 	err = json.Unmarshal(data, st)
 	if err != nil {
 		return err
 	}
-
+	if false == supportNewCmd(this.lastCmd, wsProtocol.WSC_play2) {
+		logger.LOGE("bad cmd")
+		err = errors.New("bad cmd")
+		return
+	}
+	switch this.lastCmd {
+	case wsProtocol.WSC_close:
+		//没在播就是普通的play
+		err = this.doPlay(&stPlay{Name: st.Name, Start: st.Start, Len: st.Len, Reset: st.Reset, Req: st.Req})
+		if err == nil {
+			this.lastCmd = wsProtocol.WSC_play2
+		}
+	case wsProtocol.WSC_play, wsProtocol.WSC_play2, wsProtocol.WSC_pause:
+		err = this.doPlay2(st)
+	default:
+		err = errors.New("invalid last cmd")
+	}
 	return
 }
 
@@ -76,13 +102,13 @@ func (this *websocketHandler) ctrlResume(data []byte) (err error) {
 	defer func() {
 		if err != nil {
 			logger.LOGE("resume failed do nothing")
-			this.sendWsStatus(this.conn, WS_status_status, NETSTREAM_FAILED, st.Req)
+			this.sendWsStatus(this.conn, wsProtocol.WS_status_status, wsProtocol.NETSTREAM_FAILED, st.Req)
 
 		} else {
-			this.lastCmd = WSC_play
+			this.lastCmd = wsProtocol.WSC_play
 		}
 	}()
-	if false == supportNewCmd(this.lastCmd, WSC_resume) {
+	if false == supportNewCmd(this.lastCmd, wsProtocol.WSC_resume) {
 		logger.LOGE("bad cmd")
 		err = errors.New("bad cmd")
 		return
@@ -93,7 +119,7 @@ func (this *websocketHandler) ctrlResume(data []byte) (err error) {
 	}
 	//only pase support resume
 	switch this.lastCmd {
-	case WSC_pause:
+	case wsProtocol.WSC_pause:
 		err = this.doResume(st)
 	default:
 		err = errors.New("invalid last cmd")
@@ -107,12 +133,12 @@ func (this *websocketHandler) ctrlPause(data []byte) (err error) {
 	defer func() {
 		if err != nil {
 			logger.LOGE("pause failed")
-			this.sendWsStatus(this.conn, WS_status_status, NETSTREAM_FAILED, st.Req)
+			this.sendWsStatus(this.conn, wsProtocol.WS_status_status, wsProtocol.NETSTREAM_FAILED, st.Req)
 		} else {
-			this.lastCmd = WSC_pause
+			this.lastCmd = wsProtocol.WSC_pause
 		}
 	}()
-	if false == supportNewCmd(this.lastCmd, WSC_pause) {
+	if false == supportNewCmd(this.lastCmd, wsProtocol.WSC_pause) {
 		logger.LOGE("bad cmd")
 		err = errors.New("bad cmd")
 		return
@@ -123,9 +149,9 @@ func (this *websocketHandler) ctrlPause(data []byte) (err error) {
 		return err
 	}
 	switch this.lastCmd {
-	case WSC_play:
+	case wsProtocol.WSC_play:
 		this.doPause(st)
-	case WSC_play2:
+	case wsProtocol.WSC_play2:
 		this.doPause(st)
 	default:
 		err = errors.New("invalid last cmd in pause")
@@ -135,23 +161,25 @@ func (this *websocketHandler) ctrlPause(data []byte) (err error) {
 	return
 }
 
+//直播流不能seek，回失败，不然客户端一直等回复
 func (this *websocketHandler) ctrlSeek(data []byte) (err error) {
 	st := &stSeek{}
 	err = json.Unmarshal(data, st)
 	if err != nil {
 		return err
 	}
-	return
+	logger.LOGW("seek not supported on live stream")
+	return this.sendWsStatus(this.conn, wsProtocol.WS_status_error, wsProtocol.NETSTREAM_FAILED, st.Req)
 }
 
 func (this *websocketHandler) ctrlClose(data []byte) (err error) {
 	st := &stClose{}
 	defer func() {
 		if err != nil {
-			this.sendWsStatus(this.conn, WS_status_error, NETSTREAM_FAILED, st.Req)
+			this.sendWsStatus(this.conn, wsProtocol.WS_status_error, wsProtocol.NETSTREAM_FAILED, st.Req)
 		} else {
 
-			this.lastCmd = WSC_close
+			this.lastCmd = wsProtocol.WSC_close
 		}
 	}()
 	err = json.Unmarshal(data, st)
@@ -168,9 +196,9 @@ func (this *websocketHandler) ctrlStop(data []byte) (err error) {
 	err = json.Unmarshal(data, st)
 	defer func() {
 		if err != nil {
-			this.sendWsStatus(this.conn, WS_status_error, NETSTREAM_FAILED, st.Req)
+			this.sendWsStatus(this.conn, wsProtocol.WS_status_error, wsProtocol.NETSTREAM_FAILED, st.Req)
 		} else {
-			this.lastCmd = WSC_close
+			this.lastCmd = wsProtocol.WSC_close
 		}
 	}()
 	if err != nil {
@@ -186,23 +214,40 @@ func (this *websocketHandler) ctrlPublish(data []byte) (err error) {
 	if err != nil {
 		return err
 	}
-	logger.LOGE("publish not coded")
-	return
+	if false == supportNewCmd(this.lastCmd, wsProtocol.WSC_publish) {
+		logger.LOGE("bad cmd")
+		return this.sendWsStatus(this.conn, wsProtocol.WS_status_error, wsProtocol.NETSTREAM_FAILED, st.Req)
+	}
+	err = this.doPublish(st)
+	if err != nil {
+		logger.LOGE("publish failed:" + err.Error())
+		return this.sendWsStatus(this.conn, wsProtocol.WS_status_error, wsProtocol.NETSTREAM_PUBLISH_BADNAME, st.Req)
+	}
+	this.lastCmd = wsProtocol.WSC_publish
+	return this.sendWsStatus(this.conn, wsProtocol.WS_status_status, wsProtocol.NETSTREAM_PUBLISH_START, st.Req)
 }
 
+//发布时metadata当成script tag
 func (this *websocketHandler) ctrlOnMetadata(data []byte) (err error) {
-	logger.LOGT(string(data))
-	logger.LOGW("on metadata not processed")
-	return
+	if false == this.isPublish {
+		logger.LOGW("on metadata not publishing")
+		return
+	}
+	return this.sendToSource(flv.FLV_TAG_ScriptData, 0, data)
 }
 
 func (this *websocketHandler) doClose() (err error) {
+	this.cancelSwitch()
+	this.stopViewers()
 	if this.isPlaying {
 		this.stopPlay()
 	}
 	if this.hasSink {
 		this.delSink(this.streamName, this.clientId)
 	}
+	this.stPlay.mutexCache.Lock()
+	this.activeSwitch = nil
+	this.stPlay.mutexCache.Unlock()
 	if this.isPublish {
 		this.stopPublish()
 	}
@@ -215,6 +260,7 @@ func (this *websocketHandler) doClose() (err error) {
 func (this *websocketHandler) doPlay(st *stPlay) (err error) {
 
 	logger.LOGT("play")
+	this.flvOutput = this.rawFlv || ws_mode_flv == st.Mode
 	this.clientId = wssAPI.GenerateGUID()
 	if len(this.app) > 0 {
 		this.streamName = this.app + "/" + st.Name
@@ -228,26 +274,69 @@ func (this *websocketHandler) doPlay(st *stPlay) (err error) {
 		return
 	}
 
-	err = this.sendWsStatus(this.conn, WS_status_status, NETSTREAM_PLAY_START, st.Req)
+	err = this.sendWsStatus(this.conn, wsProtocol.WS_status_status, wsProtocol.NETSTREAM_PLAY_START, st.Req)
 	return
 }
 
-func (this *websocketHandler) doPlay2() (err error) {
-	logger.LOGW("play2 not coded")
-	err = errors.New("not processed")
+//ws://host/route/app/name.flv，连上就播，不用发play
+func (this *websocketHandler) playFlvUrl(path string) (err error) {
+	path = strings.TrimSuffix(path, ws_flv_suffix)
+	this.app = ""
+	name := path
+	if idx := strings.LastIndex(path, "/"); idx >= 0 {
+		this.app = path[:idx]
+		name = path[idx+1:]
+	}
+	this.rawFlv = true
+	err = this.doPlay(&stPlay{Name: name})
+	if err != nil {
+		return
+	}
+	this.lastCmd = wsProtocol.WSC_play
 	return
 }
 
+//先订阅目标流，等到它的关键帧再切，连接不断
+func (this *websocketHandler) doPlay2(st *stPlay2) (err error) {
+	streamName := st.Name
+	if len(this.app) > 0 {
+		streamName = this.app + "/" + st.Name
+	}
+	this.cancelSwitch()
+	if streamName == this.streamName {
+		return this.sendWsStatus(this.conn, wsProtocol.WS_status_status, wsProtocol.NETSTREAM_PLAY_TRANSITIONCOMPLETE, st.Req)
+	}
+	sw := &playSwitch{handler: this, streamName: streamName, clientId: wssAPI.GenerateGUID()}
+	this.stPlay.mutexCache.Lock()
+	this.pendingSwitch = sw
+	this.stPlay.mutexCache.Unlock()
+	taskAddSink := &eStreamerEvent.EveAddSink{StreamName: sw.streamName, SinkId: sw.clientId, Sinker: sw}
+	err = wssAPI.HandleTask(taskAddSink)
+	if err != nil || false == taskAddSink.Added {
+		this.stPlay.mutexCache.Lock()
+		this.pendingSwitch = nil
+		this.stPlay.mutexCache.Unlock()
+		logger.LOGE("play2 add sink failed:" + sw.streamName)
+		return this.sendWsStatus(this.conn, wsProtocol.WS_status_error, wsProtocol.NETSTREAM_PLAY_STREAMNOTFOUND, st.Req)
+	}
+	//暂停中切换，恢复以后才切
+	if this.lastCmd != wsProtocol.WSC_pause {
+		this.lastCmd = wsProtocol.WSC_play2
+	}
+	return this.sendWsStatus(this.conn, wsProtocol.WS_status_status, wsProtocol.NETSTREAM_PLAY_TRANSITION, st.Req)
+}
+
 func (this *websocketHandler) doResume(st *stResume) (err error) {
 	logger.LOGT("resume play start")
-	err = this.sendWsStatus(this.conn, WS_status_status, NETSTREAM_PLAY_START, st.Req)
+	this.stPlay.resume()
+	err = this.sendWsStatus(this.conn, wsProtocol.WS_status_status, wsProtocol.NETSTREAM_UNPAUSE_NOTIFY, st.Req)
 	return
 }
 
 func (this *websocketHandler) doPause(st *stPause) (err error) {
-	logger.LOGT("pause do nothing")
-
-	this.sendWsStatus(this.conn, WS_status_status, NETSTREAM_PAUSE_NOTIFY, st.Req)
+	logger.LOGT("pause")
+	this.stPlay.pause()
+	err = this.sendWsStatus(this.conn, wsProtocol.WS_status_status, wsProtocol.NETSTREAM_PAUSE_NOTIFY, st.Req)
 	return
 }
 
@@ -255,6 +344,23 @@ func (this *websocketHandler) doSeek() (err error) {
 	return
 }
 
-func (this *websocketHandler) doPublish() (err error) {
+func (this *websocketHandler) doPublish(st *stPublish) (err error) {
+	if len(this.app) > 0 {
+		this.streamName = this.app + "/" + st.Name
+	} else {
+		this.streamName = st.Name
+	}
+	this.pubName = st.Name
+	id, src, err := this.addSource(this.streamName)
+	if err != nil {
+		return
+	}
+	this.mutexPublish.Lock()
+	this.sourceIdx = id
+	this.source = src
+	this.isPublish = true
+	this.mutexPublish.Unlock()
+	this.startViewers()
+	logger.LOGT("websocket publish " + this.streamName)
 	return
 }
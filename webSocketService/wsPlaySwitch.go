@@ -0,0 +1,183 @@
+package webSocketService
+
+import (
+	"bytes"
+	"events/eStreamerEvent"
+	"logger"
+	"mediaTypes/flv"
+	"webSocketService/wsProtocol"
+	"wssAPI"
+)
+
+//play2切换的目标流，作为sink挂到目标源上
+//切换前收集目标流的头，等到关键帧时在cache里接上，之后的数据照常进cache
+type playSwitch struct {
+	handler     *websocketHandler
+	streamName  string
+	clientId    string
+	audioHeader *flv.FlvTag
+	videoHeader *flv.FlvTag
+	metadata    *flv.FlvTag
+}
+
+//cache里除了tag还有这个:play2换了codec参数，发送线程取到时重置fMP4的init
+//不是tag，拥塞控制清队列时不动它
+type cacheReinit struct{}
+
+func (this *playSwitch) Init(msg *wssAPI.Msg) (err error) {
+	return
+}
+
+func (this *playSwitch) Start(msg *wssAPI.Msg) (err error) {
+	return
+}
+
+func (this *playSwitch) Stop(msg *wssAPI.Msg) (err error) {
+	return
+}
+
+func (this *playSwitch) GetType() string {
+	return wsHandler
+}
+
+func (this *playSwitch) HandleTask(task wssAPI.Task) (err error) {
+	return
+}
+
+func (this *playSwitch) ProcessMessage(msg *wssAPI.Msg) (err error) {
+	active, _ := this.handler.currentSwitch()
+	switch msg.Type {
+	case wssAPI.MSG_FLV_TAG:
+		tag := msg.Param1.(*flv.FlvTag)
+		if this == active {
+			return this.handler.appendFlvTag(tag)
+		}
+		return this.handler.switchFlvTag(this, tag)
+	case wssAPI.MSG_PLAY_STOP:
+		if this == active {
+			this.handler.stopPlay()
+		} else {
+			this.handler.cancelSwitch()
+		}
+	case wssAPI.MSG_GetSource_Failed:
+		this.handler.cancelSwitch()
+	}
+	return
+}
+
+func (this *playSwitch) releaseHeaders() {
+	for _, tag := range []*flv.FlvTag{this.audioHeader, this.videoHeader, this.metadata} {
+		if tag != nil {
+			tag.Release()
+		}
+	}
+	this.audioHeader = nil
+	this.videoHeader = nil
+	this.metadata = nil
+}
+
+func saveSwitchHeader(header **flv.FlvTag, tag *flv.FlvTag) {
+	if *header != nil {
+		(*header).Release()
+	}
+	*header = tag.Retain()
+}
+
+func sameHeader(a, b *flv.FlvTag) bool {
+	if nil == a || nil == b {
+		return a == b
+	}
+	return bytes.Equal(a.Data, b.Data)
+}
+
+func (this *websocketHandler) switchFlvTag(sw *playSwitch, tag *flv.FlvTag) (err error) {
+	this.stPlay.mutexCache.Lock()
+	if sw != this.pendingSwitch {
+		this.stPlay.mutexCache.Unlock()
+		return
+	}
+	switch {
+	case tag.TagType == flv.FLV_TAG_ScriptData:
+		saveSwitchHeader(&sw.metadata, tag)
+	case flv.IsAudioSequenceHeader(tag):
+		saveSwitchHeader(&sw.audioHeader, tag)
+	case flv.IsVideoSequenceHeader(tag):
+		saveSwitchHeader(&sw.videoHeader, tag)
+	}
+	if false == flv.IsVideoKeyFrame(tag) || this.stPlay.paused || false == this.isPlaying {
+		this.stPlay.mutexCache.Unlock()
+		return
+	}
+	//时间戳接着原来的流走
+	timestamp := this.stPlay.lastTime + 1
+	this.stPlay.beginTime = tag.Timestamp - timestamp
+	this.stPlay.lastTime = timestamp
+	this.stPlay.keyFrameWrited = true
+	//codec参数一样不用重新发init
+	if false == sameHeader(this.stPlay.audioHeader, sw.audioHeader) ||
+		false == sameHeader(this.stPlay.videoHeader, sw.videoHeader) {
+		this.stPlay.pushReinit()
+		for _, header := range []*flv.FlvTag{sw.metadata, sw.audioHeader, sw.videoHeader} {
+			if header != nil {
+				this.stPlay.pushBack(header.WithTimestamp(timestamp))
+			}
+		}
+	}
+	this.stPlay.pushBack(tag.WithTimestamp(timestamp))
+	for _, header := range []*flv.FlvTag{this.stPlay.audioHeader, this.stPlay.videoHeader, this.stPlay.metadata} {
+		if header != nil {
+			header.Release()
+		}
+	}
+	this.stPlay.audioHeader = sw.audioHeader
+	this.stPlay.videoHeader = sw.videoHeader
+	this.stPlay.metadata = sw.metadata
+	sw.audioHeader = nil
+	sw.videoHeader = nil
+	sw.metadata = nil
+	oldName, oldId := this.streamName, this.clientId
+	this.streamName = sw.streamName
+	this.clientId = sw.clientId
+	this.pendingSwitch = nil
+	this.activeSwitch = sw
+	this.stPlay.mutexCache.Unlock()
+
+	logger.LOGT("play2 switch from " + oldName + " to " + sw.streamName)
+	//在源的发送线程里，不能同步删别的sink
+	go func() {
+		taskDelSink := &eStreamerEvent.EveDelSink{StreamName: oldName, SinkId: oldId}
+		err := wssAPI.HandleTask(taskDelSink)
+		if err != nil {
+			logger.LOGE("del old sink failed:" + err.Error())
+		}
+	}()
+	return this.sendWsStatus(this.conn, wsProtocol.WS_status_status, wsProtocol.NETSTREAM_PLAY_TRANSITIONCOMPLETE, 0)
+}
+
+//切换状态在发送线程、源的线程和命令处理里都会读写，都在mutexCache下
+func (this *websocketHandler) currentSwitch() (active, pending *playSwitch) {
+	this.stPlay.mutexCache.RLock()
+	defer this.stPlay.mutexCache.RUnlock()
+	return this.activeSwitch, this.pendingSwitch
+}
+
+//还没切过去的play2取消掉
+func (this *websocketHandler) cancelSwitch() {
+	this.stPlay.mutexCache.Lock()
+	sw := this.pendingSwitch
+	this.pendingSwitch = nil
+	if sw != nil {
+		sw.releaseHeaders()
+	}
+	this.stPlay.mutexCache.Unlock()
+	if nil == sw {
+		return
+	}
+	go func() {
+		taskDelSink := &eStreamerEvent.EveDelSink{StreamName: sw.streamName, SinkId: sw.clientId}
+		err := wssAPI.HandleTask(taskDelSink)
+		if err != nil {
+			logger.LOGE("del play2 sink failed:" + err.Error())
+		}
+	}()
+}
@@ -0,0 +1,100 @@
+package wsProtocol
+
+import (
+	"errors"
+)
+
+//webSocketService和websocketClient共用的二进制协议定义
+const (
+	WS_status_status  = "status"
+	WS_status_error   = "error"
+	WS_status_warning = "warning"
+)
+
+//1byte type
+//发布时音视频包:1byte type + 4byte 时间戳(毫秒,大端) + flv tag data
+//多路复用:WS_pkt_channel + 2byte 通道号(大端) + 上面的包，不带前缀的是通道0
+const (
+	WS_pkt_audio   = 8
+	WS_pkt_video   = 9
+	WS_pkt_control = 18
+	WS_pkt_channel = 0x20
+)
+
+//通道包:WS_pkt_channel + 2byte 通道号 + 通道里的包
+func ChannelPacket(channel int, data []byte) (dataSend []byte) {
+	dataSend = make([]byte, len(data)+3)
+	dataSend[0] = WS_pkt_channel
+	dataSend[1] = byte((channel >> 8) & 0xff)
+	dataSend[2] = byte((channel >> 0) & 0xff)
+	copy(dataSend[3:], data)
+	return
+}
+
+//不带通道前缀的是通道0，payload就是data
+func SplitChannelPacket(data []byte) (channel int, payload []byte, err error) {
+	if len(data) == 0 || data[0] != WS_pkt_channel {
+		return 0, data, nil
+	}
+	if len(data) < 4 {
+		return 0, nil, errors.New("invalid channel packet")
+	}
+	channel = int(data[1])<<8 | int(data[2])
+	return channel, data[3:], nil
+}
+
+const (
+	WSC_play       = 1
+	WSC_play2      = 2
+	WSC_resume     = 3
+	WSC_pause      = 4
+	WSC_seek       = 5
+	WSC_close      = 7
+	WSC_stop       = 6
+	WSC_publish    = 0x10
+	WSC_onMetaData = 9
+	WSC_streamInfo = 0x11 //服务器发:json的流信息和codecs
+)
+
+const (
+	NETCONNECTION_CALL_FAILED         = "NetConnection.Call.Failed"
+	NETCONNECTION_CONNECT_APPSHUTDOWN = "NetConnection.Connect.AppShutdown"
+	NETCONNECTION_CONNECT_CLOSED      = "NetConnection.Connect.Closed"
+	NETCONNECTION_CONNECT_FAILED      = "NetConnection.Connect.Failed"
+	NETCONNECTION_CONNECT_IDLETIMEOUT = "NetConnection.Connect.IdleTimeout"
+	NETCONNECTION_CONNECT_INVALIDAPP  = "NetConnection.Connect.InvalidApp"
+	NETCONNECTION_CONNECT_REJECTED    = "NetConnection.Connect.Rejected"
+	NETCONNECTION_CONNECT_SUCCESS     = "NetConnection.Connect.Success"
+
+	NETSTREAM_BUFFER_EMPTY              = "NetStream.Buffer.Empty"
+	NETSTREAM_BUFFER_FLUSH              = "NetStream.Buffer.Flush"
+	NETSTREAM_BUFFER_FULL               = "NetStream.Buffer.Full"
+	NETSTREAM_FAILED                    = "NetStream.Failed"
+	NETSTREAM_PAUSE_NOTIFY              = "NetStream.Pause.Notify"
+	NETSTREAM_PLAY_FAILED               = "NetStream.Play.Failed"
+	NETSTREAM_PLAY_FILESTRUCTUREINVALID = "NetStream.Play.FileStructureInvalid"
+	NETSTREAM_PLAY_INSUFFICIENTBW       = "NetStream.Play.InsufficientBW"
+	NETSTREAM_PLAY_PUBLISHNOTIFY        = "NetStream.Play.PublishNotify"
+	NETSTREAM_PLAY_RESET                = "NetStream.Play.Reset"
+	NETSTREAM_PLAY_START                = "NetStream.Play.Start"
+	NETSTREAM_PLAY_STOP                 = "NetStream.Play.Stop"
+	NETSTREAM_PLAY_STREAMNOTFOUND       = "NetStream.Play.StreamNotFound"
+	NETSTREAM_PLAY_TRANSITION           = "NetStream.Play.Transition"
+	NETSTREAM_PLAY_TRANSITIONCOMPLETE   = "NetStream.Play.TransitionComplete"
+	NETSTREAM_PLAY_UNPUBLISHNOTIFY      = "NetStream.Play.UnpublishNotify"
+	NETSTREAM_PUBLISH_BADNAME           = "NetStream.Publish.BadName"
+	NETSTREAM_PUBLISH_IDLE              = "NetStream.Publish.Idle"
+	NETSTREAM_PUBLISH_START             = "NetStream.Publish.Start"
+	NETSTREAM_RECORD_ALREADYEXISTS      = "NetStream.Record.AlreadyExists"
+	NETSTREAM_RECORD_FAILED             = "NetStream.Record.Failed"
+	NETSTREAM_RECORD_NOACCESS           = "NetStream.Record.NoAccess"
+	NETSTREAM_RECORD_START              = "NetStream.Record.Start"
+	NETSTREAM_RECORD_STOP               = "NetStream.Record.Stop"
+	NETSTREAM_SEEK_FAILED               = "NetStream.Seek.Failed"
+	NETSTREAM_SEEK_INVALIDTIME          = "NetStream.Seek.InvalidTime"
+	NETSTREAM_SEEK_NOTIFY               = "NetStream.Seek.Notify"
+	NETSTREAM_STEP_NOTIFY               = "NetStream.Step.Notify"
+	NETSTREAM_UNPAUSE_NOTIFY            = "NetStream.Unpause.Notify"
+	NETSTREAM_UNPUBLISH_SUCCESS         = "NetStream.Unpublish.Success"
+	NETSTREAM_VIDEO_DIMENSIONCHANGE     = "NetStream.Video.DimensionChange"
+)
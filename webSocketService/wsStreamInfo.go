@@ -0,0 +1,136 @@
+package webSocketService
+
+import (
+	"encoding/json"
+	"logger"
+	"mediaTypes/flv"
+	"mediaTypes/mp4"
+	"webSocketService/wsProtocol"
+	"wssAPI"
+)
+
+//fMP4的init之前先发流信息，客户端不用猜addSourceBuffer的codecs
+//音视频是分开的fMP4，各用一个SourceBuffer，所以mime也分开给
+//头变了(换流、换编码参数)重新发
+type stStreamInfo struct {
+	HasAudio      bool   `json:"hasAudio"`
+	HasVideo      bool   `json:"hasVideo"`
+	VideoCodec    string `json:"videoCodec,omitempty"`
+	AudioCodec    string `json:"audioCodec,omitempty"`
+	VideoMimeType string `json:"videoMimeType,omitempty"`
+	AudioMimeType string `json:"audioMimeType,omitempty"`
+	Width         int    `json:"width,omitempty"`
+	Height        int    `json:"height,omitempty"`
+	Fps           int    `json:"fps,omitempty"`
+	SampleRate    int    `json:"sampleRate,omitempty"`
+	Channels      int    `json:"channels,omitempty"`
+}
+
+//每个发送线程一个，头先攒着，到第一帧时才知道有哪些轨
+type streamInfoTracker struct {
+	converter flv.PacketConverter
+	audio     *wssAPI.CodecConfig
+	video     *wssAPI.CodecConfig
+	sent      stStreamInfo
+	pending   []*flv.FlvTag
+}
+
+func (this *streamInfoTracker) needUpdate(tag *flv.FlvTag) bool {
+	switch {
+	case flv.IsAudioSequenceHeader(tag), flv.IsVideoSequenceHeader(tag):
+		return true
+	case tag.TagType == flv.FLV_TAG_Audio:
+		//mp3没有头，从第一帧取
+		return nil == this.audio
+	case flv.IsVideoKeyFrame(tag):
+		//vp9的分辨率在关键帧里
+		return this.video != nil && 0 == this.video.Width
+	}
+	return false
+}
+
+func (this *streamInfoTracker) update(tag *flv.FlvTag) {
+	pkt, err := this.converter.Convert(tag)
+	if err != nil || nil == pkt {
+		return
+	}
+	pkt.Release()
+	switch pkt.MediaType {
+	case wssAPI.MEDIA_TYPE_AUDIO:
+		this.audio = pkt.Config
+	case wssAPI.MEDIA_TYPE_VIDEO:
+		this.video = pkt.Config
+	}
+}
+
+func (this *streamInfoTracker) info() (st stStreamInfo) {
+	if this.video != nil {
+		st.HasVideo = true
+		st.VideoCodec = mp4.CodecString(this.video)
+		st.VideoMimeType = `video/mp4; codecs="` + st.VideoCodec + `"`
+		st.Width = this.video.Width
+		st.Height = this.video.Height
+		st.Fps = this.video.Fps
+	}
+	if this.audio != nil {
+		st.HasAudio = true
+		st.AudioCodec = mp4.CodecString(this.audio)
+		st.AudioMimeType = `audio/mp4; codecs="` + st.AudioCodec + `"`
+		st.SampleRate = this.audio.SampleRate
+		st.Channels = this.audio.Channels
+	}
+	return
+}
+
+//重新发init时流信息也要重发
+func (this *streamInfoTracker) reset() {
+	this.sent = stStreamInfo{}
+}
+
+func (this *streamInfoTracker) release() {
+	for _, tag := range this.pending {
+		tag.Release()
+	}
+	this.pending = nil
+}
+
+//有变化先发流信息，再把攒着的头交给fMP4出init
+func (this *websocketHandler) flushStreamInfo(fmp4Creater *mp4.FMP4Creater, tracker *streamInfoTracker) (err error) {
+	info := tracker.info()
+	if (info.HasAudio || info.HasVideo) && info != tracker.sent {
+		err = this.sendStreamInfo(&info)
+		if err != nil {
+			return
+		}
+		tracker.sent = info
+	}
+	pending := tracker.pending
+	tracker.pending = nil
+	for i, tag := range pending {
+		slice := fmp4Creater.AddFlvTag(tag)
+		tag.Release()
+		if nil == slice {
+			continue
+		}
+		err = this.sendFmp4Slice(slice)
+		if err != nil {
+			for _, left := range pending[i+1:] {
+				left.Release()
+			}
+			return
+		}
+	}
+	return
+}
+
+func (this *websocketHandler) sendStreamInfo(info *stStreamInfo) (err error) {
+	if this.textCtrl {
+		return this.sendWsEvent(WS_event_streaminfo, this.streamName, info)
+	}
+	dataJson, err := json.Marshal(info)
+	if err != nil {
+		logger.LOGE(err.Error())
+		return
+	}
+	return this.sendWsControl(this.conn, wsProtocol.WSC_streamInfo, dataJson)
+}
@@ -0,0 +1,201 @@
+package webSocketService
+
+import (
+	"encoding/json"
+	"errors"
+	"events/eStreamerEvent"
+	"logger"
+	"mediaTypes/amf"
+	"time"
+	"webSocketService/wsProtocol"
+	"wssAPI"
+
+	"github.com/gorilla/websocket"
+)
+
+//文本消息控制，方便浏览器调试和第三方接入:
+//{"cmd":"play","name":"x","req":1}，参数和二进制控制的json一样，走同一个状态机
+//{"cmd":"onMetaData","data":{"width":1280}}，发布时的metadata
+//回复:{"cmd":"status","level":"status","code":"NetStream.Play.Start","req":1}
+//推送:{"cmd":"event","event":"viewers","stream":"live/x","data":3}
+//带"channel":n的消息属于对应的通道，回复和推送也带上
+const (
+	ws_text_status = "status"
+	ws_text_event  = "event"
+)
+
+const (
+	WS_event_publish    = "publish"
+	WS_event_unpublish  = "unpublish"
+	WS_event_metadata   = "metadata"
+	WS_event_viewers    = "viewers"
+	WS_event_streaminfo = "streamInfo"
+)
+
+//观看数变了才推
+const ws_viewers_interval = 5 * time.Second
+
+var textCmdsMap = map[string]int{
+	"play":       wsProtocol.WSC_play,
+	"play2":      wsProtocol.WSC_play2,
+	"resume":     wsProtocol.WSC_resume,
+	"pause":      wsProtocol.WSC_pause,
+	"seek":       wsProtocol.WSC_seek,
+	"close":      wsProtocol.WSC_close,
+	"stop":       wsProtocol.WSC_stop,
+	"publish":    wsProtocol.WSC_publish,
+	"onMetaData": wsProtocol.WSC_onMetaData,
+}
+
+type stTextCmd struct {
+	Cmd     string `json:"cmd"`
+	Req     int    `json:"req"`
+	Channel int    `json:"channel"`
+}
+
+type stTextMetadata struct {
+	Data map[string]interface{} `json:"data"`
+}
+
+type stEvent struct {
+	Cmd     string      `json:"cmd"`
+	Event   string      `json:"event"`
+	Stream  string      `json:"stream"`
+	Data    interface{} `json:"data,omitempty"`
+	Channel int         `json:"channel,omitempty"`
+}
+
+func (this *websocketHandler) processTextMessage(data []byte) (err error) {
+	st := &stTextCmd{}
+	err = json.Unmarshal(data, st)
+	if err != nil {
+		//格式不对只回错误，不断开
+		logger.LOGW("invalid text control:" + string(data))
+		this.textCtrl = true
+		return this.sendWsStatus(this.conn, wsProtocol.WS_status_error, wsProtocol.NETCONNECTION_CALL_FAILED, 0)
+	}
+	this.textCtrl = true
+	if 0 == this.channel && st.Channel != 0 {
+		return this.textChannelMsg(st, data)
+	}
+	ctrlType, ok := textCmdsMap[st.Cmd]
+	if false == ok {
+		logger.LOGW("unknown text control:" + st.Cmd)
+		return this.sendWsStatus(this.conn, wsProtocol.WS_status_error, wsProtocol.NETCONNECTION_CALL_FAILED, st.Req)
+	}
+	if wsProtocol.WSC_onMetaData == ctrlType {
+		data, err = textMetadataToAMF(data)
+		if err != nil {
+			logger.LOGW(err.Error())
+			return this.sendWsStatus(this.conn, wsProtocol.WS_status_error, wsProtocol.NETSTREAM_FAILED, st.Req)
+		}
+	}
+	return this.dispatchCtrl(ctrlType, data)
+}
+
+//转成和二进制控制一样的AMF:"onMetaData"+object
+func textMetadataToAMF(data []byte) (amfData []byte, err error) {
+	st := &stTextMetadata{}
+	err = json.Unmarshal(data, st)
+	if err != nil {
+		return
+	}
+	if nil == st.Data {
+		return nil, errors.New("onMetaData without data")
+	}
+	obj, err := amf.MarshalObject(st.Data)
+	if err != nil {
+		return
+	}
+	enc := &amf.AMF0Encoder{}
+	enc.Init()
+	enc.EncodeString("onMetaData")
+	enc.EncodeObject(obj)
+	return enc.GetData()
+}
+
+func (this *websocketHandler) sendWsEvent(event, streamName string, data interface{}) (err error) {
+	if false == this.textCtrl {
+		return
+	}
+	this.mutexWs.Lock()
+	defer this.mutexWs.Unlock()
+	st := &stEvent{Cmd: ws_text_event, Event: event, Stream: streamName, Data: data, Channel: this.channel}
+	dataJson, err := json.Marshal(st)
+	if err != nil {
+		logger.LOGE(err.Error())
+		return
+	}
+	return this.writeMessage(websocket.TextMessage, dataJson)
+}
+
+//script tag里的onMetaData解成json对象推给客户端
+func (this *websocketHandler) sendMetadataEvent(data []byte) (err error) {
+	if false == this.textCtrl {
+		return
+	}
+	obj, err := amf.AMF0DecodeObj(data)
+	if err != nil {
+		logger.LOGW("decode metadata failed:" + err.Error())
+		return nil
+	}
+	for e := obj.Props.Front(); e != nil; e = e.Next() {
+		prop := e.Value.(*amf.AMF0Property)
+		if prop.PropType != amf.AMF0_object && prop.PropType != amf.AMF0_ecma_array {
+			continue
+		}
+		metadata := make(map[string]interface{})
+		err = amf.Unmarshal(prop, &metadata)
+		if err != nil {
+			logger.LOGW("unmarshal metadata failed:" + err.Error())
+			return nil
+		}
+		return this.sendWsEvent(WS_event_metadata, this.streamName, metadata)
+	}
+	return
+}
+
+//播放或发布期间定时查观看数，流换了或者停了就退出
+func (this *websocketHandler) startViewers() {
+	if false == this.textCtrl {
+		return
+	}
+	this.mutexViewers.Lock()
+	defer this.mutexViewers.Unlock()
+	if this.viewersStop != nil {
+		close(this.viewersStop)
+	}
+	this.viewersStop = make(chan bool)
+	go this.threadViewers(this.streamName, this.viewersStop)
+}
+
+func (this *websocketHandler) stopViewers() {
+	this.mutexViewers.Lock()
+	defer this.mutexViewers.Unlock()
+	if this.viewersStop != nil {
+		close(this.viewersStop)
+		this.viewersStop = nil
+	}
+}
+
+func (this *websocketHandler) threadViewers(streamName string, chStop chan bool) {
+	ticker := time.NewTicker(ws_viewers_interval)
+	defer ticker.Stop()
+	lastCount := -1
+	for {
+		taskGetSrc := &eStreamerEvent.EveGetSource{StreamName: streamName}
+		if nil == wssAPI.HandleTask(taskGetSrc) && taskGetSrc.SinkCount != lastCount {
+			lastCount = taskGetSrc.SinkCount
+			err := this.sendWsEvent(WS_event_viewers, streamName, lastCount)
+			if err != nil {
+				logger.LOGE(err.Error())
+				return
+			}
+		}
+		select {
+		case <-chStop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,94 +1,312 @@
-package main
+package websocketClient
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"logger"
+	"mediaTypes/amf"
+	"mediaTypes/flv"
 	"net/http"
-	"webSocketService"
-	"wssAPI"
+	"sync"
+	"webSocketService/wsProtocol"
 
 	"github.com/gorilla/websocket"
 )
 
-func main() {
-	logger.SetFlags(logger.LOG_SHORT_FILE)
-	cli := &websocket.Dialer{}
-	req := http.Header{}
+//webSocketService二进制协议的客户端:
+//控制:18 + 3byte命令 + json，回复是命令0的json
+//播放数据:1byte类型(8音频 9视频) + fMP4 init或者segment
+//发布数据:1byte类型 + 4byte时间戳(毫秒,大端) + flv tag data
+//通道:0x20 + 2byte通道号 + 上面的包，Channel(id)拿到的Client发的命令都带上
+type Client struct {
+	conn       *websocket.Conn
+	mutexWrite sync.Mutex
+	mutexReq   sync.Mutex
+	req        int
+	channel    int
+	root       *Client //通道共用连接、写锁和req，nil是连接本身
+	//回调都在Run的goroutine里调用，Run之前设置，通道的数据也走连接本身的回调
+	OnStatus     func(st *Status)
+	OnSegment    func(seg *Segment)
+	OnMetadata   func(channel int, metadata map[string]interface{})
+	OnStreamInfo func(info *StreamInfo)
+}
+
+type Status struct {
+	Level   string `json:"level"`
+	Code    string `json:"code"`
+	Req     int    `json:"req"`
+	Channel int    `json:"channel"`
+}
+
+//init之前收到，MimeType直接给MSE的addSourceBuffer
+type StreamInfo struct {
+	HasAudio      bool   `json:"hasAudio"`
+	HasVideo      bool   `json:"hasVideo"`
+	VideoCodec    string `json:"videoCodec"`
+	AudioCodec    string `json:"audioCodec"`
+	VideoMimeType string `json:"videoMimeType"`
+	AudioMimeType string `json:"audioMimeType"`
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+	Fps           int    `json:"fps"`
+	SampleRate    int    `json:"sampleRate"`
+	Channels      int    `json:"channels"`
+	Channel       int    `json:"-"` //通道号，服务器不在json里带
+}
 
-	conn, _, err := cli.Dial("ws://127.0.0.1:8080/ws/live", req)
+func (this *Status) IsError() bool {
+	return wsProtocol.WS_status_error == this.Level
+}
+
+//url:ws://host:port/route/app
+func Dial(url string, header http.Header) (cli *Client, err error) {
+	dialer := &websocket.Dialer{}
+	conn, _, err := dialer.Dial(url, header)
 	if err != nil {
-		logger.LOGE(err.Error())
 		return
 	}
-	Play(conn)
-	defer conn.Close()
-
-}
-func SetTest() {
-	si := wssAPI.NewSet()
-	si.Add("111")
-	si.Add(2)
-	fmt.Println(si.Has(3))
-	fmt.Println(si.Has(2))
-	fmt.Println(si.Has("111"))
+	cli = &Client{conn: conn}
+	return
 }
 
-type stPlay struct {
-	Name  string `json:"name"`
-	Start int    `json:"start"`
-	Len   int    `json:"len"`
-	Reset int    `json:"reset"`
-	Req   int    `json:"req"`
+//同一个连接上的另一路，比如监控墙，id 1-65535
+//只能用来发命令，Run和回调用连接本身的
+func (this *Client) Channel(id int) *Client {
+	root := this.getRoot()
+	return &Client{conn: root.conn, channel: id, root: root}
 }
 
-func Play(conn *websocket.Conn) {
+func (this *Client) getRoot() *Client {
+	if this.root != nil {
+		return this.root
+	}
+	return this
+}
 
-	//play
-	stPlay := &stPlay{}
+//读到连接断开为止
+func (this *Client) Run() (err error) {
+	for {
+		msgType, data, err := this.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		switch msgType {
+		case websocket.BinaryMessage:
+			err = this.handleBinary(data)
+		case websocket.TextMessage:
+			//flv模式和文本控制时状态是文本消息
+			err = this.handleStatus(data)
+		}
+		if err != nil {
+			logger.LOGW(err.Error())
+		}
+	}
+}
 
-	stPlay.Name = "hks"
+//断开连接，Run会返回
+func (this *Client) Disconnect() error {
+	return this.conn.Close()
+}
 
-	dataSend, _ := json.Marshal(stPlay)
-	err := webSocketService.SendWsControl(conn, webSocketService.WSC_play, dataSend)
+func (this *Client) handleBinary(data []byte) (err error) {
+	if len(data) < 1 {
+		return errors.New("empty binary message")
+	}
+	channel, data, err := wsProtocol.SplitChannelPacket(data)
 	if err != nil {
-		logger.LOGE(err.Error())
 		return
 	}
-	err = readResult(conn)
+	if len(data) < 1 {
+		return errors.New("empty channel message")
+	}
+	switch data[0] {
+	case wsProtocol.WS_pkt_audio, wsProtocol.WS_pkt_video:
+		seg, err := DemuxSlice(data)
+		if err != nil {
+			return err
+		}
+		seg.Channel = channel
+		if this.OnSegment != nil {
+			this.OnSegment(seg)
+		}
+	case wsProtocol.WS_pkt_control:
+		if len(data) < 4 {
+			return errors.New("invalid control message")
+		}
+		ctrlType, _ := amf.AMF0DecodeInt24(data[1:])
+		switch ctrlType {
+		case 0:
+			return this.handleStatus(data[4:])
+		case wsProtocol.WSC_onMetaData:
+			return this.handleMetadata(channel, data[4:])
+		case wsProtocol.WSC_streamInfo:
+			return this.handleStreamInfo(channel, data[4:])
+		default:
+			logger.LOGW(fmt.Sprintf("control type %d not supported", ctrlType))
+		}
+	default:
+		return errors.New(fmt.Sprintf("msg type %d not supported", data[0]))
+	}
+	return
+}
+
+func (this *Client) handleStatus(data []byte) (err error) {
+	st := &Status{}
+	err = json.Unmarshal(data, st)
 	if err != nil {
-		logger.LOGE(err.Error())
 		return
 	}
-	err = readResult(conn)
+	if this.OnStatus != nil {
+		this.OnStatus(st)
+	}
+	return
+}
+
+func (this *Client) handleStreamInfo(channel int, data []byte) (err error) {
+	info := &StreamInfo{}
+	err = json.Unmarshal(data, info)
 	if err != nil {
-		logger.LOGE(err.Error())
 		return
 	}
-	for {
-		readResult(conn)
+	info.Channel = channel
+	if this.OnStreamInfo != nil {
+		this.OnStreamInfo(info)
 	}
-	//end
 	return
 }
 
-func readResult(conn *websocket.Conn) (err error) {
-	msgType, data, err := conn.ReadMessage()
+//onMetaData + object或ecma array
+func (this *Client) handleMetadata(channel int, data []byte) (err error) {
+	obj, err := amf.AMF0DecodeObj(data)
 	if err != nil {
-		logger.LOGE(err.Error())
 		return
 	}
-	if msgType == websocket.BinaryMessage {
-		logger.LOGT(data)
-		pktType := data[0]
-		switch pktType {
-		case webSocketService.WS_pkt_audio:
-			logger.LOGT("audio")
-		case webSocketService.WS_pkt_video:
-			logger.LOGT("video")
-		case webSocketService.WS_pkt_control:
-			logger.LOGT(data)
+	for e := obj.Props.Front(); e != nil; e = e.Next() {
+		prop := e.Value.(*amf.AMF0Property)
+		if prop.PropType != amf.AMF0_object && prop.PropType != amf.AMF0_ecma_array {
+			continue
 		}
+		metadata := make(map[string]interface{})
+		err = amf.Unmarshal(prop, &metadata)
+		if err != nil {
+			return
+		}
+		if this.OnMetadata != nil {
+			this.OnMetadata(channel, metadata)
+		}
+		return
+	}
+	return
+}
+
+func (this *Client) nextReq() int {
+	root := this.getRoot()
+	root.mutexReq.Lock()
+	defer root.mutexReq.Unlock()
+	root.req++
+	return root.req
+}
+
+//通道0不加前缀，服务器按连接本身处理
+func (this *Client) write(data []byte) (err error) {
+	if this.channel != 0 {
+		data = wsProtocol.ChannelPacket(this.channel, data)
+	}
+	root := this.getRoot()
+	root.mutexWrite.Lock()
+	defer root.mutexWrite.Unlock()
+	return root.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+//返回的req和回复Status里的Req对应
+func (this *Client) sendControl(ctrlType int, params map[string]interface{}) (req int, err error) {
+	req = this.nextReq()
+	params["req"] = req
+	dataJson, err := json.Marshal(params)
+	if err != nil {
+		return
 	}
+	dataSend := make([]byte, len(dataJson)+4)
+	dataSend[0] = wsProtocol.WS_pkt_control
+	dataSend[1] = byte((ctrlType >> 16) & 0xff)
+	dataSend[2] = byte((ctrlType >> 8) & 0xff)
+	dataSend[3] = byte((ctrlType >> 0) & 0xff)
+	copy(dataSend[4:], dataJson)
+	err = this.write(dataSend)
 	return
 }
+
+func (this *Client) Play(name string) (req int, err error) {
+	return this.sendControl(wsProtocol.WSC_play, map[string]interface{}{"name": name})
+}
+
+//不断开切到另一路流，目标流关键帧时切换
+func (this *Client) Play2(name string) (req int, err error) {
+	return this.sendControl(wsProtocol.WSC_play2, map[string]interface{}{"name": name})
+}
+
+func (this *Client) Pause() (req int, err error) {
+	return this.sendControl(wsProtocol.WSC_pause, map[string]interface{}{})
+}
+
+func (this *Client) Resume() (req int, err error) {
+	return this.sendControl(wsProtocol.WSC_resume, map[string]interface{}{})
+}
+
+//服务器只有直播，现在总是回NetStream.Failed
+func (this *Client) Seek(offsetMs int) (req int, err error) {
+	return this.sendControl(wsProtocol.WSC_seek, map[string]interface{}{"offset": offsetMs})
+}
+
+//停止播放或发布，连接不断
+func (this *Client) Close() (req int, err error) {
+	return this.sendControl(wsProtocol.WSC_close, map[string]interface{}{})
+}
+
+func (this *Client) Publish(name string) (req int, err error) {
+	return this.sendControl(wsProtocol.WSC_publish, map[string]interface{}{"name": name, "type": "live"})
+}
+
+//发布时的metadata
+func (this *Client) SendMetadata(metadata map[string]interface{}) (err error) {
+	obj, err := amf.MarshalObject(metadata)
+	if err != nil {
+		return
+	}
+	enc := &amf.AMF0Encoder{}
+	enc.Init()
+	enc.EncodeString("onMetaData")
+	enc.EncodeObject(obj)
+	data, err := enc.GetData()
+	if err != nil {
+		return
+	}
+	dataSend := make([]byte, len(data)+4)
+	dataSend[0] = wsProtocol.WS_pkt_control
+	dataSend[3] = wsProtocol.WSC_onMetaData
+	copy(dataSend[4:], data)
+	return this.write(dataSend)
+}
+
+//data是flv audio tag data，包括头
+func (this *Client) SendAudio(timestamp uint32, data []byte) (err error) {
+	return this.sendMedia(flv.FLV_TAG_Audio, timestamp, data)
+}
+
+//data是flv video tag data，包括头
+func (this *Client) SendVideo(timestamp uint32, data []byte) (err error) {
+	return this.sendMedia(flv.FLV_TAG_Video, timestamp, data)
+}
+
+func (this *Client) sendMedia(tagType byte, timestamp uint32, data []byte) (err error) {
+	dataSend := make([]byte, len(data)+5)
+	dataSend[0] = tagType
+	dataSend[1] = byte((timestamp >> 24) & 0xff)
+	dataSend[2] = byte((timestamp >> 16) & 0xff)
+	dataSend[3] = byte((timestamp >> 8) & 0xff)
+	dataSend[4] = byte((timestamp >> 0) & 0xff)
+	copy(dataSend[5:], data)
+	return this.write(dataSend)
+}
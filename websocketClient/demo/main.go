@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"logger"
+	"websocketClient"
+)
+
+//播放一路流，打印状态和收到的片段
+func main() {
+	logger.SetFlags(logger.LOG_SHORT_FILE)
+	url := flag.String("url", "ws://127.0.0.1:8080/ws/live", "websocket url with app")
+	name := flag.String("name", "hks", "stream name")
+	flag.Parse()
+
+	cli, err := websocketClient.Dial(*url, nil)
+	if err != nil {
+		logger.LOGE(err.Error())
+		return
+	}
+	defer cli.Disconnect()
+	cli.OnStatus = func(st *websocketClient.Status) {
+		logger.LOGT(fmt.Sprintf("status %s %s req:%d", st.Level, st.Code, st.Req))
+	}
+	cli.OnMetadata = func(channel int, metadata map[string]interface{}) {
+		logger.LOGT(metadata)
+	}
+	cli.OnStreamInfo = func(info *websocketClient.StreamInfo) {
+		logger.LOGT(fmt.Sprintf("video:%s audio:%s %dx%d", info.VideoMimeType, info.AudioMimeType, info.Width, info.Height))
+	}
+	cli.OnSegment = func(seg *websocketClient.Segment) {
+		logger.LOGT(fmt.Sprintf("type:%d init:%v seq:%d time:%d size:%d",
+			seg.Type, seg.Init, seg.Sequence, seg.DecodeTime, len(seg.Data)))
+	}
+	_, err = cli.Play(*name)
+	if err != nil {
+		logger.LOGE(err.Error())
+		return
+	}
+	err = cli.Run()
+	if err != nil {
+		logger.LOGE(err.Error())
+	}
+}
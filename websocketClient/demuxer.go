@@ -0,0 +1,108 @@
+package websocketClient
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"webSocketService/wsProtocol"
+)
+
+//一个fMP4片段:init(ftyp+moov)或者media segment(moof+mdat)
+type Segment struct {
+	Type       int  //8 audio,9 video
+	Channel    int  //通道号，0是连接本身
+	Init       bool //init segment，后面的media segment要接在它后面解码
+	Sequence   uint32
+	DecodeTime uint64 //tfdt里的baseMediaDecodeTime，init为0
+	Data       []byte //不含类型字节，可以直接交给MSE或写文件
+}
+
+//data:1byte类型 + fMP4
+func DemuxSlice(data []byte) (seg *Segment, err error) {
+	if len(data) < 9 {
+		return nil, errors.New("fmp4 slice too short")
+	}
+	if data[0] != wsProtocol.WS_pkt_audio && data[0] != wsProtocol.WS_pkt_video {
+		return nil, errors.New(fmt.Sprintf("slice type %d not supported", data[0]))
+	}
+	seg = &Segment{Type: int(data[0]), Data: data[1:]}
+	boxes, err := readBoxes(seg.Data)
+	if err != nil {
+		return nil, err
+	}
+	if len(boxes) == 0 {
+		return nil, errors.New("no box in fmp4 slice")
+	}
+	switch boxes[0].name {
+	case "ftyp", "moov":
+		seg.Init = true
+	case "styp", "moof":
+		for _, box := range boxes {
+			if "moof" == box.name {
+				seg.Sequence, seg.DecodeTime, err = parseMoof(box.body)
+				if err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+	default:
+		return nil, errors.New("unknown fmp4 box " + boxes[0].name)
+	}
+	return
+}
+
+type mp4Box struct {
+	name string
+	body []byte
+}
+
+//只解一层，不支持largesize
+func readBoxes(data []byte) (boxes []mp4Box, err error) {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errors.New("truncated mp4 box header")
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			return nil, errors.New(fmt.Sprintf("invalid mp4 box size %d", size))
+		}
+		boxes = append(boxes, mp4Box{name: string(data[4:8]), body: data[8:size]})
+		data = data[size:]
+	}
+	return
+}
+
+//mfhd的sequence_number和第一个traf里tfdt的时间
+func parseMoof(data []byte) (sequence uint32, decodeTime uint64, err error) {
+	boxes, err := readBoxes(data)
+	if err != nil {
+		return
+	}
+	for _, box := range boxes {
+		switch box.name {
+		case "mfhd":
+			if len(box.body) >= 8 {
+				sequence = binary.BigEndian.Uint32(box.body[4:])
+			}
+		case "traf":
+			trafBoxes, err := readBoxes(box.body)
+			if err != nil {
+				return 0, 0, err
+			}
+			for _, child := range trafBoxes {
+				if child.name != "tfdt" || len(child.body) < 8 {
+					continue
+				}
+				//version 1是64位
+				if child.body[0] == 1 && len(child.body) >= 12 {
+					decodeTime = binary.BigEndian.Uint64(child.body[4:])
+				} else {
+					decodeTime = uint64(binary.BigEndian.Uint32(child.body[4:]))
+				}
+				return sequence, decodeTime, nil
+			}
+		}
+	}
+	return
+}
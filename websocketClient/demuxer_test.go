@@ -0,0 +1,82 @@
+package websocketClient
+
+import (
+	"io/ioutil"
+	"testing"
+	"webSocketService/wsProtocol"
+)
+
+//testdata是服务器fMP4输出的原样消息:1byte类型 + fMP4
+//video_init.bin:h264 init(ftyp+moov)，video_frag.bin:第二个media segment(moof+mdat)
+
+func readTestData(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDemuxInit(t *testing.T) {
+	seg, err := DemuxSlice(readTestData(t, "video_init.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seg.Type != wsProtocol.WS_pkt_video || false == seg.Init {
+		t.Fatalf("type %d init %v", seg.Type, seg.Init)
+	}
+	if seg.Sequence != 0 || seg.DecodeTime != 0 {
+		t.Fatalf("init sequence %d time %d", seg.Sequence, seg.DecodeTime)
+	}
+	if string(seg.Data[4:8]) != "ftyp" {
+		t.Fatalf("init starts with %s", string(seg.Data[4:8]))
+	}
+}
+
+func TestDemuxFragment(t *testing.T) {
+	seg, err := DemuxSlice(readTestData(t, "video_frag.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seg.Type != wsProtocol.WS_pkt_video || seg.Init {
+		t.Fatalf("type %d init %v", seg.Type, seg.Init)
+	}
+	if seg.Sequence != 2 || seg.DecodeTime != 40 {
+		t.Fatalf("sequence %d time %d", seg.Sequence, seg.DecodeTime)
+	}
+}
+
+func TestDemuxTruncated(t *testing.T) {
+	data := readTestData(t, "video_frag.bin")
+	_, err := DemuxSlice(data[:len(data)-1])
+	if err == nil {
+		t.Fatal("truncated fragment accepted")
+	}
+}
+
+//通道的包去掉前缀以后和通道0一样，Channel带上通道号
+func TestChannelSegment(t *testing.T) {
+	var got []*Segment
+	cli := &Client{OnSegment: func(seg *Segment) {
+		got = append(got, seg)
+	}}
+	frag := readTestData(t, "video_frag.bin")
+	err := cli.handleBinary(frag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cli.handleBinary(wsProtocol.ChannelPacket(3, frag))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Channel != 0 || got[1].Channel != 3 {
+		t.Fatalf("segments %v", got)
+	}
+	if got[1].Sequence != 2 || got[1].DecodeTime != 40 {
+		t.Fatalf("channel sequence %d time %d", got[1].Sequence, got[1].DecodeTime)
+	}
+	err = cli.handleBinary([]byte{wsProtocol.WS_pkt_channel, 0})
+	if err == nil {
+		t.Fatal("short channel packet accepted")
+	}
+}
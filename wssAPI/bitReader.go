@@ -18,7 +18,7 @@ func (this *BitReader) Init(data []byte) {
 }
 
 func (this *BitReader) ReadBit() int {
-	if this.curBit > (len(this.buf) << 3) {
+	if this.curBit >= (len(this.buf) << 3) {
 		return -1
 	}
 	idx := (this.curBit >> 3)
@@ -0,0 +1,71 @@
+package wssAPI
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+//按2的幂分级的字节池，媒体数据频繁申请释放，复用以减轻GC压力
+//超出范围的大小直接make，不放回池
+const (
+	bufferPoolMinShift = 8  //256B
+	bufferPoolMaxShift = 22 //4MB
+)
+
+var bufferPools [bufferPoolMaxShift - bufferPoolMinShift + 1]sync.Pool
+
+func bufferPoolIndex(size int) int {
+	idx := 0
+	for (1 << uint(bufferPoolMinShift+idx)) < size {
+		idx++
+	}
+	return idx
+}
+
+//返回长度为size的切片，内容未清零
+func AllocBuffer(size int) []byte {
+	if size <= 0 {
+		return nil
+	}
+	if size > 1<<bufferPoolMaxShift {
+		return make([]byte, size)
+	}
+	idx := bufferPoolIndex(size)
+	if v := bufferPools[idx].Get(); v != nil {
+		return v.([]byte)[:size]
+	}
+	return make([]byte, size, 1<<uint(bufferPoolMinShift+idx))
+}
+
+//放回池中，调用后不能再使用buf
+func FreeBuffer(buf []byte) {
+	c := cap(buf)
+	if c < 1<<bufferPoolMinShift || c > 1<<bufferPoolMaxShift || c&(c-1) != 0 {
+		return
+	}
+	bufferPools[bufferPoolIndex(c)].Put(buf[:c])
+}
+
+//引用计数的池化buffer，多个持有者共享，计数归零后放回池
+type BufferRef struct {
+	count int32
+	buf   []byte
+}
+
+//buf需由AllocBuffer申请，计数初始为1
+func NewBufferRef(buf []byte) *BufferRef {
+	return &BufferRef{count: 1, buf: buf}
+}
+
+func (this *BufferRef) Retain() {
+	if this != nil {
+		atomic.AddInt32(&this.count, 1)
+	}
+}
+
+func (this *BufferRef) Release() {
+	if this != nil && 0 == atomic.AddInt32(&this.count, -1) {
+		FreeBuffer(this.buf)
+		this.buf = nil
+	}
+}
@@ -0,0 +1,95 @@
+package wssAPI
+
+import (
+	"sync"
+	"testing"
+)
+
+//N个持有者各Retain一次，全部Release以后才放回池，多一次Release也不会重复放回
+func TestBufferRefBalance(t *testing.T) {
+	for _, holders := range []int{0, 1, 10, 100} {
+		ref := NewBufferRef(AllocBuffer(1024))
+		for i := 0; i < holders; i++ {
+			ref.Retain()
+		}
+		var wg sync.WaitGroup
+		for i := 0; i < holders; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ref.Release()
+			}()
+		}
+		wg.Wait()
+		if ref.count != 1 || nil == ref.buf {
+			t.Fatalf("%d holders: count %d freed %v before the owner released", holders, ref.count, nil == ref.buf)
+		}
+		ref.Release()
+		if ref.count != 0 || ref.buf != nil {
+			t.Fatalf("%d holders: count %d not freed", holders, ref.count)
+		}
+	}
+}
+
+//nil的BufferRef是普通tag用的，Retain/Release什么都不做
+func TestBufferRefNil(t *testing.T) {
+	var ref *BufferRef
+	ref.Retain()
+	ref.Release()
+}
+
+//分级和容量，不是2的幂的不放回池
+func TestAllocBufferSize(t *testing.T) {
+	tests := []struct {
+		size int
+		cap  int
+	}{
+		{1, 256},
+		{256, 256},
+		{257, 512},
+		{16 << 10, 16 << 10},
+		{4 << 20, 4 << 20},
+		{4<<20 + 1, 4<<20 + 1},
+	}
+	for _, test := range tests {
+		buf := AllocBuffer(test.size)
+		if len(buf) != test.size || cap(buf) != test.cap {
+			t.Errorf("size %d: len %d cap %d want cap %d", test.size, len(buf), cap(buf), test.cap)
+		}
+		FreeBuffer(buf)
+	}
+	if buf := AllocBuffer(0); buf != nil {
+		t.Errorf("size 0: %d bytes", len(buf))
+	}
+}
+
+//池化的申请释放和直接make对比，go test -bench Buffer -benchmem wssAPI
+
+const benchBufferSize = 16 << 10
+
+var benchBuffer []byte
+
+func BenchmarkBufferMake(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchBuffer = make([]byte, benchBufferSize)
+	}
+}
+
+func BenchmarkBufferPool(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		benchBuffer = AllocBuffer(benchBufferSize)
+		FreeBuffer(benchBuffer)
+	}
+}
+
+func BenchmarkBufferRef(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ref := NewBufferRef(AllocBuffer(benchBufferSize))
+		ref.Retain()
+		ref.Release()
+		ref.Release()
+	}
+}
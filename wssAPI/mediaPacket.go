@@ -0,0 +1,64 @@
+package wssAPI
+
+//与封装无关的媒体包，ingest时转换一次，sink不用再解析flv tag的Data
+const (
+	MEDIA_TYPE_AUDIO = 1
+	MEDIA_TYPE_VIDEO = 2
+	MEDIA_TYPE_DATA  = 3
+)
+
+const (
+	CODEC_UNKNOWN = iota
+	CODEC_H264
+	CODEC_AAC
+	CODEC_MP3
+	CODEC_SCRIPT //amf0 metadata
+	CODEC_H265
+	CODEC_AV1
+	CODEC_VP9
+)
+
+//编码参数，Data为原始配置：avcC,hvcC,av1C,vpcC,AudioSpecificConfig等
+type CodecConfig struct {
+	CodecId    int
+	Data       []byte
+	Profile    int
+	Level      int
+	Width      int
+	Height     int
+	Fps        int
+	SampleRate int
+	Channels   int
+	SampleSize int
+}
+
+type MediaPacket struct {
+	MediaType int
+	CodecId   int
+	Pts       uint32 //ms，已经加上composition time
+	Dts       uint32 //ms
+	Keyframe  bool
+	IsConfig  bool         //sequence header,Data和Config.Data相同
+	Config    *CodecConfig //当前生效的编码参数，同一路流的包共享
+	Data      []byte       //h264 h265为4字节长度前缀的nalu,av1为obu,vp9为帧,音频为去掉头的raw帧
+	Ref       *BufferRef   //Data所在的共享buffer
+}
+
+//sink实现这个接口并返回true，streamer发给它MSG_MEDIA_PACKET而不是MSG_FLV_TAG
+type MediaPacketSinker interface {
+	AcceptMediaPacket() bool
+}
+
+//sink实现这个接口并返回true，加入时streamer在头后面补发最近的GOP
+type GopSinker interface {
+	AcceptGop() bool
+}
+
+func (this *MediaPacket) Retain() *MediaPacket {
+	this.Ref.Retain()
+	return this
+}
+
+func (this *MediaPacket) Release() {
+	this.Ref.Release()
+}
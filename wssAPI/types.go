@@ -9,10 +9,12 @@ const (
 	OBJ_RTSPServer      = "RTSPServer"
 	OBJ_HLSServer       = "HLSServer"
 	OBJ_DASHServer      = `DASHServer`
+	OBJ_HTTPFLVServer   = "HTTPFLVServer"
 )
 
 const (
 	MSG_FLV_TAG            = "FLVTag"
+	MSG_MEDIA_PACKET       = "MediaPacket"
 	MSG_GetSource_NOTIFY   = "MSG.GetSource.Notify.Async"
 	MSG_GetSource_Failed   = "MSG.GetSource.Failed"
 	MSG_SourceClosed_Force = "MSG.SourceClosed.Force"